@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// openAuditor returns where -audit-log records pulls: the local syslog for
+// "syslog", a remote one for syslog://host:port (UDP) or
+// syslog+tcp://host:port, or else a hash-chained file.
+func openAuditor(target string) (ollamadl.Auditor, error) {
+	if target == "syslog" {
+		return newSyslogAuditor("", "")
+	}
+	if u, err := url.Parse(target); err == nil && strings.HasPrefix(u.Scheme, "syslog") {
+		switch u.Scheme {
+		case "syslog":
+			return newSyslogAuditor("udp", u.Host)
+		case "syslog+tcp":
+			return newSyslogAuditor("tcp", u.Host)
+		}
+		return nil, fmt.Errorf("unsupported syslog scheme %s", u.Scheme)
+	}
+	return ollamadl.OpenAuditLog(target), nil
+}
+
+// runAudit implements "ollama-dl audit", which checks an audit log file
+// written with -audit-log and lists its events.
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: ollama-dl audit <file>")
+		os.Exit(1)
+	}
+
+	events, err := ollamadl.VerifyAuditLog(fs.Arg(0))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tUSER\tHOST\tMODEL\tDIGEST\tSTATUS\tDESTINATION")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.User, e.Host,
+			strings.TrimPrefix(e.Model, "library/"), shortDigest(e.Digest), e.Status, e.Dest)
+	}
+	w.Flush()
+	if err != nil {
+		return fmt.Errorf("audit log is not intact: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+func newSyslogAuditor(network, addr string) (ollamadl.Auditor, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,37 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// syslogAuditor sends audit events to syslog as JSON messages.
+type syslogAuditor struct {
+	w *syslog.Writer
+}
+
+// newSyslogAuditor connects to the syslog daemon at addr over network, or
+// to the local one if network is "".
+func newSyslogAuditor(network, addr string) (ollamadl.Auditor, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTHPRIV, "ollama-dl")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogAuditor{w: w}, nil
+}
+
+func (a *syslogAuditor) Record(_ context.Context, event ollamadl.AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Status == "done" {
+		return a.w.Info(string(data))
+	}
+	return a.w.Warning(string(data))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runCat implements "ollama-dl cat", which streams a layer to stdout, e.g.
+// to pipe a model straight to another machine.
+func runCat(args []string) error {
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	layerType := fs.String("type", "model", "Layer to stream: model, template, params, license, system, ... or a full media type")
+	ref := parseModelArgs(fs, args, "ollama-dl cat [flags] <name>")
+
+	mediaType := *layerType
+	if !strings.Contains(mediaType, "/") {
+		mediaType = "application/vnd.ollama.image." + mediaType
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriterSize(os.Stdout, 1<<20)
+	if err := d.Cat(commandContext(), ref, mediaType, out); err != nil {
+		out.Flush()
+		return err
+	}
+	return out.Flush()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// defaultCleanAge is how old temporary files must be for "ollama-dl clean"
+// to delete them whatever layer they belong to.
+const defaultCleanAge = 7 * 24 * time.Hour
+
+// runClean implements "ollama-dl clean", which deletes the temporary files
+// interrupted pulls left behind.
+func runClean(args []string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "Only report what would be deleted")
+	fs.BoolVar(dryRun, "dry-run", false, "Same as -n")
+	olderThan := fs.Duration("older-than", defaultCleanAge, "Delete temporary files older than this even if a pull could resume from them (0 keeps them)")
+	fs.Parse(args)
+	root := "."
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	d, err := ollamadl.New(ollamadl.Options{
+		Store:  ollamadl.NewFileStore(""),
+		Logger: newLogger(slog.LevelInfo),
+	})
+	if err != nil {
+		return err
+	}
+	result, err := d.Clean(commandContext(), root, *olderThan, *dryRun)
+	if err != nil {
+		return fmt.Errorf("clean failed: %w", err)
+	}
+
+	if *dryRun {
+		for _, file := range result.Removed {
+			fmt.Println("Would delete", file)
+		}
+		fmt.Printf("%d files, %s reclaimable\n", len(result.Removed), formatSize(result.Bytes))
+	} else {
+		fmt.Printf("Deleted %d files, %s\n", len(result.Removed), formatSize(result.Bytes))
+	}
+	return nil
+}
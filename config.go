@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/schedule"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// Config is the optional JSON configuration file. MediaTypes extends or
+// overrides the file names used for layer media types; an empty template
+// drops the media type. Schedules are the pulls the daemon runs on its own.
+// Webhook is the URL the outcome of every pull is posted to; Notify turns on
+// desktop notifications as -notify does. MaxStoreSize, Eviction and Pins
+// are defaults for -max-store-size, -evict and -pin. Webseeds are tried
+// after those given with -webseed. Signature, when set, makes every pull
+// verify signatures as -verify-signature does. Policy, when set, decides
+// which models may be pulled, unless -policy gives another. AuditLog and
+// RateSchedule are the defaults for -audit-log and -rate-schedule.
+type Config struct {
+	MediaTypes   map[string]string `json:"mediaTypes"`
+	Schedules    []ScheduleConfig  `json:"schedules"`
+	Webhook      string            `json:"webhook"`
+	Notify       bool              `json:"notify"`
+	MaxStoreSize string            `json:"maxStoreSize"`
+	Eviction     string            `json:"eviction"`
+	Pins         []string          `json:"pins"`
+	Webseeds     []string          `json:"webseeds"`
+	Signature    *SignatureConfig  `json:"signature"`
+	Policy       *PolicyConfig     `json:"policy"`
+	AuditLog     string            `json:"auditLog"`
+	RateSchedule string            `json:"rateSchedule"`
+}
+
+// SignatureConfig is the signature policy, as the -signature-key,
+// -certificate-* and -rekor-key flags give it. Keys, Roots and RekorKeys
+// are PEM files.
+type SignatureConfig struct {
+	Keys      []string `json:"keys"`
+	Identity  string   `json:"identity"`
+	Issuer    string   `json:"issuer"`
+	Roots     string   `json:"roots"`
+	RekorKeys []string `json:"rekorKeys"`
+}
+
+// policy loads the keys and roots c names.
+func (c SignatureConfig) policy() (*ollamadl.SignaturePolicy, error) {
+	if len(c.Keys) == 0 && c.Identity == "" {
+		return nil, errors.New("signature verification needs a key or a certificate identity")
+	}
+	policy := &ollamadl.SignaturePolicy{Identity: c.Identity, Issuer: c.Issuer}
+	var err error
+	if policy.Keys, err = readPublicKeys(c.Keys); err != nil {
+		return nil, err
+	}
+	if c.Identity != "" {
+		if c.Roots == "" {
+			return nil, errors.New("keyless signature verification needs the roots certificates chain up to")
+		}
+		if len(c.RekorKeys) == 0 {
+			return nil, errors.New("keyless signature verification needs the transparency log's public key")
+		}
+		if policy.RekorKeys, err = readPublicKeys(c.RekorKeys); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(c.Roots)
+		if err != nil {
+			return nil, err
+		}
+		policy.Roots = x509.NewCertPool()
+		if !policy.Roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no PEM certificates", c.Roots)
+		}
+	}
+	return policy, nil
+}
+
+// readPublicKeys reads the PEM public keys in the named files.
+func readPublicKeys(names []string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ollamadl.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// PolicyConfig is a model policy, as the config file or a -policy file
+// gives it, e.g. {"allow": ["library/*"], "deny": ["library/*:*-fp16"],
+// "maxSize": "20G", "allowLicenses": ["Apache License", "\\bMIT\\b"]}.
+// License patterns are regular expressions, matched case-insensitively.
+type PolicyConfig struct {
+	Allow         []string `json:"allow"`
+	Deny          []string `json:"deny"`
+	MaxSize       string   `json:"maxSize"`
+	AllowLicenses []string `json:"allowLicenses"`
+	DenyLicenses  []string `json:"denyLicenses"`
+}
+
+// policy parses the size and compiles the patterns of c.
+func (c PolicyConfig) policy() (*ollamadl.ModelPolicy, error) {
+	policy := &ollamadl.ModelPolicy{Allow: c.Allow, Deny: c.Deny}
+	for _, pattern := range append(c.Allow, c.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	var err error
+	if policy.MaxSize, err = parseSize(c.MaxSize); err != nil {
+		return nil, fmt.Errorf("invalid maxSize %q", c.MaxSize)
+	}
+	if policy.AllowLicenses, err = compileLicensePatterns(c.AllowLicenses); err != nil {
+		return nil, err
+	}
+	if policy.DenyLicenses, err = compileLicensePatterns(c.DenyLicenses); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func compileLicensePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid license pattern %q: %v", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// loadPolicy reads a -policy file.
+func loadPolicy(name string) (*ollamadl.ModelPolicy, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c PolicyConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	return c.policy()
+}
+
+// ScheduleConfig is a pull the daemon starts on a cron schedule, e.g.
+// {"model": "llama3:latest", "cron": "0 2 * * *", "jitter": "15m"}.
+type ScheduleConfig struct {
+	Model   string `json:"model"`
+	Dest    string `json:"dest"`
+	Cron    string `json:"cron"`
+	Jitter  string `json:"jitter"`
+	Retries *int   `json:"retries"`
+}
+
+// defaultScheduleRetries is how often a failed scheduled pull is retried
+// unless the schedule says otherwise.
+const defaultScheduleRetries = 3
+
+// entry parses c into a schedule entry.
+func (c ScheduleConfig) entry() (schedule.Entry, error) {
+	if c.Model == "" {
+		return schedule.Entry{}, errors.New("schedule without a model")
+	}
+	spec, err := schedule.Parse(c.Cron)
+	if err != nil {
+		return schedule.Entry{}, err
+	}
+	e := schedule.Entry{Spec: spec, Model: c.Model, Dest: c.Dest, Retries: defaultScheduleRetries}
+	if c.Jitter != "" {
+		if e.Jitter, err = time.ParseDuration(c.Jitter); err != nil {
+			return schedule.Entry{}, fmt.Errorf("schedule for %s: invalid jitter %q", c.Model, c.Jitter)
+		}
+	}
+	if c.Retries != nil {
+		e.Retries = *c.Retries
+	}
+	return e, nil
+}
+
+// defaultConfigPath returns the per-user config file location, e.g.
+// ~/.config/ollama-dl/config.json on Linux.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ollama-dl", "config.json")
+}
+
+// defaultStatePath returns where the state database is kept by default,
+// next to the config file.
+func defaultStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ollama-dl", "state.json")
+}
+
+// defaultManifestCacheDir returns where fetched manifests are cached by
+// default, e.g. ~/.cache/ollama-dl/manifests on Linux.
+func defaultManifestCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ollama-dl", "manifests")
+}
+
+// addStateFlag adds the -state flag, which sets the state database file.
+func addStateFlag(fs *flag.FlagSet, path *string) {
+	fs.StringVar(path, "state", defaultStatePath(), `State database recording local pulls; "" disables it`)
+}
+
+// openState returns the state database at path, or nil for "".
+func openState(path string) *ollamadl.State {
+	if path == "" {
+		return nil
+	}
+	return ollamadl.OpenState(path)
+}
+
+// loadConfig reads the config file at path. A missing file is only an error
+// when the path was given explicitly.
+func loadConfig(path string, explicit bool) (*Config, error) {
+	var cfg Config
+	if path == "" {
+		return &cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return &cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// createFlag is -create[=name]: set on its own, the model is registered
+// under its own name.
+type createFlag struct {
+	set  bool
+	name string
+}
+
+func (f *createFlag) String() string   { return f.name }
+func (f *createFlag) IsBoolFlag() bool { return true }
+
+func (f *createFlag) Set(s string) error {
+	switch s {
+	case "true":
+		f.set, f.name = true, ""
+	case "false":
+		f.set, f.name = false, ""
+	default:
+		f.set, f.name = true, s
+	}
+	return nil
+}
+
+// ollamaName returns the name Ollama gives ref, without the library
+// namespace, e.g. llama3.2:3b.
+func ollamaName(ref ollamadl.Reference) string {
+	return strings.TrimPrefix(ref.String(), "library/")
+}
+
+// ollamaCreate writes a Modelfile for the model pulled into dir and
+// registers it with the local Ollama as name. Without ollama installed the
+// Modelfile is left for later.
+func ollamaCreate(ctx context.Context, d *ollamadl.Downloader, dir, name string, log *slog.Logger) error {
+	modelfile, err := d.Modelfile(ctx, dir)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, ollamadl.ModelfileName)
+	if err := os.WriteFile(file, modelfile, 0644); err != nil {
+		return err
+	}
+
+	ollama, err := exec.LookPath("ollama")
+	if err != nil {
+		log.Warn("ollama not found in PATH; run `ollama create` once installed", "name", name, "modelfile", file)
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, ollama, "create", name, "-f", ollamadl.ModelfileName)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ollama create %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/grpcapi"
+	"github.com/dimchansky/ollama-dl-go/internal/health"
+	"github.com/dimchansky/ollama-dl-go/internal/jobs"
+	"github.com/dimchansky/ollama-dl-go/internal/metrics"
+	"github.com/dimchansky/ollama-dl-go/internal/restapi"
+	"github.com/dimchansky/ollama-dl-go/internal/schedule"
+	"github.com/dimchansky/ollama-dl-go/internal/tracing"
+)
+
+// defaultStallTimeout is how long downloads may receive nothing before
+// /healthz reports them as stalled.
+const defaultStallTimeout = 5 * time.Minute
+
+// runDaemon implements "ollama-dl daemon", a long-running download service
+// driven over its APIs and by its schedules.
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	listen := fs.String("listen", "", "Serve the HTTP/JSON API on this address, e.g. localhost:8080; :8080 listens on every interface")
+	grpcListen := fs.String("grpc-listen", "", "Serve the gRPC control API on this address, e.g. localhost:9090; :9090 listens on every interface")
+	metricsListen := fs.String("metrics-listen", "", "Serve Prometheus metrics on this address (default: /metrics on -listen)")
+	stallTimeout := fs.Duration("stall-timeout", defaultStallTimeout, "Report downloads as stalled on /healthz when no data arrived for this long")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file; without it the APIs are served in plain text")
+	tlsKey := fs.String("tls-key", "", "TLS key file")
+	tokenFile := fs.String("api-token-file", "", "Require API clients to send the bearer token in this `file` (default: $OLLAMA_DL_API_TOKEN)")
+	clientCA := fs.String("client-ca", "", "Require API clients to present a TLS certificate signed by a CA in this `file`")
+	noAuth := fs.Bool("insecure-no-auth", false, "Serve the APIs without a token or client certificate, letting anyone who can connect start pulls")
+	destRoot := fs.String("dest-root", ".", "Directory API clients' destinations are relative to; they can't reach outside it")
+	allowAnyDest := fs.Bool("allow-any-dest", false, "Let API clients pull to any directory or storage URL instead of only below -dest-root")
+	maxActive := fs.Int("max-active", 2, "Number of pulls to run at the same time")
+	retention := fs.Duration("job-retention", jobs.DefaultRetention, "Forget finished pulls after this long")
+	maxFinished := fs.Int("max-finished-jobs", jobs.DefaultMaxFinished, "Remember at most this many finished pulls")
+	var schedules []ScheduleConfig
+	fs.Func("schedule", `Pull a model on a cron schedule, as "<cron> <model> [dest]", e.g. "0 2 * * * llama3:latest"; repeatable`, func(s string) error {
+		c, err := parseScheduleFlag(s)
+		if err != nil {
+			return err
+		}
+		schedules = append(schedules, c)
+		return nil
+	})
+	fs.Parse(args)
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		return errors.New("-client-ca needs -tls-cert and -tls-key")
+	}
+	token := os.Getenv("OLLAMA_DL_API_TOKEN")
+	if *tokenFile != "" {
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if (*listen != "" || *grpcListen != "") && token == "" && *clientCA == "" && !*noAuth {
+		return errors.New("the APIs need -api-token-file, $OLLAMA_DL_API_TOKEN or -client-ca to authenticate clients, or -insecure-no-auth")
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	var entries []schedule.Entry
+	for _, c := range append(rf.config.Schedules, schedules...) {
+		e, err := c.entry()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	if *listen == "" && *grpcListen == "" && len(entries) == 0 {
+		fmt.Println("Usage: ollama-dl daemon [-listen <addr>] [-grpc-listen <addr>] [-schedule <spec>] [flags]")
+		os.Exit(1)
+	}
+	log := opts.Logger
+	stats := metrics.New()
+	status := health.New(*stallTimeout)
+	manager := jobs.NewManager(status.Instrument(stats.Instrument(opts)), openStore, *maxActive)
+	manager.ClientDests = jobs.DestRoot{Dir: *destRoot, AllowAny: *allowAnyDest}
+	manager.Retention, manager.MaxFinished = *retention, *maxFinished
+	if manager.Tracer, err = tracing.FromEnv("ollama-dl", log); err != nil {
+		return err
+	}
+	defer shutdownTracer(manager.Tracer)
+
+	var tlsConfig *tls.Config
+	if *clientCA != "" {
+		pem, err := os.ReadFile(*clientCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("-client-ca: no certificates in %s", *clientCA)
+		}
+		tlsConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+
+	var servers []*http.Server
+	if *listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/", requireToken(restapi.NewHandler(manager), token))
+		if *metricsListen == "" {
+			mux.Handle("GET /metrics", stats)
+			mux.Handle("GET /healthz", status)
+		}
+		servers = append(servers, &http.Server{Addr: *listen, Handler: mux, TLSConfig: tlsConfig})
+	}
+	if *metricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", stats)
+		mux.Handle("GET /healthz", status)
+		servers = append(servers, &http.Server{Addr: *metricsListen, Handler: mux})
+	}
+	if *grpcListen != "" {
+		srv := &http.Server{Addr: *grpcListen, Handler: requireToken(grpcapi.NewHandler(manager), token), TLSConfig: tlsConfig}
+		if *tlsCert == "" {
+			if err := enableH2C(srv); err != nil {
+				return err
+			}
+		}
+		servers = append(servers, srv)
+	}
+
+	ctx := commandContext()
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		srv.ErrorLog = slog.NewLogLogger(log.Handler(), slog.LevelError)
+		go func() {
+			log.Info("Listening", "addr", srv.Addr)
+			if *tlsCert != "" {
+				errc <- srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+				return
+			}
+			errc <- srv.ListenAndServe()
+		}()
+	}
+
+	if len(entries) > 0 {
+		go schedule.Run(ctx, manager, entries, log)
+	}
+
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+
+	// Running pulls are interrupted; their partial downloads resume when the
+	// same pulls are started again.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdownCtx)
+	}
+	return err
+}
+
+// requireToken wraps h so that requests must carry token as a bearer token.
+// An empty token lets every request through.
+func requireToken(h http.Handler, token string) http.Handler {
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseScheduleFlag parses a -schedule value: a cron expression of five
+// fields or an @ shorthand, then the model and optionally its destination.
+func parseScheduleFlag(s string) (ScheduleConfig, error) {
+	fields := strings.Fields(s)
+	n := 5
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		n = 1
+	}
+	if len(fields) < n+1 || len(fields) > n+2 {
+		return ScheduleConfig{}, fmt.Errorf("want \"<cron> <model> [dest]\", got %q", s)
+	}
+	c := ScheduleConfig{Cron: strings.Join(fields[:n], " "), Model: fields[n]}
+	if len(fields) > n+1 {
+		c.Dest = fields[n+1]
+	}
+	return c, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runDiff implements "ollama-dl diff", which compares a download with the
+// registry's current manifest for the same tag.
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	destDir := fs.String("d", "", "Directory or storage URL the model was downloaded to")
+	fs.StringVar(destDir, "dest", "", "Same as -d")
+	ref := parseModelArgs(fs, args, "ollama-dl diff [flags] <name>")
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	store, dir, err := openStore(*destDir, ref)
+	if err != nil {
+		return err
+	}
+	opts.Store = store
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := d.Diff(commandContext(), ref, dir)
+	if err != nil {
+		return fmt.Errorf("diff failed: %w", err)
+	}
+	if result.UpToDate() {
+		fmt.Println("Up to date with", ref)
+		return nil
+	}
+
+	if result.LocalDigest != "" {
+		fmt.Printf("%s: %s -> %s\n", ref, shortDigest(result.LocalDigest), shortDigest(result.RemoteDigest))
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, change := range result.Changes {
+		switch {
+		case change.Old == nil:
+			fmt.Fprintf(tw, "+ %s\t%s\t\t%s\n", layerKind(*change.New), shortDigest(change.New.Digest), formatSize(change.New.Size))
+		case change.New == nil:
+			fmt.Fprintf(tw, "- %s\t%s\t\t%s\n", layerKind(*change.Old), shortDigest(change.Old.Digest), formatSize(change.Old.Size))
+		default:
+			fmt.Fprintf(tw, "~ %s\t%s -> %s\t%s -> %s\n", layerKind(*change.New),
+				shortDigest(change.Old.Digest), shortDigest(change.New.Digest),
+				formatSize(change.Old.Size), formatSize(change.New.Size))
+		}
+	}
+	tw.Flush()
+
+	sign := "+"
+	delta := result.SizeDelta
+	if delta < 0 {
+		sign, delta = "-", -delta
+	}
+	fmt.Printf("%d unchanged, %d changed; size %s%s, a sync downloads %s\n",
+		len(result.Unchanged), len(result.Changes), sign, formatSize(delta), formatSize(result.Download))
+	return nil
+}
+
+// layerKind names the kind of a layer by its media type, e.g. "model" for
+// application/vnd.ollama.image.model.
+func layerKind(layer ollamadl.Layer) string {
+	kind := strings.TrimSuffix(strings.TrimSuffix(layer.MediaType, "+json"), ".v1")
+	if i := strings.LastIndexAny(kind, "./"); i >= 0 {
+		kind = kind[i+1:]
+	}
+	for _, suffix := range []string{"+gzip", "+zstd"} {
+		kind = strings.TrimSuffix(kind, suffix)
+	}
+	return kind
+}
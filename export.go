@@ -0,0 +1,89 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+	"github.com/klauspost/compress/zstd"
+)
+
+// runExport implements "ollama-dl export", which writes a model bundle for
+// air-gapped transfer.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	output := fs.String("o", "", "Bundle file to write; .tar.zst and .tar.gz names are compressed")
+	fromDir := fs.String("d", "", "Take files from this earlier download of the model instead of downloading them again")
+	fs.StringVar(fromDir, "dest", "", "Same as -d")
+	ref := parseModelArgs(fs, args, "ollama-dl export [flags] -o <file> <name>")
+	if *output == "" {
+		return fmt.Errorf("no bundle file given; use -o")
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	opts.Progress = newBarReporter()
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+
+	// Write under a temporary name so a failed export leaves no bundle
+	// that looks complete.
+	tmp, err := os.CreateTemp(filepath.Dir(*output), filepath.Base(*output)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w, err := compressWriter(tmp, *output)
+	if err != nil {
+		return err
+	}
+	res, err := d.Export(commandContext(), ref, *fromDir, w)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), *output)
+	}
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+
+	var size int64
+	for _, layer := range append([]ollamadl.Layer{res.Manifest.Config}, res.Manifest.Layers...) {
+		size += layer.Size
+	}
+	fmt.Printf("Exported %s (%s) to %s\n", ref, formatSize(size), *output)
+	return nil
+}
+
+// compressWriter wraps w in the compression the bundle name asks for.
+func compressWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".zst"), strings.HasSuffix(name, ".tzst"):
+		return zstd.NewWriter(w)
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".tgz"):
+		return gzip.NewWriter(w), nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
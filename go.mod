@@ -2,10 +2,14 @@ module github.com/dimchansky/ollama-dl-go
 
 go 1.22.2
 
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/schollz/progressbar/v3 v3.17.1
+	golang.org/x/sys v0.27.0
+)
+
 require (
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	github.com/schollz/progressbar/v3 v3.17.1 // indirect
-	golang.org/x/sys v0.27.0 // indirect
 	golang.org/x/term v0.26.0 // indirect
 )
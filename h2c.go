@@ -0,0 +1,14 @@
+//go:build go1.24
+
+package main
+
+import "net/http"
+
+// enableH2C lets srv accept HTTP/2 without TLS, which gRPC clients use for
+// plain-text connections.
+func enableH2C(srv *http.Server) error {
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !go1.24
+
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// enableH2C fails before Go 1.24, whose net/http is the first to serve
+// HTTP/2 without TLS.
+func enableH2C(*http.Server) error {
+	return errors.New("serving gRPC without TLS requires a build with Go 1.24 or later; use -tls-cert and -tls-key")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+	"github.com/klauspost/compress/zstd"
+)
+
+// runImport implements "ollama-dl import", which unpacks a bundle written
+// by "ollama-dl export".
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	destDir := fs.String("d", "", "Destination directory or storage URL (default named after the model)")
+	fs.StringVar(destDir, "dest", "", "Same as -d")
+	sf := addStoreFlags(fs)
+	ollamaStore := fs.Bool("ollama-store", false, "Import into the local Ollama installation ($OLLAMA_MODELS or ~/.ollama/models) instead")
+	checksums := fs.Bool("checksums", false, "Write a SHA256SUMS file covering the unpacked files and the manifest")
+	verbose := fs.Bool("v", false, "Log debug messages")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: ollama-dl import [flags] <bundle>")
+		os.Exit(1)
+	}
+	if *ollamaStore && *destDir != "" {
+		return fmt.Errorf("-d and -ollama-store cannot be combined")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompressReader(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	opts := ollamadl.Options{Progress: newBarReporter(), Logger: newLogger(level)}
+	ctx := commandContext()
+
+	if *ollamaStore {
+		modelsDir, err := ollamadl.OllamaModelsDir()
+		if err != nil {
+			return err
+		}
+		d, err := ollamadl.New(opts)
+		if err != nil {
+			return err
+		}
+		ref, err := d.ImportToOllama(ctx, r, modelsDir)
+		if err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+		fmt.Printf("Imported %s into %s\n", ref, modelsDir)
+		return nil
+	}
+
+	store, dir, err := openStore(*destDir, ollamadl.Reference{})
+	if err != nil {
+		return err
+	}
+	if *destDir == "" {
+		dir = "" // Import names it after the model.
+	}
+	if err := sf.apply(store); err != nil {
+		return err
+	}
+	opts.Store = store
+	opts.WriteChecksums = *checksums
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+	res, err := d.Import(ctx, r, dir)
+	if err != nil {
+		return fmt.Errorf("import failed: %w", err)
+	}
+	fmt.Printf("Imported %s into %s\n", res.Ref, res.DestDir)
+	return nil
+}
+
+// decompressReader undoes the compression of a bundle, recognized by its
+// magic number.
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		dec, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	}
+	return io.NopCloser(br), nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dimchansky/ollama-dl-go/internal/gguf"
+)
+
+// laterSplitPattern matches the parts of a split model after the first,
+// which carry no metadata of their own.
+var laterSplitPattern = regexp.MustCompile(`-000(0[2-9]|[1-9]\d)-of-\d{5}\.gguf$`)
+
+// runInspect implements "ollama-dl inspect", which prints what the GGUF
+// header of a model says about it.
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	all := fs.Bool("a", false, "Print all metadata keys as well")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: ollama-dl inspect [-a] <file|dir>")
+		os.Exit(1)
+	}
+
+	files := []string{fs.Arg(0)}
+	if info, err := os.Stat(fs.Arg(0)); err != nil {
+		return err
+	} else if info.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(fs.Arg(0), "*.gguf"))
+		if err != nil {
+			return err
+		}
+		files = files[:0]
+		for _, match := range matches {
+			if !laterSplitPattern.MatchString(match) {
+				files = append(files, match)
+			}
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no GGUF files in %s", fs.Arg(0))
+		}
+	}
+
+	for i, name := range files {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := inspectFile(name, *all); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func inspectFile(name string, all bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	file, err := gguf.Read(f)
+	if err != nil {
+		return err
+	}
+
+	arch := file.Architecture()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	row := func(label string, value any) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s:\t%v\n", label, value)
+		}
+	}
+	number := func(key string) string {
+		if n, ok := file.Uint(key); ok {
+			return fmt.Sprint(n)
+		}
+		return ""
+	}
+	row("File", name)
+	row("Name", file.String("general.name"))
+	row("Architecture", arch)
+	row("Parameters", formatCount(file.ParameterCount()))
+	row("Quantization", file.FileType())
+	row("Context length", number(arch+".context_length"))
+	row("Embedding length", number(arch+".embedding_length"))
+	row("Layers", number(arch+".block_count"))
+	row("Attention heads", number(arch+".attention.head_count"))
+	row("KV heads", number(arch+".attention.head_count_kv"))
+	row("Tokenizer", tokenizerSummary(file))
+	row("Chat template", chatTemplateSummary(file))
+	row("Tensors", len(file.Tensors))
+	row("GGUF version", file.Version)
+	if count, ok := file.Uint("split.count"); ok && count > 1 {
+		// Each part only describes its own tensors.
+		index, _ := file.Uint("split.no")
+		row("Split", fmt.Sprintf("part %d of %d; parameters and tensors of this part only", index+1, count))
+	}
+	if all {
+		for _, kv := range file.Metadata {
+			row(kv.Key, metadataValue(kv.Value))
+		}
+	}
+	return tw.Flush()
+}
+
+// tokenizerSummary describes the tokenizer, e.g. "gpt2, 128256 tokens,
+// bos 128000, eos 128009".
+func tokenizerSummary(file *gguf.File) string {
+	model := file.String("tokenizer.ggml.model")
+	if model == "" {
+		return ""
+	}
+	parts := []string{model}
+	if tokens, ok := file.Get("tokenizer.ggml.tokens").(gguf.Array); ok {
+		parts = append(parts, fmt.Sprintf("%d tokens", tokens.Len))
+	}
+	for _, special := range []string{"bos", "eos"} {
+		if id, ok := file.Uint("tokenizer.ggml." + special + "_token_id"); ok {
+			parts = append(parts, fmt.Sprintf("%s %d", special, id))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// chatTemplateSummary says whether the file embeds a chat template.
+func chatTemplateSummary(file *gguf.File) string {
+	if template := file.String("tokenizer.chat_template"); template != "" {
+		return fmt.Sprintf("embedded (%d characters)", len(template))
+	}
+	return "none"
+}
+
+// metadataValue formats a metadata value, abbreviating long ones.
+func metadataValue(value any) string {
+	var s string
+	switch v := value.(type) {
+	case gguf.Array:
+		if v.Values == nil && v.Len > 0 {
+			return fmt.Sprintf("[%d values]", v.Len)
+		}
+		s = fmt.Sprint(v.Values)
+	case string:
+		s = fmt.Sprintf("%q", v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if len(s) > 80 {
+		s = s[:77] + "..."
+	}
+	return s
+}
+
+// formatCount formats a parameter count the way model names do, e.g. 3.2B.
+func formatCount(n uint64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.0fM", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.0fK", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
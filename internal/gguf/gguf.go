@@ -0,0 +1,315 @@
+// Package gguf reads the header of GGUF model files: the metadata and the
+// tensor descriptions that precede the tensor data.
+package gguf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Magic starts every GGUF file.
+const Magic = "GGUF"
+
+// ErrNotGGUF is returned for files that don't start with Magic.
+var ErrNotGGUF = errors.New("not a GGUF file")
+
+// maxArrayValues is how many values of an array are kept; longer arrays,
+// such as a tokenizer's vocabulary, only have their length recorded.
+const maxArrayValues = 64
+
+// Limits on sizes read from the header, so a corrupt file fails to parse
+// instead of exhausting memory.
+const (
+	maxStringLen = 1 << 24
+	maxCount     = 1 << 28
+	maxDims      = 8
+)
+
+// Array is a metadata value holding a list.
+type Array struct {
+	// Len is the number of values; Values holds them if there are no more
+	// than a few dozen.
+	Len    uint64
+	Values []any
+}
+
+// KV is a metadata key and value. Values are uint8 through int64, float32,
+// float64, bool, string or Array.
+type KV struct {
+	Key   string
+	Value any
+}
+
+// Tensor describes a tensor of the file.
+type Tensor struct {
+	Name string
+	Dims []uint64
+	Type uint32
+	// Offset is where the tensor's data starts, relative to the data
+	// section.
+	Offset uint64
+}
+
+// Elements returns the number of values of the tensor.
+func (t Tensor) Elements() uint64 {
+	n := uint64(1)
+	for _, dim := range t.Dims {
+		n *= dim
+	}
+	return n
+}
+
+// File is the header of a GGUF file.
+type File struct {
+	Version  uint32
+	Metadata []KV
+	Tensors  []Tensor
+}
+
+// Get returns the metadata value of key, or nil.
+func (f *File) Get(key string) any {
+	for _, kv := range f.Metadata {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return nil
+}
+
+// String returns the string value of key, or "".
+func (f *File) String(key string) string {
+	s, _ := f.Get(key).(string)
+	return s
+}
+
+// Uint returns the value of key if it is an unsigned or non-negative
+// integer.
+func (f *File) Uint(key string) (uint64, bool) {
+	switch v := f.Get(key).(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int8:
+		return uint64(v), v >= 0
+	case int16:
+		return uint64(v), v >= 0
+	case int32:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
+
+// Architecture returns general.architecture, e.g. llama.
+func (f *File) Architecture() string {
+	return f.String("general.architecture")
+}
+
+// ParameterCount returns the number of values in all the tensors.
+func (f *File) ParameterCount() uint64 {
+	var n uint64
+	for _, t := range f.Tensors {
+		n += t.Elements()
+	}
+	return n
+}
+
+// Read parses the header at the start of r.
+func Read(r io.Reader) (*File, error) {
+	p := &parser{r: bufio.NewReaderSize(r, 1<<16)}
+	var magic [4]byte
+	if _, err := io.ReadFull(p.r, magic[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotGGUF
+		}
+		return nil, err
+	}
+	if string(magic[:]) != Magic {
+		return nil, ErrNotGGUF
+	}
+
+	f := &File{Version: p.uint32()}
+	if p.err == nil && (f.Version < 1 || f.Version > 3) {
+		return nil, fmt.Errorf("unsupported GGUF version %d", f.Version)
+	}
+	p.v1 = f.Version == 1
+	tensors, kvs := p.count(), p.count()
+	for i := uint64(0); i < kvs && p.err == nil; i++ {
+		key := p.string()
+		f.Metadata = append(f.Metadata, KV{Key: key, Value: p.value(p.uint32())})
+	}
+	for i := uint64(0); i < tensors && p.err == nil; i++ {
+		t := Tensor{Name: p.string()}
+		dims := p.uint32()
+		if dims > maxDims {
+			p.fail(fmt.Errorf("tensor %s has %d dimensions", t.Name, dims))
+			break
+		}
+		for j := uint32(0); j < dims; j++ {
+			t.Dims = append(t.Dims, p.count())
+		}
+		t.Type = p.uint32()
+		t.Offset = p.uint64()
+		f.Tensors = append(f.Tensors, t)
+	}
+	if p.err != nil {
+		if errors.Is(p.err, io.EOF) {
+			p.err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("reading GGUF header: %w", p.err)
+	}
+	return f, nil
+}
+
+// parser reads the little-endian values of a header, remembering the first
+// error.
+type parser struct {
+	r   *bufio.Reader
+	v1  bool
+	err error
+	buf [8]byte
+}
+
+func (p *parser) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *parser) read(n int) []byte {
+	if p.err != nil {
+		return make([]byte, n)
+	}
+	if _, err := io.ReadFull(p.r, p.buf[:n]); err != nil {
+		p.fail(err)
+	}
+	return p.buf[:n]
+}
+
+func (p *parser) uint32() uint32 { return binary.LittleEndian.Uint32(p.read(4)) }
+func (p *parser) uint64() uint64 { return binary.LittleEndian.Uint64(p.read(8)) }
+
+// count reads a length, which version 1 stores in 32 bits.
+func (p *parser) count() uint64 {
+	var n uint64
+	if p.v1 {
+		n = uint64(p.uint32())
+	} else {
+		n = p.uint64()
+	}
+	if n > maxCount {
+		p.fail(fmt.Errorf("implausible count %d", n))
+		return 0
+	}
+	return n
+}
+
+func (p *parser) string() string {
+	n := p.count()
+	if n > maxStringLen {
+		p.fail(fmt.Errorf("implausible string length %d", n))
+	}
+	if p.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		p.fail(err)
+	}
+	return string(b)
+}
+
+// Value types.
+const (
+	typeUint8 uint32 = iota
+	typeInt8
+	typeUint16
+	typeInt16
+	typeUint32
+	typeInt32
+	typeFloat32
+	typeBool
+	typeString
+	typeArray
+	typeUint64
+	typeInt64
+	typeFloat64
+)
+
+func (p *parser) value(typ uint32) any {
+	switch typ {
+	case typeUint8:
+		return p.read(1)[0]
+	case typeInt8:
+		return int8(p.read(1)[0])
+	case typeUint16:
+		return binary.LittleEndian.Uint16(p.read(2))
+	case typeInt16:
+		return int16(binary.LittleEndian.Uint16(p.read(2)))
+	case typeUint32:
+		return p.uint32()
+	case typeInt32:
+		return int32(p.uint32())
+	case typeFloat32:
+		return math.Float32frombits(p.uint32())
+	case typeBool:
+		return p.read(1)[0] != 0
+	case typeString:
+		return p.string()
+	case typeArray:
+		elem := p.uint32()
+		if elem == typeArray {
+			p.fail(errors.New("nested arrays are not supported"))
+			return nil
+		}
+		a := Array{Len: p.count()}
+		for i := uint64(0); i < a.Len && p.err == nil; i++ {
+			v := p.value(elem)
+			if a.Len <= maxArrayValues {
+				a.Values = append(a.Values, v)
+			}
+		}
+		return a
+	case typeUint64:
+		return p.uint64()
+	case typeInt64:
+		return int64(p.uint64())
+	case typeFloat64:
+		return math.Float64frombits(p.uint64())
+	}
+	p.fail(fmt.Errorf("unknown value type %d", typ))
+	return nil
+}
+
+// fileTypes names the values of general.file_type, as llama.cpp does.
+var fileTypes = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S",
+	15: "Q4_K_M", 16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS",
+	20: "IQ2_XS", 21: "Q2_K_S", 22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S",
+	25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M", 28: "IQ2_S", 29: "IQ2_M",
+	30: "IQ4_XS", 31: "IQ1_M", 32: "BF16", 36: "TQ1_0", 37: "TQ2_0",
+}
+
+// FileType returns the name of the file's quantization, from
+// general.file_type, e.g. Q4_K_M, or "" if it doesn't say.
+func (f *File) FileType() string {
+	t, ok := f.Uint("general.file_type")
+	if !ok {
+		return ""
+	}
+	if name, ok := fileTypes[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", t)
+}
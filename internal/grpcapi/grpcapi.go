@@ -0,0 +1,214 @@
+// Package grpcapi serves the daemon's gRPC control API, defined in
+// api/ollamadl.proto, on top of net/http's HTTP/2 support.
+package grpcapi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/jobs"
+)
+
+// ServicePrefix is the path prefix of the service's methods.
+const ServicePrefix = "/ollamadl.v1.Downloader/"
+
+// maxMessageSize bounds request messages, which only carry a few strings.
+const maxMessageSize = 1 << 20
+
+// progressInterval is the minimum time between GetProgress updates.
+const progressInterval = 500 * time.Millisecond
+
+// gRPC status codes used by the service.
+const (
+	codeOK               = 0
+	codeCanceled         = 1
+	codeInvalidArgument  = 3
+	codeNotFound         = 5
+	codePermissionDenied = 7
+	codeUnimplemented    = 12
+	codeInternal         = 13
+)
+
+// statusError is an error with a gRPC status code.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+func errorf(code int, format string, args ...any) error {
+	return &statusError{code, fmt.Sprintf(format, args...)}
+}
+
+// Handler serves the gRPC service for a job manager. Requests must arrive
+// over HTTP/2, either with TLS or as h2c.
+type Handler struct {
+	jobs *jobs.Manager
+}
+
+// NewHandler returns a Handler for m.
+func NewHandler(m *jobs.Manager) *Handler {
+	return &Handler{jobs: m}
+}
+
+// call is a single RPC: it reads the request message from req and writes
+// response messages with send.
+type call func(ctx context.Context, req []byte, send func([]byte) error) error
+
+func (h *Handler) methods() map[string]call {
+	return map[string]call{
+		"StartPull":   h.startPull,
+		"GetProgress": h.getProgress,
+		"Cancel":      h.cancel,
+		"List":        h.list,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 ||
+		!strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	err := h.serve(w, r)
+	code, msg := codeOK, ""
+	if err != nil {
+		var se *statusError
+		switch {
+		case errors.As(err, &se):
+			code, msg = se.code, se.msg
+		case errors.Is(err, context.Canceled):
+			code, msg = codeCanceled, err.Error()
+		default:
+			code, msg = codeInternal, err.Error()
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", percentEncode(msg))
+	}
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	name, ok := strings.CutPrefix(r.URL.Path, ServicePrefix)
+	method := h.methods()[name]
+	if !ok || method == nil {
+		return errorf(codeUnimplemented, "unknown method %s", r.URL.Path)
+	}
+
+	req, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+
+	rc := http.NewResponseController(w)
+	send := func(msg []byte) error {
+		frame := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		if _, err := w.Write(append(frame, msg...)); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	return method(r.Context(), req, send)
+}
+
+// percentEncode escapes a status message for the Grpc-Message trailer, which
+// allows printable ASCII other than '%'.
+func percentEncode(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// readMessage reads the single length-prefixed message of a unary or
+// server-streaming call.
+func readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, errorf(codeInvalidArgument, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errorf(codeUnimplemented, "compressed requests are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return nil, errorf(codeInvalidArgument, "request of %d bytes is too large", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errorf(codeInvalidArgument, "reading request: %v", err)
+	}
+	return msg, nil
+}
+
+// jobError maps job manager errors to gRPC statuses.
+func jobError(err error) error {
+	if errors.Is(err, jobs.ErrNotFound) {
+		return errorf(codeNotFound, "%v", err)
+	}
+	return err
+}
+
+func (h *Handler) startPull(_ context.Context, req []byte, send func([]byte) error) error {
+	fields, err := stringFields(req)
+	if err != nil {
+		return errorf(codeInvalidArgument, "%v", err)
+	}
+	if fields[1] == "" {
+		return errorf(codeInvalidArgument, "model is required")
+	}
+	job, err := h.jobs.StartClient(fields[1], fields[2])
+	if errors.Is(err, jobs.ErrDestNotAllowed) {
+		return errorf(codePermissionDenied, "%v", err)
+	}
+	if err != nil {
+		return errorf(codeInvalidArgument, "%v", err)
+	}
+	return send(marshalJob(job))
+}
+
+func (h *Handler) getProgress(ctx context.Context, req []byte, send func([]byte) error) error {
+	fields, err := stringFields(req)
+	if err != nil {
+		return errorf(codeInvalidArgument, "%v", err)
+	}
+	return jobError(h.jobs.Watch(ctx, fields[1], progressInterval, func(job jobs.Job) error {
+		return send(marshalJob(job))
+	}))
+}
+
+func (h *Handler) cancel(_ context.Context, req []byte, send func([]byte) error) error {
+	fields, err := stringFields(req)
+	if err != nil {
+		return errorf(codeInvalidArgument, "%v", err)
+	}
+	job, err := h.jobs.Cancel(fields[1])
+	if err != nil {
+		return jobError(err)
+	}
+	return send(marshalJob(job))
+}
+
+func (h *Handler) list(_ context.Context, req []byte, send func([]byte) error) error {
+	return send(marshalJobList(h.jobs.List()))
+}
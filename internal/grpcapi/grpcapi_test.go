@@ -0,0 +1,108 @@
+package grpcapi
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dimchansky/ollama-dl-go/internal/jobs"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+func TestReadMessage(t *testing.T) {
+	msg, err := readMessage(bytes.NewReader(unhex(t, "00 00000003 0a0161 ff")))
+	if err != nil || !bytes.Equal(msg, unhex(t, "0a0161")) {
+		t.Fatalf("readMessage() = %x, %v", msg, err)
+	}
+
+	tests := []struct {
+		name  string
+		frame string
+		code  int
+	}{
+		{"empty", "", codeInvalidArgument},
+		{"short prefix", "0000", codeInvalidArgument},
+		{"compressed", "01 00000000", codeUnimplemented},
+		{"too large", "00 00100001", codeInvalidArgument},
+		{"short message", "00 00000003 0a01", codeInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readMessage(bytes.NewReader(unhex(t, tt.frame)))
+			var se *statusError
+			if !errors.As(err, &se) || se.code != tt.code {
+				t.Errorf("readMessage() error = %v, want code %d", err, tt.code)
+			}
+		})
+	}
+}
+
+// invoke makes a gRPC call of method with the message msg, returning the
+// response body and the Grpc-Status and Grpc-Message trailers.
+func invoke(t *testing.T, h http.Handler, method string, msg []byte) (body []byte, status, message string) {
+	t.Helper()
+	frame := append(unhex(t, "00"), byte(len(msg)>>24), byte(len(msg)>>16), byte(len(msg)>>8), byte(len(msg)))
+	req := httptest.NewRequest(http.MethodPost, ServicePrefix+method, bytes.NewReader(append(frame, msg...)))
+	req.ProtoMajor, req.ProtoMinor = 2, 0
+	req.Header.Set("Content-Type", "application/grpc")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	resp := rec.Result()
+	body, _ = io.ReadAll(resp.Body)
+	return body, resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+}
+
+func newTestHandler() *Handler {
+	opener := func(dest string, ref ollamadl.Reference) (ollamadl.BlobStore, string, error) {
+		return ollamadl.NewFileStore(dest), ref.DirName(), nil
+	}
+	return NewHandler(jobs.NewManager(ollamadl.Options{}, opener, 1))
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := newTestHandler()
+
+	body, status, _ := invoke(t, h, "List", nil)
+	if status != "0" || !bytes.Equal(body, unhex(t, "00 00000000")) {
+		t.Errorf("List = %x, status %s; want an empty message", body, status)
+	}
+
+	_, status, message := invoke(t, h, "StartPull", unhex(t, "120178"))
+	if status != "3" || message != "model is required" {
+		t.Errorf("StartPull without a model: status %s %q", status, message)
+	}
+
+	_, status, message = invoke(t, h, "GetProgress", unhex(t, "0a0178"))
+	if status != "5" {
+		t.Errorf("GetProgress of an unknown job: status %s %q", status, message)
+	}
+
+	_, status, _ = invoke(t, h, "Delete", nil)
+	if status != "12" {
+		t.Errorf("unknown method: status %s, want 12", status)
+	}
+
+	_, status, message = invoke(t, h, "StartPull", unhex(t, "0b"))
+	if status != "3" || message != "malformed protobuf message" {
+		t.Errorf("malformed request: status %s %q", status, message)
+	}
+}
+
+func TestServeHTTPRejectsOtherRequests(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, ServicePrefix+"List", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	rec := httptest.NewRecorder()
+	newTestHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("HTTP/1.1 request: status %d", rec.Code)
+	}
+}
+
+func TestPercentEncode(t *testing.T) {
+	if got, want := percentEncode("100% done\nnext: ü"), "100%25 done%0Anext: %C3%BC"; got != want {
+		t.Errorf("percentEncode() = %q, want %q", got, want)
+	}
+}
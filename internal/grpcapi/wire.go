@@ -0,0 +1,160 @@
+package grpcapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/jobs"
+)
+
+// The messages of api/ollamadl.proto are few and flat, so they are encoded
+// by hand rather than pulling in the protobuf runtime.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformed = errors.New("malformed protobuf message")
+
+// encoder appends protobuf fields to a buffer. Zero values are omitted, as
+// proto3 does.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field, wire int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+func (e *encoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) int64(field int, v int64) {
+	e.varint(field, uint64(v))
+}
+
+func (e *encoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// message encodes a nested message. Unlike scalars, an empty message is still
+// written, which matters for repeated fields.
+func (e *encoder) message(field int, fn func(*encoder)) {
+	var sub encoder
+	fn(&sub)
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(sub.buf)))
+	e.buf = append(e.buf, sub.buf...)
+}
+
+// timestamp encodes a google.protobuf.Timestamp, omitting unset times.
+func (e *encoder) timestamp(field int, t *time.Time) {
+	if t == nil {
+		return
+	}
+	e.message(field, func(e *encoder) {
+		e.int64(1, t.Unix())
+		e.int64(2, int64(t.Nanosecond()))
+	})
+}
+
+var stateNumbers = map[jobs.State]uint64{
+	jobs.Queued:    1,
+	jobs.Running:   2,
+	jobs.Done:      3,
+	jobs.Failed:    4,
+	jobs.Cancelled: 5,
+}
+
+func encodeJob(e *encoder, j jobs.Job) {
+	done, total := j.Progress()
+	e.string(1, j.ID)
+	e.string(2, j.Model)
+	e.string(3, j.Dest)
+	e.varint(4, stateNumbers[j.State])
+	e.string(5, j.Error)
+	e.int64(6, done)
+	e.int64(7, total)
+	for _, l := range j.Layers {
+		e.message(8, func(e *encoder) {
+			e.string(1, l.Path)
+			e.string(2, l.Digest)
+			e.int64(3, l.Size)
+			e.int64(4, l.Done)
+			e.varint(5, stateNumbers[l.State])
+		})
+	}
+	e.timestamp(9, &j.Created)
+	e.timestamp(10, j.Started)
+	e.timestamp(11, j.Finished)
+}
+
+func marshalJob(j jobs.Job) []byte {
+	var e encoder
+	encodeJob(&e, j)
+	return e.buf
+}
+
+func marshalJobList(list []jobs.Job) []byte {
+	var e encoder
+	for _, j := range list {
+		e.message(1, func(e *encoder) { encodeJob(e, j) })
+	}
+	return e.buf
+}
+
+// stringFields decodes a message whose fields of interest are all strings,
+// returning them by field number. Other fields are skipped.
+func stringFields(msg []byte) (map[int]string, error) {
+	fields := make(map[int]string)
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errMalformed
+		}
+		msg = msg[n:]
+		field, wire := int(key>>3), int(key&7)
+		if field == 0 {
+			return nil, errMalformed
+		}
+
+		switch wire {
+		case wireVarint:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return nil, errMalformed
+			}
+		case wireFixed64:
+			n = 8
+		case wireFixed32:
+			n = 4
+		case wireBytes:
+			size, m := binary.Uvarint(msg)
+			if m <= 0 || size > uint64(len(msg)-m) {
+				return nil, errMalformed
+			}
+			fields[field] = string(msg[m : m+int(size)])
+			n = m + int(size)
+		default:
+			return nil, errMalformed
+		}
+		if n > len(msg) {
+			return nil, errMalformed
+		}
+		msg = msg[n:]
+	}
+	return fields, nil
+}
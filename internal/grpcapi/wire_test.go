@@ -0,0 +1,126 @@
+package grpcapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/jobs"
+)
+
+// unhex decodes a byte vector written as hex with spaces between fields.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMarshalJob(t *testing.T) {
+	finished := time.Unix(1, 0)
+	tests := []struct {
+		name string
+		job  jobs.Job
+		want string
+	}{
+		{
+			name: "running",
+			job: jobs.Job{
+				ID:      "1",
+				Model:   "m",
+				State:   jobs.Running,
+				Created: time.Unix(1700000000, 5),
+				Layers:  []jobs.Layer{{Path: "p", Digest: "d", Size: 300, Done: 1, State: jobs.Running}},
+			},
+			want: "0a0131 12016d 2002 3001 38ac02" +
+				" 420d 0a0170 120164 18ac02 2001 2802" +
+				" 4a08 0880e2cfaa06 1005",
+		},
+		{
+			// Times before 1970 have negative seconds, ten bytes long.
+			name: "failed",
+			job:  jobs.Job{ID: "2", Dest: "d", State: jobs.Failed, Error: "boom", Finished: &finished},
+			want: "0a0132 1a0164 2004 2a04626f6f6d" +
+				" 4a0b 088092b8c398feffffff01" +
+				" 5a02 0801",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := marshalJob(tt.job), unhex(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("marshalJob() = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestMarshalJobList(t *testing.T) {
+	if got := marshalJobList(nil); len(got) != 0 {
+		t.Errorf("marshalJobList(nil) = %x, want nothing", got)
+	}
+	// An empty timestamp is still written, as a message.
+	got := marshalJobList([]jobs.Job{{ID: "x", Created: time.Unix(0, 0)}, {ID: "y", Created: time.Unix(0, 0)}})
+	if want := unhex(t, "0a05 0a0178 4a00 0a05 0a0179 4a00"); !bytes.Equal(got, want) {
+		t.Errorf("marshalJobList() = %x, want %x", got, want)
+	}
+}
+
+func TestStringFields(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want map[int]string
+	}{
+		{"empty", "", map[int]string{}},
+		{"StartPullRequest", "0a066c6c616d6133 120178", map[int]string{1: "llama3", 2: "x"}},
+		{
+			name: "unknown fields",
+			msg:  "189601 210102030405060708 2d01020304 0a0161",
+			want: map[int]string{1: "a"},
+		},
+		{"last value wins", "0a0161 0a0162", map[int]string{1: "b"}},
+		{"empty string", "0a00", map[int]string{1: ""}},
+		{"high field number", "a2060161", map[int]string{100: "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stringFields(unhex(t, tt.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stringFields() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStringFieldsMalformed(t *testing.T) {
+	tests := []struct{ name, msg string }{
+		{"truncated key", "80"},
+		{"truncated varint", "0880"},
+		{"varint overflow", "08ffffffffffffffffffff01"},
+		{"truncated length", "0a"},
+		{"string past end", "0a0561"},
+		{"huge length", "0affffffffffffffffff01"},
+		{"truncated fixed64", "210102"},
+		{"truncated fixed32", "2d01"},
+		{"start group", "0b"},
+		{"end group", "0c"},
+		{"wire type 6", "0e"},
+		{"field 0", "0200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stringFields(unhex(t, tt.msg)); !errors.Is(err, errMalformed) {
+				t.Errorf("stringFields(%s) error = %v, want errMalformed", tt.msg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Package health reports whether downloads are making progress, over HTTP,
+// so orchestrators can tell a slow pull from a hung one.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// Health follows the layer transfers of Downloaders configured with
+// Instrument.
+type Health struct {
+	stall time.Duration
+
+	mu        sync.Mutex
+	last      time.Time
+	bytes     int64
+	completed int
+	failed    int
+	active    map[string]*Layer
+}
+
+// Layer is a layer being downloaded.
+type Layer struct {
+	Path     string `json:"path"`
+	Received int64  `json:"received"`
+	Size     int64  `json:"size"`
+}
+
+// Status is the JSON body served by Health.
+type Status struct {
+	// Status is "downloading", "idle" when no layer is being downloaded,
+	// or "stalled" when layers are but no data arrived for the stall
+	// timeout.
+	Status       string    `json:"status"`
+	LastActivity time.Time `json:"lastActivity"`
+	// Bytes is how much layer data was received in all.
+	Bytes     int64   `json:"bytes"`
+	Completed int     `json:"completed"`
+	Failed    int     `json:"failed"`
+	Active    []Layer `json:"active"`
+}
+
+// New returns a Health that considers transfers stalled once no data has
+// arrived for stall.
+func New(stall time.Duration) *Health {
+	return &Health{stall: stall, last: time.Now(), active: make(map[string]*Layer)}
+}
+
+// Instrument returns opts with a progress reporter that records activity,
+// passing progress on to the one already set.
+func (h *Health) Instrument(opts ollamadl.Options) ollamadl.Options {
+	opts.Progress = &reporter{h: h, next: opts.Progress}
+	return opts
+}
+
+// Status returns the current status.
+func (h *Health) Status() Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := Status{
+		Status:       "idle",
+		LastActivity: h.last.UTC(),
+		Bytes:        h.bytes,
+		Completed:    h.completed,
+		Failed:       h.failed,
+		Active:       []Layer{},
+	}
+	for _, l := range h.active {
+		s.Active = append(s.Active, *l)
+	}
+	sort.Slice(s.Active, func(i, j int) bool { return s.Active[i].Path < s.Active[j].Path })
+	switch {
+	case len(s.Active) > 0 && time.Since(h.last) > h.stall:
+		s.Status = "stalled"
+	case len(s.Active) > 0:
+		s.Status = "downloading"
+	}
+	return s
+}
+
+// ServeHTTP writes the Status as JSON, with 503 Service Unavailable when
+// transfers are stalled and 200 OK otherwise.
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.Status()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if s.Status == "stalled" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+// reporter records activity, passing progress on to next if set.
+type reporter struct {
+	h    *Health
+	next ollamadl.ProgressReporter
+}
+
+func (r *reporter) LayerStarted(job ollamadl.DownloadJob, offset int64) {
+	r.h.mu.Lock()
+	r.h.last = time.Now()
+	r.h.active[job.DestPath] = &Layer{Path: job.DestPath, Received: offset, Size: job.Size}
+	r.h.mu.Unlock()
+	if r.next != nil {
+		r.next.LayerStarted(job, offset)
+	}
+}
+
+func (r *reporter) BytesWritten(job ollamadl.DownloadJob, n int64) {
+	r.h.mu.Lock()
+	r.h.last = time.Now()
+	r.h.bytes += n
+	if l, ok := r.h.active[job.DestPath]; ok {
+		l.Received += n
+	}
+	r.h.mu.Unlock()
+	if r.next != nil {
+		r.next.BytesWritten(job, n)
+	}
+}
+
+func (r *reporter) LayerCompleted(job ollamadl.DownloadJob) {
+	r.finish(job, true)
+	if r.next != nil {
+		r.next.LayerCompleted(job)
+	}
+}
+
+func (r *reporter) LayerFailed(job ollamadl.DownloadJob, err error) {
+	r.finish(job, false)
+	if r.next != nil {
+		r.next.LayerFailed(job, err)
+	}
+}
+
+func (r *reporter) finish(job ollamadl.DownloadJob, ok bool) {
+	r.h.mu.Lock()
+	defer r.h.mu.Unlock()
+	r.h.last = time.Now()
+	delete(r.h.active, job.DestPath)
+	if ok {
+		r.h.completed++
+	} else {
+		r.h.failed++
+	}
+}
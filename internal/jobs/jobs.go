@@ -0,0 +1,447 @@
+// Package jobs runs pulls in the background for the daemon's APIs: a queue
+// of pulls with per-layer progress that can be listed, watched and
+// cancelled.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/tracing"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// State is the lifecycle state of a job or of one of its layers.
+type State string
+
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Done      State = "done"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+)
+
+// Finished reports whether s is a final state.
+func (s State) Finished() bool {
+	return s == Done || s == Failed || s == Cancelled
+}
+
+// ErrNotFound is returned for unknown job IDs.
+var ErrNotFound = errors.New("job not found")
+
+// Layer is the progress of one file of a job.
+type Layer struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Done   int64  `json:"done"`
+	State  State  `json:"state"`
+}
+
+// Job is a snapshot of a pull.
+type Job struct {
+	ID       string     `json:"id"`
+	Model    string     `json:"model"`
+	Dest     string     `json:"dest,omitempty"`
+	State    State      `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Layers   []Layer    `json:"layers"`
+}
+
+// Progress returns the total size of the job's layers and how much of it is
+// downloaded. The total is 0 until the manifest has been resolved.
+func (j Job) Progress() (done, total int64) {
+	for _, l := range j.Layers {
+		done += l.Done
+		total += l.Size
+	}
+	return done, total
+}
+
+// Defaults for how long a Manager remembers finished jobs.
+const (
+	DefaultRetention   = 24 * time.Hour
+	DefaultMaxFinished = 1000
+)
+
+// StoreOpener returns the store for a destination and the directory within it
+// a model's files go to.
+type StoreOpener func(dest string, ref ollamadl.Reference) (ollamadl.BlobStore, string, error)
+
+// Manager queues pulls and runs up to MaxActive of them at a time.
+type Manager struct {
+	// Tracer, if set before any job starts, records each pull as a trace.
+	Tracer *tracing.Tracer
+	// ClientDests confines the destinations of pulls started with
+	// StartClient.
+	ClientDests DestRoot
+	// Retention is how long finished jobs are kept, and MaxFinished how
+	// many of them at most; older ones are forgotten. Zero keeps them all.
+	Retention   time.Duration
+	MaxFinished int
+
+	options   ollamadl.Options
+	openStore StoreOpener
+	slots     chan struct{}
+
+	mu     sync.Mutex
+	nextID int
+	jobs   map[string]*job
+}
+
+// job is the manager's record of a pull. Its fields are guarded by the
+// manager's mutex; changed is closed and replaced on every update so
+// watchers can wait for the next one.
+type job struct {
+	snap    Job
+	cancel  context.CancelFunc
+	changed chan struct{}
+}
+
+// NewManager returns a Manager that pulls with options, opening destinations
+// with openStore, and runs at most maxActive pulls at once.
+func NewManager(options ollamadl.Options, openStore StoreOpener, maxActive int) *Manager {
+	if maxActive < 1 {
+		maxActive = 1
+	}
+	return &Manager{
+		options:   options,
+		openStore: openStore,
+		slots:     make(chan struct{}, maxActive),
+		jobs:      make(map[string]*job),
+
+		Retention:   DefaultRetention,
+		MaxFinished: DefaultMaxFinished,
+	}
+}
+
+// Start queues a pull of model into dest and returns its initial snapshot.
+func (m *Manager) Start(model, dest string) (Job, error) {
+	ref, err := ollamadl.ParseReference(model)
+	if err != nil {
+		return Job{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.expire(time.Now())
+	m.nextID++
+	j := &job{
+		snap: Job{
+			ID:      strconv.Itoa(m.nextID),
+			Model:   ref.String(),
+			Dest:    dest,
+			State:   Queued,
+			Created: time.Now(),
+			Layers:  []Layer{},
+		},
+		cancel:  cancel,
+		changed: make(chan struct{}),
+	}
+	m.jobs[j.snap.ID] = j
+	snap := j.snapshot()
+	m.mu.Unlock()
+
+	go m.run(ctx, j, ref, dest)
+	return snap, nil
+}
+
+// StartClient queues a pull requested by an API client, whose dest is
+// confined by m.ClientDests.
+func (m *Manager) StartClient(model, dest string) (Job, error) {
+	ref, err := ollamadl.ParseReference(model)
+	if err != nil {
+		return Job{}, err
+	}
+	if dest, err = m.ClientDests.Resolve(dest, ref); err != nil {
+		return Job{}, err
+	}
+	return m.Start(model, dest)
+}
+
+// DestRoot limits where API clients may pull to.
+type DestRoot struct {
+	// Dir is the directory client destinations are relative to; empty
+	// means the current directory.
+	Dir string
+	// AllowAny lets clients name any directory or storage URL, as -d does.
+	AllowAny bool
+}
+
+// ErrDestNotAllowed is returned for client destinations outside the root.
+var ErrDestNotAllowed = errors.New("destination is not allowed")
+
+// Resolve returns the destination to pull ref into for a client's dest: a
+// relative path within r.Dir, or the model's default directory there if
+// dest is empty. The check is lexical, so symbolic links inside Dir are
+// followed.
+func (r DestRoot) Resolve(dest string, ref ollamadl.Reference) (string, error) {
+	if r.AllowAny {
+		return dest, nil
+	}
+	if dest == "" {
+		dest = ref.DirName()
+	}
+	if !filepath.IsLocal(dest) || strings.Contains(dest, "://") {
+		return "", fmt.Errorf("%w: %q must be a relative path inside the daemon's destination root", ErrDestNotAllowed, dest)
+	}
+	return filepath.Join(r.Dir, dest), nil
+}
+
+// Get returns a snapshot of the job with the given ID.
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// List returns snapshots of all jobs, oldest first.
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(time.Now())
+	list := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, j.snapshot())
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Created.Before(list[b].Created) })
+	return list
+}
+
+// Cancel stops a queued or running job. Partial downloads are kept, so
+// starting the same pull again resumes it.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	j.cancel()
+	return m.Get(id)
+}
+
+// Watch calls fn with a snapshot of the job now and after later changes,
+// at most once per interval, until the job finishes, fn returns an error or
+// ctx is done.
+func (m *Manager) Watch(ctx context.Context, id string, interval time.Duration, fn func(Job) error) error {
+	for {
+		m.mu.Lock()
+		j, ok := m.jobs[id]
+		if !ok {
+			m.mu.Unlock()
+			return ErrNotFound
+		}
+		snap, changed := j.snapshot(), j.changed
+		m.mu.Unlock()
+
+		if err := fn(snap); err != nil {
+			return err
+		}
+		if snap.State.Finished() {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// expire forgets finished jobs that are older than m.Retention or beyond the
+// newest m.MaxFinished. The caller holds m.mu.
+func (m *Manager) expire(now time.Time) {
+	var finished []*job
+	for id, j := range m.jobs {
+		if j.snap.Finished == nil {
+			continue
+		}
+		if m.Retention > 0 && now.Sub(*j.snap.Finished) > m.Retention {
+			delete(m.jobs, id)
+			continue
+		}
+		finished = append(finished, j)
+	}
+	if m.MaxFinished <= 0 || len(finished) <= m.MaxFinished {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].snap.Finished.After(*finished[b].snap.Finished) })
+	for _, j := range finished[m.MaxFinished:] {
+		delete(m.jobs, j.snap.ID)
+	}
+}
+
+func (j *job) snapshot() Job {
+	snap := j.snap
+	snap.Layers = append([]Layer{}, j.snap.Layers...)
+	return snap
+}
+
+// update changes a job under the lock and wakes its watchers.
+func (m *Manager) update(j *job, fn func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&j.snap)
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// updateLayer changes the layer stored at path.
+func (m *Manager) updateLayer(j *job, path string, fn func(*Layer)) {
+	m.update(j, func(snap *Job) {
+		for i := range snap.Layers {
+			if snap.Layers[i].Path == path {
+				fn(&snap.Layers[i])
+			}
+		}
+	})
+}
+
+func (m *Manager) run(ctx context.Context, j *job, ref ollamadl.Reference, dest string) {
+	defer j.cancel()
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		m.finish(j, ctx.Err())
+		return
+	}
+	m.update(j, func(snap *Job) {
+		snap.State = Running
+		now := time.Now()
+		snap.Started = &now
+	})
+
+	m.finish(j, m.pull(ctx, j, ref, dest))
+}
+
+func (m *Manager) pull(ctx context.Context, j *job, ref ollamadl.Reference, dest string) error {
+	store, dir, err := m.openStore(dest, ref)
+	if err != nil {
+		return err
+	}
+
+	// The job's own tracking comes on top of any progress reporter and hooks
+	// in the manager's options.
+	opts := m.options
+	opts.Store = store
+	opts.Progress = &reporter{m: m, j: j, next: m.options.Progress}
+	opts.Hooks.OnManifestResolved = func(ctx context.Context, res *ollamadl.Resolution) error {
+		if next := m.options.Hooks.OnManifestResolved; next != nil {
+			if err := next(ctx, res); err != nil {
+				return err
+			}
+		}
+		m.update(j, func(snap *Job) {
+			snap.Layers = snap.Layers[:0]
+			for _, dj := range res.Jobs {
+				snap.Layers = append(snap.Layers, Layer{Path: dj.DestPath, Digest: dj.Layer.Digest, Size: dj.Size, State: Queued})
+			}
+		})
+		return nil
+	}
+
+	d, err := ollamadl.New(m.Tracer.Instrument(opts))
+	if err != nil {
+		return err
+	}
+	ctx, span := m.Tracer.Start(ctx, "pull", "ollamadl.model", ref.String(), "ollamadl.job", j.snap.ID)
+	_, err = d.Pull(ctx, ref, dir)
+	span.End(err)
+	return err
+}
+
+// finish records the outcome of a job. Layers that were never downloaded
+// because they were already present count as done on success.
+func (m *Manager) finish(j *job, err error) {
+	m.update(j, func(snap *Job) {
+		now := time.Now()
+		snap.Finished = &now
+		switch {
+		case err == nil:
+			snap.State = Done
+			for i := range snap.Layers {
+				if l := &snap.Layers[i]; l.State == Queued {
+					l.State, l.Done = Done, l.Size
+				}
+			}
+		case errors.Is(err, context.Canceled):
+			snap.State = Cancelled
+		default:
+			snap.State = Failed
+			snap.Error = err.Error()
+		}
+	})
+}
+
+// reporter feeds download progress into a job, passing it on to next if set.
+type reporter struct {
+	m    *Manager
+	j    *job
+	next ollamadl.ProgressReporter
+}
+
+func (r *reporter) LayerStarted(dj ollamadl.DownloadJob, offset int64) {
+	if r.next != nil {
+		r.next.LayerStarted(dj, offset)
+	}
+	r.m.updateLayer(r.j, dj.DestPath, func(l *Layer) {
+		l.State, l.Done = Running, offset
+	})
+}
+
+func (r *reporter) BytesWritten(dj ollamadl.DownloadJob, n int64) {
+	if r.next != nil {
+		r.next.BytesWritten(dj, n)
+	}
+	r.m.updateLayer(r.j, dj.DestPath, func(l *Layer) { l.Done += n })
+}
+
+func (r *reporter) LayerCompleted(dj ollamadl.DownloadJob) {
+	if r.next != nil {
+		r.next.LayerCompleted(dj)
+	}
+	r.m.updateLayer(r.j, dj.DestPath, func(l *Layer) {
+		l.State, l.Done = Done, l.Size
+	})
+}
+
+func (r *reporter) LayerFailed(dj ollamadl.DownloadJob, err error) {
+	if r.next != nil {
+		r.next.LayerFailed(dj, err)
+	}
+	state := Failed
+	if errors.Is(err, context.Canceled) {
+		state = Cancelled
+	}
+	r.m.updateLayer(r.j, dj.DestPath, func(l *Layer) { l.State = state })
+}
+
+// String describes a job for logs.
+func (j Job) String() string {
+	done, total := j.Progress()
+	return fmt.Sprintf("job %s %s (%s, %d/%d bytes)", j.ID, j.Model, j.State, done, total)
+}
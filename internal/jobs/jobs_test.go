@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+func TestDestRootResolve(t *testing.T) {
+	ref, err := ollamadl.ParseReference("llama3.2:3b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := DestRoot{Dir: "/models"}
+
+	tests := []struct {
+		dest, want string
+	}{
+		{"", filepath.Join("/models", ref.DirName())},
+		{"llama", filepath.Join("/models", "llama")},
+		{"team/llama", filepath.Join("/models", "team", "llama")},
+		{"a/../b", filepath.Join("/models", "b")},
+	}
+	for _, tt := range tests {
+		got, err := root.Resolve(tt.dest, ref)
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.dest, got, err, tt.want)
+		}
+	}
+
+	for _, dest := range []string{"/etc", "../outside", "a/../../b", "s3://bucket/models", "sftp://host/x"} {
+		if got, err := root.Resolve(dest, ref); !errors.Is(err, ErrDestNotAllowed) {
+			t.Errorf("Resolve(%q) = %q, %v; want ErrDestNotAllowed", dest, got, err)
+		}
+	}
+
+	any := DestRoot{Dir: "/models", AllowAny: true}
+	if got, err := any.Resolve("s3://bucket/models", ref); err != nil || got != "s3://bucket/models" {
+		t.Errorf("Resolve with AllowAny = %q, %v", got, err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	m := NewManager(ollamadl.Options{}, nil, 1)
+	m.Retention, m.MaxFinished = time.Hour, 2
+	for id, finished := range map[string]*time.Time{
+		"running": nil,
+		"old":     at(2 * time.Hour),
+		"1m":      at(time.Minute),
+		"2m":      at(2 * time.Minute),
+		"3m":      at(3 * time.Minute),
+	} {
+		m.jobs[id] = &job{snap: Job{ID: id, Finished: finished}}
+	}
+
+	m.expire(now)
+	var got []string
+	for id := range m.jobs {
+		got = append(got, id)
+	}
+	sort.Strings(got)
+	if want := []string{"1m", "2m", "running"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("jobs after expire = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,186 @@
+// Package metrics records download metrics and serves them in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the layer duration
+// histogram: layers range from bytes of template to tens of gigabytes of
+// weights.
+var durationBuckets = []float64{0.1, 1, 5, 15, 60, 300, 900, 3600, 10800}
+
+// Metrics collects download metrics from the pulls of Downloaders configured
+// with Instrument.
+type Metrics struct {
+	mu        sync.Mutex
+	bytes     int64
+	retries   int64
+	completed int64
+	failed    int64
+	started   map[string]time.Time
+
+	// buckets[i] counts layers that took at most durationBuckets[i]; the
+	// last element counts the rest.
+	buckets  []int64
+	duration float64
+}
+
+// New returns an empty Metrics.
+func New() *Metrics {
+	return &Metrics{
+		started: make(map[string]time.Time),
+		buckets: make([]int64, len(durationBuckets)+1),
+	}
+}
+
+// layerKey identifies a layer transfer.
+func layerKey(job ollamadl.DownloadJob) string {
+	return job.DestPath + "@" + job.Layer.Digest
+}
+
+// Instrument returns opts with hooks and a progress reporter that record
+// metrics added on top of the ones already set.
+func (m *Metrics) Instrument(opts ollamadl.Options) ollamadl.Options {
+	next := opts.Hooks
+	opts.Hooks.OnLayerStart = func(ctx context.Context, job ollamadl.DownloadJob) error {
+		if next.OnLayerStart != nil {
+			if err := next.OnLayerStart(ctx, job); err != nil {
+				return err
+			}
+		}
+		m.mu.Lock()
+		m.started[layerKey(job)] = time.Now()
+		m.mu.Unlock()
+		return nil
+	}
+	opts.Hooks.OnLayerDone = func(ctx context.Context, job ollamadl.DownloadJob) {
+		m.layerFinished(job, true)
+		if next.OnLayerDone != nil {
+			next.OnLayerDone(ctx, job)
+		}
+	}
+	opts.Hooks.OnError = func(ctx context.Context, job *ollamadl.DownloadJob, err error) {
+		if job != nil {
+			m.layerFinished(*job, false)
+		}
+		if next.OnError != nil {
+			next.OnError(ctx, job, err)
+		}
+	}
+	opts.Hooks.OnRetry = func(ctx context.Context, job ollamadl.DownloadJob, attempt int, err error) {
+		m.mu.Lock()
+		m.retries++
+		m.mu.Unlock()
+		if next.OnRetry != nil {
+			next.OnRetry(ctx, job, attempt, err)
+		}
+	}
+	opts.Progress = &reporter{m: m, next: opts.Progress}
+	return opts
+}
+
+// layerFinished records the end of a layer transfer. Errors for layers that
+// never started, such as failing to check the store, are not counted.
+func (m *Metrics) layerFinished(job ollamadl.DownloadJob, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := layerKey(job)
+	start, started := m.started[key]
+	if !started {
+		return
+	}
+	delete(m.started, key)
+
+	if !ok {
+		m.failed++
+		return
+	}
+	m.completed++
+	d := time.Since(start).Seconds()
+	m.duration += d
+	i := 0
+	for i < len(durationBuckets) && d > durationBuckets[i] {
+		i++
+	}
+	m.buckets[i]++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	m.mu.Lock()
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("ollamadl_downloaded_bytes_total", "counter", "Bytes of layer data received from registries.")
+	fmt.Fprintf(&b, "ollamadl_downloaded_bytes_total %d\n", m.bytes)
+
+	metric("ollamadl_layer_retries_total", "counter", "Layer download attempts that failed and were retried.")
+	fmt.Fprintf(&b, "ollamadl_layer_retries_total %d\n", m.retries)
+
+	metric("ollamadl_layers_total", "counter", "Layer downloads by result.")
+	fmt.Fprintf(&b, "ollamadl_layers_total{result=\"completed\"} %d\n", m.completed)
+	fmt.Fprintf(&b, "ollamadl_layers_total{result=\"failed\"} %d\n", m.failed)
+
+	metric("ollamadl_active_transfers", "gauge", "Layers being downloaded.")
+	fmt.Fprintf(&b, "ollamadl_active_transfers %d\n", len(m.started))
+
+	metric("ollamadl_layer_duration_seconds", "histogram", "Time taken by completed layer downloads, retries included.")
+	var cumulative int64
+	for i, le := range durationBuckets {
+		cumulative += m.buckets[i]
+		fmt.Fprintf(&b, "ollamadl_layer_duration_seconds_bucket{le=\"%g\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(&b, "ollamadl_layer_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.completed)
+	fmt.Fprintf(&b, "ollamadl_layer_duration_seconds_sum %g\n", m.duration)
+	fmt.Fprintf(&b, "ollamadl_layer_duration_seconds_count %d\n", m.completed)
+
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// reporter counts received bytes, passing progress on to next if set.
+type reporter struct {
+	m    *Metrics
+	next ollamadl.ProgressReporter
+}
+
+func (r *reporter) LayerStarted(job ollamadl.DownloadJob, offset int64) {
+	if r.next != nil {
+		r.next.LayerStarted(job, offset)
+	}
+}
+
+func (r *reporter) BytesWritten(job ollamadl.DownloadJob, n int64) {
+	r.m.mu.Lock()
+	r.m.bytes += n
+	r.m.mu.Unlock()
+	if r.next != nil {
+		r.next.BytesWritten(job, n)
+	}
+}
+
+func (r *reporter) LayerCompleted(job ollamadl.DownloadJob) {
+	if r.next != nil {
+		r.next.LayerCompleted(job)
+	}
+}
+
+func (r *reporter) LayerFailed(job ollamadl.DownloadJob, err error) {
+	if r.next != nil {
+		r.next.LayerFailed(job, err)
+	}
+}
@@ -0,0 +1,50 @@
+// Package notify shows a desktop notification when a long pull finishes,
+// since pulls of large models often take the better part of an hour.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// Instrument returns opts with a hook that shows a desktop notification
+// when a pull that took at least minDuration finishes or fails. Cancelled
+// pulls are left alone, as whoever cancelled them knows.
+func Instrument(opts ollamadl.Options, minDuration time.Duration) ollamadl.Options {
+	next := opts.Hooks.OnPullDone
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	opts.Hooks.OnPullDone = func(ctx context.Context, summary ollamadl.PullSummary) {
+		if next != nil {
+			next(ctx, summary)
+		}
+		if summary.Duration < minDuration || errors.Is(summary.Err, context.Canceled) {
+			return
+		}
+		if !interactive() {
+			log.Debug("No desktop session to notify")
+			return
+		}
+		title, body := message(summary)
+		if err := command(title, body).Run(); err != nil {
+			log.Warn("Desktop notification failed", "error", err)
+		}
+	}
+	return opts
+}
+
+// message returns the title and text of the notification for summary.
+func message(summary ollamadl.PullSummary) (title, body string) {
+	took := summary.Duration.Round(time.Second)
+	if summary.Err != nil {
+		return "Download failed", fmt.Sprintf("%s after %s: %v", summary.Ref, took, summary.Err)
+	}
+	return "Download complete", fmt.Sprintf("%s in %s (%s)", summary.Ref, took, summary.DestDir)
+}
@@ -0,0 +1,17 @@
+package notify
+
+import "os/exec"
+
+func interactive() bool {
+	return true
+}
+
+// command shows a notification through AppleScript. The texts are passed
+// as arguments, so they need no quoting.
+func command(title, body string) *exec.Cmd {
+	return exec.Command("osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, body)
+}
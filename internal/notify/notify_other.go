@@ -0,0 +1,19 @@
+//go:build !darwin && !windows
+
+package notify
+
+import (
+	"os"
+	"os/exec"
+)
+
+// interactive reports whether there is a graphical session to notify, as
+// there isn't over SSH or in a container.
+func interactive() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// command shows a notification with notify-send, from libnotify.
+func command(title, body string) *exec.Cmd {
+	return exec.Command("notify-send", "--app-name=ollama-dl", title, body)
+}
@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast with the WinRT notification API, which
+// PowerShell can reach without extra modules. It takes the texts from the
+// environment, so they need no quoting.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:OLLAMA_DL_TITLE)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:OLLAMA_DL_BODY)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('ollama-dl').Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+func interactive() bool {
+	return true
+}
+
+func command(title, body string) *exec.Cmd {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "OLLAMA_DL_TITLE="+title, "OLLAMA_DL_BODY="+body)
+	return cmd
+}
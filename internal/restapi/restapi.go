@@ -0,0 +1,132 @@
+// Package restapi serves the daemon's HTTP/JSON API:
+//
+//	POST   /pulls       start a pull of {"model": ..., "dest": ...}
+//	GET    /pulls       list all pulls
+//	GET    /pulls/{id}  a pull's state, or a stream of them as server-sent
+//	                    events when the request accepts text/event-stream
+//	DELETE /pulls/{id}  cancel a pull
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/jobs"
+)
+
+// progressInterval is the minimum time between progress events.
+const progressInterval = 500 * time.Millisecond
+
+// NewHandler returns the API's handler for m.
+func NewHandler(m *jobs.Manager) http.Handler {
+	h := &handler{jobs: m}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /pulls", h.start)
+	mux.HandleFunc("GET /pulls", h.list)
+	mux.HandleFunc("GET /pulls/{id}", h.get)
+	mux.HandleFunc("DELETE /pulls/{id}", h.cancel)
+	return mux
+}
+
+type handler struct {
+	jobs *jobs.Manager
+}
+
+// pullRequest is the body of POST /pulls.
+type pullRequest struct {
+	Model string `json:"model"`
+	Dest  string `json:"dest"`
+}
+
+func (h *handler) start(w http.ResponseWriter, r *http.Request) {
+	var req pullRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	if req.Model == "" {
+		writeError(w, http.StatusBadRequest, errors.New("model is required"))
+		return
+	}
+
+	job, err := h.jobs.StartClient(req.Model, req.Dest)
+	if errors.Is(err, jobs.ErrDestNotAllowed) {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/pulls/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.jobs.List())
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		job, err := h.jobs.Get(id)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	if _, err := h.jobs.Get(id); err != nil {
+		writeJobError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Each event carries the whole job; the stream ends with the job.
+	rc := http.NewResponseController(w)
+	h.jobs.Watch(r.Context(), id, progressInterval, func(job jobs.Job) error {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", job.State, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+}
+
+func (h *handler) cancel(w http.ResponseWriter, r *http.Request) {
+	job, err := h.jobs.Cancel(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, jobs.ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	writeError(w, status, err)
+}
@@ -0,0 +1,119 @@
+// Package schedule starts the daemon's pulls on cron-style schedules, so
+// mirrors stay current without an external cron.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/internal/jobs"
+)
+
+const (
+	// retryDelay is how long the first retry of a failed pull waits; each
+	// further retry waits twice as long, up to maxRetryDelay.
+	retryDelay    = time.Minute
+	maxRetryDelay = 30 * time.Minute
+)
+
+// Entry is a model to pull on a schedule.
+type Entry struct {
+	Spec  *Spec
+	Model string
+	Dest  string
+	// Jitter delays each run by a random duration up to this long, so
+	// many schedules on the same time don't all hit the registry at once.
+	Jitter time.Duration
+	// Retries is how many times a failed pull is retried before waiting
+	// for the next scheduled run.
+	Retries int
+}
+
+// Run starts the pulls of entries with m as scheduled until ctx is done.
+func Run(ctx context.Context, m *jobs.Manager, entries []Entry, log *slog.Logger) {
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx, m, e, log.With("model", e.Model, "schedule", e.Spec.String()))
+		}()
+	}
+	wg.Wait()
+}
+
+func run(ctx context.Context, m *jobs.Manager, e Entry, log *slog.Logger) {
+	for {
+		next := e.Spec.Next(time.Now())
+		if next.IsZero() {
+			log.Warn("Schedule never runs")
+			return
+		}
+		if e.Jitter > 0 {
+			next = next.Add(rand.N(e.Jitter))
+		}
+		log.Info("Next scheduled pull", "at", next.Format(time.RFC3339))
+		if !sleep(ctx, time.Until(next)) {
+			return
+		}
+		delay := retryDelay
+		for attempt := 0; ; attempt++ {
+			job, err := pull(ctx, m, e)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				log.Info("Scheduled pull finished", "job", job.ID)
+				break
+			}
+			if attempt == e.Retries {
+				log.Error("Scheduled pull failed", "job", job.ID, "err", err)
+				break
+			}
+			log.Warn("Scheduled pull failed, retrying", "job", job.ID, "err", err, "in", delay)
+			if !sleep(ctx, delay) {
+				return
+			}
+			delay = min(2*delay, maxRetryDelay)
+		}
+	}
+}
+
+// pull runs one pull of e to completion and returns its final snapshot.
+func pull(ctx context.Context, m *jobs.Manager, e Entry) (jobs.Job, error) {
+	job, err := m.Start(e.Model, e.Dest)
+	if err != nil {
+		return job, err
+	}
+	err = m.Watch(ctx, job.ID, time.Second, func(snap jobs.Job) error {
+		job = snap
+		return nil
+	})
+	if err != nil {
+		return job, err
+	}
+	switch {
+	case job.State == jobs.Done:
+		return job, nil
+	case job.Error != "":
+		return job, errors.New(job.Error)
+	}
+	return job, fmt.Errorf("job %s", job.State)
+}
+
+// sleep waits for d, reporting false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,303 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exportInterval is how often queued spans are sent, as the default of
+// OTEL_BSP_SCHEDULE_DELAY.
+const exportInterval = 5 * time.Second
+
+// Tracer creates spans and exports them to an OTLP/HTTP endpoint in batches.
+type Tracer struct {
+	endpoint string
+	headers  http.Header
+	resource []attr
+	client   *http.Client
+	log      *slog.Logger
+
+	mu       sync.Mutex
+	queue    []*Span
+	closed   bool
+	stop     chan struct{}
+	done     chan struct{}
+	shutdown sync.Once
+}
+
+// FromEnv returns a Tracer configured by the OTEL_* environment variables, or
+// nil when no OTLP endpoint is set or OTEL_TRACES_EXPORTER is "none". Only
+// the http/json protocol is supported. Export failures are logged to log.
+func FromEnv(service string, log *slog.Logger) (*Tracer, error) {
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter == "none" ||
+		exporter != "" && exporter != "otlp" {
+		return nil, nil
+	}
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return nil, nil
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %v", err)
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("OTLP protocol %q is not supported, use http/json", protocol)
+	}
+
+	headers := http.Header{}
+	for _, list := range []string{os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")} {
+		for key, value := range parseKeyValues(list) {
+			headers.Set(key, value)
+		}
+	}
+
+	resourceAttrs := parseKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		resourceAttrs["service.name"] = name
+	} else if resourceAttrs["service.name"] == "" {
+		resourceAttrs["service.name"] = service
+	}
+	var resource []attr
+	for key, value := range resourceAttrs {
+		resource = append(resource, attr{key, value})
+	}
+
+	t := &Tracer{
+		endpoint: endpoint,
+		headers:  headers,
+		resource: resource,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		log:      log,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.loop()
+	return t, nil
+}
+
+// parseKeyValues parses the "key1=value1,key2=value2" lists of OTEL_*
+// variables, whose values are URL-encoded.
+func parseKeyValues(list string) map[string]string {
+	kv := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			continue
+		}
+		if v, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = v
+		}
+		kv[strings.TrimSpace(key)] = value
+	}
+	return kv
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.queue = append(t.queue, s)
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush(context.Background())
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Shutdown exports the spans still queued and stops the Tracer. Spans ended
+// afterwards are dropped. A nil Tracer does nothing.
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.shutdown.Do(func() {
+		close(t.stop)
+		<-t.done
+		t.flush(ctx)
+		t.mu.Lock()
+		t.closed = true
+		t.mu.Unlock()
+	})
+}
+
+func (t *Tracer) flush(ctx context.Context) {
+	t.mu.Lock()
+	spans := t.queue
+	t.queue = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.export(ctx, spans); err != nil {
+		t.log.Warn("Exporting traces failed", "spans", len(spans), "error", err)
+	}
+}
+
+func (t *Tracer) export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(encodeSpans(t.resource, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range t.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The types below follow the JSON mapping of the OTLP trace protobufs.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type spanJSON struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []keyValue  `json:"attributes,omitempty"`
+	Events            []eventJSON `json:"events,omitempty"`
+	Status            *statusJSON `json:"status,omitempty"`
+}
+
+type eventJSON struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []keyValue `json:"attributes,omitempty"`
+}
+
+type statusJSON struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+const (
+	spanKindInternal = 1
+	statusError      = 2
+)
+
+func encodeSpans(res []attr, spans []*Span) exportRequest {
+	var scope scopeSpans
+	scope.Scope.Name = "github.com/dimchansky/ollama-dl-go"
+	for _, s := range spans {
+		s.mu.Lock()
+		js := spanJSON{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        encodeAttrs(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			js.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, e := range s.events {
+			js.Events = append(js.Events, eventJSON{TimeUnixNano: unixNano(e.time), Name: e.name, Attributes: encodeAttrs(e.attrs)})
+		}
+		if s.err != "" {
+			js.Status = &statusJSON{Code: statusError, Message: s.err}
+		}
+		s.mu.Unlock()
+		scope.Spans = append(scope.Spans, js)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: encodeAttrs(res)},
+		ScopeSpans: []scopeSpans{scope},
+	}}}
+}
+
+func encodeAttrs(attrs []attr) []keyValue {
+	var kvs []keyValue
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int64:
+			// 64-bit integers are strings in the JSON mapping.
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		}
+		kvs = append(kvs, keyValue{Key: a.key, Value: value})
+	}
+	return kvs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
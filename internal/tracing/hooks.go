@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// Instrument returns opts with hooks added on top of the ones already set
+// that record manifest resolution and each layer download as spans. They
+// are children of the span current in the context passed to Pull, normally
+// one started around the whole pull. A nil Tracer returns opts unchanged.
+func (t *Tracer) Instrument(opts ollamadl.Options) ollamadl.Options {
+	if t == nil {
+		return opts
+	}
+
+	var (
+		mu     sync.Mutex
+		layers = make(map[string]*Span)
+	)
+	layerKey := func(job ollamadl.DownloadJob) string {
+		return job.DestPath + "@" + job.Layer.Digest
+	}
+	endLayer := func(job ollamadl.DownloadJob, err error) {
+		mu.Lock()
+		s := layers[layerKey(job)]
+		delete(layers, layerKey(job))
+		mu.Unlock()
+		s.End(err)
+	}
+
+	next := opts.Hooks
+	opts.Hooks.OnManifestResolved = func(ctx context.Context, res *ollamadl.Resolution) error {
+		// Resolving is the first thing a pull does, so its span runs from
+		// the start of the pull's span to now.
+		if parent := SpanFromContext(ctx); parent != nil {
+			s := t.newSpan(ctx, "resolve manifest", parent.start)
+			s.SetAttributes("ollamadl.model", res.Ref.String(), "ollamadl.layers", len(res.Jobs))
+			s.End(nil)
+		}
+		if next.OnManifestResolved != nil {
+			return next.OnManifestResolved(ctx, res)
+		}
+		return nil
+	}
+	opts.Hooks.OnLayerStart = func(ctx context.Context, job ollamadl.DownloadJob) error {
+		if next.OnLayerStart != nil {
+			if err := next.OnLayerStart(ctx, job); err != nil {
+				return err
+			}
+		}
+		_, s := t.Start(ctx, "download layer",
+			"ollamadl.digest", job.Layer.Digest,
+			"ollamadl.media_type", job.Layer.MediaType,
+			"ollamadl.path", job.DestPath,
+			"ollamadl.size", job.Size)
+		mu.Lock()
+		layers[layerKey(job)] = s
+		mu.Unlock()
+		return nil
+	}
+	opts.Hooks.OnLayerDone = func(ctx context.Context, job ollamadl.DownloadJob) {
+		endLayer(job, nil)
+		if next.OnLayerDone != nil {
+			next.OnLayerDone(ctx, job)
+		}
+	}
+	opts.Hooks.OnRetry = func(ctx context.Context, job ollamadl.DownloadJob, attempt int, err error) {
+		mu.Lock()
+		s := layers[layerKey(job)]
+		mu.Unlock()
+		s.AddEvent("retry", "attempt", attempt, "error", err.Error())
+		if next.OnRetry != nil {
+			next.OnRetry(ctx, job, attempt, err)
+		}
+	}
+	opts.Hooks.OnError = func(ctx context.Context, job *ollamadl.DownloadJob, err error) {
+		if job != nil {
+			endLayer(*job, err)
+		}
+		if next.OnError != nil {
+			next.OnError(ctx, job, err)
+		}
+	}
+	return opts
+}
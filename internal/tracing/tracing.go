@@ -0,0 +1,160 @@
+// Package tracing records pulls as OpenTelemetry spans and exports them over
+// OTLP/HTTP with JSON encoding, configured by the standard OTEL_* environment
+// variables. It implements only what the CLI needs rather than depending on
+// the OpenTelemetry SDK.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation within a trace.
+type Span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	start   time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []attr
+	events []event
+	err    string
+	ended  bool
+}
+
+type attr struct {
+	key   string
+	value any // string, int64 or bool
+}
+
+type event struct {
+	name  string
+	time  time.Time
+	attrs []attr
+}
+
+type spanKey struct{}
+
+// remoteParent is a span context propagated from another process.
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+type remoteKey struct{}
+
+// SpanFromContext returns the current span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithTraceparent returns ctx with a parent span taken from a W3C
+// traceparent value such as the TRACEPARENT variable set by CI systems. An
+// empty or malformed value leaves ctx unchanged.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || parts[0] != "00" {
+		return ctx
+	}
+	var p remoteParent
+	tid, err1 := hex.DecodeString(parts[1])
+	sid, err2 := hex.DecodeString(parts[2])
+	if err1 != nil || err2 != nil || len(tid) != len(p.traceID) || len(sid) != len(p.spanID) {
+		return ctx
+	}
+	copy(p.traceID[:], tid)
+	copy(p.spanID[:], sid)
+	return context.WithValue(ctx, remoteKey{}, p)
+}
+
+// Start begins a span named name as a child of ctx's current span, or of a
+// parent from ContextWithTraceparent, and returns a context carrying it. A
+// nil Tracer returns ctx and a nil span, whose methods do nothing.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := t.newSpan(ctx, name, time.Now())
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *Tracer) newSpan(ctx context.Context, name string, start time.Time) *Span {
+	s := &Span{tracer: t, name: name, start: start}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+	} else if p, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		s.traceID, s.parent = p.traceID, p.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// SetAttributes adds attributes given as alternating keys and values.
+// Values other than strings, integers and booleans are not recorded.
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, toAttrs(kv)...)
+}
+
+// AddEvent records a named point in time during the span.
+func (s *Span) AddEvent(name string, kv ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event{name: name, time: time.Now(), attrs: toAttrs(kv)})
+}
+
+// End finishes the span, marking it failed if err is not nil, and queues it
+// for export. Only the first call has an effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+func toAttrs(kv []any) []attr {
+	var attrs []attr
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		switch v := kv[i+1].(type) {
+		case string, bool, int64:
+			attrs = append(attrs, attr{key, v})
+		case int:
+			attrs = append(attrs, attr{key, int64(v)})
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,93 @@
+// Package webhook posts the outcome of every pull to a URL, so chat-ops
+// bots and pipelines can react to new models landing.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// Payload is the JSON body posted for a finished pull.
+type Payload struct {
+	Model  string `json:"model"`
+	Dest   string `json:"dest"`
+	Digest string `json:"digest,omitempty"`
+	// Bytes is how much the pull downloaded.
+	Bytes int64 `json:"bytes"`
+	// Duration is how long the pull took, in seconds.
+	Duration float64 `json:"duration"`
+	// Status is "done", "failed" or "cancelled".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Instrument returns opts with a hook that posts a Payload to url after
+// every pull. A failed post is logged and doesn't fail the pull.
+func Instrument(opts ollamadl.Options, url string) ollamadl.Options {
+	next := opts.Hooks.OnPullDone
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	opts.Hooks.OnPullDone = func(ctx context.Context, summary ollamadl.PullSummary) {
+		if next != nil {
+			next(ctx, summary)
+		}
+		// A cancelled pull is still reported.
+		if err := post(context.WithoutCancel(ctx), client, url, newPayload(summary)); err != nil {
+			log.Warn("Webhook failed", "url", url, "error", err)
+		}
+	}
+	return opts
+}
+
+func newPayload(summary ollamadl.PullSummary) Payload {
+	p := Payload{
+		Model:    summary.Ref.String(),
+		Dest:     summary.DestDir,
+		Bytes:    summary.Downloaded,
+		Duration: summary.Duration.Seconds(),
+		Status:   "done",
+	}
+	if summary.Resolution != nil {
+		p.Digest = summary.Resolution.Manifest.Digest
+	}
+	switch {
+	case errors.Is(summary.Err, context.Canceled):
+		p.Status, p.Error = "cancelled", summary.Err.Error()
+	case summary.Err != nil:
+		p.Status, p.Error = "failed", summary.Err.Error()
+	}
+	return p
+}
+
+func post(ctx context.Context, client *http.Client, url string, p Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runList implements "ollama-dl list", which shows the models downloaded
+// under a directory.
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	quick := fs.Bool("quick", false, "Read the state database instead of scanning the directories")
+	var statePath string
+	addStateFlag(fs, &statePath)
+	fs.Parse(args)
+	root := "."
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	var models []ollamadl.LocalModel
+	if *quick {
+		state := openState(statePath)
+		if state == nil {
+			return errors.New("-quick needs a state database")
+		}
+		var err error
+		if models, err = state.List(root); err != nil {
+			return fmt.Errorf("listing failed: %w", err)
+		}
+	} else {
+		d, err := ollamadl.New(ollamadl.Options{
+			Store:  ollamadl.NewFileStore(""),
+			Logger: newLogger(slog.LevelInfo),
+		})
+		if err != nil {
+			return err
+		}
+		if models, err = d.List(commandContext(), root); err != nil {
+			return fmt.Errorf("listing failed: %w", err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tDIGEST\tSIZE\tPULLED\tDIRECTORY")
+	for _, m := range models {
+		name := "-"
+		if m.Ref.Name != "" {
+			name = strings.TrimPrefix(m.Ref.String(), "library/")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, shortDigest(m.Manifest.Digest), formatSize(m.Size), m.Pulled.Local().Format(time.DateTime), m.Dir)
+	}
+	return w.Flush()
+}
+
+// shortDigest returns the first 12 hex digits of a sha256 digest, or "-".
+func shortDigest(digest string) string {
+	if short, ok := strings.CutPrefix(digest, "sha256:"); ok && len(short) >= 12 {
+		return short[:12]
+	}
+	return "-"
+}
@@ -1,212 +1,887 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/schollz/progressbar/v3"
+	"github.com/dimchansky/ollama-dl-go/internal/health"
+	"github.com/dimchansky/ollama-dl-go/internal/notify"
+	"github.com/dimchansky/ollama-dl-go/internal/tracing"
+	"github.com/dimchansky/ollama-dl-go/internal/webhook"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
 )
 
-const (
-	numRetries = 10
-)
+// commands maps subcommand names to their implementations. Anything else on
+// the command line is taken as a model to pull.
+var commands = map[string]func(args []string) error{
+	"audit":    runAudit,
+	"cat":      runCat,
+	"clean":    runClean,
+	"daemon":   runDaemon,
+	"diff":     runDiff,
+	"export":   runExport,
+	"import":   runImport,
+	"inspect":  runInspect,
+	"list":     runList,
+	"mirror":   runMirror,
+	"prune":    runPrune,
+	"pull":     runPull,
+	"rm":       runRm,
+	"run-args": runRunArgs,
+	"search":   runSearch,
+	"serve":    runServe,
+	"share":    runShare,
+	"sizes":    runSizes,
+	"sync":     runSync,
+	"template": runTemplate,
+	"verify":   runVerify,
+	"watch":    runWatch,
+}
 
-var mediaTypeToFileTemplate = map[string]string{
-	"application/vnd.ollama.image.license":  "license-%s.txt",
-	"application/vnd.ollama.image.model":    "model-%s.gguf",
-	"application/vnd.ollama.image.params":   "params-%s.json",
-	"application/vnd.ollama.image.system":   "system-%s.txt",
-	"application/vnd.ollama.image.template": "template-%s.txt",
+// defaultManifestMaxStale is how long a cached manifest stands in for one
+// the registry can't serve, by default.
+const defaultManifestMaxStale = time.Hour
+
+// notifyAfter is how long a pull must take for -notify to announce it.
+const notifyAfter = time.Minute
+
+// registryFlags are the flags shared by every command that talks to a
+// registry.
+type registryFlags struct {
+	registry       string
+	dialOverride   string
+	connectTimeout time.Duration
+	tlsTimeout     time.Duration
+	headerTimeout  time.Duration
+	idleTimeout    time.Duration
+	resolve        map[string]string
+	resolver       string
+	dnsCacheTTL    time.Duration
+	ipv4, ipv6     bool
+	maxIdlePerHost int
+	maxPerHost     int
+	keepAlive      time.Duration
+	http1          bool
+	tokenURL       string
+	repoPrefix     string
+	configPath     string
+	includeUnknown bool
+	verbose        bool
+	concurrency    int
+	limitRate      string
+	rateSchedule   string
+	statePath      string
+	manifestCache  string
+	manifestStale  time.Duration
+	webhook        string
+	notify         bool
+	maxStoreSize   string
+	storeRoot      string
+	eviction       string
+	pins           []string
+	webseeds       []string
+	ipfsMap        string
+	ipfsGateway    string
+	verifySig      bool
+	signature      SignatureConfig
+	policy         string
+	auditLog       string
+
+	// config is the config file loaded by options.
+	config *Config
 }
 
-type Layer struct {
-	MediaType string `json:"mediaType"`
-	Digest    string `json:"digest"`
-	Size      int64  `json:"size"`
+func addRegistryFlags(fs *flag.FlagSet) *registryFlags {
+	f := &registryFlags{}
+	fs.StringVar(&f.registry, "registry", ollamadl.DefaultRegistry, "Registry URL (http(s)://, a bare host for HTTPS, or unix:///path/to.sock)")
+	fs.StringVar(&f.dialOverride, "dial-override", "", "Connect to this address (host:port or unix:///path) instead of the registry host")
+	fs.DurationVar(&f.connectTimeout, "connect-timeout", 0, "Give up opening a connection to the registry after this long (default 30s)")
+	fs.DurationVar(&f.tlsTimeout, "tls-timeout", 0, "Give up on a TLS handshake after this long (default 10s)")
+	fs.DurationVar(&f.headerTimeout, "response-header-timeout", 0, "Give up on a request whose response headers take longer than this to arrive (default 30s)")
+	fs.DurationVar(&f.idleTimeout, "idle-timeout", 0, "Close connections left unused for this long instead of keeping them for later requests (default 1m30s)")
+	fs.Func("resolve", "Connect to `host:port:addr`'s host and port at the IP address addr instead of the one DNS gives, like curl; repeatable", func(s string) error {
+		hostPort, addr, err := parseResolve(s)
+		if err != nil {
+			return err
+		}
+		if f.resolve == nil {
+			f.resolve = make(map[string]string)
+		}
+		f.resolve[hostPort] = addr
+		return nil
+	})
+	fs.StringVar(&f.resolver, "resolver", "", "Look names up with this DNS server (host or host:port) instead of the system's")
+	fs.DurationVar(&f.dnsCacheTTL, "dns-cache", 0, "Keep the addresses looked up for a host this long, e.g. 5m, where DNS is flaky (default: look them up for every connection)")
+	fs.BoolVar(&f.ipv4, "ipv4", false, "Connect to the registry over IPv4 only")
+	fs.BoolVar(&f.ipv6, "ipv6", false, "Connect to the registry over IPv6 only")
+	fs.IntVar(&f.maxIdlePerHost, "max-idle-conns-per-host", 0, "Keep this many unused connections to a host for reuse (default 16)")
+	fs.IntVar(&f.maxPerHost, "max-conns-per-host", 0, "Open at most this many connections to a host, making further requests wait (default no limit)")
+	fs.DurationVar(&f.keepAlive, "keep-alive", 0, "Interval of TCP keep-alive probes on connections, negative to turn them off (default 30s)")
+	fs.BoolVar(&f.http1, "http1", false, "Talk to the registry over HTTP/1.1 only, for proxies that mishandle HTTP/2 (-v logs the protocol used)")
+	fs.StringVar(&f.tokenURL, "token-url", "", "Fetch bearer tokens from this URL instead of the token service the registry names, e.g. on Artifactory or Nexus proxies")
+	fs.StringVar(&f.repoPrefix, "repo-prefix", "", "Put this path, e.g. an Artifactory repository key, in front of repository names on the registry")
+	fs.StringVar(&f.configPath, "config", "", "Config file (default "+defaultConfigPath()+")")
+	fs.BoolVar(&f.includeUnknown, "include-unknown", false, "Also download layers with unrecognized media types")
+	fs.BoolVar(&f.verbose, "v", false, "Log debug messages")
+	fs.IntVar(&f.concurrency, "concurrency", 0, "Download at most this many layers at a time (default all)")
+	fs.StringVar(&f.limitRate, "limit-rate", "", "Limit the download rate in bytes per second, with an optional K, M or G suffix")
+	fs.StringVar(&f.rateSchedule, "rate-schedule", "", "Other rate limits by local time of day, as comma-separated `windows` like 00:00-07:00=unlimited or 09:00-18:00=500K; -limit-rate applies outside them (default from the config file)")
+	fs.StringVar(&f.webhook, "webhook", "", "POST the outcome of every pull as JSON to this URL (default from the config file)")
+	fs.BoolVar(&f.notify, "notify", false, "Show a desktop notification when a pull that took over a minute finishes")
+	fs.StringVar(&f.maxStoreSize, "max-store-size", "", "Evict other models under -store-root to keep them within this size, e.g. 50G")
+	fs.StringVar(&f.storeRoot, "store-root", "", "Directory -max-store-size and -keep cover (default the parent of the model's directory)")
+	fs.StringVar(&f.eviction, "evict", "", "Which models -max-store-size evicts first: lru, oldest or largest (default lru)")
+	fs.Func("pin", "Never evict this model or directory; repeatable", func(s string) error {
+		f.pins = append(f.pins, s)
+		return nil
+	})
+	fs.Func("webseed", "Fetch blobs from this HTTP server holding them as sha256-<hex> before the registry; repeatable", func(s string) error {
+		f.webseeds = append(f.webseeds, s)
+		return nil
+	})
+	fs.StringVar(&f.ipfsMap, "ipfs-map", "", "Fetch the blobs this file maps to IPFS CIDs, one \"<digest> <cid>\" per line, through an IPFS gateway before the registry")
+	fs.StringVar(&f.ipfsGateway, "ipfs-gateway", ollamadl.DefaultIPFSGateway, "IPFS gateway -ipfs-map fetches through")
+	fs.BoolVar(&f.verifySig, "verify-signature", false, "Only pull models whose manifests carry a cosign signature by -signature-key or -certificate-identity")
+	fs.Func("signature-key", "PEM public key a signature may be made with, e.g. cosign.pub; repeatable", func(s string) error {
+		f.signature.Keys = append(f.signature.Keys, s)
+		return nil
+	})
+	fs.StringVar(&f.signature.Identity, "certificate-identity", "", "Accept keyless signatures whose certificate is for this email address or URI")
+	fs.StringVar(&f.signature.Issuer, "certificate-oidc-issuer", "", "Require keyless signatures' certificates to come from this OIDC issuer, e.g. https://accounts.google.com")
+	fs.StringVar(&f.signature.Roots, "certificate-roots", "", "PEM file of the CA certificates keyless signatures must chain up to, e.g. Fulcio's")
+	fs.Func("rekor-key", "PEM public key of the transparency log keyless signatures must be recorded in, e.g. Rekor's; repeatable", func(s string) error {
+		f.signature.RekorKeys = append(f.signature.RekorKeys, s)
+		return nil
+	})
+	fs.StringVar(&f.policy, "policy", "", "JSON `file` of patterns and limits deciding which models may be pulled (default from the config file)")
+	fs.StringVar(&f.auditLog, "audit-log", "", "Append every pull to this hash-chained `file`, or send it to syslog, syslog://host:port or syslog+tcp://host:port (default from the config file)")
+	addStateFlag(fs, &f.statePath)
+	fs.StringVar(&f.manifestCache, "manifest-cache", defaultManifestCacheDir(), `Directory caching fetched manifests, revalidated with the registry by ETag; "" disables it`)
+	fs.DurationVar(&f.manifestStale, "manifest-max-stale", defaultManifestMaxStale, "Use cached manifests up to this old while the registry can't be reached (0 never does)")
+	return f
 }
 
-type Manifest struct {
-	MediaType string  `json:"mediaType"`
-	Layers    []Layer `json:"layers"`
+// options loads the config file and combines it with the flags.
+func (f *registryFlags) options() (ollamadl.Options, error) {
+	explicitConfig := f.configPath != ""
+	if !explicitConfig {
+		f.configPath = defaultConfigPath()
+	}
+	cfg, err := loadConfig(f.configPath, explicitConfig)
+	if err != nil {
+		return ollamadl.Options{}, fmt.Errorf("loading config: %v", err)
+	}
+	f.config = cfg
+
+	rate, err := parseRate(f.limitRate)
+	if err != nil {
+		return ollamadl.Options{}, err
+	}
+	if f.rateSchedule == "" {
+		f.rateSchedule = cfg.RateSchedule
+	}
+	rateSchedule, err := parseRateSchedule(f.rateSchedule)
+	if err != nil {
+		return ollamadl.Options{}, fmt.Errorf("-rate-schedule: %v", err)
+	}
+	if f.maxStoreSize == "" {
+		f.maxStoreSize = cfg.MaxStoreSize
+	}
+	maxStoreSize, err := parseSize(f.maxStoreSize)
+	if err != nil {
+		return ollamadl.Options{}, fmt.Errorf("-max-store-size: %v", err)
+	}
+	if f.eviction == "" {
+		f.eviction = cfg.Eviction
+	}
+
+	ipVersion := 0
+	switch {
+	case f.ipv4 && f.ipv6:
+		return ollamadl.Options{}, errors.New("-ipv4 and -ipv6 exclude each other")
+	case f.ipv4:
+		ipVersion = 4
+	case f.ipv6:
+		ipVersion = 6
+	}
+
+	level := slog.LevelInfo
+	if f.verbose {
+		level = slog.LevelDebug
+	}
+
+	opts := ollamadl.Options{
+		Registry:              f.registry,
+		DialOverride:          f.dialOverride,
+		ConnectTimeout:        f.connectTimeout,
+		TLSHandshakeTimeout:   f.tlsTimeout,
+		ResponseHeaderTimeout: f.headerTimeout,
+		IdleConnTimeout:       f.idleTimeout,
+		Resolve:               f.resolve,
+		Resolver:              f.resolver,
+		DNSCacheTTL:           f.dnsCacheTTL,
+		IPVersion:             ipVersion,
+		MaxIdleConnsPerHost:   f.maxIdlePerHost,
+		MaxConnsPerHost:       f.maxPerHost,
+		KeepAlive:             f.keepAlive,
+		HTTP1:                 f.http1,
+		TokenURL:              f.tokenURL,
+		RepositoryPrefix:      f.repoPrefix,
+		FileTemplates:         cfg.MediaTypes,
+		IncludeUnknown:        f.includeUnknown,
+		Auth:                  credentialsFromEnv(),
+		HuggingFaceToken:      huggingFaceToken(),
+		Concurrency:           f.concurrency,
+		RateLimit:             rate,
+		RateSchedule:          rateSchedule,
+		Logger:                newLogger(level),
+		State:                 openState(f.statePath),
+		ManifestCache:         f.manifestCache,
+		ManifestCacheMaxStale: f.manifestStale,
+		MaxStoreSize:          maxStoreSize,
+		StoreRoot:             f.storeRoot,
+		Eviction:              ollamadl.EvictionPolicy(f.eviction),
+		Pinned:                append(cfg.Pins, f.pins...),
+		Webseeds:              append(f.webseeds, cfg.Webseeds...),
+	}
+	switch {
+	case f.verifySig:
+		if opts.SignaturePolicy, err = f.signature.policy(); err != nil {
+			return ollamadl.Options{}, fmt.Errorf("-verify-signature: %v", err)
+		}
+	case cfg.Signature != nil:
+		if opts.SignaturePolicy, err = cfg.Signature.policy(); err != nil {
+			return ollamadl.Options{}, fmt.Errorf("config signature: %v", err)
+		}
+	}
+	switch {
+	case f.policy != "":
+		if opts.Policy, err = loadPolicy(f.policy); err != nil {
+			return ollamadl.Options{}, fmt.Errorf("-policy: %v", err)
+		}
+	case cfg.Policy != nil:
+		if opts.Policy, err = cfg.Policy.policy(); err != nil {
+			return ollamadl.Options{}, fmt.Errorf("config policy: %v", err)
+		}
+	}
+	if f.auditLog == "" {
+		f.auditLog = cfg.AuditLog
+	}
+	if f.auditLog != "" {
+		if opts.Audit, err = openAuditor(f.auditLog); err != nil {
+			return ollamadl.Options{}, fmt.Errorf("-audit-log: %v", err)
+		}
+	}
+	if f.ipfsMap != "" {
+		if opts.IPFSCIDs, err = readIPFSMap(f.ipfsMap); err != nil {
+			return ollamadl.Options{}, fmt.Errorf("-ipfs-map: %v", err)
+		}
+		opts.IPFSGateway = f.ipfsGateway
+	}
+	if f.webhook == "" {
+		f.webhook = cfg.Webhook
+	}
+	if f.webhook != "" {
+		opts = webhook.Instrument(opts, f.webhook)
+	}
+	if f.notify || cfg.Notify {
+		opts = notify.Instrument(opts, notifyAfter)
+	}
+	return opts, nil
 }
 
-type DownloadJob struct {
-	Layer    Layer
-	DestPath string
-	BlobURL  string
-	Size     int64
+// credentialsFromEnv returns the registry credentials set in the
+// environment, if any. They are not taken as flags to keep them out of
+// process listings and shell history.
+func credentialsFromEnv() *ollamadl.Credentials {
+	creds := ollamadl.Credentials{
+		Username: os.Getenv("OLLAMA_DL_USERNAME"),
+		Password: os.Getenv("OLLAMA_DL_PASSWORD"),
+		Token:    os.Getenv("OLLAMA_DL_TOKEN"),
+	}
+	if creds == (ollamadl.Credentials{}) {
+		return nil
+	}
+	return &creds
 }
 
-func getShortHash(layer Layer) (string, error) {
-	if !strings.HasPrefix(layer.Digest, "sha256:") {
-		return "", fmt.Errorf("unexpected digest: %s", layer.Digest)
+// huggingFaceToken returns the Hugging Face access token set in the
+// environment, under the names the Hugging Face tools use.
+func huggingFaceToken() string {
+	if token := os.Getenv("HF_TOKEN"); token != "" {
+		return token
 	}
-	return layer.Digest[7:19], nil
+	return os.Getenv("HUGGING_FACE_HUB_TOKEN")
 }
 
-func downloadBlob(client *http.Client, job DownloadJob, wg *sync.WaitGroup) error {
-	defer wg.Done()
+// parseResolve parses a -resolve value, host:port:addr as curl takes it,
+// into the host:port and the address, which may be an IPv6 address in
+// brackets.
+func parseResolve(s string) (string, string, error) {
+	host, rest, ok := strings.Cut(s, ":")
+	port, addr, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 || host == "" || port == "" {
+		return "", "", fmt.Errorf("invalid -resolve %q, want host:port:addr", s)
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if _, err := netip.ParseAddr(addr); err != nil {
+		return "", "", fmt.Errorf("invalid -resolve %q: %v", s, err)
+	}
+	return net.JoinHostPort(host, port), addr, nil
+}
 
-	for attempt := 1; attempt <= numRetries; attempt++ {
-		tempPath := job.DestPath + ".tmp"
+// parseRate parses a rate such as "500K" or "10M" into bytes per second.
+func parseRate(s string) (int64, error) {
+	n, err := parseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
 
-		// Ensure the directory exists
-		if err := os.MkdirAll(filepath.Dir(tempPath), 0755); err != nil {
-			return fmt.Errorf("failed to create directory: %v", err)
+// parseRateSchedule parses comma-separated windows of the form
+// "HH:MM-HH:MM=<rate>", where the rate is "unlimited" or "0" for none.
+func parseRateSchedule(s string) ([]ollamadl.RateWindow, error) {
+	var windows []ollamadl.RateWindow
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
 		}
-
-		outFile, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return err
+		span, rate, ok := strings.Cut(part, "=")
+		start, end, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid window %q, want HH:MM-HH:MM=<rate>", part)
 		}
-		defer outFile.Close()
+		var w ollamadl.RateWindow
+		var err error
+		if w.Start, err = parseTimeOfDay(start); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseTimeOfDay(end); err != nil {
+			return nil, err
+		}
+		if rate != "unlimited" && rate != "0" {
+			if w.Rate, err = parseRate(rate); err != nil {
+				return nil, err
+			}
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses "HH:MM", from 00:00 to 24:00, into the time since
+// midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// parseSize parses a size such as "500K" or "50G" into bytes.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	digits, multiplier := s, int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		digits = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// parseModelArgs parses a flag set whose single argument is a model reference.
+func parseModelArgs(fs *flag.FlagSet, args []string, usage string) ollamadl.Reference {
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fmt.Println("Usage:", usage)
+		os.Exit(1)
+	}
 
-		// Check for partial download
-		startOffset, _ := outFile.Seek(0, io.SeekEnd)
-		req, err := http.NewRequest("GET", job.BlobURL, nil)
+	ref, err := ollamadl.ParseReference(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	// Flags may also follow the model name.
+	fs.Parse(fs.Args()[1:])
+	return ref
+}
+
+// parseModelList parses a flag set whose arguments are model references,
+// adding those listed in the file named by batchFile and those the
+// Modelfile named by modelfile builds on, if set.
+func parseModelList(fs *flag.FlagSet, args []string, batchFile, modelfile *string, usage string) []ollamadl.Reference {
+	var names []string
+	fs.Parse(args)
+	// Flags may also follow the model names.
+	for fs.NArg() > 0 {
+		names = append(names, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	if *batchFile != "" {
+		listed, err := readBatchFile(*batchFile)
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
 		}
-
-		if startOffset > 0 {
-			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startOffset))
+		names = append(names, listed...)
+	}
+	if *modelfile != "" {
+		models, err := readModelfileModels(*modelfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
 		}
+		names = append(names, models...)
+	}
+	if len(names) == 0 {
+		fmt.Println("Usage:", usage)
+		os.Exit(1)
+	}
 
-		resp, err := client.Do(req)
+	refs := make([]ollamadl.Reference, len(names))
+	for i, name := range names {
+		ref, err := ollamadl.ParseReference(name)
 		if err != nil {
-			return err
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
 		}
-		defer resp.Body.Close()
+		refs[i] = ref
+	}
+	return refs
+}
 
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// readBatchFile returns the model names listed in the file name, or on
+// standard input for "-", one per line. Blank lines and lines starting
+// with # are skipped.
+func readBatchFile(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
 		}
+	}
+	return names, nil
+}
 
-		bar := progressbar.DefaultBytes(job.Size, job.DestPath)
-		bar.Set64(startOffset)
-
-		_, err = io.Copy(io.MultiWriter(outFile, bar), resp.Body)
-		if err != nil {
+// readModelfileModels returns the models the Modelfile name builds on with
+// FROM and ADAPTER, failing if a local file it names is missing, since
+// `ollama create` would fail too.
+func readModelfileModels(name string) ([]string, error) {
+	sources, err := ollamadl.ReadModelfile(name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, src := range sources {
+		if !src.Local {
+			names = append(names, src.Ref.String())
 			continue
 		}
-
-		// Rename the temporary file to the final destination
-		if err := os.Rename(tempPath, job.DestPath); err != nil {
-			return err
+		if _, err := os.Stat(src.Path); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s %s: %w", name, src.Line, src.Command, src.Value, err)
 		}
-		return nil
 	}
-
-	return errors.New("maximum retries reached")
+	return names, nil
 }
 
-func getDownloadJobs(client *http.Client, registry, destDir, name, version string) ([]DownloadJob, error) {
-	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", registry, name, version)
-	resp, err := client.Get(manifestURL)
+// readIPFSMap reads a file mapping blob digests to IPFS CIDs, one
+// "<digest> <cid>" per line.
+func readIPFSMap(name string) (map[string]string, error) {
+	data, err := os.ReadFile(name)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get manifest: %d", resp.StatusCode)
+	cids := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "sha256:") {
+			return nil, fmt.Errorf("line %d: want \"<digest> <cid>\"", i+1)
+		}
+		cids[fields[0]] = fields[1]
 	}
+	return cids, nil
+}
 
-	var manifest Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+// serveHealth serves handler on addr for the duration of a pull, and
+// returns the function that stops it.
+func serveHealth(addr string, handler http.Handler, log *slog.Logger) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
 		return nil, err
 	}
+	srv := &http.Server{Handler: handler, ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelError)}
+	go srv.Serve(ln)
+	log.Debug("Serving health", "addr", ln.Addr().String())
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+// readAttestationKey reads the -attest-key file, if given.
+func readAttestationKey(file string) (crypto.Signer, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("-attest-key: %v", err)
+	}
+	key, err := ollamadl.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("-attest-key %s: %v", file, err)
+	}
+	return key, nil
+}
 
-	if manifest.MediaType != "application/vnd.docker.distribution.manifest.v2+json" {
-		return nil, fmt.Errorf("unexpected media type for manifest: %s", manifest.MediaType)
+func runPull(args []string) error {
+	fs := flag.NewFlagSet("ollama-dl", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	destDir := fs.String("d", "", "Destination directory or storage URL (s3://, gs://, az://, sftp://, webdav://)")
+	fs.StringVar(destDir, "dest", "", "Same as -d")
+	sf := addStoreFlags(fs)
+	aggregateLicenses := fs.Bool("aggregate-licenses", false, "Also combine all license layers into "+ollamadl.LicensesFileName)
+	checksums := fs.Bool("checksums", false, "Write a SHA256SUMS file covering the downloaded files and the manifest")
+	attest := fs.Bool("attest", false, "Write an in-toto provenance attestation of the download as "+ollamadl.AttestationFileName)
+	attestKey := fs.String("attest-key", "", "Sign the attestation with the PEM private key in this `file` (implies -attest)")
+	mergeSplits := fs.Bool("merge-splits", false, "Merge split GGUF model parts into a single file with llama-gguf-split")
+	dedupeDir := fs.String("dedupe-dir", "", "Link files of layers already downloaded under this directory instead of downloading them again")
+	symlink := fs.Bool("symlink", false, "Link deduplicated files symbolically instead of with hard links")
+	reuseOllama := fs.Bool("reuse-ollama", false, "Link or copy layers the local Ollama already has in its models directory ($OLLAMA_MODELS or ~/.ollama/models) instead of downloading them")
+	delta := fs.Bool("delta", false, "Fetch changed layers by reusing the unchanged ranges of their previous files")
+	oci := fs.Bool("oci", false, "Write an OCI image layout that skopeo, oras or crane can push to another registry")
+	modelCard := fs.Bool("card", false, "Save the model's description and readme from ollama.com (or Hugging Face) as "+ollamadl.ModelCardFileName)
+	lmStudio := fs.Bool("lmstudio", false, "Lay the model out under LM Studio's models directory (or -d) so LM Studio lists it")
+	force := fs.Bool("force", false, "Download every file again, replacing existing files and discarding partial downloads")
+	staleTempAge := fs.Duration("stale-tmp-age", 0, "Start partial downloads older than this over instead of resuming them, e.g. 72h (0 always resumes)")
+	keep := fs.Int("keep", 0, "Keep only this many versions of the model under -store-root, deleting older ones after the pull")
+	latestLink := fs.String("link", "", "After a successful pull, point the symbolic link at this `path` to the model's directory, e.g. models/llama3-latest")
+	versioned := fs.Bool("versioned", false, "Put each version of the model in a subdirectory named after its manifest digest, keeping earlier versions for rollback")
+	healthListen := fs.String("health-listen", "", "Serve download progress and liveness as JSON at /healthz on this address while pulling, e.g. :8086")
+	stallTimeout := fs.Duration("stall-timeout", defaultStallTimeout, "Report downloads as stalled on /healthz when no data arrived for this long")
+	waitComplete := fs.Bool("wait-complete", false, "For init containers: check files already present, replace damaged ones, log JSON to standard error and leave "+ollamadl.CompleteFileName+" behind; a model already marked complete is left alone")
+	timeout := addTimeoutFlag(fs)
+	batchFile := fs.String("f", "", "Also pull the models listed in this file, one per line (- for standard input)")
+	var create createFlag
+	fs.Var(&create, "create", "Register the model with the local Ollama through a generated Modelfile, as `name` if given (-create=name)")
+	pushToOllama := fs.String("push-to-ollama", "", "Create the model on the Ollama server at this URL, e.g. http://host:11434, uploading its files through the API")
+	modelfile := fs.String("modelfile", "", "Also pull the models this Modelfile builds on with FROM and ADAPTER, as ollama create needs them")
+	refs := parseModelList(fs, args, batchFile, modelfile, "ollama-dl [flags] <name>... | -f <file> | -modelfile <file>")
+	if create.name != "" && len(refs) > 1 {
+		return errors.New("-create=name takes a single model")
+	}
+	if *latestLink != "" && len(refs) > 1 {
+		return errors.New("-link takes a single model")
 	}
 
-	var jobs []DownloadJob
-	for _, layer := range manifest.Layers {
-		fileTemplate, ok := mediaTypeToFileTemplate[layer.MediaType]
-		if !ok {
-			continue
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	if *lmStudio && *destDir == "" {
+		if *destDir, err = ollamadl.LMStudioModelsDir(); err != nil {
+			return err
+		}
+	}
+	store, dir, err := openStore(*destDir, refs[0])
+	if err != nil {
+		return err
+	}
+	// Several models go into directories of their own, under -d if given.
+	dirs := []string{dir}
+	if *lmStudio {
+		// LM Studio wants <publisher>/<model> directories under its own.
+		dirs = dirs[:0]
+		for _, ref := range refs {
+			dirs = append(dirs, path.Join(dir, ollamadl.LMStudioDir(ref)))
+		}
+	} else if len(refs) > 1 {
+		dirs = dirs[:0]
+		for _, ref := range refs {
+			if *destDir == "" {
+				dirs = append(dirs, ref.DirName())
+			} else {
+				dirs = append(dirs, path.Join(dir, ref.DirName()))
+			}
 		}
+	}
+	if err := sf.apply(store); err != nil {
+		return err
+	}
+	opts.Store = store
+	opts.AggregateLicenses = *aggregateLicenses
+	opts.WriteChecksums = *checksums
+	if opts.AttestationKey, err = readAttestationKey(*attestKey); err != nil {
+		return err
+	}
+	opts.Attestation = *attest || *attestKey != ""
+	opts.MergeSplits = *mergeSplits
+	opts.DedupeDir = *dedupeDir
+	opts.DedupeSymlinks = *symlink
+	if *reuseOllama {
+		if opts.OllamaModels, err = ollamadl.OllamaModelsDir(); err != nil {
+			return err
+		}
+	}
+	opts.OCILayout = *oci
+	opts.LMStudioLayout = *lmStudio
+	opts.ModelCard = *modelCard
+	opts.DeltaUpdates = *delta
+	opts.Progress = newBarReporter()
+	opts.Force = *force
+	opts.StaleTempAge = *staleTempAge
+	opts.KeepVersions = *keep
+	opts.LatestLink = *latestLink
+	opts.VersionedDirs = *versioned
+	// status logs what -wait-complete does with each model.
+	var status *slog.Logger
+	if *waitComplete {
+		level := slog.LevelWarn
+		if rf.verbose {
+			level = slog.LevelDebug
+		}
+		opts.Logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+		opts.Progress = nil
+		status = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
 
-		shortHash, err := getShortHash(layer)
+	if *healthListen != "" {
+		status := health.New(*stallTimeout)
+		opts = status.Instrument(opts)
+		mux := http.NewServeMux()
+		mux.Handle("GET /healthz", status)
+		stop, err := serveHealth(*healthListen, mux, opts.Logger)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		filename := fmt.Sprintf(fileTemplate, shortHash)
-		destPath := filepath.Join(destDir, filename)
-		blobURL := fmt.Sprintf("%s/v2/%s/blobs/%s", registry, name, layer.Digest)
-
-		jobs = append(jobs, DownloadJob{
-			Layer:    layer,
-			DestPath: destPath,
-			BlobURL:  blobURL,
-			Size:     layer.Size,
-		})
+		defer stop()
 	}
 
-	return jobs, nil
-}
+	tracer, err := tracing.FromEnv("ollama-dl", opts.Logger)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracer(tracer)
 
-func main() {
-	registry := flag.String("registry", "https://registry.ollama.ai/", "Registry URL")
-	destDir := flag.String("d", "", "Destination directory")
+	d, err := ollamadl.New(tracer.Instrument(opts))
+	if err != nil {
+		return err
+	}
 
-	flag.Parse()
+	// All models are pulled at once by the same Downloader, so they share
+	// -concurrency and -limit-rate and take turns for download slots.
+	ctx, cancel := withTimeout(tracing.ContextWithTraceparent(commandContext(), os.Getenv("TRACEPARENT")), *timeout)
+	defer cancel()
+	errs := make([]error, len(refs))
+	var wg sync.WaitGroup
+	for i, ref := range refs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, span := tracer.Start(ctx, "pull", "ollamadl.model", ref.String())
+			defer func() { span.End(errs[i]) }()
+			var res *ollamadl.Resolution
+			if !*waitComplete {
+				if res, errs[i] = d.Pull(ctx, ref, dirs[i]); errs[i] == nil {
+					// With -versioned the model is in a subdirectory.
+					dirs[i] = res.DestDir
+				}
+				return
+			}
+			var complete bool
+			res, complete, errs[i] = d.PullComplete(ctx, ref, dirs[i])
+			if errs[i] == nil {
+				dirs[i] = res.DestDir
+			}
+			switch {
+			case errs[i] != nil:
+				status.Error("Model failed", "model", ref.String(), "dir", dirs[i], "error", errs[i].Error())
+			case complete:
+				status.Info("Model already complete", "model", ref.String(), "dir", dirs[i], "digest", res.Manifest.Digest)
+			default:
+				status.Info("Model complete", "model", ref.String(), "dir", dirs[i], "digest", res.Manifest.Digest)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := range errs {
+		errs[i] = timedOut(ctx, errs[i])
+	}
 
-	if len(flag.Args()) < 1 {
-		fmt.Println("Usage: ollama-dl <name>")
-		os.Exit(1)
+	if create.set {
+		if _, ok := store.(*ollamadl.FileStore); !ok || *oci {
+			return errors.New("-create needs a local directory and the usual layout")
+		}
+		for i, ref := range refs {
+			if errs[i] != nil {
+				continue
+			}
+			name := create.name
+			if name == "" {
+				name = ollamaName(ref)
+			}
+			errs[i] = ollamaCreate(ctx, d, dirs[i], name, opts.Logger)
+		}
+	}
+	if *pushToOllama != "" {
+		if _, ok := store.(*ollamadl.FileStore); !ok || *oci {
+			return errors.New("-push-to-ollama needs a local directory and the usual layout")
+		}
+		for i, ref := range refs {
+			if errs[i] != nil {
+				continue
+			}
+			name := create.name
+			if name == "" {
+				name = ollamaName(ref)
+			}
+			errs[i] = d.PushToOllama(ctx, dirs[i], *pushToOllama, name)
+		}
 	}
 
-	name := flag.Arg(0)
-	if !strings.Contains(name, "/") {
-		name = "library/" + name
+	if *waitComplete {
+		// The outcome of each model has been logged already.
+		failed := 0
+		for _, err := range errs {
+			if err != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d models are not complete", failed, len(refs))
+		}
+		return nil
+	}
+	if len(refs) == 1 {
+		if errs[0] != nil {
+			return fmt.Errorf("download failed: %w", errs[0])
+		}
+		fmt.Println("Download complete")
+		return nil
 	}
+	failed := 0
+	for i, ref := range refs {
+		if errs[i] != nil {
+			fmt.Printf("FAILED %s: %v\n", ref, errs[i])
+			failed++
+		} else {
+			fmt.Printf("OK     %s (%s)\n", ref, dirs[i])
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed: %w", failed, len(refs), errors.Join(errs...))
+	}
+	fmt.Println("Download complete")
+	return nil
+}
+
+// commandContext returns a context cancelled on Ctrl-C or SIGTERM, so
+// commands stop cleanly and leave resumable partial downloads behind.
+func commandContext() context.Context {
+	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	return ctx
+}
 
-	// Check for version and append ":latest" if not specified
-	if !strings.Contains(name, ":") {
-		name += ":latest"
+// errTimedOut is why a context bounded by -timeout ended.
+var errTimedOut = errors.New("timed out")
+
+// addTimeoutFlag adds the -timeout flag, which bounds a whole command.
+func addTimeoutFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("timeout", 0, "Give up after this long, e.g. 2h, however far along; single transfers have no limit of their own within it (default none)")
+}
+
+// withTimeout bounds ctx by timeout, unless that is 0. When the time is
+// up, context.Cause tells so.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", errTimedOut, timeout))
+}
 
-	// Construct the destination directory name after handling the version
-	if *destDir == "" {
-		*destDir = strings.ReplaceAll(strings.ReplaceAll(name, "/", "-"), ":", "-")
+// timedOut returns why ctx ended in place of err when that was its
+// timeout: whatever the timeout interrupted fails with a bare deadline
+// error.
+func timedOut(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); err != nil && errors.Is(cause, errTimedOut) {
+		return cause
 	}
+	return err
+}
 
-	nameParts := strings.Split(name, ":")
-	name, version := nameParts[0], nameParts[1]
+// shutdownTracer sends the spans the tracer still holds, giving up after a
+// few seconds so an unreachable collector doesn't hang the command.
+func shutdownTracer(t *tracing.Tracer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t.Shutdown(ctx)
+}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	jobs, err := getDownloadJobs(client, *registry, *destDir, name, version)
-	if err != nil {
-		fmt.Println("Error getting download jobs:", err)
-		os.Exit(1)
+func main() {
+	args := os.Args[1:]
+	run := runPull
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			run, args = cmd, args[1:]
+		}
 	}
 
-	var wg sync.WaitGroup
-	for _, job := range jobs {
-		if _, err := os.Stat(job.DestPath); err == nil {
-			fmt.Println("Already have", job.DestPath)
-			continue
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		switch {
+		case errors.Is(err, ollamadl.ErrManifestNotFound):
+			fmt.Fprintln(os.Stderr, "Check the model name and tag on https://ollama.com/library")
+		case errors.Is(err, ollamadl.ErrUnauthorized):
+			fmt.Fprintln(os.Stderr, "The registry refused access; the model may be private")
 		}
-		wg.Add(1)
-		go func(job DownloadJob) {
-			if err := downloadBlob(client, job, &wg); err != nil {
-				fmt.Println("Download error:", err)
-			}
-		}(job)
+		os.Exit(1)
 	}
-
-	wg.Wait()
-	fmt.Println("Download complete")
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+const mirrorUsage = "ollama-dl mirror [flags] <name|namespace/>..."
+
+// runMirror implements "ollama-dl mirror", which pulls every tag of models,
+// or of all models in a namespace.
+func runMirror(args []string) error {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	destDir := fs.String("d", "", "Destination directory or storage URL; each tag goes into a subdirectory")
+	fs.StringVar(destDir, "dest", "", "Same as -d")
+	sf := addStoreFlags(fs)
+	listFile := fs.String("list", "", "Also mirror the models and namespaces listed in this file, one per line")
+	var include, exclude patterns
+	fs.Var(&include, "include", "Only mirror models matching this glob, e.g. library/llama*; with a colon it is matched against name:tag (repeatable)")
+	fs.Var(&exclude, "exclude", "Don't mirror models matching this glob (repeatable)")
+	jobs := fs.Int("jobs", 1, "Mirror this many models at a time")
+	symlink := fs.Bool("symlink", false, "Link files shared between tags and models symbolically instead of with hard links")
+	delta := fs.Bool("delta", false, "Fetch changed layers by reusing the unchanged ranges of their previous files")
+	ledgerPath := fs.String("ledger", "", "Record mirrored tags in this file and skip them when run again (default .mirror-ledger in a local -d)")
+	timeout := addTimeoutFlag(fs)
+
+	// Flags may come before, between and after the names.
+	var names []string
+	fs.Parse(args)
+	for fs.NArg() > 0 {
+		names = append(names, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	if *listFile != "" {
+		listed, err := readNameList(*listFile)
+		if err != nil {
+			return err
+		}
+		names = append(names, listed...)
+	}
+	if len(names) == 0 {
+		fmt.Println("Usage:", mirrorUsage)
+		os.Exit(1)
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	store, dir, err := openStore(*destDir, ollamadl.Reference{})
+	if err != nil {
+		return err
+	}
+	// A single model mirrors its tags straight into -d; several get a
+	// directory each.
+	single := len(names) == 1 && !strings.HasSuffix(names[0], "/")
+	if *destDir == "" {
+		dir = "."
+		if single {
+			ref, err := ollamadl.ParseReference(names[0])
+			if err != nil {
+				return err
+			}
+			dir = strings.ReplaceAll(ref.Name, "/", "-")
+		}
+	}
+	if _, ok := store.(*ollamadl.FileStore); ok {
+		// Models share layers such as licenses; store those once.
+		opts.DedupeDir = dir
+		opts.DedupeSymlinks = *symlink
+		if *ledgerPath == "" {
+			*ledgerPath = filepath.Join(dir, ".mirror-ledger")
+		}
+	}
+	if err := sf.apply(store); err != nil {
+		return err
+	}
+	opts.Store = store
+	opts.DeltaUpdates = *delta
+	opts.Progress = newBarReporter()
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(commandContext(), *timeout)
+	defer cancel()
+
+	targets, err := expandMirrorNames(ctx, d, names, include, exclude)
+	if err != nil {
+		return err
+	}
+	ledger, err := openMirrorLedger(*ledgerPath)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	var (
+		mu                     sync.Mutex
+		errs                   []error
+		models, pulled, reused int
+		wg                     sync.WaitGroup
+		slots                  = make(chan struct{}, max(*jobs, 1))
+	)
+	for _, t := range targets {
+		modelDir := dir
+		if !single {
+			modelDir = path.Join(filepath.ToSlash(dir), strings.ReplaceAll(t.ref.Name, "/", "-"))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+
+			result, err := mirrorRepo(ctx, d, t, modelDir, include, exclude, ledger)
+			mu.Lock()
+			defer mu.Unlock()
+			if result != nil && len(result.Tags) > 0 {
+				models++
+				pulled += len(result.Pulled)
+				reused += result.Reused
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Printf("Mirrored %d tags of %d models, reusing %d shared files\n", pulled, models, reused)
+	if ctx.Err() != nil {
+		return fmt.Errorf("mirror failed: %w", context.Cause(ctx))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("mirror failed: %w", err)
+	}
+	return nil
+}
+
+// mirrorTarget is a repository to mirror. A nil tags means all of them.
+type mirrorTarget struct {
+	ref  ollamadl.Reference
+	tags []string
+}
+
+// expandMirrorNames turns the names given to mirror into repositories.
+// Names ending in a slash are namespaces, expanded through the registry's
+// catalog; names with a tag stand for just that tag.
+func expandMirrorNames(ctx context.Context, d *ollamadl.Downloader, names []string, include, exclude patterns) ([]mirrorTarget, error) {
+	var (
+		targets []mirrorTarget
+		byName  = make(map[string]int)
+		catalog []string
+	)
+	add := func(name, tag string) {
+		if !include.allowsRepo(name) || exclude.excludesRepo(name) {
+			return
+		}
+		var tags []string
+		if tag != "" {
+			tags = []string{tag}
+		}
+		i, ok := byName[name]
+		switch {
+		case !ok:
+			byName[name] = len(targets)
+			targets = append(targets, mirrorTarget{ref: ollamadl.Reference{Name: name}, tags: tags})
+		case tags == nil:
+			targets[i].tags = nil
+		case targets[i].tags != nil:
+			targets[i].tags = append(targets[i].tags, tag)
+		}
+	}
+
+	for _, name := range names {
+		if namespace, ok := strings.CutSuffix(name, "/"); ok {
+			if catalog == nil {
+				var err error
+				if catalog, err = d.Catalog(ctx); err != nil {
+					return nil, fmt.Errorf("listing repositories for %s: %w", name, err)
+				}
+			}
+			for _, repo := range catalog {
+				if strings.HasPrefix(repo, namespace+"/") {
+					add(repo, "")
+				}
+			}
+			continue
+		}
+
+		ref, err := ollamadl.ParseReference(name)
+		if err != nil {
+			return nil, err
+		}
+		tag := ""
+		if strings.Contains(name, ":") {
+			tag = ref.Tag
+		}
+		add(ref.Name, tag)
+	}
+	return targets, nil
+}
+
+// mirrorRepo mirrors the tags of t that the patterns select and the
+// ledger doesn't list yet, and records the ones mirrored.
+func mirrorRepo(ctx context.Context, d *ollamadl.Downloader, t mirrorTarget, dir string, include, exclude patterns, ledger *mirrorLedger) (*ollamadl.MirrorResult, error) {
+	tags := t.tags
+	if tags == nil {
+		var err error
+		if tags, err = d.Tags(ctx, t.ref); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.ref.Name, err)
+		}
+	}
+
+	var todo []string
+	for _, tag := range tags {
+		ref := ollamadl.Reference{Name: t.ref.Name, Tag: tag}
+		if (len(include) > 0 && !include.match(ref)) || exclude.match(ref) || ledger.has(ref) {
+			continue
+		}
+		todo = append(todo, tag)
+	}
+	if len(todo) == 0 {
+		return nil, nil
+	}
+
+	result, err := d.MirrorTags(ctx, t.ref, todo, dir)
+	if result != nil {
+		for _, res := range result.Pulled {
+			if lerr := ledger.add(res.Ref); lerr != nil {
+				err = errors.Join(err, lerr)
+			}
+		}
+	}
+	return result, err
+}
+
+// readNameList reads the names in a -list file. Blank lines and lines
+// starting with # are ignored.
+func readNameList(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+// patterns is a repeatable flag of glob patterns over model names. A
+// pattern with a colon is matched against name:tag, others against the
+// name alone.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(s string) error {
+	if _, err := path.Match(s, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q", s)
+	}
+	*p = append(*p, s)
+	return nil
+}
+
+// match reports whether any pattern matches ref.
+func (p patterns) match(ref ollamadl.Reference) bool {
+	for _, pattern := range p {
+		s := ref.Name
+		if strings.Contains(pattern, ":") {
+			s = ref.String()
+		}
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsRepo reports whether include patterns p may select some tag of
+// the repository name, so it is worth listing its tags.
+func (p patterns) allowsRepo(name string) bool {
+	if len(p) == 0 {
+		return true
+	}
+	for _, pattern := range p {
+		namePattern, _, _ := strings.Cut(pattern, ":")
+		if ok, _ := path.Match(namePattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// excludesRepo reports whether exclude patterns p rule out every tag of
+// the repository name.
+func (p patterns) excludesRepo(name string) bool {
+	for _, pattern := range p {
+		if strings.Contains(pattern, ":") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorLedger records mirrored tags in a file, one name:tag per line, so
+// an interrupted mirror skips them when run again. A nil ledger records
+// nothing.
+type mirrorLedger struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]bool
+}
+
+func openMirrorLedger(name string) (*mirrorLedger, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &mirrorLedger{file: f, done: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			l.done[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading ledger %s: %v", name, err)
+	}
+	return l, nil
+}
+
+func (l *mirrorLedger) has(ref ollamadl.Reference) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.done[ref.String()]
+}
+
+func (l *mirrorLedger) add(ref ollamadl.Reference) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done[ref.String()] {
+		return nil
+	}
+	l.done[ref.String()] = true
+	if _, err := fmt.Fprintln(l.file, ref); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *mirrorLedger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package ollamadl
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime falls back to the modification time where reading the access
+// time isn't wired up.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,217 @@
+package ollamadl
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AttestationFileName is the file in a model's directory that
+// Options.Attestation writes the pull's provenance to.
+const AttestationFileName = "attestation.intoto.json"
+
+// Types of the attestation: an in-toto statement carrying SLSA provenance,
+// wrapped in a DSSE envelope when signed.
+const (
+	inTotoStatementType  = "https://in-toto.io/Statement/v1"
+	inTotoPayloadType    = "application/vnd.in-toto+json"
+	slsaProvenanceType   = "https://slsa.dev/provenance/v1"
+	attestationBuildType = "https://" + modulePath + "/pull/v1"
+)
+
+// inTotoStatement is what an attestation states: that the subjects, the
+// files of a pull, came about as the predicate describes.
+type inTotoStatement struct {
+	Type          string            `json:"_type"`
+	Subject       []inTotoSubject   `json:"subject"`
+	PredicateType string            `json:"predicateType"`
+	Predicate     provenancePayload `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// provenancePayload is a SLSA provenance predicate for a pull: the model
+// and registry it was asked for, the manifest and layers it resolved to,
+// and the version of ollama-dl that fetched them.
+type provenancePayload struct {
+	BuildDefinition struct {
+		BuildType          string `json:"buildType"`
+		ExternalParameters struct {
+			Model    string `json:"model"`
+			Registry string `json:"registry"`
+		} `json:"externalParameters"`
+		ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  time.Time `json:"startedOn"`
+			FinishedOn time.Time `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+type resourceDescriptor struct {
+	URI       string            `json:"uri"`
+	Digest    map[string]string `json:"digest"`
+	MediaType string            `json:"mediaType,omitempty"`
+}
+
+// dsseEnvelope is a signed attestation.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// ParsePrivateKey parses a PEM-encoded, unencrypted private key for
+// Options.AttestationKey: PKCS #8, or an EC or RSA key in its own format,
+// as openssl writes them.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	if strings.Contains(block.Type, "ENCRYPTED") || block.Headers["Proc-Type"] != "" {
+		return nil, errors.New("encrypted keys are not supported")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// writeAttestation writes AttestationFileName to the directory of res,
+// stating the provenance of its files as pulled between started and
+// meta.Pulled.
+func (d *Downloader) writeAttestation(ctx context.Context, res *Resolution, meta pullMetadata, started time.Time) error {
+	sums, err := d.fileDigests(ctx, res)
+	if err != nil {
+		return err
+	}
+	st := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []inTotoSubject{},
+		PredicateType: slsaProvenanceType,
+	}
+	for name, digest := range sums {
+		st.Subject = append(st.Subject, inTotoSubject{Name: name, Digest: digestSet(digest)})
+	}
+	sort.Slice(st.Subject, func(i, j int) bool { return st.Subject[i].Name < st.Subject[j].Name })
+
+	p := &st.Predicate
+	p.BuildDefinition.BuildType = attestationBuildType
+	p.BuildDefinition.ExternalParameters.Model = meta.Model
+	p.BuildDefinition.ExternalParameters.Registry = meta.Registry
+	repo := meta.Registry + "/v2/" + res.Ref.Name
+	p.BuildDefinition.ResolvedDependencies = append(p.BuildDefinition.ResolvedDependencies, resourceDescriptor{
+		URI:       repo + "/manifests/" + res.Ref.Tag,
+		Digest:    digestSet(meta.Digest),
+		MediaType: res.Manifest.MediaType,
+	})
+	for _, layer := range append([]Layer{res.Manifest.Config}, res.Manifest.Layers...) {
+		p.BuildDefinition.ResolvedDependencies = append(p.BuildDefinition.ResolvedDependencies, resourceDescriptor{
+			URI:       repo + "/blobs/" + layer.Digest,
+			Digest:    digestSet(layer.Digest),
+			MediaType: layer.MediaType,
+		})
+	}
+	p.RunDetails.Builder.ID = "https://" + modulePath
+	p.RunDetails.Builder.Version = map[string]string{"ollama-dl": meta.Tool}
+	p.RunDetails.Metadata.StartedOn = started.UTC().Truncate(time.Second)
+	p.RunDetails.Metadata.FinishedOn = meta.Pulled
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if key := d.opts.AttestationKey; key != nil {
+		if data, err = signAttestation(key, data); err != nil {
+			return fmt.Errorf("signing: %w", err)
+		}
+	}
+	name := path.Join(path.Clean(filepath.ToSlash(res.DestDir)), AttestationFileName)
+	return writeFile(ctx, d.opts.Store, name, "application/json", append(data, '\n'))
+}
+
+// digestSet turns "sha256:<hex>" into the form in-toto records digests in.
+func digestSet(digest string) map[string]string {
+	algorithm, hash, _ := strings.Cut(digest, ":")
+	return map[string]string{algorithm: hash}
+}
+
+// signAttestation wraps statement in a DSSE envelope signed by key, which
+// cosign verify-blob-attestation and other in-toto tooling can check.
+func signAttestation(key crypto.Signer, statement []byte) ([]byte, error) {
+	// DSSE signs the pre-authentication encoding of the payload and its
+	// type, not the payload alone.
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(inTotoPayloadType), inTotoPayloadType, len(statement), statement)
+	var sig []byte
+	var err error
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		sig, err = key.Sign(rand.Reader, []byte(pae), crypto.Hash(0))
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+		hash := sha256.Sum256([]byte(pae))
+		sig, err = key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	keyID := sha256.Sum256(pub)
+	return json.MarshalIndent(dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures: []dsseSignature{{
+			KeyID: hex.EncodeToString(keyID[:]),
+			Sig:   base64.StdEncoding.EncodeToString(sig),
+		}},
+	}, "", "  ")
+}
@@ -0,0 +1,198 @@
+package ollamadl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// AuditEvent records one pull, for Options.Audit: who ran it, when, what it
+// fetched and where it put it.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// User and Host are the account and machine the pull ran as and on.
+	User   string `json:"user"`
+	Host   string `json:"host"`
+	Model  string `json:"model"`
+	Digest string `json:"digest,omitempty"`
+	// Layers are the digests of the model's config and layers.
+	Layers   []string `json:"layers,omitempty"`
+	Dest     string   `json:"dest"`
+	Registry string   `json:"registry,omitempty"`
+	// Bytes is how much the pull downloaded.
+	Bytes int64 `json:"bytes"`
+	// Status is "done", "failed" or "cancelled".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Tool   string `json:"tool"`
+}
+
+// Auditor records AuditEvents somewhere they can't be quietly changed, for
+// Options.Audit.
+type Auditor interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// auditEvent describes the outcome of a pull.
+func (d *Downloader) auditEvent(summary PullSummary) AuditEvent {
+	e := AuditEvent{
+		Time:   time.Now().UTC(),
+		User:   currentUser(),
+		Model:  summary.Ref.String(),
+		Dest:   summary.DestDir,
+		Bytes:  summary.Downloaded,
+		Status: "done",
+		Tool:   toolVersion(),
+	}
+	e.Host, _ = os.Hostname()
+	if fs, ok := d.opts.Store.(*FileStore); ok {
+		e.Dest = fs.Path(filepath.ToSlash(summary.DestDir))
+	}
+	if res := summary.Resolution; res != nil {
+		meta := d.metadata(res)
+		e.Digest, e.Registry = meta.Digest, meta.Registry
+		for _, layer := range append([]Layer{res.Manifest.Config}, res.Manifest.Layers...) {
+			e.Layers = append(e.Layers, layer.Digest)
+		}
+	}
+	switch {
+	case errors.Is(summary.Err, context.Canceled):
+		e.Status, e.Error = "cancelled", summary.Err.Error()
+	case summary.Err != nil:
+		e.Status, e.Error = "failed", summary.Err.Error()
+	}
+	return e
+}
+
+// currentUser returns the name of the account the process runs as.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// AuditLog is an Auditor appending events to a file, one JSON object per
+// line. Each line carries the SHA-256 of the line before it, so changing or
+// removing an entry breaks the chain from there on, which VerifyAuditLog
+// detects; removing entries from the end can only be told from a copy of
+// the last hash kept elsewhere. Several processes can append at once.
+type AuditLog struct {
+	path string
+}
+
+// OpenAuditLog returns the audit log in the file path, which is created
+// on the first event.
+func OpenAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// auditEntry is a line of an AuditLog.
+type auditEntry struct {
+	AuditEvent
+	// Prev is the hex SHA-256 of the previous line, without its newline,
+	// or "" for the first.
+	Prev string `json:"prev"`
+}
+
+// maxAuditLine bounds the length of a line of an AuditLog.
+const maxAuditLine = 1 << 20
+
+func (l *AuditLog) Record(ctx context.Context, event AuditEvent) error {
+	unlock, err := lockFile(ctx, l.path+".lock", func() {})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	last, err := lastLine(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", l.path, err)
+	}
+	entry := auditEntry{AuditEvent: event}
+	if last != nil {
+		sum := sha256.Sum256(last)
+		entry.Prev = hex.EncodeToString(sum[:])
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// lastLine returns the last line of f, without its newline, or nil if f is
+// empty.
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, err
+	}
+	offset := max(info.Size()-maxAuditLine, 0)
+	buf := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	if buf[len(buf)-1] != '\n' {
+		return nil, errors.New("last line is incomplete")
+	}
+	buf = buf[:len(buf)-1]
+	i := bytes.LastIndexByte(buf, '\n')
+	if i < 0 && offset > 0 {
+		return nil, errors.New("last line is too long")
+	}
+	return buf[i+1:], nil
+}
+
+// VerifyAuditLog reads the audit log in the file path, checking the chain
+// of hashes, and returns its events up to the first line that breaks it,
+// with an error saying which line that is.
+func VerifyAuditLog(path string) ([]AuditEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return verifyAuditLog(f)
+}
+
+func verifyAuditLog(r io.Reader) ([]AuditEvent, error) {
+	var events []AuditEvent
+	prev := ""
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxAuditLine)
+	for n := 1; sc.Scan(); n++ {
+		var entry auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+			return events, fmt.Errorf("line %d: %v", n, err)
+		}
+		if entry.Prev != prev {
+			return events, fmt.Errorf("line %d: the line before it was changed or removed", n)
+		}
+		sum := sha256.Sum256(sc.Bytes())
+		prev = hex.EncodeToString(sum[:])
+		events = append(events, entry.AuditEvent)
+	}
+	return events, sc.Err()
+}
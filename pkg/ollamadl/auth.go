@@ -0,0 +1,187 @@
+package ollamadl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Credentials authenticate requests to registries that need it, such as
+// private registries and mirrors.
+type Credentials struct {
+	// Username and Password are sent with basic authentication, and are
+	// exchanged for a bearer token when the registry asks for one through a
+	// token service, as Docker-style registries do.
+	Username string
+	Password string
+	// Token is a bearer token sent as is, instead of Username and Password.
+	Token string
+}
+
+// authTransport adds credentials to requests to the registry host. Requests
+// to other hosts, such as the storage a blob request redirects to, are sent
+// without them.
+type authTransport struct {
+	next  http.RoundTripper
+	host  string
+	creds Credentials
+	// tokenURL, when set, is asked for tokens instead of the realm the
+	// registry's challenges name.
+	tokenURL string
+
+	mu     sync.Mutex
+	tokens map[string]string // by repository
+}
+
+func newAuthTransport(next http.RoundTripper, registry string, creds Credentials) (*authTransport, error) {
+	u, err := url.Parse(registry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry URL: %v", err)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &authTransport{next: next, host: u.Host, creds: creds, tokens: make(map[string]string)}, nil
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	repo := repository(req.URL.Path)
+	resp, err := t.next.RoundTrip(t.authorize(req, repo))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || t.creds.Token != "" || req.Body != nil {
+		return resp, err
+	}
+
+	// Only bearer challenges can be answered; anything else is the
+	// registry rejecting the credentials.
+	challenge := parseChallenge(resp.Header.Get("Www-Authenticate"))
+	if challenge == nil {
+		return resp, nil
+	}
+	token, err := t.fetchToken(req, challenge)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("getting registry token: %w", err)
+	}
+	resp.Body.Close()
+
+	t.mu.Lock()
+	t.tokens[repo] = token
+	t.mu.Unlock()
+	return t.next.RoundTrip(t.authorize(req, repo))
+}
+
+// authorize returns a copy of req carrying the best credentials known for
+// repo.
+func (t *authTransport) authorize(req *http.Request, repo string) *http.Request {
+	t.mu.Lock()
+	token := t.tokens[repo]
+	t.mu.Unlock()
+	if t.creds.Token != "" {
+		token = t.creds.Token
+	}
+
+	req = req.Clone(req.Context())
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case t.creds.Username != "":
+		req.SetBasicAuth(t.creds.Username, t.creds.Password)
+	}
+	return req
+}
+
+// fetchToken gets a bearer token from the token service named in a
+// challenge, presenting the basic credentials if there are any.
+func (t *authTransport) fetchToken(req *http.Request, challenge map[string]string) (string, error) {
+	realmURL := challenge["realm"]
+	if t.tokenURL != "" {
+		realmURL = t.tokenURL
+	}
+	// Some proxies, such as Nexus behind a path prefix, name the realm
+	// relative to the request.
+	realm, err := req.URL.Parse(realmURL)
+	if err != nil || realm.Host == "" || realmURL == "" {
+		return "", fmt.Errorf("invalid token realm %q", realmURL)
+	}
+	q := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if v := challenge[key]; v != "" {
+			q.Set(key, v)
+		}
+	}
+	realm.RawQuery = q.Encode()
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), "GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if t.creds.Username != "" {
+		tokenReq.SetBasicAuth(t.creds.Username, t.creds.Password)
+	}
+	resp, err := t.next.RoundTrip(tokenReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &HTTPError{StatusCode: resp.StatusCode, URL: realm.String()}
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token == "" {
+		body.Token = body.AccessToken
+	}
+	if body.Token == "" {
+		return "", fmt.Errorf("no token in response from %s", realm.Host)
+	}
+	return body.Token, nil
+}
+
+// repository returns the repository a /v2/<name>/{manifests,blobs,tags}/...
+// request is for, which is what bearer tokens are scoped to. The registry
+// may live under a path prefix, as Artifactory's /artifactory/api/docker/<key>.
+func repository(p string) string {
+	if i := strings.Index(p, "/v2/"); i >= 0 {
+		p = p[i:]
+	}
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(p, sep); i >= 0 {
+			return strings.TrimPrefix(p[:i], "/v2/")
+		}
+	}
+	return ""
+}
+
+// parseChallenge parses a `Bearer realm="...",service="...",scope="..."`
+// challenge, returning nil for other schemes.
+func parseChallenge(header string) map[string]string {
+	scheme, params, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil
+	}
+	challenge := make(map[string]string)
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		challenge[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return challenge
+}
@@ -0,0 +1,385 @@
+// Package azstore implements an ollamadl.BlobStore on Azure Blob Storage.
+// Blobs are streamed as staged blocks of a block blob, which only becomes
+// visible when its block list is committed after verification. Uncommitted
+// blocks survive for a week, so an interrupted upload can be continued by a
+// later run, with the digest's progress kept in a blob next to it so
+// nothing has to be read back.
+package azstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// BlockSize is the size of each staged block. A block blob holds at most
+// 50,000 blocks, so this caps a single blob at about 1.5 TiB.
+const BlockSize = 32 << 20
+
+const apiVersion = "2021-08-06"
+
+// hashStateSuffix names, after a blob's key, the blob keeping the hash state
+// of its unfinished upload.
+const hashStateSuffix = ".sha256.tmp"
+
+// Store writes blobs to Container in Account under Prefix.
+type Store struct {
+	Account   string
+	Container string
+	Prefix    string
+	// Endpoint is the blob service URL, e.g.
+	// https://account.blob.core.windows.net or, for Azurite,
+	// http://127.0.0.1:10000/devstoreaccount1.
+	Endpoint string
+	// Key is the decoded account key used for Shared Key authorization.
+	Key []byte
+	// SAS is a shared access signature query string, used instead of Key.
+	SAS    url.Values
+	Client *http.Client
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+// New returns a Store for a URL of the form az://account/container/prefix.
+// Credentials come from AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN. A
+// custom blob endpoint (e.g. Azurite) can be given in
+// AZURE_STORAGE_BLOB_ENDPOINT or as ?endpoint= in the URL.
+func New(rawURL string) (*Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	container, prefix, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if u.Scheme != "az" || u.Host == "" || container == "" {
+		return nil, fmt.Errorf("invalid Azure URL %q: want az://account/container/prefix", rawURL)
+	}
+
+	s := &Store{
+		Account:   u.Host,
+		Container: container,
+		Prefix:    strings.Trim(prefix, "/"),
+		Endpoint:  firstNonEmpty(u.Query().Get("endpoint"), os.Getenv("AZURE_STORAGE_BLOB_ENDPOINT"), "https://"+u.Host+".blob.core.windows.net"),
+		Client:    http.DefaultClient,
+	}
+
+	switch {
+	case os.Getenv("AZURE_STORAGE_SAS_TOKEN") != "":
+		s.SAS, err = url.ParseQuery(strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %v", err)
+		}
+	case os.Getenv("AZURE_STORAGE_KEY") != "":
+		s.Key, err = base64.StdEncoding.DecodeString(os.Getenv("AZURE_STORAGE_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %v", err)
+		}
+	default:
+		return nil, errors.New("Azure credentials missing: set AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN")
+	}
+	return s, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *Store) key(name string) string {
+	return path.Join(s.Prefix, name)
+}
+
+func (s *Store) blobURL(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Container + "/" + key
+
+	q := url.Values{}
+	for name, values := range query {
+		q[name] = values
+	}
+	for name, values := range s.SAS {
+		q[name] = values
+	}
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// do sends an authorized request and returns the response if its status is
+// one of ok.
+func (s *Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, ok ...int) (*http.Response, error) {
+	u, err := s.blobURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Ms-Version", apiVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	if s.SAS == nil {
+		signSharedKey(req, s.Account, s.Key)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	var azErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &azErr) == nil && azErr.Code != "" {
+		return nil, fmt.Errorf("azure %s %s: %s: %s", method, key, azErr.Code, strings.SplitN(azErr.Message, "\n", 2)[0])
+	}
+	if code := resp.Header.Get("X-Ms-Error-Code"); code != "" {
+		return nil, fmt.Errorf("azure %s %s: %s", method, key, code)
+	}
+	return nil, fmt.Errorf("azure %s %s: unexpected status code: %d", method, key, resp.StatusCode)
+}
+
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.key(name), nil, nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (s *Store) Remove(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.key(name), nil, nil, nil, http.StatusAccepted, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(name), nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Store) SaveHashState(ctx context.Context, name string, state []byte) error {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}, "Content-Type": {"application/json"}}
+	resp, err := s.do(ctx, http.MethodPut, s.key(name)+hashStateSuffix, nil, header, state, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) LoadHashState(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(name)+hashStateSuffix, nil, nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+func (s *Store) RemoveHashState(ctx context.Context, name string) error {
+	return s.Remove(ctx, name+hashStateSuffix)
+}
+
+// blockID names the i-th block. All IDs of a blob must have the same length.
+func blockID(i int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", i)))
+}
+
+// stagedBlocks returns the number of leading uncommitted blocks of key that
+// can be continued: a contiguous run of full blocks starting at the first.
+func (s *Store) stagedBlocks(ctx context.Context, key string) (int, error) {
+	query := url.Values{"comp": {"blocklist"}, "blocklisttype": {"uncommitted"}}
+	resp, err := s.do(ctx, http.MethodGet, key, query, nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+
+	var result struct {
+		Blocks []struct {
+			Name string `xml:"Name"`
+			Size int64  `xml:"Size"`
+		} `xml:"UncommittedBlocks>Block"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	full := make(map[string]bool)
+	for _, b := range result.Blocks {
+		if b.Size == BlockSize {
+			full[b.Name] = true
+		}
+	}
+	n := 0
+	for full[blockID(n)] {
+		n++
+	}
+	return n, nil
+}
+
+func (s *Store) ResumeOffset(ctx context.Context, name string) (int64, error) {
+	n, err := s.stagedBlocks(ctx, s.key(name))
+	return int64(n) * BlockSize, err
+}
+
+// upload tracks the blocks written for a blob that has not been committed.
+type upload struct {
+	blocks int
+}
+
+func (s *Store) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	key := s.key(name)
+	up := &upload{}
+
+	// Blocks left over from earlier attempts are simply overwritten or,
+	// beyond the new data, dropped when the block list is committed.
+	if resume {
+		n, err := s.stagedBlocks(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		up.blocks = n
+	}
+
+	s.mu.Lock()
+	if s.uploads == nil {
+		s.uploads = make(map[string]*upload)
+	}
+	s.uploads[name] = up
+	s.mu.Unlock()
+
+	return &writer{ctx: ctx, store: s, key: key, upload: up, buf: make([]byte, 0, BlockSize)}, nil
+}
+
+// Commit publishes the staged blocks as the blob's content, recording the
+// layer's digest and media type.
+func (s *Store) Commit(ctx context.Context, name string, layer ollamadl.Layer) error {
+	s.mu.Lock()
+	up := s.uploads[name]
+	delete(s.uploads, name)
+	s.mu.Unlock()
+	if up == nil {
+		return fmt.Errorf("no upload in progress for %s", name)
+	}
+
+	blockList := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{}
+	for i := 0; i < up.blocks; i++ {
+		blockList.Latest = append(blockList.Latest, blockID(i))
+	}
+	body, err := xml.Marshal(blockList)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{
+		"Content-Type":           {"application/xml"},
+		"X-Ms-Blob-Content-Type": {layer.MediaType},
+		"X-Ms-Meta-Digest":       {layer.Digest},
+		"X-Ms-Meta-Mediatype":    {layer.MediaType},
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.key(name), url.Values{"comp": {"blocklist"}}, header, body, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// writer buffers writes into blocks and stages each as it fills.
+type writer struct {
+	ctx    context.Context
+	store  *Store
+	key    string
+	upload *upload
+	buf    []byte
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) flush() error {
+	query := url.Values{"comp": {"block"}, "blockid": {blockID(w.upload.blocks)}}
+	resp, err := w.store.do(w.ctx, http.MethodPut, w.key, query, nil, w.buf, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	w.upload.blocks++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Staged returns how many bytes have been staged as blocks.
+func (w *writer) Staged() int64 {
+	return int64(w.upload.blocks) * BlockSize
+}
+
+func (w *writer) Close() error {
+	if len(w.buf) > 0 {
+		return w.flush()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package azstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// blobServer serves block blobs from memory for Put Blob, Get Blob and
+// Delete Blob.
+func blobServer(t *testing.T) *Store {
+	t.Helper()
+	var mu sync.Mutex
+	blobs := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			if r.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+				http.Error(w, "missing blob type", http.StatusBadRequest)
+				return
+			}
+			blobs[r.URL.Path], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet, http.MethodHead:
+			data, ok := blobs[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			if _, ok := blobs[r.URL.Path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(blobs, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &Store{Account: "acct", Container: "models", Prefix: "m", Endpoint: srv.URL, SAS: url.Values{"sig": {"x"}}, Client: srv.Client()}
+}
+
+func TestHashState(t *testing.T) {
+	ctx := context.Background()
+	s := blobServer(t)
+
+	if state, err := s.LoadHashState(ctx, "model.gguf"); err != nil || state != nil {
+		t.Fatalf("LoadHashState() = %q, %v before saving", state, err)
+	}
+	if err := s.SaveHashState(ctx, "model.gguf", []byte(`{"offset":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := s.LoadHashState(ctx, "model.gguf"); err != nil || string(state) != `{"offset":1}` {
+		t.Fatalf("LoadHashState() = %q, %v", state, err)
+	}
+	if exists, err := s.Exists(ctx, "model.gguf"); err != nil || exists {
+		t.Errorf("Exists() = %v, %v: the state must not look like the blob", exists, err)
+	}
+	if err := s.RemoveHashState(ctx, "model.gguf"); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := s.LoadHashState(ctx, "model.gguf"); err != nil || state != nil {
+		t.Fatalf("LoadHashState() = %q, %v after removing", state, err)
+	}
+}
+
+func TestWriterStaged(t *testing.T) {
+	w := &writer{upload: &upload{blocks: 2}, buf: make([]byte, 0, BlockSize)}
+	w.buf = append(w.buf, "buffered"...)
+	if got := w.Staged(); got != 2*BlockSize {
+		t.Errorf("Staged() = %d, want %d: buffered data isn't staged", got, 2*BlockSize)
+	}
+}
@@ -0,0 +1,424 @@
+package ollamadl
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ChecksumsFileName is the file in a bundle listing the SHA-256 of every
+// other file, in the format of sha256sum. With Options.WriteChecksums, pulls
+// write one too, covering the downloaded files and the manifest.
+const ChecksumsFileName = "SHA256SUMS"
+
+// bundleBlobPath returns the name of a blob in a bundle, e.g.
+// blobs/sha256/<hex>, as in an OCI image layout.
+func bundleBlobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// Export writes a bundle of ref to w, for carrying the model into networks
+// without registry access. A bundle is a tar archive holding the manifest as
+// manifest.json, the model's name and manifest digest as metadata.json,
+// every blob as stored in the registry under blobs/sha256/, and last a
+// SHA256SUMS file covering all of them.
+//
+// Blobs a pull saved unchanged into localDir are read from the store
+// rather than downloaded again; an empty localDir downloads everything.
+func (d *Downloader) Export(ctx context.Context, ref Reference, localDir string, w io.Writer) (*Resolution, error) {
+	res, err := d.Resolve(ctx, ref, localDir)
+	if err != nil {
+		return nil, err
+	}
+	local := make(map[string]string) // stored file by digest
+	if localDir != "" {
+		for _, job := range res.Jobs {
+			if job.compression() != "" {
+				continue // stored decompressed
+			}
+			if exists, err := d.opts.Store.Exists(ctx, job.DestPath); err != nil {
+				return nil, err
+			} else if exists {
+				local[job.Layer.Digest] = job.DestPath
+			}
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	var sums strings.Builder
+	now := time.Now().UTC().Truncate(time.Second)
+	addFile := func(name string, data []byte) error {
+		fmt.Fprintf(&sums, "%x  %s\n", sha256.Sum256(data), name)
+		return writeTarFile(tw, name, now, data)
+	}
+
+	manifest, err := json.MarshalIndent(res.Manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := addFile(ManifestFileName, append(manifest, '\n')); err != nil {
+		return nil, err
+	}
+	meta := d.metadata(res)
+	meta.Pulled = now
+	metadata, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := addFile(MetadataFileName, append(metadata, '\n')); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, layer := range append([]Layer{res.Manifest.Config}, res.Manifest.Layers...) {
+		if layer.Digest == "" || seen[layer.Digest] {
+			continue
+		}
+		seen[layer.Digest] = true
+		name := bundleBlobPath(layer.Digest)
+		job := DownloadJob{Layer: layer, Ref: ref, DestPath: name, BlobURL: d.blobURL(ref, layer.Digest), Size: layer.Size}
+		if err := d.exportBlob(ctx, job, local[layer.Digest], now, tw); err != nil {
+			return nil, fmt.Errorf("%s: %w", layer.Digest, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", strings.TrimPrefix(layer.Digest, "sha256:"), name)
+	}
+
+	if err := writeTarFile(tw, ChecksumsFileName, now, []byte(sums.String())); err != nil {
+		return nil, err
+	}
+	return res, tw.Close()
+}
+
+// exportBlob adds the blob of job to tw, reading it from the stored file
+// local if set, and from the registry otherwise.
+func (d *Downloader) exportBlob(ctx context.Context, job DownloadJob, local string, modTime time.Time, tw *tar.Writer) error {
+	var body io.ReadCloser
+	if local != "" {
+		opener, ok := d.opts.Store.(BlobOpener)
+		if !ok {
+			return fmt.Errorf("store cannot read back %s", local)
+		}
+		r, err := opener.Open(ctx, local)
+		if err != nil {
+			return err
+		}
+		body = r
+		d.log.Debug("Exporting stored file", "path", local)
+	} else {
+		body = &rangeReader{ctx: ctx, registry: d.registry, ref: job.Ref, digest: job.Layer.Digest}
+	}
+	defer body.Close()
+
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     job.DestPath,
+		Size:     job.Size,
+		Mode:     0644,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	reporter := d.opts.Progress
+	reporter.LayerStarted(job, 0)
+	hasher := sha256.New()
+	var r io.Reader = body
+	if local == "" {
+		r = d.limiter.reader(ctx, r)
+	}
+	// Copying exactly Size bytes keeps the archive well formed even if the
+	// blob is longer; the digest then catches it.
+	_, err = io.CopyN(io.MultiWriter(tw, hasher, progressWriter{job, reporter}), ctxReader{ctx, r}, job.Size)
+	if err == nil {
+		if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); got != job.Layer.Digest {
+			err = fmt.Errorf("%w: got %s", ErrDigestMismatch, got)
+		}
+	}
+	if err != nil {
+		reporter.LayerFailed(job, err)
+		return err
+	}
+	reporter.LayerCompleted(job)
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// Import unpacks a bundle written by Export from r into destDir, laid out
+// as Pull lays out downloads, and records it like a pull. An empty destDir
+// means the model's DirName. Every blob is checked against its digest and
+// the other files against the bundle's SHA256SUMS.
+func (d *Downloader) Import(ctx context.Context, r io.Reader, destDir string) (*Resolution, error) {
+	var (
+		res      *Resolution
+		byDigest = make(map[string][]DownloadJob)
+		stored   = make(map[string]bool)
+		unlock   = func() {}
+	)
+	defer func() { unlock() }()
+	var registry string
+	onModel := func(ref Reference, manifest *Manifest, meta pullMetadata) error {
+		registry = meta.Registry
+		if destDir == "" {
+			destDir = ref.DirName()
+		}
+		var err error
+		if unlock, err = d.lockDir(ctx, destDir); err != nil {
+			unlock = func() {}
+			return err
+		}
+		jobs, err := d.planJobs(ref, manifest, destDir)
+		if err != nil {
+			return err
+		}
+		res = &Resolution{Ref: ref, Manifest: *manifest, DestDir: destDir, Jobs: jobs}
+		for _, job := range jobs {
+			byDigest[job.Layer.Digest] = append(byDigest[job.Layer.Digest], job)
+		}
+		return nil
+	}
+	onBlob := func(layer Layer, blob io.Reader) (bool, error) {
+		jobs := byDigest[layer.Digest]
+		if len(jobs) == 0 {
+			return false, nil
+		}
+		if err := d.storeBlob(ctx, jobs[0], blob); err != nil {
+			return true, fmt.Errorf("%s: %w", jobs[0].DestPath, err)
+		}
+		for _, job := range jobs[1:] {
+			if err := d.linkStored(ctx, jobs[0].DestPath, job.DestPath, job.Layer); err != nil {
+				return true, err
+			}
+		}
+		stored[layer.Digest] = true
+		return true, nil
+	}
+	if err := readBundle(ctx, r, onModel, onBlob); err != nil {
+		return nil, err
+	}
+
+	for digest, jobs := range byDigest {
+		if !stored[digest] {
+			return nil, fmt.Errorf("bundle lacks the blob of %s", jobs[0].DestPath)
+		}
+	}
+	if d.opts.OCILayout {
+		if err := saveOCILayout(ctx, d.opts.Store, res); err != nil {
+			return nil, fmt.Errorf("saving %s: %w", OCIIndexFileName, err)
+		}
+		return res, nil
+	}
+	if err := saveManifest(ctx, d.opts.Store, destDir, res.Manifest); err != nil {
+		return nil, fmt.Errorf("saving %s: %w", ManifestFileName, err)
+	}
+	// The model still comes from where the bundle was exported from.
+	meta := d.metadata(res)
+	if registry != "" {
+		meta.Registry = registry
+	}
+	if err := saveMetadata(ctx, d.opts.Store, destDir, meta); err != nil {
+		return nil, fmt.Errorf("saving %s: %w", MetadataFileName, err)
+	}
+	if d.opts.WriteChecksums {
+		if err := d.writeChecksums(ctx, res); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", ChecksumsFileName, err)
+		}
+	}
+	return res, nil
+}
+
+// storeBlob writes the blob read from r to the store as job's file,
+// decompressing it like a download, and commits it once its digest checks
+// out.
+func (d *Downloader) storeBlob(ctx context.Context, job DownloadJob, r io.Reader) error {
+	reporter := d.opts.Progress
+	reporter.LayerStarted(job, 0)
+	err := func() error {
+		w, err := d.opts.Store.Create(ctx, job.DestPath, false)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+
+		hasher := sha256.New()
+		blob := io.TeeReader(ctxReader{ctx, r}, io.MultiWriter(hasher, progressWriter{job, reporter}))
+		content, err := decompress(blob, job.compression())
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		if _, err := io.Copy(w, content); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, blob); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); got != job.Layer.Digest {
+			return fmt.Errorf("%w: got %s", ErrDigestMismatch, got)
+		}
+		return d.opts.Store.Commit(ctx, job.DestPath, job.Layer)
+	}()
+	if err != nil {
+		reporter.LayerFailed(job, err)
+		return err
+	}
+	reporter.LayerCompleted(job)
+	return nil
+}
+
+// readBundle reads a bundle, calling onModel once its manifest and
+// metadata are read, with both, and onBlob for each blob of the manifest. onBlob
+// reports whether it consumed the blob, in which case it must also have
+// checked its digest; other blobs are checked by readBundle. Once the
+// whole bundle is read it is checked against its SHA256SUMS.
+func readBundle(ctx context.Context, r io.Reader, onModel func(ref Reference, manifest *Manifest, meta pullMetadata) error, onBlob func(layer Layer, r io.Reader) (bool, error)) error {
+	var (
+		tr       = tar.NewReader(r)
+		manifest *Manifest
+		layers   map[string]Layer
+		sums     = make(map[string]string) // hex digest by file name
+		expected []byte
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		hasher := sha256.New()
+		body := io.Reader(ctxReader{ctx, tr})
+		switch name := hdr.Name; {
+		case name == ManifestFileName:
+			manifest = &Manifest{}
+			if err := decodeEntry(body, hasher, name, manifest); err != nil {
+				return err
+			}
+		case name == MetadataFileName:
+			var meta pullMetadata
+			if err := decodeEntry(body, hasher, name, &meta); err != nil {
+				return err
+			}
+			if manifest == nil {
+				return fmt.Errorf("not a bundle: %s precedes %s", MetadataFileName, ManifestFileName)
+			}
+			ref, err := ParseReference(meta.Model)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", name, err)
+			}
+			manifest.Digest = meta.Digest
+			layers = make(map[string]Layer)
+			for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+				layers[layer.Digest] = layer
+			}
+			if err := onModel(ref, manifest, meta); err != nil {
+				return err
+			}
+		case name == ChecksumsFileName:
+			if expected, err = io.ReadAll(body); err != nil {
+				return err
+			}
+			continue
+		case strings.HasPrefix(name, "blobs/sha256/"):
+			layer, ok := layers["sha256:"+strings.TrimPrefix(name, "blobs/sha256/")]
+			if !ok {
+				return fmt.Errorf("not a bundle of the model: unexpected %s", name)
+			}
+			consumed, err := onBlob(layer, body)
+			if err != nil {
+				return err
+			}
+			if !consumed {
+				if _, err := io.Copy(hasher, body); err != nil {
+					return err
+				}
+				if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); got != layer.Digest {
+					return fmt.Errorf("%s: %w: got %s", name, ErrDigestMismatch, got)
+				}
+			}
+			sums[name] = strings.TrimPrefix(layer.Digest, "sha256:")
+			continue
+		default:
+			if _, err := io.Copy(hasher, body); err != nil {
+				return err
+			}
+		}
+		sums[hdr.Name] = hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	if layers == nil {
+		return fmt.Errorf("not a bundle: no %s and %s", ManifestFileName, MetadataFileName)
+	}
+	if expected == nil {
+		return fmt.Errorf("bundle has no %s", ChecksumsFileName)
+	}
+	want, err := parseChecksums(expected)
+	if err != nil {
+		return err
+	}
+	for name, sum := range want {
+		got, ok := sums[name]
+		switch {
+		case !ok:
+			return fmt.Errorf("bundle lacks %s", name)
+		case got != sum:
+			return fmt.Errorf("%s: %w", name, ErrDigestMismatch)
+		}
+	}
+	return nil
+}
+
+// parseChecksums parses the content of a ChecksumsFileName into hex digests
+// by file name.
+func parseChecksums(data []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return nil, fmt.Errorf("malformed %s line: %q", ChecksumsFileName, line)
+		}
+		sums[name] = sum
+	}
+	return sums, nil
+}
+
+// decodeEntry decodes the JSON bundle entry name from r into v, hashing
+// it as it goes.
+func decodeEntry(r io.Reader, hasher io.Writer, name string, v any) error {
+	data, err := io.ReadAll(io.TeeReader(r, hasher))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("reading %s: %v", name, err)
+	}
+	return nil
+}
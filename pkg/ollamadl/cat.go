@@ -0,0 +1,124 @@
+package ollamadl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Cat streams the content of ref's layers with the given media type to w, in
+// manifest order, decompressing them like Pull does. Interrupted transfers
+// are resumed with range requests.
+//
+// Each layer's digest is only known to match once it has been written in
+// full; a mismatch is reported as an error after the data has gone to w.
+// Options.SignaturePolicy and Options.Policy are checked before anything is
+// written.
+func (d *Downloader) Cat(ctx context.Context, ref Reference, mediaType string, w io.Writer) error {
+	manifest, err := d.fetchAllowedManifest(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	var layers []Layer
+	for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+		if layer.Digest != "" && baseMediaType(layer.MediaType) == mediaType {
+			layers = append(layers, layer)
+		}
+	}
+	if len(layers) == 0 {
+		return fmt.Errorf("%s has no %s layer", ref, mediaType)
+	}
+
+	for _, layer := range layers {
+		if err := d.catLayer(ctx, ref, layer, w); err != nil {
+			return fmt.Errorf("%s: %w", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+func (d *Downloader) catLayer(ctx context.Context, ref Reference, layer Layer, w io.Writer) error {
+	body := &rangeReader{ctx: ctx, registry: d.registry, ref: ref, digest: layer.Digest}
+	defer body.Close()
+
+	hasher := sha256.New()
+	blob := io.TeeReader(d.limiter.reader(ctx, body), hasher)
+	content, err := decompress(blob, layerCompression(layer.MediaType))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if _, err := io.Copy(w, content); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, blob); err != nil {
+		return err
+	}
+
+	if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); got != layer.Digest {
+		return fmt.Errorf("%w: got %s", ErrDigestMismatch, got)
+	}
+	return nil
+}
+
+// rangeReader reads a blob, reconnecting with a range request for the rest
+// when the connection fails part way.
+type rangeReader struct {
+	ctx      context.Context
+	registry Registry
+	ref      Reference
+	digest   string
+	offset   int64
+	body     io.ReadCloser
+	retries  int
+}
+
+func (r *rangeReader) open() error {
+	body, offset, err := r.registry.GetBlob(r.ctx, r.ref, r.digest, r.offset)
+	if err != nil {
+		return err
+	}
+	if offset != r.offset {
+		body.Close()
+		return fmt.Errorf("connection lost after %d bytes and the server can't resume", r.offset)
+	}
+	r.body = body
+	return nil
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		var err error
+		if r.body == nil {
+			err = r.open()
+		}
+		var n int
+		if err == nil {
+			n, err = r.body.Read(p)
+			r.offset += int64(n)
+		}
+		if err == nil || err == io.EOF || r.ctx.Err() != nil || r.retries >= numRetries || !retryable(err) {
+			return n, err
+		}
+
+		r.retries++
+		if r.body != nil {
+			r.body.Close()
+			r.body = nil
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *rangeReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"testing"
+)
+
+func TestCat(t *testing.T) {
+	d, reg, _ := newTestDownloader(t)
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, testGGUF("weights"))
+	reg.AddManifest(ref, config, model)
+
+	var buf bytes.Buffer
+	if err := d.Cat(context.Background(), ref, ModelMediaType, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := testGGUF("weights"); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Cat() wrote %q, want %q", buf.Bytes(), want)
+	}
+}
+
+func TestCatVerifiesSignature(t *testing.T) {
+	d, reg, _ := newTestDownloader(t)
+	d.opts.SignaturePolicy = &SignaturePolicy{Keys: []crypto.PublicKey{newTestKey(t).Public()}}
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, testGGUF("unsigned weights"))
+	reg.AddManifest(ref, config, model)
+
+	var buf bytes.Buffer
+	if err := d.Cat(context.Background(), ref, ModelMediaType, &buf); !errors.Is(err, ErrSignature) {
+		t.Errorf("Cat() of an unsigned model: %v, want ErrSignature", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Cat() wrote %d bytes of an unsigned model", buf.Len())
+	}
+}
+
+func TestCatPolicy(t *testing.T) {
+	d, reg, _ := newTestDownloader(t)
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, testGGUF("weights"))
+	for _, name := range []string{"library/llama3.2:3b", "test/model:latest"} {
+		ref, err := ParseReference(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg.AddManifest(ref, config, model)
+	}
+
+	tests := []struct {
+		name   string
+		ref    string
+		policy ModelPolicy
+	}{
+		{"denied", "llama3.2:3b", ModelPolicy{Deny: []string{"library/llama*"}}},
+		{"not allowed", "test/model", ModelPolicy{Allow: []string{"library/*"}}},
+		{"too large", "test/model", ModelPolicy{MaxSize: model.Size}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.opts.Policy = &tt.policy
+			ref, err := ParseReference(tt.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := d.Cat(context.Background(), ref, ModelMediaType, &buf); !errors.Is(err, ErrPolicy) {
+				t.Errorf("Cat() = %v, want ErrPolicy", err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("Cat() wrote %d bytes of a blocked model", buf.Len())
+			}
+		})
+	}
+}
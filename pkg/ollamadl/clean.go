@@ -0,0 +1,163 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Clean deletes the temporary files under root that interrupted pulls left
+// behind: those older than maxAge, when positive, and, in directories with
+// a saved manifest, those of layers the manifest doesn't have. Recent
+// staged downloads of current layers are what a later pull resumes from,
+// so they stay. Directories a pull is writing to are skipped. With dryRun
+// set nothing is deleted. Clean needs a FileStore.
+func (d *Downloader) Clean(ctx context.Context, root string, maxAge time.Duration, dryRun bool) (*PruneResult, error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil, errors.New("cleaning needs a local directory")
+	}
+
+	result := &PruneResult{}
+	top := store.Path(root)
+	err := filepath.WalkDir(top, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(top, p)
+		if err != nil {
+			return err
+		}
+		return d.cleanDir(ctx, store, path.Join(filepath.ToSlash(root), filepath.ToSlash(rel)), maxAge, dryRun, result)
+	})
+	if err != nil {
+		return result, err
+	}
+	sort.Strings(result.Removed)
+	return result, nil
+}
+
+// cleanDir removes the stale temporary files directly in the store
+// directory dir for Clean.
+func (d *Downloader) cleanDir(ctx context.Context, store *FileStore, dir string, maxAge time.Duration, dryRun bool, result *PruneResult) error {
+	temps, err := d.tempFiles(store, dir)
+	if err != nil || len(temps) == 0 {
+		return err
+	}
+
+	// A pull holding the lock is still using its files. Directories
+	// without a lock file aren't locked by anyone.
+	lockName := store.Path(path.Join(dir, LockFileName))
+	if _, err := os.Stat(lockName); err == nil {
+		unlock, err := tryLock(lockName)
+		if err != nil {
+			return err
+		} else if unlock == nil {
+			d.log.Debug("Not cleaning a directory in use", "dir", dir)
+			return nil
+		}
+		defer unlock()
+	}
+
+	manifest, err := readSavedManifest(ctx, store, dir)
+	if err != nil {
+		return err
+	}
+	var current map[string]bool
+	if manifest != nil {
+		current = make(map[string]bool)
+		for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+			if hash, err := getShortHash(layer); err == nil {
+				current[hash] = true
+			}
+		}
+	}
+
+	for _, info := range temps {
+		stale := maxAge > 0 && time.Since(info.ModTime()) > maxAge
+		if !stale && (current == nil || current[d.layerFileHash(strings.TrimSuffix(strings.TrimSuffix(info.Name(), hashStateSuffix), ".tmp"))]) {
+			continue
+		}
+		name := path.Join(dir, info.Name())
+		if !dryRun {
+			if err := os.Remove(store.Path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			d.log.Info("Removed", "path", name)
+		}
+		result.Removed = append(result.Removed, name)
+		result.Bytes += info.Size()
+	}
+	return nil
+}
+
+// removeStaleTemps removes the temporary files in the directory of res
+// that pulling it won't resume from: those of files res doesn't have, and
+// those older than Options.StaleTempAge. It runs with the directory
+// locked, so nothing else is writing them. Only FileStore directories
+// have them lying around.
+func (d *Downloader) removeStaleTemps(ctx context.Context, res *Resolution) error {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil
+	}
+	dir := path.Clean(filepath.ToSlash(res.DestDir))
+	temps, err := d.tempFiles(store, dir)
+	if err != nil {
+		return err
+	}
+	staged := make(map[string]bool)
+	for _, job := range res.Jobs {
+		staged[path.Clean(job.DestPath)+".tmp"] = true
+		staged[path.Clean(job.DestPath)+hashStateSuffix] = true
+	}
+	for _, info := range temps {
+		name := path.Join(dir, info.Name())
+		if staged[name] && (d.opts.StaleTempAge <= 0 || time.Since(info.ModTime()) <= d.opts.StaleTempAge) {
+			continue
+		}
+		if err := os.Remove(store.Path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		d.log.Info("Removed stale temporary file", "path", name)
+	}
+	return ctx.Err()
+}
+
+// tempFiles returns the temporary files Pull leaves directly in the store
+// directory dir, which needn't exist. Other files ending in .tmp are left
+// out.
+func (d *Downloader) tempFiles(store *FileStore, dir string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(store.Path(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var temps []fs.FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != ".tmp" || !d.pulledFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		temps = append(temps, info)
+	}
+	return temps, nil
+}
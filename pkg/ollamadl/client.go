@@ -0,0 +1,166 @@
+package ollamadl
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseDialTarget splits a dial address such as "unix:///run/registry.sock"
+// or "127.0.0.1:5000" into a network and address usable with net.Dial.
+func parseDialTarget(target string) (string, string, error) {
+	if strings.HasPrefix(target, "unix://") {
+		path := strings.TrimPrefix(target, "unix://")
+		if path == "" {
+			return "", "", fmt.Errorf("missing socket path in %q", target)
+		}
+		return "unix", path, nil
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return "", "", fmt.Errorf("invalid dial address %q: %v", target, err)
+	}
+	return "tcp", target, nil
+}
+
+// Defaults of the transport settings in Options.
+const (
+	defaultConnectTimeout        = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 30 * time.Second
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultMaxIdleConnsPerHost   = 16
+	defaultKeepAlive             = 30 * time.Second
+)
+
+// newHTTPClient builds the client used to talk to the registry given by
+// opts and returns the base URL requests should be made against. A
+// registry given as unix:///path is reached over that socket;
+// opts.DialOverride, when set, sends every connection to the given address
+// regardless of the host in the request URL. The seed client, for webseeds
+// and IPFS gateways, has the same timeouts, limits and name resolution but
+// dials the hosts it is asked for.
+func newHTTPClient(opts *Options) (client, seedClient *http.Client, registry string, err error) {
+	registry, dialOverride := opts.Registry, opts.DialOverride
+	if strings.HasPrefix(registry, "unix://") {
+		if dialOverride != "" {
+			return nil, nil, "", errors.New("a dial override cannot be combined with a unix:// registry")
+		}
+		dialOverride = registry
+		registry = "http://localhost"
+	}
+
+	// There is deliberately no overall client timeout: a multi-gigabyte blob
+	// can take hours. Callers bound operations with a context instead.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = cmp.Or(opts.TLSHandshakeTimeout, defaultTLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = cmp.Or(opts.ResponseHeaderTimeout, defaultResponseHeaderTimeout)
+	transport.IdleConnTimeout = cmp.Or(opts.IdleConnTimeout, defaultIdleConnTimeout)
+	transport.MaxIdleConnsPerHost = cmp.Or(opts.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	dialer := &net.Dialer{Timeout: cmp.Or(opts.ConnectTimeout, defaultConnectTimeout), KeepAlive: cmp.Or(opts.KeepAlive, defaultKeepAlive)}
+	transport.DialContext = dialer.DialContext
+	resolver, err := newHostResolver(opts, dialer)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if resolver != nil {
+		transport.DialContext = resolver.DialContext
+	}
+	switch opts.IPVersion {
+	case 0:
+	case 4, 6:
+		// The other dialing follows the network asked for.
+		dial, network := transport.DialContext, fmt.Sprintf("tcp%d", opts.IPVersion)
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		}
+	default:
+		return nil, nil, "", fmt.Errorf("invalid IP version %d", opts.IPVersion)
+	}
+
+	if opts.HTTP1 {
+		// A non-nil, empty TLSNextProto keeps HTTP/2 from being set up, and
+		// the TLS config cloned from the default transport offers it in
+		// ALPN already.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	seedTransport := transport.Clone()
+	if dialOverride != "" {
+		network, addr, err := parseDialTarget(dialOverride)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	registry, err = normalizeRegistryURL(registry)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	client = &http.Client{Transport: &protocolLogger{next: transport, log: opts.Logger}}
+	seedClient = &http.Client{Transport: &protocolLogger{next: seedTransport, log: opts.Logger}}
+	return client, seedClient, registry, nil
+}
+
+// protocolLogger logs, at debug level, the protocol of the first response
+// from each host, e.g. to confirm Options.HTTP1 took effect.
+type protocolLogger struct {
+	next http.RoundTripper
+	log  *slog.Logger
+	seen sync.Map // host -> struct{}
+}
+
+func (t *protocolLogger) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		if _, seen := t.seen.LoadOrStore(req.URL.Host, struct{}{}); !seen {
+			t.log.Debug("Negotiated protocol", "host", req.URL.Host, "protocol", resp.Proto)
+		}
+	}
+	return resp, err
+}
+
+// normalizeRegistryURL turns a registry as users give it into the base the
+// /v2 endpoints go under: a bare host, such as registry.example.com:5000,
+// gets https://, and trailing slashes and a /v2 path are dropped, while a
+// path a reverse proxy serves the registry under is kept.
+func normalizeRegistryURL(registry string) (string, error) {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+	u, err := url.Parse(registry)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid registry URL %s: scheme must be http or https", registry)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid registry URL %s: no host", registry)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid registry URL %s: query or fragment", registry)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.Path = strings.TrimSuffix(u.Path, "/v2")
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
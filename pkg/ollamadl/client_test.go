@@ -0,0 +1,45 @@
+package ollamadl
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSeedClient(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	// The dial override is for the registry only; the response header
+	// timeout applies to seeds as well.
+	_, seedClient, _, err := newHTTPClient(&Options{
+		Registry:              DefaultRegistry,
+		DialOverride:          "127.0.0.1:1",
+		ResponseHeaderTimeout: 50 * time.Millisecond,
+		Logger:                slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := seedClient.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("seed answered without headers")
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Get() = %v, want a response header timeout", err)
+	}
+}
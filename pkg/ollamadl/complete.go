@@ -0,0 +1,141 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// CompleteFileName is the marker file PullComplete leaves in a model's
+// directory once every file is in place and checked.
+const CompleteFileName = ".ollama-dl-complete"
+
+// completeMarker is the content of CompleteFileName.
+type completeMarker struct {
+	Model     string    `json:"model"`
+	Digest    string    `json:"digest"`
+	Completed time.Time `json:"completed"`
+}
+
+// PullComplete is Pull for init containers and other jobs that must leave
+// a complete, intact model behind or fail. Files already in destDir, e.g.
+// on a pre-populated volume, are checked against their digests first, and
+// those that don't match are replaced; layers stored decompressed can only
+// be checked for existence. Once every file is in place, it writes
+// CompleteFileName. If that marker is already there for ref and the files
+// it covers exist, PullComplete returns without contacting the registry
+// and reports that the model was complete.
+func (d *Downloader) PullComplete(ctx context.Context, ref Reference, destDir string) (*Resolution, bool, error) {
+	dir := path.Clean(filepath.ToSlash(destDir))
+	markerName := path.Join(dir, CompleteFileName)
+	unlock, err := d.lockDir(ctx, destDir)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	var marker completeMarker
+	if found, err := readStoredJSON(ctx, d.opts.Store, markerName, &marker); err != nil {
+		return nil, false, err
+	} else if found && marker.Model == ref.String() {
+		savedDir := destDir
+		if d.opts.VersionedDirs {
+			// The marker stays in destDir and names the version it covers.
+			if dir, err := versionDir(destDir, &Manifest{Digest: marker.Digest}); err == nil {
+				savedDir = dir
+			}
+		}
+		res, err := d.savedResolution(ctx, savedDir)
+		if err == nil && res.Manifest.Digest == marker.Digest {
+			if missing, err := d.missingFiles(ctx, res); err != nil {
+				return nil, false, err
+			} else if len(missing) == 0 {
+				return res, true, nil
+			}
+		}
+		d.log.Warn("Model marked complete is missing files; pulling it again", "model", ref.String(), "dir", destDir)
+	}
+
+	done := d.startPull(ctx, ref, destDir)
+	res, err := d.Resolve(ctx, ref, destDir)
+	if err != nil {
+		return nil, false, done(nil, d.opts.Hooks.fail(ctx, nil, err))
+	}
+	if err := d.replaceDamaged(ctx, res); err != nil {
+		return nil, false, done(res, d.opts.Hooks.fail(ctx, nil, err))
+	}
+	if err := d.pull(ctx, res); err != nil {
+		return nil, false, done(res, err)
+	}
+	if missing, err := d.missingFiles(ctx, res); err != nil {
+		return nil, false, done(res, err)
+	} else if len(missing) > 0 {
+		return nil, false, done(res, fmt.Errorf("files missing after the pull: %v", missing))
+	}
+
+	data, err := json.MarshalIndent(completeMarker{
+		Model:     ref.String(),
+		Digest:    res.Manifest.Digest,
+		Completed: time.Now().UTC().Truncate(time.Second),
+	}, "", "  ")
+	if err != nil {
+		return nil, false, done(res, err)
+	}
+	if err := writeFile(ctx, d.opts.Store, markerName, "application/json", append(data, '\n')); err != nil {
+		return nil, false, done(res, fmt.Errorf("writing %s: %w", CompleteFileName, err))
+	}
+	return res, false, done(res, nil)
+}
+
+// replaceDamaged checks the files of res already in the store and removes
+// those that don't match their digests, so pulling res downloads them
+// again.
+func (d *Downloader) replaceDamaged(ctx context.Context, res *Resolution) error {
+	var present []DownloadJob
+	for _, job := range res.Jobs {
+		exists, err := d.opts.Store.Exists(ctx, job.DestPath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			present = append(present, job)
+		}
+	}
+	results, err := d.verifyFiles(ctx, present, nil, nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		remover, ok := d.opts.Store.(BlobRemover)
+		if !ok {
+			return fmt.Errorf("%s: %w", r.Path, r.Err)
+		}
+		d.log.Warn("Replacing damaged file", "path", r.Path, "error", r.Err)
+		if err := remover.Remove(ctx, r.Path); err != nil {
+			return errors.Join(fmt.Errorf("%s: %w", r.Path, r.Err), err)
+		}
+	}
+	return nil
+}
+
+// missingFiles returns the files of res that aren't in the store.
+func (d *Downloader) missingFiles(ctx context.Context, res *Resolution) ([]string, error) {
+	var missing []string
+	for _, job := range res.Jobs {
+		exists, err := d.opts.Store.Exists(ctx, job.DestPath)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, job.DestPath)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,456 @@
+// Package davstore implements an ollamadl.BlobStore on a WebDAV share.
+//
+// Data is uploaded in chunks so an interrupted upload can be continued. On
+// Nextcloud and ownCloud, chunks go to the server's chunked upload area and
+// are assembled with a final MOVE. Other servers receive them as partial PUTs
+// (Content-Range) to a .tmp file that is moved into place once verified;
+// servers that ignore Content-Range on PUT are detected and reported.
+package davstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// ChunkSize is the size of each uploaded chunk. Nextcloud requires chunks
+// of at least 5 MiB, except for the last.
+const ChunkSize = 16 << 20
+
+// Store writes files below Base.
+type Store struct {
+	// Base is the URL of the collection files are stored under.
+	Base     *url.URL
+	Username string
+	Password string
+	Client   *http.Client
+	// Uploads is the Nextcloud chunked upload collection of the user, or
+	// nil to use partial PUTs.
+	Uploads *url.URL
+}
+
+// New returns a Store for a URL of the form webdav://host/path, or
+// webdavs://host/path for HTTPS. Credentials can be given in the URL or in
+// WEBDAV_USERNAME and WEBDAV_PASSWORD.
+func New(rawURL string) (*Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "webdav":
+		u.Scheme = "http"
+	case "webdavs":
+		u.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("invalid WebDAV URL %q: want webdav://host/path or webdavs://host/path", rawURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV URL %q: missing host", rawURL)
+	}
+
+	s := &Store{
+		Username: os.Getenv("WEBDAV_USERNAME"),
+		Password: os.Getenv("WEBDAV_PASSWORD"),
+		Client:   http.DefaultClient,
+	}
+	if u.User != nil {
+		s.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			s.Password = password
+		}
+		u.User = nil
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawPath = ""
+	s.Base = u
+
+	// Nextcloud/ownCloud files live under /remote.php/dav/files/<user>/.
+	if before, rest, ok := strings.Cut(u.Path, "/remote.php/dav/files/"); ok {
+		user, _, _ := strings.Cut(rest, "/")
+		uploads := *u
+		uploads.Path = before + "/remote.php/dav/uploads/" + user + "/"
+		s.Uploads = &uploads
+	}
+	return s, nil
+}
+
+func (s *Store) fileURL(name string) string {
+	u := *s.Base
+	u.Path = s.Base.Path + strings.TrimPrefix(name, "/")
+	return u.String()
+}
+
+// uploadURL returns the chunked upload collection for name, or the URL of a
+// chunk within it.
+func (s *Store) uploadURL(name, chunk string) string {
+	sum := sha256.Sum256([]byte(s.fileURL(name)))
+	u := *s.Uploads
+	u.Path += "ollama-dl-" + hex.EncodeToString(sum[:16]) + "/" + chunk
+	return u.String()
+}
+
+// do sends an authenticated request and returns the response if its status
+// is one of ok.
+func (s *Store) do(ctx context.Context, method, rawURL string, header http.Header, body io.Reader, length int64, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = length
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if s.Username != "" || s.Password != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("webdav %s %s: unexpected status code: %d", method, req.URL.Path, resp.StatusCode)
+}
+
+func (s *Store) doEmpty(ctx context.Context, method, rawURL string, header http.Header, ok ...int) error {
+	resp, err := s.do(ctx, method, rawURL, header, nil, 0, ok...)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) put(ctx context.Context, rawURL string, header http.Header, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, rawURL, header, bytes.NewReader(data), int64(len(data)),
+		http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// size returns the size of the resource at rawURL, or -1 if it doesn't exist.
+func (s *Store) size(ctx context.Context, rawURL string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, rawURL, nil, nil, 0, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
+
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	size, err := s.size(ctx, s.fileURL(name))
+	return size >= 0, err
+}
+
+func (s *Store) Remove(ctx context.Context, name string) error {
+	return s.doEmpty(ctx, http.MethodDelete, s.fileURL(name), nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+}
+
+func (s *Store) get(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, rawURL, nil, nil, 0, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Store) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.get(ctx, s.fileURL(name))
+}
+
+// OpenStaged reads back the data uploaded so far; with chunked uploads, the
+// leading full chunks that a resumed upload keeps.
+func (s *Store) OpenStaged(ctx context.Context, name string) (io.ReadCloser, error) {
+	if s.Uploads == nil {
+		return s.get(ctx, s.fileURL(name+".tmp"))
+	}
+	n, err := s.stagedChunks(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{ctx: ctx, store: s, name: name, count: n}, nil
+}
+
+// chunkReader reads uploaded chunks one after another.
+type chunkReader struct {
+	ctx   context.Context
+	store *Store
+	name  string
+	count int
+	next  int
+	cur   io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if r.next == r.count {
+				return 0, io.EOF
+			}
+			r.next++
+			body, err := r.store.get(r.ctx, r.store.uploadURL(r.name, chunkName(r.next)))
+			if err != nil {
+				return 0, err
+			}
+			r.cur = body
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// mkcolAll creates the collection with the URL path p and its parents.
+func (s *Store) mkcolAll(ctx context.Context, p string) error {
+	if p == "/" || p == "." {
+		return nil
+	}
+	u := *s.Base
+	u.Path = strings.TrimSuffix(p, "/") + "/"
+	// 405 means the collection already exists; 409 a missing parent.
+	resp, err := s.do(ctx, "MKCOL", u.String(), nil, nil, 0, http.StatusCreated, http.StatusMethodNotAllowed, http.StatusConflict)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		return nil
+	}
+	if err := s.mkcolAll(ctx, path.Dir(strings.TrimSuffix(p, "/"))); err != nil {
+		return err
+	}
+	return s.doEmpty(ctx, "MKCOL", u.String(), nil, http.StatusCreated, http.StatusMethodNotAllowed)
+}
+
+type chunkInfo struct {
+	name string
+	size int64
+}
+
+// listChunks returns the chunks in a Nextcloud upload collection, sorted by
+// number, or nil if it doesn't exist.
+func (s *Store) listChunks(ctx context.Context, name string) ([]chunkInfo, error) {
+	body := `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/></d:prop></d:propfind>`
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml"}}
+	resp, err := s.do(ctx, "PROPFIND", s.uploadURL(name, ""), header, strings.NewReader(body), int64(len(body)),
+		http.StatusMultiStatus, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	var ms struct {
+		Responses []struct {
+			Href   string `xml:"href"`
+			Length string `xml:"propstat>prop>getcontentlength"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	var chunks []chunkInfo
+	for _, r := range ms.Responses {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil || strings.HasSuffix(href, "/") {
+			continue
+		}
+		size, err := strconv.ParseInt(r.Length, 10, 64)
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, chunkInfo{name: path.Base(href), size: size})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].name < chunks[j].name })
+	return chunks, nil
+}
+
+// chunkName names the i-th chunk (1-based); Nextcloud orders chunks by name.
+func chunkName(i int) string {
+	return fmt.Sprintf("%05d", i)
+}
+
+// stagedChunks returns how many leading full chunks of name are uploaded.
+func (s *Store) stagedChunks(ctx context.Context, name string) (int, error) {
+	chunks, err := s.listChunks(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range chunks {
+		if c.name != chunkName(n+1) || c.size != ChunkSize {
+			break
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *Store) ResumeOffset(ctx context.Context, name string) (int64, error) {
+	if s.Uploads != nil {
+		n, err := s.stagedChunks(ctx, name)
+		return int64(n) * ChunkSize, err
+	}
+	size, err := s.size(ctx, s.fileURL(name+".tmp"))
+	if err != nil || size < 0 {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (s *Store) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	if err := s.mkcolAll(ctx, path.Dir(s.Base.Path+name)); err != nil {
+		return nil, err
+	}
+	w := &writer{ctx: ctx, store: s, name: name, buf: make([]byte, 0, ChunkSize)}
+
+	if s.Uploads != nil {
+		if resume {
+			n, err := s.stagedChunks(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			w.chunks, w.offset = n, int64(n)*ChunkSize
+		}
+		if w.chunks == 0 {
+			if err := s.doEmpty(ctx, http.MethodDelete, s.uploadURL(name, ""), nil, http.StatusNoContent, http.StatusNotFound); err != nil {
+				return nil, err
+			}
+			header := http.Header{"Destination": {s.fileURL(name)}}
+			if err := s.doEmpty(ctx, "MKCOL", s.uploadURL(name, ""), header, http.StatusCreated); err != nil {
+				return nil, err
+			}
+		}
+		return w, nil
+	}
+
+	if resume {
+		size, err := s.size(ctx, s.fileURL(name+".tmp"))
+		if err != nil {
+			return nil, err
+		}
+		w.offset = max(size, 0)
+	}
+	return w, nil
+}
+
+func (s *Store) Commit(ctx context.Context, name string, layer ollamadl.Layer) error {
+	header := http.Header{"Destination": {s.fileURL(name)}, "Overwrite": {"T"}}
+	source := s.fileURL(name + ".tmp")
+	if s.Uploads != nil {
+		source = s.uploadURL(name, ".file")
+	}
+	return s.doEmpty(ctx, "MOVE", source, header, http.StatusCreated, http.StatusNoContent)
+}
+
+// writer buffers writes into chunks and uploads each as it fills.
+type writer struct {
+	ctx    context.Context
+	store  *Store
+	name   string
+	offset int64
+	chunks int
+	buf    []byte
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) flush() error {
+	s := w.store
+	length := int64(len(w.buf))
+
+	if s.Uploads != nil {
+		header := http.Header{"Destination": {s.fileURL(w.name)}}
+		if err := s.put(w.ctx, s.uploadURL(w.name, chunkName(w.chunks+1)), header, w.buf); err != nil {
+			return err
+		}
+		w.chunks++
+	} else {
+		staged := s.fileURL(w.name + ".tmp")
+		header := http.Header{}
+		if w.offset > 0 {
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", w.offset, w.offset+length-1))
+		}
+		if err := s.put(w.ctx, staged, header, w.buf); err != nil {
+			return err
+		}
+		if w.offset > 0 {
+			size, err := s.size(w.ctx, staged)
+			if err != nil {
+				return err
+			}
+			if size != w.offset+length {
+				return errors.New("webdav server does not support partial PUT (Content-Range); use a server that does, such as Apache mod_dav, or Nextcloud")
+			}
+		}
+	}
+
+	w.offset += length
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Close() error {
+	// Always send a final chunk so that empty files are created too.
+	if len(w.buf) > 0 || w.offset == 0 {
+		return w.flush()
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dedupeIndex maps layer digests to files stored under Options.DedupeDir.
+// It is loaded on first use and extended as pulls complete.
+type dedupeIndex struct {
+	mu    sync.Mutex
+	files map[string]string // nil until loaded
+}
+
+// lookup returns the stored file of a layer.
+func (x *dedupeIndex) lookup(digest string) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	file, ok := x.files[digest]
+	return file, ok
+}
+
+// add records the files of completed jobs, if the index is in use.
+func (x *dedupeIndex) add(jobs []DownloadJob) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.files == nil {
+		return
+	}
+	for _, job := range jobs {
+		if _, ok := x.files[job.Layer.Digest]; !ok {
+			x.files[job.Layer.Digest] = job.DestPath
+		}
+	}
+}
+
+// dedupeFiles links files of layers already stored under
+// Options.DedupeDir into place for jobs, so they aren't downloaded again.
+func (d *Downloader) dedupeFiles(ctx context.Context, jobs []DownloadJob) error {
+	if d.opts.DedupeDir == "" {
+		return nil
+	}
+	if err := d.loadDedupeIndex(ctx); err != nil {
+		return err
+	}
+	_, err := d.reuseFiles(ctx, jobs, d.dedupe.lookup)
+	return err
+}
+
+// loadDedupeIndex indexes the files of the manifests saved under
+// Options.DedupeDir, unless that was done already.
+func (d *Downloader) loadDedupeIndex(ctx context.Context) error {
+	d.dedupe.mu.Lock()
+	defer d.dedupe.mu.Unlock()
+	if d.dedupe.files != nil {
+		return nil
+	}
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return errors.New("deduplicating files needs a local file store")
+	}
+
+	files, err := d.indexStored(ctx, store, d.opts.DedupeDir)
+	if err != nil {
+		return err
+	}
+	d.dedupe.files = files
+	d.log.Debug("Indexed stored layers", "dir", d.opts.DedupeDir, "layers", len(files))
+	return nil
+}
+
+// indexStored maps the digests of layers whose files are stored under root,
+// as found through the manifests saved there, to those files.
+func (d *Downloader) indexStored(ctx context.Context, store *FileStore, root string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := walkSaved(ctx, store, root, func(dir string, manifest *Manifest) error {
+		jobs, err := d.planJobs(Reference{}, manifest, dir)
+		if err != nil {
+			// Written with other options; nothing to reuse from it.
+			return nil
+		}
+		for _, job := range jobs {
+			if _, ok := files[job.Layer.Digest]; ok {
+				continue
+			}
+			if exists, err := store.Exists(ctx, job.DestPath); err != nil {
+				return err
+			} else if exists {
+				files[job.Layer.Digest] = job.DestPath
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+// reuseFiles puts the files of jobs whose layers are already stored under
+// another name, as found by have, into place, so pulling the jobs won't
+// download them again.
+func (d *Downloader) reuseFiles(ctx context.Context, jobs []DownloadJob, have func(digest string) (string, bool)) (int, error) {
+	if d.opts.Force {
+		return 0, nil
+	}
+	reused := 0
+	for _, job := range jobs {
+		src, ok := have(job.Layer.Digest)
+		if !ok || src == job.DestPath {
+			continue
+		}
+		exists, err := d.opts.Store.Exists(ctx, job.DestPath)
+		if err != nil {
+			return reused, err
+		}
+		if exists {
+			continue
+		}
+		if err := d.linkStored(ctx, src, job.DestPath, job.Layer); err != nil {
+			// Downloading the layer still works.
+			d.log.Warn("Could not reuse file", "from", src, "to", job.DestPath, "error", err)
+			continue
+		}
+		d.log.Info("Reused", "path", job.DestPath, "from", src)
+		reused++
+	}
+	return reused, nil
+}
+
+// linkStored makes the stored file src available as dst. A FileStore links
+// the file, symbolically with Options.DedupeSymlinks; other stores, and
+// links that fail, copy it.
+func (d *Downloader) linkStored(ctx context.Context, src, dst string, layer Layer) error {
+	store := d.opts.Store
+	if fs, ok := store.(*FileStore); ok {
+		if err := fs.mkdirAll(filepath.Dir(fs.Path(dst))); err != nil {
+			return err
+		}
+		if d.opts.DedupeSymlinks {
+			// Link to the file itself, not to another link, so removing
+			// a model only has to care about links into it.
+			real, err := filepath.EvalSymlinks(fs.Path(src))
+			if err != nil {
+				return err
+			}
+			target, err := filepath.Rel(shortPath(filepath.Dir(fs.Path(dst))), shortPath(real))
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, fs.Path(dst))
+		}
+		if os.Link(fs.Path(src), fs.Path(dst)) == nil {
+			return nil
+		}
+	}
+
+	opener, ok := store.(BlobOpener)
+	if !ok {
+		return errors.New("store cannot read back downloaded files")
+	}
+	r, err := opener.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return copyStored(ctx, store, r, dst, layer)
+}
+
+// copyStored writes the content of r to store as dst, the file of layer.
+func copyStored(ctx context.Context, store BlobStore, r io.Reader, dst string, layer Layer) error {
+	w, err := store.Create(ctx, dst, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, ctxReader{ctx, r}); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return store.Commit(ctx, dst, layer)
+}
@@ -0,0 +1,342 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// deltaSampleSize is how much of each end of a block of the new blob is
+	// fetched to find the block in the previous version of the file.
+	deltaSampleSize = 4 << 10
+	// deltaMinBlockSize and deltaMaxBlocks bound how finely the new blob
+	// is split: every block costs one small range request up front.
+	deltaMinBlockSize = 8 << 20
+	deltaMaxBlocks    = 256
+	// deltaSampleRequests is how many sample requests run at once.
+	deltaSampleRequests = 8
+)
+
+// errNoRangeReads is returned when the registry ignores range requests,
+// which delta updates can't do without.
+var errNoRangeReads = errors.New("registry does not support range requests")
+
+// planDeltas points each job of res at the file the previous pull into the
+// same directory stored for the layer it replaces, if any: a layer of the
+// same media type, and the same part of a split model. Only layers stored
+// as served qualify, as the ranges copied must be ranges of the blob.
+func (d *Downloader) planDeltas(ctx context.Context, res *Resolution) error {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok || !d.opts.DeltaUpdates || d.opts.Force {
+		return nil
+	}
+	saved, err := readSavedManifest(ctx, store, res.DestDir)
+	if err != nil || saved == nil {
+		return err
+	}
+	old, err := d.planJobs(res.Ref, saved, res.DestDir)
+	if err != nil {
+		return err
+	}
+
+	type role struct {
+		mediaType string
+		split     int
+	}
+	previous := make(map[role]DownloadJob)
+	for _, job := range old {
+		if job.compression() == "" {
+			previous[role{baseMediaType(job.Layer.MediaType), job.Split}] = job
+		}
+	}
+	for i := range res.Jobs {
+		job := &res.Jobs[i]
+		prev, ok := previous[role{baseMediaType(job.Layer.MediaType), job.Split}]
+		// Layers smaller than a block are fetched whole; sampling them
+		// would cost as much.
+		if !ok || job.compression() != "" || prev.Layer.Digest == job.Layer.Digest || job.Size < deltaMinBlockSize {
+			continue
+		}
+		if exists, err := store.Exists(ctx, prev.DestPath); err != nil {
+			return err
+		} else if exists {
+			job.seed = prev.DestPath
+		}
+	}
+	return nil
+}
+
+// deltaBlock is a range of the new blob, the samples of its first and last
+// bytes, and where the same bytes were found in the seed, or -1.
+type deltaBlock struct {
+	offset, size int64
+	head, tail   []byte
+	seedOffset   int64
+}
+
+// matches reports whether block can be copied from seed at offset at.
+func (block *deltaBlock) matches(seed io.ReaderAt, seedSize, at int64) bool {
+	if at+block.size > seedSize {
+		return false
+	}
+	buf := make([]byte, deltaSampleSize)
+	if _, err := seed.ReadAt(buf, at+block.size-deltaSampleSize); err != nil || !bytes.Equal(buf, block.tail) {
+		return false
+	}
+	if _, err := seed.ReadAt(buf, at); err != nil || !bytes.Equal(buf, block.head) {
+		return false
+	}
+	return true
+}
+
+// downloadDelta assembles job's file from the ranges of its seed that the
+// new blob shares and range requests for the rest, zsync style. The blob
+// is split into blocks; small samples of both ends of each block are
+// fetched and looked for in the seed, first at the same offset and then at
+// any offset with a rolling checksum, so content that moved is found too.
+// Blocks are matched by their samples only, so the result is checked
+// against the digest like any download, and the caller falls back to
+// downloading it whole if that fails.
+func (d *Downloader) downloadDelta(ctx context.Context, job DownloadJob) (reused int64, err error) {
+	store := d.opts.Store.(*FileStore)
+	seed, err := os.Open(store.Path(job.seed))
+	if err != nil {
+		return 0, err
+	}
+	defer seed.Close()
+	info, err := seed.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	blocks, err := d.sampleBlocks(ctx, job)
+	if err != nil {
+		return 0, err
+	}
+	if err := findBlocks(ctx, seed, info.Size(), blocks); err != nil {
+		return 0, err
+	}
+
+	w, err := store.Create(ctx, job.DestPath, false)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+
+	reporter := d.opts.Progress
+	reporter.LayerStarted(job, 0)
+	hasher := sha256.New()
+	out := io.MultiWriter(w, hasher, progressWriter{job, reporter})
+	for i := 0; i < len(blocks); {
+		block := blocks[i]
+		if block.seedOffset >= 0 {
+			if _, err := io.Copy(out, ctxReader{ctx, io.NewSectionReader(seed, block.seedOffset, block.size)}); err != nil {
+				return reused, err
+			}
+			reused += block.size
+			i++
+			continue
+		}
+		// Fetch runs of missing blocks with one request.
+		size := int64(0)
+		for ; i < len(blocks) && blocks[i].seedOffset < 0; i++ {
+			size += blocks[i].size
+		}
+		if err := d.copyRange(ctx, job, block.offset, size, out); err != nil {
+			return reused, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return reused, err
+	}
+	if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); got != job.Layer.Digest {
+		return reused, fmt.Errorf("%w for %s: got %s", ErrDigestMismatch, job.Layer.Digest, got)
+	}
+	return reused, store.Commit(ctx, job.DestPath, job.Layer)
+}
+
+// sampleBlocks splits job's blob into blocks and fetches both ends of
+// each. Blocks too small to sample are left without samples, to be
+// downloaded.
+func (d *Downloader) sampleBlocks(ctx context.Context, job DownloadJob) ([]*deltaBlock, error) {
+	blockSize := max(int64(deltaMinBlockSize), (job.Size+deltaMaxBlocks-1)/deltaMaxBlocks)
+	var blocks []*deltaBlock
+	for offset := int64(0); offset < job.Size; offset += blockSize {
+		blocks = append(blocks, &deltaBlock{offset: offset, size: min(blockSize, job.Size-offset), seedOffset: -1})
+	}
+
+	// One request per boundary between blocks covers the end of one and
+	// the start of the next.
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  []error
+		slots = make(chan struct{}, deltaSampleRequests)
+	)
+	for i := 0; i <= len(blocks); i++ {
+		var before, after *deltaBlock
+		if i > 0 && blocks[i-1].size >= 2*deltaSampleSize {
+			before = blocks[i-1]
+		}
+		if i < len(blocks) && blocks[i].size >= 2*deltaSampleSize {
+			after = blocks[i]
+		}
+		if before == nil && after == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			start, size := int64(0), int64(0)
+			if before != nil {
+				start = before.offset + before.size - deltaSampleSize
+				size += deltaSampleSize
+			} else {
+				start = after.offset
+			}
+			if after != nil {
+				size += deltaSampleSize
+			}
+			var buf bytes.Buffer
+			if err := d.copyRange(ctx, job, start, size, &buf); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			data := buf.Bytes()
+			if before != nil {
+				before.tail, data = data[:deltaSampleSize], data[deltaSampleSize:]
+			}
+			if after != nil {
+				after.head = data
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return blocks, nil
+}
+
+// copyRange copies size bytes of job's blob, starting at offset, to w.
+func (d *Downloader) copyRange(ctx context.Context, job DownloadJob, offset, size int64, w io.Writer) error {
+	body, got, err := d.registry.GetBlob(ctx, job.Ref, job.Layer.Digest, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if got != offset {
+		return errNoRangeReads
+	}
+	_, err = io.CopyN(w, d.limiter.reader(ctx, body), size)
+	return err
+}
+
+// findBlocks sets the seedOffset of the blocks whose samples occur in seed
+// as far apart as in the new blob.
+func findBlocks(ctx context.Context, seed io.ReaderAt, seedSize int64, blocks []*deltaBlock) error {
+	// Content that stayed in place is the common case and cheap to check.
+	bySum := make(map[uint32][]*deltaBlock)
+	for _, block := range blocks {
+		if block.head == nil {
+			continue
+		}
+		if block.matches(seed, seedSize, block.offset) {
+			block.seedOffset = block.offset
+			continue
+		}
+		sum := rollingSum(block.head)
+		bySum[sum] = append(bySum[sum], block)
+	}
+	if len(bySum) == 0 {
+		return nil
+	}
+
+	// Roll a window over the whole seed for the rest.
+	const chunkSize = 1 << 20
+	r := io.NewSectionReader(seed, 0, seedSize)
+	window := make([]byte, 0, chunkSize+deltaSampleSize)
+	var (
+		base    int64 // seed offset of window[0]
+		sum     uint32
+		a, b    uint32
+		started bool
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(r, window[len(window):cap(window)])
+		window = window[:len(window)+n]
+		if len(window) < deltaSampleSize {
+			return nil
+		}
+		if !started {
+			a, b = rollingParts(window[:deltaSampleSize])
+			started = true
+		}
+		i := 0
+		for ; ; i++ {
+			sum = a | b<<16
+			if candidates := bySum[sum]; candidates != nil {
+				at := base + int64(i)
+				for j, block := range candidates {
+					if bytes.Equal(window[i:i+deltaSampleSize], block.head) && block.matches(seed, seedSize, at) {
+						block.seedOffset = at
+						candidates = append(candidates[:j], candidates[j+1:]...)
+						break
+					}
+				}
+				if len(candidates) == 0 {
+					delete(bySum, sum)
+					if len(bySum) == 0 {
+						return nil
+					}
+				} else {
+					bySum[sum] = candidates
+				}
+			}
+			if i+deltaSampleSize >= len(window) {
+				break
+			}
+			out, in := uint32(window[i]), uint32(window[i+deltaSampleSize])
+			a = (a - out + in) & 0xffff
+			b = (b - deltaSampleSize*out + a) & 0xffff
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Keep the window from the current position on; it is checked
+		// again, which is harmless, before rolling on.
+		base += int64(i)
+		window = window[:copy(window, window[i:])]
+	}
+}
+
+// rollingParts returns the two halves of the rsync rolling checksum of p.
+func rollingParts(p []byte) (a, b uint32) {
+	for i, c := range p {
+		a += uint32(c)
+		b += uint32(len(p)-i) * uint32(c)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+func rollingSum(p []byte) uint32 {
+	a, b := rollingParts(p)
+	return a | b<<16
+}
@@ -0,0 +1,118 @@
+package ollamadl
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// LayerChange is a layer of a model that differs between two manifests.
+// Old is nil for added layers and New for removed ones.
+type LayerChange struct {
+	Old, New *Layer
+}
+
+// DiffResult compares a local download with the registry's current
+// manifest for the same tag.
+type DiffResult struct {
+	// LocalDigest is the manifest digest recorded at the pull, if any;
+	// RemoteDigest is the registry's current one.
+	LocalDigest, RemoteDigest string
+	// Changes are the layers added, removed or replaced, in manifest
+	// order; layers of the same media type in the same position count as
+	// replaced.
+	Changes []LayerChange
+	// Unchanged are the layers both manifests have.
+	Unchanged []Layer
+	// SizeDelta is how much the model's layers grew, or shrank if
+	// negative.
+	SizeDelta int64
+	// Download is how much a Sync would download.
+	Download int64
+}
+
+// UpToDate reports whether the local download has every layer of the
+// registry's current manifest and nothing else.
+func (r *DiffResult) UpToDate() bool {
+	return len(r.Changes) == 0
+}
+
+// Diff compares the model pulled into destDir with the registry's current
+// manifest for ref, without downloading anything but the manifest.
+func (d *Downloader) Diff(ctx context.Context, ref Reference, destDir string) (*DiffResult, error) {
+	saved, err := d.SavedManifest(ctx, destDir)
+	if err != nil {
+		return nil, err
+	} else if saved == nil {
+		return nil, fmt.Errorf("no %s in %s", ManifestFileName, destDir)
+	}
+	current, err := d.fetchManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DiffResult{RemoteDigest: current.Digest}
+	var meta pullMetadata
+	if _, err := readStoredJSON(ctx, d.opts.Store, path.Join(filepath.ToSlash(destDir), MetadataFileName), &meta); err != nil {
+		return nil, err
+	}
+	result.LocalDigest = meta.Digest
+
+	oldLayers, newLayers := manifestLayers(saved), manifestLayers(current)
+	had := make(map[string]bool, len(oldLayers))
+	for _, layer := range oldLayers {
+		had[layer.Digest] = true
+		result.SizeDelta -= layer.Size
+	}
+	has := make(map[string]bool, len(newLayers))
+	for _, layer := range newLayers {
+		has[layer.Digest] = true
+		result.SizeDelta += layer.Size
+	}
+
+	// Pair the layers only one side has by media type, in order.
+	removed := make(map[string][]Layer)
+	for _, layer := range oldLayers {
+		if !has[layer.Digest] {
+			mediaType := baseMediaType(layer.MediaType)
+			removed[mediaType] = append(removed[mediaType], layer)
+		}
+	}
+	for _, layer := range newLayers {
+		if had[layer.Digest] {
+			result.Unchanged = append(result.Unchanged, layer)
+			continue
+		}
+		result.Download += layer.Size
+		change := LayerChange{New: &layer}
+		mediaType := baseMediaType(layer.MediaType)
+		if olds := removed[mediaType]; len(olds) > 0 {
+			change.Old = &olds[0]
+			removed[mediaType] = olds[1:]
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	for _, layer := range oldLayers {
+		if olds := removed[baseMediaType(layer.MediaType)]; len(olds) > 0 && olds[0].Digest == layer.Digest {
+			result.Changes = append(result.Changes, LayerChange{Old: &olds[0]})
+			removed[baseMediaType(layer.MediaType)] = olds[1:]
+		}
+	}
+	return result, nil
+}
+
+// manifestLayers returns the config and layers of manifest, each digest
+// once.
+func manifestLayers(manifest *Manifest) []Layer {
+	var layers []Layer
+	seen := make(map[string]bool)
+	for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+		if layer.Digest == "" || seen[layer.Digest] {
+			continue
+		}
+		seen[layer.Digest] = true
+		layers = append(layers, layer)
+	}
+	return layers
+}
@@ -0,0 +1,339 @@
+package ollamadl
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const numRetries = 10
+
+// decompress wraps r with a decoder for the given compression.
+func decompress(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "", "identity":
+		return io.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported compression %s", ErrUnsupportedMediaType, compression)
+}
+
+// ctxReader stops reading once its context is done, so long local reads such
+// as hashing a multi-gigabyte file can be cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// readerDigest returns the sha256 digest of everything read from r.
+func readerDigest(ctx context.Context, r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, ctxReader{ctx, r}); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storedDigest returns the digest of the committed object name in store.
+func storedDigest(ctx context.Context, store BlobStore, name string) (string, error) {
+	opener, ok := store.(BlobOpener)
+	if !ok {
+		return "", errors.New("store cannot read back downloaded files")
+	}
+	r, err := opener.Open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return readerDigest(ctx, r)
+}
+
+// removePartials deletes the partial downloads and other temporary files
+// left in destDir, for Options.Force. Only FileStore directories have
+// them lying around.
+func (d *Downloader) removePartials(ctx context.Context, destDir string) error {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok || !d.opts.Force {
+		return nil
+	}
+	entries, err := os.ReadDir(store.Path(destDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != ".tmp" || !d.pulledFile(entry.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(store.Path(destDir), entry.Name())); err != nil {
+			return err
+		}
+		d.log.Info("Removed", "path", path.Join(filepath.ToSlash(destDir), entry.Name()))
+	}
+	return ctx.Err()
+}
+
+// resumeOffset returns how much of job can be resumed from staged data,
+// restoring into h the hash of that data. Data covered by a saved hash
+// state isn't read again; stores that can't read staged data back resume
+// only when the saved state covers all of it. It is 0 when the layer is
+// compressed: staged data of a compressed layer is decompressed and can't
+// be mapped back to an offset in the blob.
+func (d *Downloader) resumeOffset(ctx context.Context, job DownloadJob, h hash.Hash) (int64, error) {
+	store := d.opts.Store
+	staged, canOpen := store.(StagedOpener)
+	_, keepsState := store.(HashStateStore)
+	if (!canOpen && !keepsState) || job.compression() != "" {
+		return 0, nil
+	}
+
+	offset, err := store.ResumeOffset(ctx, job.DestPath)
+	if err != nil || offset == 0 {
+		return 0, err
+	}
+
+	hashed := d.loadHashState(ctx, job, h, offset)
+	if hashed > 0 {
+		d.log.Debug("Restored hash state", "path", job.DestPath, "offset", hashed)
+	}
+	if hashed == offset {
+		return offset, nil
+	}
+	if !canOpen {
+		// What the state doesn't cover can't be hashed; start over.
+		h.Reset()
+		return 0, nil
+	}
+
+	r, err := staged.OpenStaged(ctx, job.DestPath)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	if hashed > 0 {
+		if seeker, ok := r.(io.Seeker); ok {
+			_, err = seeker.Seek(hashed, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, ctxReader{ctx, r}, hashed)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if _, err := io.CopyN(h, ctxReader{ctx, r}, offset-hashed); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// retryable reports whether a failed registry request is worth retrying:
+// network errors and server errors are, client errors are not.
+func retryable(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500
+	}
+	return !errors.Is(err, ErrUnsupportedMediaType)
+}
+
+func (d *Downloader) downloadBlob(ctx context.Context, job DownloadJob) error {
+	fresh := d.opts.Force
+	if job.seed != "" {
+		reused, err := d.downloadDelta(ctx, job)
+		if err == nil {
+			d.log.Info("Updated from earlier version", "path", job.DestPath, "from", job.seed, "reused", reused, "downloaded", job.Size-reused)
+			d.opts.Progress.LayerCompleted(job)
+			return nil
+		}
+		if ctx.Err() != nil {
+			d.opts.Progress.LayerFailed(job, ctx.Err())
+			return ctx.Err()
+		}
+		d.log.Warn("Delta update failed, downloading in full", "path", job.DestPath, "error", err)
+		// What the attempt staged can't be trusted.
+		fresh = true
+	}
+	err := d.downloadBlobRetrying(ctx, job, fresh)
+	if err != nil {
+		d.opts.Progress.LayerFailed(job, err)
+		return err
+	}
+	d.opts.Progress.LayerCompleted(job)
+	return nil
+}
+
+func (d *Downloader) downloadBlobRetrying(ctx context.Context, job DownloadJob, fresh bool) error {
+	var err error
+	for attempt := 1; attempt <= numRetries; attempt++ {
+		var retry bool
+		retry, err = d.downloadBlobAttempt(ctx, job, fresh)
+		if err == nil {
+			return nil
+		}
+		// Staged data that produced a bad digest must not be resumed.
+		fresh = errors.Is(err, ErrDigestMismatch)
+		if seeded, ok := d.registry.(*webseedRegistry); ok && ctx.Err() == nil && seeded.distrust(job.Layer.Digest) {
+			// Other sources may do better than the web seed; what it
+			// served may be corrupt even if the failure didn't say so.
+			retry, fresh = true, fresh || !retry
+		}
+		if !retry {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		d.log.Warn("Download failed, retrying", "path", job.DestPath, "attempt", attempt, "error", err)
+		if d.opts.Hooks.OnRetry != nil {
+			d.opts.Hooks.OnRetry(ctx, job, attempt, err)
+		}
+	}
+
+	return fmt.Errorf("maximum retries reached: %w", err)
+}
+
+// downloadBlobAttempt makes a single attempt at fetching job into store,
+// resuming any partial download unless fresh is set. It reports whether a
+// failure is worth retrying.
+//
+// The digest always covers the bytes as stored in the registry: layers with a
+// compressed media type are hashed before decompression.
+func (d *Downloader) downloadBlobAttempt(ctx context.Context, job DownloadJob, fresh bool) (bool, error) {
+	store, reporter := d.opts.Store, d.opts.Progress
+	compression := job.compression()
+
+	// Check for partial download
+	hasher := sha256.New()
+	var startOffset int64
+	if fresh {
+		if err := d.removeHashState(ctx, job); err != nil {
+			return false, err
+		}
+	} else {
+		var err error
+		if startOffset, err = d.resumeOffset(ctx, job, hasher); err != nil {
+			return false, err
+		}
+	}
+
+	if startOffset >= job.Size {
+		// Nothing is left to fetch: the layer is empty, or was staged in
+		// full before an interruption. A range request starting at its
+		// end would be refused.
+		return d.commitStaged(ctx, job, hasher, startOffset)
+	}
+	if startOffset > 0 {
+		d.log.Debug("Resuming download", "path", job.DestPath, "offset", startOffset)
+	}
+	body, offset, err := d.registry.GetBlob(ctx, job.Ref, job.Layer.Digest, startOffset)
+	if err != nil {
+		return retryable(err), err
+	}
+	defer body.Close()
+	if offset != startOffset {
+		// The registry can't resume; start from scratch.
+		startOffset = 0
+		hasher.Reset()
+	}
+
+	outFile, err := store.Create(ctx, job.DestPath, startOffset > 0)
+	if err != nil {
+		return false, err
+	}
+	defer outFile.Close()
+
+	reporter.LayerStarted(job, startOffset)
+
+	// The hash and progress follow the blob as stored in the registry; the
+	// file receives the decompressed content. Uncompressed content is
+	// hashed as it is written, so the hash state can be saved in step
+	// with what is staged.
+	var dst io.Writer = outFile
+	tee := io.Writer(progressWriter{job, reporter})
+	if compression == "" {
+		cw := &checkpointWriter{w: outFile, h: hasher, offset: startOffset}
+		if _, ok := store.(HashStateStore); ok {
+			cw.save = func(offset int64, state []byte) { d.saveHashState(ctx, job, offset, state) }
+		}
+		dst = cw
+	} else {
+		tee = io.MultiWriter(hasher, tee)
+	}
+	blob := io.TeeReader(d.limiter.reader(ctx, body), tee)
+	content, err := decompress(blob, compression)
+	if err != nil {
+		return false, err
+	}
+	defer content.Close()
+
+	if _, err := io.Copy(dst, content); err != nil {
+		return true, err
+	}
+	if compression != "" {
+		// Drain anything the decoder didn't need so the digest is complete.
+		if _, err := io.Copy(io.Discard, blob); err != nil {
+			return true, err
+		}
+	}
+
+	if err := outFile.Close(); err != nil {
+		return false, err
+	}
+
+	if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); got != job.Layer.Digest {
+		return true, fmt.Errorf("%w for %s: got %s", ErrDigestMismatch, job.Layer.Digest, got)
+	}
+
+	if err := store.Commit(ctx, job.DestPath, job.Layer); err != nil {
+		return false, err
+	}
+	return false, d.removeHashState(ctx, job)
+}
+
+// commitStaged commits job's staged file, whose offset bytes are hashed
+// into h already, without contacting the registry. It creates the file
+// if it wasn't staged, as for an empty layer.
+func (d *Downloader) commitStaged(ctx context.Context, job DownloadJob, h hash.Hash, offset int64) (bool, error) {
+	outFile, err := d.opts.Store.Create(ctx, job.DestPath, offset > 0)
+	if err != nil {
+		return false, err
+	}
+	if err := outFile.Close(); err != nil {
+		return false, err
+	}
+	d.opts.Progress.LayerStarted(job, offset)
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != job.Layer.Digest {
+		return true, fmt.Errorf("%w for %s: got %s", ErrDigestMismatch, job.Layer.Digest, got)
+	}
+	if err := d.opts.Store.Commit(ctx, job.DestPath, job.Layer); err != nil {
+		return false, err
+	}
+	return false, d.removeHashState(ctx, job)
+}
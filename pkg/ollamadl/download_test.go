@@ -0,0 +1,26 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPullDigestMismatchAfterRetries(t *testing.T) {
+	d, reg, _ := newTestDownloader(t)
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, testGGUF("weights"))
+	// The registry serves other bytes of the same size under the digest.
+	reg.blobs[model.Digest] = testGGUF("WEIGHTS")
+	reg.AddManifest(ref, config, model)
+
+	_, err = d.Pull(context.Background(), ref, "m")
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("Pull() error = %v, want ErrDigestMismatch", err)
+	}
+}
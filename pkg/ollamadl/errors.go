@@ -0,0 +1,53 @@
+package ollamadl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned by the Downloader, to be checked with errors.Is.
+var (
+	// ErrManifestNotFound means the registry has no such model or tag.
+	ErrManifestNotFound = errors.New("manifest not found")
+	// ErrUnauthorized means the registry refused the request (401 or 403).
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrDigestMismatch means downloaded content doesn't hash to the
+	// layer's digest.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrUnsupportedMediaType means a manifest or layer uses a media type
+	// or compression this package can't handle.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrCatalogUnsupported means the registry can't list its
+	// repositories.
+	ErrCatalogUnsupported = errors.New("registry does not support listing repositories")
+	// ErrStoreFull means a pull doesn't fit within Options.MaxStoreSize,
+	// even after evicting every model that may be evicted.
+	ErrStoreFull = errors.New("store size limit reached")
+	// ErrInvalidGGUF means a layer that should be a GGUF file, such as the
+	// model, doesn't start with a GGUF header that parses, though it
+	// matches its digest: the registry serves something else under that
+	// media type.
+	ErrInvalidGGUF = errors.New("invalid GGUF file")
+	// ErrSignature means Options.SignaturePolicy is set and the model's
+	// manifest has no signature that satisfies it.
+	ErrSignature = errors.New("signature verification failed")
+	// ErrPolicy means Options.Policy doesn't allow the model to be pulled.
+	ErrPolicy = errors.New("blocked by policy")
+)
+
+// HTTPError is an unexpected response from the registry. It matches
+// ErrUnauthorized for 401 and 403 responses.
+type HTTPError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+func (e *HTTPError) Is(target error) bool {
+	return target == ErrUnauthorized &&
+		(e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
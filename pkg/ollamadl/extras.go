@@ -0,0 +1,162 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LicensesFileName is the combined license file written when
+// Options.AggregateLicenses is set.
+const LicensesFileName = "LICENSES.txt"
+
+// writeLicenses concatenates every license layer among jobs into a single
+// LICENSES.txt in destDir, each preceded by a header naming its file and digest.
+func writeLicenses(ctx context.Context, store BlobStore, destDir string, jobs []DownloadJob) error {
+	opener, ok := store.(BlobOpener)
+	if !ok {
+		return errors.New("store cannot read back downloaded files")
+	}
+
+	var buf bytes.Buffer
+	for _, job := range jobs {
+		if baseMediaType(job.Layer.MediaType) != LicenseMediaType {
+			continue
+		}
+		r, err := opener.Open(ctx, job.DestPath)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "==== %s (%s) ====\n\n", path.Base(job.DestPath), job.Layer.Digest)
+		buf.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	return writeFile(ctx, store, path.Join(filepath.ToSlash(destDir), LicensesFileName), "text/plain", buf.Bytes())
+}
+
+// writeChecksums writes ChecksumsFileName to the directory of res, listing
+// its files, the manifest and LICENSES.txt, if written, by name within the
+// directory.
+func (d *Downloader) writeChecksums(ctx context.Context, res *Resolution) error {
+	dir := path.Clean(filepath.ToSlash(res.DestDir))
+	sums, err := d.fileDigests(ctx, res)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", strings.TrimPrefix(sums[name], "sha256:"), name)
+	}
+	return writeFile(ctx, d.opts.Store, path.Join(dir, ChecksumsFileName), "text/plain", buf.Bytes())
+}
+
+// fileDigests returns the digests of the files of res, the manifest and
+// LICENSES.txt, if written, by name within the directory. Files stored as
+// served have their layers' digests; the others, decompressed layers and
+// LICENSES.txt, are read back and hashed.
+func (d *Downloader) fileDigests(ctx context.Context, res *Resolution) (map[string]string, error) {
+	dir := path.Clean(filepath.ToSlash(res.DestDir))
+	relName := func(name string) string {
+		return strings.TrimPrefix(name, dir+"/")
+	}
+
+	manifest, err := manifestJSON(res.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[string]string{ManifestFileName: sha256Digest(manifest)}
+	for _, job := range res.Jobs {
+		digest := job.Layer.Digest
+		if job.compression() != "" {
+			if digest, err = storedDigest(ctx, d.opts.Store, job.DestPath); err != nil {
+				return nil, err
+			}
+		}
+		sums[relName(job.DestPath)] = digest
+	}
+	if d.opts.AggregateLicenses {
+		name := path.Join(dir, LicensesFileName)
+		if exists, err := d.opts.Store.Exists(ctx, name); err != nil {
+			return nil, err
+		} else if exists {
+			if sums[LicensesFileName], err = storedDigest(ctx, d.opts.Store, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sums, nil
+}
+
+// writeFile stores a small generated file.
+func writeFile(ctx context.Context, store BlobStore, name, mediaType string, data []byte) error {
+	w, err := store.Create(ctx, name, false)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	layer := Layer{MediaType: mediaType, Digest: sha256Digest(data), Size: int64(len(data))}
+	return store.Commit(ctx, name, layer)
+}
+
+// sha256Digest returns the digest of data in the form registries use.
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// mergeSplits joins a split GGUF model into a single file using llama.cpp's
+// gguf-split tool. GGUF splits can't simply be concatenated, so when the tool
+// isn't installed the parts are left as they are.
+func mergeSplits(firstPart, dest string) error {
+	var tool string
+	for _, name := range []string{"llama-gguf-split", "gguf-split"} {
+		if p, err := exec.LookPath(name); err == nil {
+			tool = p
+			break
+		}
+	}
+	if tool == "" {
+		return errors.New("llama-gguf-split not found in PATH; keeping split files (llama.cpp loads them directly)")
+	}
+
+	cmd := exec.Command(tool, "--merge", firstPart, dest)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,178 @@
+package gcsstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// TokenSource returns OAuth2 access tokens for the Cloud Storage API.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a fixed access token, e.g. from `gcloud auth print-access-token`.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) { return string(t), nil }
+
+// DefaultTokenSource picks credentials the way Google tooling usually does:
+// an explicit token in GOOGLE_OAUTH_ACCESS_TOKEN, a service account key file
+// in GOOGLE_APPLICATION_CREDENTIALS, or else the GCE/GKE metadata server.
+func DefaultTokenSource(client *http.Client) (TokenSource, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return StaticToken(token), nil
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return newServiceAccountSource(client, path)
+	}
+	return &cachedSource{fetch: metadataToken(client)}, nil
+}
+
+// cachedSource reuses a token until shortly before it expires.
+type cachedSource struct {
+	fetch func(ctx context.Context) (string, time.Duration, error)
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *cachedSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expires) {
+		return s.token, nil
+	}
+	token, ttl, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expires = token, time.Now().Add(ttl-time.Minute)
+	return token, nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func decodeToken(resp *http.Response) (string, time.Duration, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token request failed: %d", resp.StatusCode)
+	}
+	var tok tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", 0, err
+	}
+	if tok.AccessToken == "" {
+		return "", 0, errors.New("token response has no access_token")
+	}
+	return tok.AccessToken, time.Duration(tok.ExpiresIn) * time.Second, nil
+}
+
+func metadataToken(client *http.Client) func(ctx context.Context) (string, time.Duration, error) {
+	return func(ctx context.Context) (string, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET",
+			"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", 0, fmt.Errorf("no GCS credentials (set GOOGLE_APPLICATION_CREDENTIALS or GOOGLE_OAUTH_ACCESS_TOKEN): %v", err)
+		}
+		return decodeToken(resp)
+	}
+}
+
+// newServiceAccountSource exchanges a signed JWT for access tokens using a
+// service account key file.
+func newServiceAccountSource(client *http.Client, path string) (TokenSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("%s: unsupported credentials type %q", path, key.Type)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s: invalid private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: private key is not RSA", path)
+	}
+
+	fetch := func(ctx context.Context) (string, time.Duration, error) {
+		enc := base64.RawURLEncoding
+		header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+		now := time.Now().Unix()
+		claims, _ := json.Marshal(map[string]any{
+			"iss":   key.ClientEmail,
+			"scope": storageScope,
+			"aud":   key.TokenURI,
+			"iat":   now,
+			"exp":   now + 3600,
+		})
+		unsigned := header + "." + enc.EncodeToString(claims)
+		digest := sha256.Sum256([]byte(unsigned))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			return "", 0, err
+		}
+
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", key.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", 0, err
+		}
+		return decodeToken(resp)
+	}
+	return &cachedSource{fetch: fetch}, nil
+}
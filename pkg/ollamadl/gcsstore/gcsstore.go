@@ -0,0 +1,431 @@
+// Package gcsstore implements an ollamadl.BlobStore on Google Cloud Storage.
+// Blobs are streamed with resumable uploads to a staging object, which is
+// composed into its final name, together with the layer's digest and media
+// type as object metadata, once the download has been verified. An
+// interrupted upload is continued by a later run, with the digest's progress
+// kept in an object next to it so nothing has to be read back.
+package gcsstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// ChunkSize is the size of each uploaded chunk. Resumable uploads require
+// chunks to be a multiple of 256 KiB.
+const ChunkSize = 16 << 20
+
+const stagedSuffix = ".tmp"
+
+// hashStateSuffix names, after a blob's key, the object keeping the hash
+// state of its unfinished upload.
+const hashStateSuffix = ".sha256.tmp"
+
+// Store writes blobs to Bucket under Prefix.
+type Store struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string
+	// Tokens authorizes requests; nil sends them unauthenticated, as
+	// emulators such as fake-gcs-server expect.
+	Tokens TokenSource
+	Client *http.Client
+	// SessionDir keeps the URIs of unfinished upload sessions so a later
+	// run can continue them.
+	SessionDir string
+}
+
+// New returns a Store for a URL of the form gs://bucket/prefix. Credentials
+// are found by DefaultTokenSource; STORAGE_EMULATOR_HOST points the store at
+// an emulator instead of storage.googleapis.com.
+func New(rawURL string) (*Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "gs" || u.Host == "" {
+		return nil, fmt.Errorf("invalid GCS URL %q: want gs://bucket/prefix", rawURL)
+	}
+
+	s := &Store{
+		Bucket:   u.Host,
+		Prefix:   strings.Trim(u.Path, "/"),
+		Endpoint: "https://storage.googleapis.com",
+		Client:   http.DefaultClient,
+	}
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		s.SessionDir = filepath.Join(cacheDir, "ollama-dl", "gcs-uploads")
+	}
+
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		s.Endpoint = strings.TrimSuffix(host, "/")
+		return s, nil
+	}
+
+	s.Tokens, err = DefaultTokenSource(s.Client)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) key(name string) string {
+	return path.Join(s.Prefix, name)
+}
+
+func (s *Store) objectURL(key string) string {
+	return s.Endpoint + "/storage/v1/b/" + url.PathEscape(s.Bucket) + "/o/" + url.PathEscape(key)
+}
+
+// do sends an authorized request and returns the response if its status is
+// one of ok.
+func (s *Store) do(ctx context.Context, method, rawURL string, header http.Header, body []byte, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if s.Tokens != nil {
+		token, err := s.Tokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	var gcsErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &gcsErr) == nil && gcsErr.Error.Message != "" {
+		return nil, fmt.Errorf("gcs %s %s: %s", method, req.URL.Path, gcsErr.Error.Message)
+	}
+	return nil, fmt.Errorf("gcs %s %s: unexpected status code: %d", method, req.URL.Path, resp.StatusCode)
+}
+
+func (s *Store) doJSON(ctx context.Context, method, rawURL string, in, out any) error {
+	var body []byte
+	header := http.Header{}
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.do(ctx, method, rawURL, header, body, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(s.key(name))+"?fields=name", nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (s *Store) Remove(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(s.key(name)), nil, nil, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(s.key(name))+"?alt=media", nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Store) SaveHashState(ctx context.Context, name string, state []byte) error {
+	upload := s.Endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.Bucket) +
+		"/o?uploadType=media&name=" + url.QueryEscape(s.key(name)+hashStateSuffix)
+	header := http.Header{"Content-Type": {"application/json"}}
+	resp, err := s.do(ctx, http.MethodPost, upload, header, state, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) LoadHashState(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(s.key(name)+hashStateSuffix)+"?alt=media", nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+func (s *Store) RemoveHashState(ctx context.Context, name string) error {
+	return s.Remove(ctx, name+hashStateSuffix)
+}
+
+// sessionFile is where the upload session URI for key is remembered.
+func (s *Store) sessionFile(key string) string {
+	sum := sha256.Sum256([]byte(s.Bucket + "/" + key))
+	return filepath.Join(s.SessionDir, hex.EncodeToString(sum[:]))
+}
+
+func (s *Store) loadSession(key string) string {
+	if s.SessionDir == "" {
+		return ""
+	}
+	data, err := os.ReadFile(s.sessionFile(key))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (s *Store) saveSession(key, session string) error {
+	if s.SessionDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.SessionDir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.sessionFile(key), []byte(session), 0600)
+}
+
+func (s *Store) forgetSession(key string) {
+	if s.SessionDir != "" {
+		os.Remove(s.sessionFile(key))
+	}
+}
+
+// persisted returns how many bytes of an upload session have been received,
+// or -1 if the session no longer exists.
+func (s *Store) persisted(ctx context.Context, session string) (int64, error) {
+	header := http.Header{"Content-Range": {"bytes */*"}}
+	resp, err := s.do(ctx, http.MethodPut, session, header, nil,
+		http.StatusPermanentRedirect, http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusGone)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		// Either expired or already finalized; neither can be appended to.
+		return -1, nil
+	}
+	return rangeEnd(resp.Header.Get("Range")), nil
+}
+
+// rangeEnd parses the "bytes=0-N" Range header of a 308 response into the
+// number of bytes received.
+func rangeEnd(header string) int64 {
+	_, end, ok := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n + 1
+}
+
+func (s *Store) ResumeOffset(ctx context.Context, name string) (int64, error) {
+	session := s.loadSession(s.key(name) + stagedSuffix)
+	if session == "" {
+		return 0, nil
+	}
+	offset, err := s.persisted(ctx, session)
+	if err != nil || offset < 0 {
+		return 0, err
+	}
+	return offset, nil
+}
+
+func (s *Store) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	staged := s.key(name) + stagedSuffix
+
+	if session := s.loadSession(staged); session != "" {
+		if resume {
+			offset, err := s.persisted(ctx, session)
+			if err != nil {
+				return nil, err
+			}
+			if offset >= 0 {
+				return &writer{ctx: ctx, store: s, session: session, offset: offset, buf: make([]byte, 0, ChunkSize)}, nil
+			}
+		} else {
+			// Cancel the old session rather than leave it to expire.
+			if resp, err := s.do(ctx, http.MethodDelete, session, nil, nil, 499, http.StatusNotFound, http.StatusGone); err == nil {
+				resp.Body.Close()
+			}
+		}
+		s.forgetSession(staged)
+	}
+
+	start := s.Endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.Bucket) +
+		"/o?uploadType=resumable&name=" + url.QueryEscape(staged)
+	header := http.Header{"Content-Type": {"application/json"}}
+	resp, err := s.do(ctx, http.MethodPost, start, header, []byte("{}"), http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return nil, errors.New("gcs: resumable upload returned no session URI")
+	}
+	if err := s.saveSession(staged, session); err != nil {
+		return nil, err
+	}
+
+	return &writer{ctx: ctx, store: s, session: session, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+// Commit composes the staged object into its final name, recording the
+// layer's digest and media type, and removes the staged object.
+func (s *Store) Commit(ctx context.Context, name string, layer ollamadl.Layer) error {
+	key := s.key(name)
+	staged := key + stagedSuffix
+
+	compose := map[string]any{
+		"sourceObjects": []map[string]string{{"name": staged}},
+		"destination": map[string]any{
+			"contentType": layer.MediaType,
+			"metadata": map[string]string{
+				"digest":    layer.Digest,
+				"mediaType": layer.MediaType,
+			},
+		},
+	}
+	if err := s.doJSON(ctx, http.MethodPost, s.objectURL(key)+"/compose", compose, nil); err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(staged), nil, nil, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	s.forgetSession(staged)
+	return nil
+}
+
+// writer buffers writes into chunks and uploads each as it fills. The last
+// chunk is sent on Close, which finalizes the staged object.
+type writer struct {
+	ctx     context.Context
+	store   *Store
+	session string
+	offset  int64
+	buf     []byte
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) flush(final bool) error {
+	end := w.offset + int64(len(w.buf))
+	total := "*"
+	if final {
+		total = strconv.FormatInt(end, 10)
+	}
+	contentRange := "bytes */" + total
+	if len(w.buf) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%s", w.offset, end-1, total)
+	}
+
+	header := http.Header{"Content-Range": {contentRange}}
+	resp, err := w.store.do(w.ctx, http.MethodPut, w.session, header, w.buf,
+		http.StatusPermanentRedirect, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		w.offset, w.buf = end, w.buf[:0]
+		return nil
+	}
+	if final {
+		return fmt.Errorf("gcs: upload incomplete after final chunk (%s)", resp.Header.Get("Range"))
+	}
+
+	// The server may keep less than it was sent; carry the rest over to
+	// the next chunk.
+	received := rangeEnd(resp.Header.Get("Range"))
+	if received < w.offset || received > end {
+		return fmt.Errorf("gcs: unexpected upload range %q", resp.Header.Get("Range"))
+	}
+	w.buf = w.buf[:copy(w.buf, w.buf[received-w.offset:])]
+	w.offset = received
+	return nil
+}
+
+// Staged returns how many bytes the server has received.
+func (w *writer) Staged() int64 {
+	return w.offset
+}
+
+func (w *writer) Close() error {
+	return w.flush(true)
+}
@@ -0,0 +1,36 @@
+package ollamadl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dimchansky/ollama-dl-go/internal/gguf"
+)
+
+// ggufMediaTypes are the layers stored as GGUF files.
+var ggufMediaTypes = map[string]bool{
+	ModelMediaType:                         true,
+	ProjectorMediaType:                     true,
+	"application/vnd.ollama.image.adapter": true,
+}
+
+// checkGGUF parses the header of the file of a GGUF layer, such as the
+// model, once downloaded. The digest only proves the file is what the
+// registry served; this catches a registry serving something else under
+// the media type before anything tries to load it. Stores that can't read
+// files back aren't checked.
+func checkGGUF(ctx context.Context, store BlobStore, job DownloadJob) error {
+	opener, ok := store.(BlobOpener)
+	if !ok || !ggufMediaTypes[baseMediaType(job.Layer.MediaType)] || job.compression() != "" {
+		return nil
+	}
+	r, err := opener.Open(ctx, job.DestPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := gguf.Read(r); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidGGUF, err)
+	}
+	return nil
+}
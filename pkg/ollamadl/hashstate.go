@@ -0,0 +1,197 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// hashStateSuffix names, after a staged file's name, where the state of
+// its digest is kept, so that resuming hashes only what came after instead
+// of reading gigabytes back.
+const hashStateSuffix = ".sha256.tmp"
+
+// hashCheckpointInterval is how many bytes are staged between saves of
+// the hash state. Object stores stage data in chunks of a multiple of it,
+// so a state is taken exactly where each of their chunks ends.
+const hashCheckpointInterval = 16 << 20
+
+// hashState is the saved state of a digest computed over the first Offset
+// bytes of a staged download.
+type hashState struct {
+	Digest string `json:"digest"`
+	Offset int64  `json:"offset"`
+	State  []byte `json:"state"`
+}
+
+// hashStatePath returns the file the hash state of name's staged download
+// is kept in.
+func (s *FileStore) hashStatePath(name string) string {
+	return s.Path(name) + hashStateSuffix
+}
+
+// SaveHashState replaces the hash state of name's staged download, through
+// a temporary file so it is never half written. It gets the store's
+// FileMode and Owner like the staged file itself.
+func (s *FileStore) SaveHashState(ctx context.Context, name string, state []byte) error {
+	statePath := s.hashStatePath(name)
+	if err := s.mkdirAll(filepath.Dir(statePath)); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(statePath), filepath.Base(statePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(state); err != nil {
+		tmp.Close()
+		return err
+	}
+	mode := s.FileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	err = tmp.Chmod(mode)
+	if o := s.Owner; o != nil && err == nil {
+		err = tmp.Chown(o.UID, o.GID)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), statePath)
+}
+
+func (s *FileStore) LoadHashState(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(s.hashStatePath(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *FileStore) RemoveHashState(ctx context.Context, name string) error {
+	if err := os.Remove(s.hashStatePath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// saveHashState records that state is the hash of the first offset bytes
+// of job's staged download, if the store keeps hash states. Failing to
+// save it is only logged: resuming then hashes the staged data again, or
+// starts over.
+func (d *Downloader) saveHashState(ctx context.Context, job DownloadJob, offset int64, state []byte) {
+	store, ok := d.opts.Store.(HashStateStore)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(hashState{Digest: job.Layer.Digest, Offset: offset, State: state})
+	if err == nil {
+		err = store.SaveHashState(ctx, job.DestPath, data)
+	}
+	if err != nil {
+		d.log.Debug("Could not save hash state", "path", job.DestPath, "error", err)
+	}
+}
+
+// loadHashState restores into h the saved hash state of job's staged
+// download, of which size bytes are staged, and returns the offset it was
+// saved at. It returns 0, with h reset, when there is no usable state.
+func (d *Downloader) loadHashState(ctx context.Context, job DownloadJob, h hash.Hash, size int64) int64 {
+	store, ok := d.opts.Store.(HashStateStore)
+	u, canLoad := h.(encoding.BinaryUnmarshaler)
+	if !ok || !canLoad {
+		return 0
+	}
+	data, err := store.LoadHashState(ctx, job.DestPath)
+	if err != nil || data == nil {
+		return 0
+	}
+	var state hashState
+	if err := json.Unmarshal(data, &state); err != nil || state.Digest != job.Layer.Digest ||
+		state.Offset <= 0 || state.Offset > size {
+		return 0
+	}
+	if err := u.UnmarshalBinary(state.State); err != nil {
+		h.Reset()
+		return 0
+	}
+	return state.Offset
+}
+
+// removeHashState deletes the saved hash state of job's staged download.
+func (d *Downloader) removeHashState(ctx context.Context, job DownloadJob) error {
+	store, ok := d.opts.Store.(HashStateStore)
+	if !ok {
+		return nil
+	}
+	return store.RemoveHashState(ctx, job.DestPath)
+}
+
+// checkpointWriter writes a staged download and hashes what it writes,
+// taking the hash state whenever the offset reaches a multiple of
+// hashCheckpointInterval. A state is only saved once the store has staged
+// all the bytes it covers, which writers that buffer tell through
+// StagedCounter, so it never runs ahead of what a resumed download finds.
+// A nil save only hashes.
+type checkpointWriter struct {
+	w       io.Writer
+	h       hash.Hash
+	save    func(offset int64, state []byte)
+	offset  int64
+	pending []hashState
+}
+
+func (c *checkpointWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if left := hashCheckpointInterval - c.offset%hashCheckpointInterval; int64(len(chunk)) > left {
+			chunk = chunk[:left]
+		}
+		n, err := c.w.Write(chunk)
+		c.h.Write(chunk[:n])
+		c.offset += int64(n)
+		written += n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+		if c.save != nil && c.offset%hashCheckpointInterval == 0 {
+			if m, ok := c.h.(encoding.BinaryMarshaler); ok {
+				if state, err := m.MarshalBinary(); err == nil {
+					c.pending = append(c.pending, hashState{Offset: c.offset, State: state})
+				}
+			}
+		}
+	}
+	c.saveStaged()
+	return written, nil
+}
+
+// saveStaged saves the latest pending state that the store has staged the
+// bytes of, and drops it and the ones before it.
+func (c *checkpointWriter) saveStaged() {
+	staged := c.offset
+	if sc, ok := c.w.(StagedCounter); ok {
+		staged = sc.Staged()
+	}
+	i := 0
+	for i < len(c.pending) && c.pending[i].Offset <= staged {
+		i++
+	}
+	if i > 0 {
+		c.save(c.pending[i-1].Offset, c.pending[i-1].State)
+		c.pending = c.pending[i:]
+	}
+}
@@ -0,0 +1,158 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSaveHashStateMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on Windows")
+	}
+	root := t.TempDir()
+	store := &FileStore{Root: root, FileMode: 0600, DirMode: 0700}
+	if err := store.SaveHashState(context.Background(), "m/model-0123456789ab.gguf", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(root, "m", "model-0123456789ab.gguf"+hashStateSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("hash state mode = %o, want 600", mode)
+	}
+	if info, err := os.Stat(filepath.Join(root, "m")); err != nil {
+		t.Error(err)
+	} else if mode := info.Mode().Perm(); mode != 0700 {
+		t.Errorf("directory mode = %o, want 700", mode)
+	}
+}
+
+// chunkStore is an object store in memory that stages data in chunks of
+// two hash checkpoints and can't read it back.
+type chunkStore struct {
+	staged []byte
+	states map[string][]byte
+}
+
+func (s *chunkStore) Exists(ctx context.Context, name string) (bool, error) { return false, nil }
+
+func (s *chunkStore) ResumeOffset(ctx context.Context, name string) (int64, error) {
+	return int64(len(s.staged)), nil
+}
+
+func (s *chunkStore) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	if !resume {
+		s.staged = nil
+	}
+	return &chunkWriter{store: s}, nil
+}
+
+func (s *chunkStore) Commit(ctx context.Context, name string, layer Layer) error { return nil }
+
+func (s *chunkStore) SaveHashState(ctx context.Context, name string, state []byte) error {
+	s.states[name] = state
+	return nil
+}
+
+func (s *chunkStore) LoadHashState(ctx context.Context, name string) ([]byte, error) {
+	return s.states[name], nil
+}
+
+func (s *chunkStore) RemoveHashState(ctx context.Context, name string) error {
+	delete(s.states, name)
+	return nil
+}
+
+type chunkWriter struct {
+	store *chunkStore
+	buf   []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= 2*hashCheckpointInterval {
+		w.store.staged = append(w.store.staged, w.buf[:2*hashCheckpointInterval]...)
+		w.buf = w.buf[2*hashCheckpointInterval:]
+	}
+	return len(p), nil
+}
+
+func (w *chunkWriter) Staged() int64 { return int64(len(w.store.staged)) }
+
+func (w *chunkWriter) Close() error { return nil }
+
+func TestCheckpointWriterWaitsForStaged(t *testing.T) {
+	store := &chunkStore{states: make(map[string][]byte)}
+	out, _ := store.Create(context.Background(), "blob", false)
+	var saved []int64
+	var state []byte
+	cw := &checkpointWriter{w: out, h: sha256.New(), save: func(offset int64, s []byte) {
+		saved, state = append(saved, offset), s
+	}}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (3*hashCheckpointInterval+100)/16)
+	for p := data; len(p) > 0; {
+		n := min(len(p), 1<<20+7)
+		if _, err := cw.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+
+	// The state at the first checkpoint is superseded before the chunk
+	// holding it is staged; the third checkpoint's chunk isn't staged.
+	if want := []int64{2 * hashCheckpointInterval}; !reflect.DeepEqual(saved, want) {
+		t.Fatalf("saved states at %v, want %v", saved, want)
+	}
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(data[:2*hashCheckpointInterval]); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Error("saved state doesn't hash the staged data")
+	}
+}
+
+func TestResumeFromHashStateOnly(t *testing.T) {
+	ctx := context.Background()
+	store := &chunkStore{states: make(map[string][]byte)}
+	d, err := New(Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}, WithRegistryClient(NewMemoryRegistry()), WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := DownloadJob{Layer: Layer{Digest: "sha256:0123"}, DestPath: "blob", Size: 1 << 30}
+
+	store.staged = bytes.Repeat([]byte{'x'}, 32)
+	h := sha256.New()
+	h.Write(store.staged)
+	state, _ := h.(encoding.BinaryMarshaler).MarshalBinary()
+	d.saveHashState(ctx, job, 32, state)
+
+	h = sha256.New()
+	if offset, err := d.resumeOffset(ctx, job, h); err != nil || offset != 32 {
+		t.Fatalf("resumeOffset() = %d, %v, want 32", offset, err)
+	}
+	if want := sha256.Sum256(store.staged); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Error("restored hash doesn't cover the staged data")
+	}
+
+	// Staged data beyond the state can't be hashed without reading it.
+	store.staged = append(store.staged, 'y')
+	h = sha256.New()
+	if offset, err := d.resumeOffset(ctx, job, h); err != nil || offset != 0 {
+		t.Fatalf("resumeOffset() = %d, %v, want 0", offset, err)
+	}
+	if want := sha256.Sum256(nil); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Error("hash not reset")
+	}
+}
@@ -0,0 +1,87 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSkipLayer can be returned from Hooks.OnLayerStart to leave a layer out
+// of the pull without failing it.
+var ErrSkipLayer = errors.New("skip this layer")
+
+// Hooks let applications observe and gate the stages of a Pull. Every field
+// is optional. The layer hooks are called concurrently from the goroutines
+// downloading different layers.
+type Hooks struct {
+	// OnManifestResolved is called with the resolved manifest before
+	// anything is downloaded. Returning an error aborts the pull.
+	OnManifestResolved func(ctx context.Context, res *Resolution) error
+	// OnLayerStart is called before a layer is downloaded; files already
+	// in the store are not downloaded and don't trigger it. Returning
+	// ErrSkipLayer skips the layer; any other error fails it.
+	OnLayerStart func(ctx context.Context, job DownloadJob) error
+	// OnLayerDone is called once a layer is verified and in the store.
+	OnLayerDone func(ctx context.Context, job DownloadJob)
+	// OnRetry is called when a failed download attempt of a layer is about
+	// to be retried; attempt counts from 1 for the first failure.
+	OnRetry func(ctx context.Context, job DownloadJob, attempt int, err error)
+	// OnError is called for each error that fails a pull. job is the layer
+	// concerned, or nil for errors not tied to a single layer.
+	OnError func(ctx context.Context, job *DownloadJob, err error)
+	// OnPullDone is called when a Pull, a Sync or a tag of MirrorTags
+	// finishes, successfully or not.
+	OnPullDone func(ctx context.Context, summary PullSummary)
+}
+
+// PullSummary is the outcome of a pull, as passed to Hooks.OnPullDone.
+type PullSummary struct {
+	Ref     Reference
+	DestDir string
+	// Resolution is nil if the pull failed before its manifest was
+	// resolved.
+	Resolution *Resolution
+	// Downloaded is how many bytes the pull fetched; files already
+	// present don't count.
+	Downloaded int64
+	Duration   time.Duration
+	Err        error
+}
+
+// startPull notes the start of a pull of ref into destDir. The function it
+// returns reports the outcome through OnPullDone and Options.Audit and
+// returns err, or why the outcome couldn't be audited.
+func (d *Downloader) startPull(ctx context.Context, ref Reference, destDir string) func(res *Resolution, err error) error {
+	start := time.Now()
+	return func(res *Resolution, err error) error {
+		if d.opts.Hooks.OnPullDone == nil && d.opts.Audit == nil {
+			return err
+		}
+		summary := PullSummary{Ref: ref, DestDir: destDir, Resolution: res, Duration: time.Since(start), Err: err}
+		if res != nil {
+			summary.Downloaded = res.downloaded
+		}
+		if d.opts.Hooks.OnPullDone != nil {
+			d.opts.Hooks.OnPullDone(ctx, summary)
+		}
+		if d.opts.Audit != nil {
+			// A cancelled pull is still audited.
+			if auditErr := d.opts.Audit.Record(context.WithoutCancel(ctx), d.auditEvent(summary)); auditErr != nil {
+				d.log.Error("Failed to record pull in audit log", "error", auditErr)
+				if err == nil {
+					err = fmt.Errorf("recording audit event: %w", auditErr)
+				}
+			}
+		}
+		return err
+	}
+}
+
+// fail reports err through OnError and returns it.
+func (h *Hooks) fail(ctx context.Context, job *DownloadJob, err error) error {
+	if h.OnError != nil {
+		h.OnError(ctx, job, err)
+	}
+	return err
+}
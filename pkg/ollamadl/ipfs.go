@@ -0,0 +1,21 @@
+package ollamadl
+
+import "strings"
+
+// DefaultIPFSGateway is the HTTP gateway of a local IPFS node, e.g. Kubo.
+const DefaultIPFSGateway = "http://127.0.0.1:8080"
+
+// ipfsSeed returns a web seed fetching the blobs cids names through an
+// IPFS gateway.
+func ipfsSeed(gateway string, cids map[string]string) blobSeed {
+	if gateway == "" {
+		gateway = DefaultIPFSGateway
+	}
+	gateway = strings.TrimSuffix(gateway, "/")
+	return blobSeed{name: gateway, url: func(digest string) string {
+		if cid, ok := cids[digest]; ok {
+			return gateway + "/ipfs/" + cid
+		}
+		return ""
+	}}
+}
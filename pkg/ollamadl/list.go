@@ -0,0 +1,152 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataFileName is the file in a model's directory that Pull records
+// which model it downloaded, and when, in.
+const MetadataFileName = "metadata.json"
+
+// pullMetadata is the content of MetadataFileName. It travels with the
+// model's directory, so where a copy came from can be told without the
+// machine that pulled it.
+type pullMetadata struct {
+	Model  string    `json:"model"`
+	Digest string    `json:"digest,omitempty"`
+	Pulled time.Time `json:"pulled"`
+	// Registry is the registry the model was pulled from.
+	Registry string `json:"registry,omitempty"`
+	// Size is the combined size of the model's layers, as served.
+	Size int64 `json:"size,omitempty"`
+	// Tool is the version of ollama-dl that pulled the model.
+	Tool string `json:"tool,omitempty"`
+}
+
+// modulePath is the module this package is part of.
+const modulePath = "github.com/dimchansky/ollama-dl-go"
+
+// toolVersion returns the version of ollama-dl running, as far as the build
+// records it, e.g. "ollama-dl v1.4.0" or "ollama-dl (devel) 3f2a9c1e0b7d".
+var toolVersion = sync.OnceValue(func() string {
+	version := "(devel)"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "ollama-dl " + version
+	}
+	if info.Main.Path == modulePath {
+		version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			version = dep.Version
+		}
+	}
+	if version == "(devel)" || version == "" {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+				return "ollama-dl (devel) " + setting.Value[:12]
+			}
+		}
+	}
+	return "ollama-dl " + version
+})
+
+// metadata describes the pull of res from the Downloader's registry, now.
+func (d *Downloader) metadata(res *Resolution) pullMetadata {
+	var size int64
+	for _, job := range res.Jobs {
+		size += job.Size
+	}
+	registry := strings.TrimSuffix(d.opts.Registry, "/")
+	if host := res.Ref.Host(); host != "" {
+		registry = "https://" + host
+	}
+	return pullMetadata{
+		Model:    res.Ref.String(),
+		Digest:   res.Manifest.Digest,
+		Pulled:   time.Now().UTC().Truncate(time.Second),
+		Registry: registry,
+		Size:     size,
+		Tool:     toolVersion(),
+	}
+}
+
+// saveMetadata writes meta into destDir.
+func saveMetadata(ctx context.Context, store BlobStore, destDir string, meta pullMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(ctx, store, path.Join(filepath.ToSlash(destDir), MetadataFileName), "application/json", append(data, '\n'))
+}
+
+// LocalModel is a model found on disk by List.
+type LocalModel struct {
+	// Dir is the model's directory within the store.
+	Dir string
+	// Ref is the model pulled, or zero if the pull didn't record it.
+	Ref Reference
+	// Manifest is the saved manifest, with the Digest recorded at the
+	// pull, if any.
+	Manifest *Manifest
+	// Pulled is when the model was last pulled or synced.
+	Pulled time.Time
+	// Size is the combined size of the model's layer files in Dir.
+	Size int64
+}
+
+// List finds the models pulled anywhere under root, by the manifests saved
+// with them, sorted by directory. List needs a FileStore.
+func (d *Downloader) List(ctx context.Context, root string) ([]LocalModel, error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil, errors.New("listing models needs a local directory")
+	}
+
+	var models []LocalModel
+	err := walkSaved(ctx, store, root, func(dir string, manifest *Manifest) error {
+		model := LocalModel{Dir: dir, Manifest: manifest}
+		var meta pullMetadata
+		found, err := readStoredJSON(ctx, store, path.Join(dir, MetadataFileName), &meta)
+		if err != nil {
+			return err
+		}
+		if found {
+			if model.Ref, err = ParseReference(meta.Model); err != nil {
+				return err
+			}
+			manifest.Digest = meta.Digest
+			model.Pulled = meta.Pulled
+		} else if info, err := os.Stat(store.Path(path.Join(dir, ManifestFileName))); err == nil {
+			model.Pulled = info.ModTime()
+		}
+
+		files, err := d.layerFiles(store, dir, manifest)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.referenced {
+				model.Size += file.size
+			}
+		}
+		models = append(models, model)
+		return nil
+	})
+	if err != nil {
+		return models, err
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Dir < models[j].Dir })
+	return models, nil
+}
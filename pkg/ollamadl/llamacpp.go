@@ -0,0 +1,105 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// llamaCppParams maps Ollama parameters to the llama.cpp options that set
+// them.
+var llamaCppParams = []struct{ param, flag string }{
+	{"num_ctx", "--ctx-size"},
+	{"num_predict", "--n-predict"},
+	{"temperature", "--temp"},
+	{"top_k", "--top-k"},
+	{"top_p", "--top-p"},
+	{"min_p", "--min-p"},
+	{"repeat_penalty", "--repeat-penalty"},
+	{"repeat_last_n", "--repeat-last-n"},
+	{"seed", "--seed"},
+}
+
+// LlamaCppArgs returns the command line that runs the model pulled into
+// destDir with llama.cpp's program, llama-server or llama-cli (or a path to
+// either): the model
+// and any projector, the parameters of the params layer, and the template
+// layer converted to a Jinja chat template. Stop words are passed to
+// llama-cli as reverse prompts; llama-server only takes them with each
+// request. A template that doesn't convert is left out, with a warning, so
+// llama.cpp falls back to the one embedded in the GGUF file.
+func (d *Downloader) LlamaCppArgs(ctx context.Context, destDir, program string) ([]string, error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil, errors.New("llama.cpp needs the model in a local directory")
+	}
+	res, err := d.savedResolution(ctx, destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{program}
+	var params map[string]any
+	for _, job := range res.Jobs {
+		switch baseMediaType(job.Layer.MediaType) {
+		case ModelMediaType:
+			// llama.cpp finds the other parts of a split model itself.
+			if job.Split <= 1 {
+				args = append(args, "--model", store.Path(job.DestPath))
+			}
+		case ProjectorMediaType:
+			args = append(args, "--mmproj", store.Path(job.DestPath))
+		case ParamsMediaType:
+			data, _, err := readStored(ctx, store, job.DestPath)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(data, &params); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", job.DestPath, err)
+			}
+		case TemplateMediaType:
+			data, _, err := readStored(ctx, store, job.DestPath)
+			if err != nil {
+				return nil, err
+			}
+			template, err := ConvertTemplate(string(data))
+			if err != nil {
+				d.log.Warn("Leaving out the chat template", "path", job.DestPath, "error", err)
+				continue
+			}
+			args = append(args, "--jinja", "--chat-template", template)
+		}
+	}
+	if len(args) == 1 {
+		return nil, fmt.Errorf("no model file in %s", destDir)
+	}
+
+	for _, p := range llamaCppParams {
+		if value, ok := params[p.param]; ok {
+			args = append(args, p.flag, paramString(value))
+		}
+	}
+	if strings.TrimSuffix(filepath.Base(program), ".exe") == "llama-cli" {
+		stops, _ := params["stop"].([]any)
+		for _, stop := range stops {
+			args = append(args, "--reverse-prompt", paramString(stop))
+		}
+	}
+	return args, nil
+}
+
+// paramString formats a value of the params layer as a command-line
+// argument.
+func paramString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
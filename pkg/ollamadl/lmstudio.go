@@ -0,0 +1,60 @@
+package ollamadl
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LMStudioModelsDir returns the directory LM Studio looks for models in:
+// .lmstudio/models in the home directory, or .cache/lm-studio/models where
+// an older LM Studio keeps them and the newer one doesn't exist.
+func LMStudioModelsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".lmstudio", "models")
+	legacy := filepath.Join(home, ".cache", "lm-studio", "models")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if info, err := os.Stat(legacy); err == nil && info.IsDir() {
+			return legacy, nil
+		}
+	}
+	return dir, nil
+}
+
+// LMStudioDir returns the directory ref goes into under LM Studio's models
+// directory, which LM Studio expects to be <publisher>/<model>: the
+// namespace, with "ollama" for the library, and the name and tag, e.g.
+// ollama/llama3.2-3b or bartowski/Llama-3.2-1B-Instruct-GGUF-Q4_K_M.
+func LMStudioDir(ref Reference) string {
+	name := strings.TrimPrefix(ref.Name, ref.Host()+"/")
+	publisher, repo := path.Split(name)
+	publisher = path.Base(publisher)
+	if publisher == "library" || publisher == "." || publisher == "/" {
+		publisher = "ollama"
+	}
+	return path.Join(sanitizeFileName(publisher), sanitizeFileName(repo+"-"+ref.Tag))
+}
+
+// lmStudioFileName returns the file name the LM Studio layout gives layers
+// of mediaType, or "" for the layers it names as usual. LM Studio reads the
+// quantization from the file name and recognizes projectors by the mmproj
+// prefix, so the name is the model's and the tag, without the -GGUF suffix
+// Hugging Face repositories carry.
+func lmStudioFileName(ref Reference, mediaType string) string {
+	name := path.Base(ref.Name)
+	for _, suffix := range []string{"-GGUF", "-gguf"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	name = sanitizeFileName(name+"-"+ref.Tag) + ".gguf"
+	switch mediaType {
+	case ModelMediaType:
+		return name
+	case ProjectorMediaType:
+		return "mmproj-" + name
+	}
+	return ""
+}
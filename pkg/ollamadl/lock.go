@@ -0,0 +1,73 @@
+package ollamadl
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LockFileName is the file in a model's directory that a pull holds a lock
+// on, so concurrent pulls into the same directory, from this process or
+// others, take turns instead of writing the same staged files.
+const LockFileName = ".ollama-dl.lock"
+
+// lockPollInterval is how often a pull waiting for a locked directory
+// checks again.
+const lockPollInterval = time.Second
+
+// lockDir takes the lock on destDir, waiting for whoever holds it, and
+// returns the function that releases it, which may be called more than
+// once. Only FileStore directories are locked; other stores can't be
+// shared this way.
+func (d *Downloader) lockDir(ctx context.Context, destDir string) (func(), error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return func() {}, nil
+	}
+	name := store.Path(path.Join(filepath.ToSlash(destDir), LockFileName))
+	if err := store.mkdirAll(filepath.Dir(name)); err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(ctx, name, func() {
+		d.log.Info("Waiting for another download into the same directory", "dir", destDir)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Whoever pulls into the directory next must be able to lock it too.
+	if err := store.chown(name); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// lockFile takes the lock on the file name, creating it and its directory
+// if needed. If someone else holds it, onWait is called once and lockFile
+// waits for it.
+func lockFile(ctx context.Context, name string, onWait func()) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	waiting := false
+	for {
+		unlock, err := tryLock(name)
+		if err != nil {
+			return nil, err
+		} else if unlock != nil {
+			return sync.OnceFunc(unlock), nil
+		}
+		if !waiting {
+			onWait()
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !unix && !windows
+
+package ollamadl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// tryLock creates the file name, which must not exist, and returns the
+// function that removes it again. It returns nil without an error if the
+// file exists. A process that dies holding the lock leaves the file
+// behind, and it has to be deleted by hand.
+func tryLock(name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return func() {
+		f.Close()
+		os.Remove(name)
+	}, nil
+}
@@ -0,0 +1,24 @@
+package ollamadl
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), LockFileName)
+	unlock, err := lockFile(context.Background(), name, func() { t.Error("waited for a free lock") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := tryLock(name); err != nil || again != nil {
+		t.Fatalf("tryLock() = %v, %v while the lock is held", again != nil, err)
+	}
+	unlock()
+	again, err := tryLock(name)
+	if err != nil || again == nil {
+		t.Fatalf("tryLock() = %v, %v after unlocking", again != nil, err)
+	}
+	again()
+}
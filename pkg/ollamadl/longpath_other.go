@@ -0,0 +1,13 @@
+//go:build !windows
+
+package ollamadl
+
+// longPath returns p; only Windows limits the length of paths this much.
+func longPath(p string) string {
+	return p
+}
+
+// shortPath returns p.
+func shortPath(p string) string {
+	return p
+}
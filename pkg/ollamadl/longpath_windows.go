@@ -0,0 +1,36 @@
+package ollamadl
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxShortPath is the longest path Windows takes without the \\?\ prefix;
+// directories need room for an 8.3 file name within MAX_PATH.
+const maxShortPath = 247
+
+// longPath makes p usable beyond MAX_PATH: long paths are made absolute
+// and given the \\?\ prefix, which lifts the limit for Windows APIs and
+// tools run on the files, whatever the LongPathsEnabled policy says.
+func longPath(p string) string {
+	if len(p) <= maxShortPath || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if unc, ok := strings.CutPrefix(abs, `\\`); ok {
+		return `\\?\UNC\` + unc
+	}
+	return `\\?\` + abs
+}
+
+// shortPath undoes longPath, for comparing paths and making relative ones
+// such as symbolic link targets, which can't carry the prefix.
+func shortPath(p string) string {
+	if unc, ok := strings.CutPrefix(p, `\\?\UNC\`); ok {
+		return `\\` + unc
+	}
+	return strings.TrimPrefix(p, `\\?\`)
+}
@@ -0,0 +1,252 @@
+package ollamadl
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// ManifestMediaType is the only manifest format the registry serves.
+	ManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+	ModelMediaType    = "application/vnd.ollama.image.model"
+	LicenseMediaType  = "application/vnd.ollama.image.license"
+	TemplateMediaType = "application/vnd.ollama.image.template"
+	ParamsMediaType   = "application/vnd.ollama.image.params"
+	// ProjectorMediaType is the multimodal projector of vision models.
+	ProjectorMediaType = "application/vnd.ollama.image.projector"
+
+	// configFileTemplate names the manifest's config blob, which carries the
+	// model family, parameter size and quantization level.
+	configFileTemplate = "config-%s.json"
+
+	// unknownFileTemplate names layers whose media type we don't recognize
+	// when Options.IncludeUnknown is set.
+	unknownFileTemplate = "layer-%s.bin"
+)
+
+var defaultFileTemplates = map[string]string{
+	"application/vnd.ollama.image.adapter":   "adapter-%s.gguf",
+	"application/vnd.ollama.image.license":   "license-%s.txt",
+	"application/vnd.ollama.image.messages":  "messages-%s.json",
+	"application/vnd.ollama.image.model":     "model-%s.gguf",
+	"application/vnd.ollama.image.params":    "params-%s.json",
+	"application/vnd.ollama.image.projector": "projector-%s.gguf",
+	"application/vnd.ollama.image.system":    "system-%s.txt",
+	"application/vnd.ollama.image.template":  "template-%s.txt",
+}
+
+type Layer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type Manifest struct {
+	SchemaVersion int     `json:"schemaVersion,omitempty"`
+	MediaType     string  `json:"mediaType"`
+	Config        Layer   `json:"config"`
+	Layers        []Layer `json:"layers"`
+
+	// Digest identifies the manifest in the registry: the sha256 of the
+	// manifest as served. It isn't part of the JSON.
+	Digest string `json:"-"`
+	// raw is the manifest as served, when it came from a registry.
+	raw []byte
+}
+
+// Reference identifies a model in a registry, e.g. library/llama3.2:latest.
+type Reference struct {
+	Name string
+	Tag  string
+}
+
+// ParseReference parses a model reference as accepted on the command line.
+// The namespace defaults to "library" and the tag to "latest". A name may
+// start with a registry host, as in hf.co/<user>/<repo>[:quant] for GGUF
+// repositories on Hugging Face; huggingface.co is taken as hf.co.
+func ParseReference(s string) (Reference, error) {
+	if s == "" {
+		return Reference{}, errors.New("empty model name")
+	}
+	if rest, ok := strings.CutPrefix(s, "huggingface.co/"); ok {
+		s = HuggingFaceHost + "/" + rest
+	}
+
+	name, tag, found := strings.Cut(s, ":")
+	if !found {
+		tag = "latest"
+	}
+	if name == "" || tag == "" {
+		return Reference{}, fmt.Errorf("invalid model reference: %s", s)
+	}
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	for _, component := range strings.Split(name, "/") {
+		if !nameComponentPattern.MatchString(component) {
+			return Reference{}, fmt.Errorf("invalid model reference: %s: name component %q", s, component)
+		}
+	}
+	if !referenceTagPattern.MatchString(tag) {
+		return Reference{}, fmt.Errorf("invalid model reference: %s: tag %q", s, tag)
+	}
+	return Reference{Name: name, Tag: tag}, nil
+}
+
+// The grammar of repository name components and tags in the distribution
+// spec, except that names may have capitals, as Hugging Face repositories
+// do. Registries treat anything else as malformed.
+var (
+	nameComponentPattern = regexp.MustCompile(`^[a-zA-Z0-9]+(?:(?:\.|_|__|-+)[a-zA-Z0-9]+)*$`)
+	referenceTagPattern  = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+)
+
+// escapePath percent-encodes each segment of the slash-separated p for use
+// in a URL path.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Host returns the registry host ref's name starts with, such as hf.co, or
+// "" if the model is on the configured registry. As with Docker, a first
+// name component containing a dot is a host.
+func (r Reference) Host() string {
+	first, rest, ok := strings.Cut(r.Name, "/")
+	if !ok || rest == "" || !strings.Contains(first, ".") {
+		return ""
+	}
+	return first
+}
+
+func (r Reference) String() string {
+	return r.Name + ":" + r.Tag
+}
+
+// DirName returns the default destination directory for the model, e.g.
+// library-llama3.2-latest. It is a valid file name on every platform; see
+// sanitizeFileName.
+func (r Reference) DirName() string {
+	return sanitizeFileName(strings.ReplaceAll(r.Name, "/", "-") + "-" + r.Tag)
+}
+
+// windowsReserved are the device names Windows doesn't allow as file
+// names, with or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFileName makes name, derived from a model reference, a valid
+// file name on NTFS and everywhere else, the same way on every platform so
+// a model gets the same directory wherever it's pulled: characters Windows
+// forbids or that separate paths become "_", trailing dots and spaces are
+// dropped, and device names such as CON get a "_" added.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	base, _, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = base + "_" + name[len(base):]
+	}
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
+
+// ValidateFileTemplate checks that a file template names a single file and
+// has exactly one %s for the layer's short hash.
+func ValidateFileTemplate(mediaType, fileTemplate string) error {
+	if strings.Count(fileTemplate, "%s") != 1 || strings.Count(fileTemplate, "%") != 1 {
+		return fmt.Errorf("file template for %s must contain exactly one %%s: %q", mediaType, fileTemplate)
+	}
+	if strings.ContainsAny(fileTemplate, `/\`) {
+		return fmt.Errorf("file template for %s must not contain path separators: %q", mediaType, fileTemplate)
+	}
+	return nil
+}
+
+func getShortHash(layer Layer) (string, error) {
+	if !strings.HasPrefix(layer.Digest, "sha256:") || len(layer.Digest) < 19 {
+		return "", fmt.Errorf("unexpected digest: %s", layer.Digest)
+	}
+	return layer.Digest[7:19], nil
+}
+
+// layerFileName expands fileTemplate with the layer's short hash.
+func layerFileName(layer Layer, fileTemplate string) (string, error) {
+	shortHash, err := getShortHash(layer)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(fileTemplate, shortHash), nil
+}
+
+// layerNamePattern returns a pattern matching exactly the names planJobs
+// gives layer files with any of templates, including the parts of split
+// models, with the short hash as the only non-empty submatch.
+func layerNamePattern(templates []string) *regexp.Regexp {
+	sort.Strings(templates)
+	var alternatives []string
+	for i, fileTemplate := range templates {
+		if i > 0 && fileTemplate == templates[i-1] {
+			continue
+		}
+		prefix, suffix, _ := strings.Cut(fileTemplate, "%s")
+		ext := filepath.Ext(suffix)
+		alternatives = append(alternatives, regexp.QuoteMeta(prefix)+`([0-9a-f]{12})`+
+			regexp.QuoteMeta(strings.TrimSuffix(suffix, ext))+`(?:-\d{5}-of-\d{5})?`+regexp.QuoteMeta(ext))
+	}
+	return regexp.MustCompile(`^(?:` + strings.Join(alternatives, "|") + `)$`)
+}
+
+// splitFileName returns the name of the index'th (1-based) part of a split
+// GGUF model, following llama.cpp's "<prefix>-00001-of-00003.gguf" scheme.
+func splitFileName(prefix, ext string, index, count int) string {
+	return fmt.Sprintf("%s-%05d-of-%05d%s", prefix, index, count, ext)
+}
+
+// mergedFileName returns the name of the single file a split model is merged
+// into, given the path of its first part.
+func mergedFileName(firstPart string, count int) string {
+	ext := filepath.Ext(firstPart)
+	return strings.TrimSuffix(firstPart, splitFileName("", ext, 1, count)) + ext
+}
+
+// layerCompression returns the compression ("gzip" or "zstd") applied to a
+// layer's content according to its media type, or "" for plain layers.
+func layerCompression(mediaType string) string {
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip"), strings.HasSuffix(mediaType, ".gzip"):
+		return "gzip"
+	case strings.HasSuffix(mediaType, "+zstd"), strings.HasSuffix(mediaType, ".zstd"):
+		return "zstd"
+	}
+	return ""
+}
+
+// baseMediaType strips a compression suffix from mediaType.
+func baseMediaType(mediaType string) string {
+	for _, suffix := range []string{"+gzip", ".gzip", "+zstd", ".zstd"} {
+		if strings.HasSuffix(mediaType, suffix) {
+			return strings.TrimSuffix(mediaType, suffix)
+		}
+	}
+	return mediaType
+}
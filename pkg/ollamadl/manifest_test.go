@@ -0,0 +1,97 @@
+package ollamadl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultFileTemplates(t *testing.T) {
+	for mediaType, template := range defaultFileTemplates {
+		if err := ValidateFileTemplate(mediaType, template); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestResolveVisionModel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/llava/manifests/7b" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{
+			"schemaVersion": 2,
+			"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+			"layers": [
+				{"mediaType": "application/vnd.ollama.image.model", "digest": "sha256:170370233dd5c5415250a2ecd5c71586352850729062ccef1496385647293868", "size": 4},
+				{"mediaType": "application/vnd.ollama.image.projector", "digest": "sha256:72d6f08a42f656d36b356dbe0920675899a99ce21192fd66266fb7d82ed07539", "size": 3}
+			]
+		}`))
+	}))
+	defer srv.Close()
+
+	d, err := New(Options{Registry: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ParseReference("llava:7b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := d.Resolve(context.Background(), ref, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"model-170370233dd5.gguf", "projector-72d6f08a42f6.gguf"}
+	if len(res.Jobs) != len(want) {
+		t.Fatalf("got %d jobs, want %d", len(res.Jobs), len(want))
+	}
+	for i, job := range res.Jobs {
+		if job.DestPath != filepath.Join("m", want[i]) {
+			t.Errorf("job %d writes %s, want %s", i, job.DestPath, want[i])
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"library-llama3.2-latest", "library-llama3.2-latest"},
+		{`a<b>c:d"e/f\g|h?i*j`, "a_b_c_d_e_f_g_h_i_j"},
+		{"tab\there\x00", "tab_here_"},
+		{"trailing. . ", "trailing"},
+		{"...", "_"},
+		{"", "_"},
+		{"CON", "CON_"},
+		{"con.tar", "con_.tar"},
+		{"Lpt9.gguf", "Lpt9_.gguf"},
+		{"COM10", "COM10"},
+		{"console", "console"},
+		{"nul .txt", "nul _.txt"},
+		{"模型-latest", "模型-latest"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFileName(tt.name); got != tt.want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDirName(t *testing.T) {
+	tests := []struct{ ref, want string }{
+		{"llama3.2", "library-llama3.2-latest"},
+		{"hf.co/User/Repo-GGUF:Q4_K_M", "hf.co-User-Repo-GGUF-Q4_K_M"},
+		{"aux", "library-aux-latest"},
+	}
+	for _, tt := range tests {
+		ref, err := ParseReference(tt.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ref.DirName(); got != tt.want {
+			t.Errorf("%s: DirName() = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ollamadl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestCache keeps the manifests httpRegistry fetched, with their
+// ETags, as files in a directory, for Options.ManifestCache.
+type manifestCache struct {
+	dir string
+	// maxStale is how old a cached manifest may be to stand in for one
+	// the registry can't serve.
+	maxStale time.Duration
+	log      *slog.Logger
+}
+
+// cachedManifest is a manifest as kept by manifestCache.
+type cachedManifest struct {
+	URL     string    `json:"url"`
+	ETag    string    `json:"etag,omitempty"`
+	Fetched time.Time `json:"fetched"`
+	// Data is the manifest exactly as served, as its digest covers.
+	Data []byte `json:"manifest"`
+}
+
+// file returns the file the manifest fetched from manifestURL is kept in.
+func (c *manifestCache) file(manifestURL string) string {
+	sum := sha256.Sum256([]byte(manifestURL))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached manifest fetched from manifestURL, or nil.
+func (c *manifestCache) get(manifestURL string) *cachedManifest {
+	data, err := os.ReadFile(c.file(manifestURL))
+	if err != nil {
+		return nil
+	}
+	var entry cachedManifest
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != manifestURL {
+		return nil
+	}
+	return &entry
+}
+
+// usable reports whether entry may stand in for a manifest the registry
+// can't serve now.
+func (c *manifestCache) usable(entry *cachedManifest) bool {
+	return c != nil && entry != nil && time.Since(entry.Fetched) <= c.maxStale
+}
+
+// put caches data as fetched from manifestURL just now. Failing to is
+// only logged: the cache merely saves requests.
+func (c *manifestCache) put(manifestURL, etag string, data []byte) {
+	entry, err := json.Marshal(cachedManifest{URL: manifestURL, ETag: etag, Fetched: time.Now().UTC(), Data: data})
+	if err == nil {
+		err = writeFileAtomic(c.file(manifestURL), entry)
+	}
+	if err != nil {
+		c.log.Debug("Could not cache manifest", "url", manifestURL, "error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// MemoryRegistry is a Registry that serves models from memory, so code using
+// a Downloader can be tested without network access:
+//
+//	reg := ollamadl.NewMemoryRegistry()
+//	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+//	model := reg.AddBlob("application/vnd.ollama.image.model", weights)
+//	reg.AddManifest(ref, config, model)
+//	d, err := ollamadl.New(ollamadl.WithRegistryClient(reg), ollamadl.WithStore(store))
+//
+// It is safe for concurrent use.
+type MemoryRegistry struct {
+	mu        sync.Mutex
+	manifests map[Reference]Manifest
+	blobs     map[string][]byte
+}
+
+// NewMemoryRegistry returns an empty MemoryRegistry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		manifests: make(map[Reference]Manifest),
+		blobs:     make(map[string][]byte),
+	}
+}
+
+// AddBlob stores data and returns a layer with the given media type
+// describing it.
+func (r *MemoryRegistry) AddBlob(mediaType string, data []byte) Layer {
+	digest := sha256Digest(data)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[digest] = bytes.Clone(data)
+	return Layer{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// AddManifest makes ref resolve to a manifest of config and layers, which
+// should have been added with AddBlob. It replaces any manifest ref had.
+func (r *MemoryRegistry) AddManifest(ref Reference, config Layer, layers ...Layer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Config:        config,
+		Layers:        append([]Layer{}, layers...),
+	}
+	data, _ := json.Marshal(manifest)
+	manifest.Digest = sha256Digest(data)
+	manifest.raw = data
+	r.manifests[ref] = manifest
+}
+
+func (r *MemoryRegistry) GetManifest(ctx context.Context, ref Reference) (*Manifest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manifest, ok := r.manifests[ref]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrManifestNotFound, ref)
+	}
+	manifest.Layers = append([]Layer{}, manifest.Layers...)
+	return &manifest, nil
+}
+
+// GetBlob serves blobs regardless of the repository, and always resumes at
+// offset. A missing blob is reported as an HTTPError with status 404, as a
+// registry would.
+func (r *MemoryRegistry) GetBlob(ctx context.Context, ref Reference, digest string, offset int64) (io.ReadCloser, int64, error) {
+	r.mu.Lock()
+	data, ok := r.blobs[digest]
+	r.mu.Unlock()
+	if !ok {
+		return nil, 0, &HTTPError{StatusCode: http.StatusNotFound, URL: digest}
+	}
+	if offset > int64(len(data)) {
+		return nil, 0, &HTTPError{StatusCode: http.StatusRequestedRangeNotSatisfiable, URL: digest}
+	}
+	return io.NopCloser(bytes.NewReader(data[offset:])), offset, nil
+}
+
+func (r *MemoryRegistry) ListTags(ctx context.Context, ref Reference) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tags []string
+	for m := range r.manifests {
+		if m.Name == ref.Name {
+			tags = append(tags, m.Tag)
+		}
+	}
+	if tags == nil {
+		return nil, fmt.Errorf("%w: %s", ErrManifestNotFound, ref.Name)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (r *MemoryRegistry) Catalog(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[string]bool)
+	var repos []string
+	for m := range r.manifests {
+		if !seen[m.Name] {
+			seen[m.Name] = true
+			repos = append(repos, m.Name)
+		}
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
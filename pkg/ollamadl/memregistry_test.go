@@ -0,0 +1,114 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestDownloader returns a Downloader pulling from a MemoryRegistry into
+// a FileStore in a temporary directory.
+func newTestDownloader(t *testing.T) (*Downloader, *MemoryRegistry, string) {
+	t.Helper()
+	reg := NewMemoryRegistry()
+	root := t.TempDir()
+	d, err := New(Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}, WithRegistryClient(reg), WithStore(NewFileStore(root)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, reg, root
+}
+
+// testGGUF returns a GGUF file without metadata or tensors, followed by
+// data, as a model layer.
+func testGGUF(data string) []byte {
+	header := []byte("GGUF")
+	header = binary.LittleEndian.AppendUint32(header, 3)
+	header = binary.LittleEndian.AppendUint64(header, 0)
+	header = binary.LittleEndian.AppendUint64(header, 0)
+	return append(header, data...)
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	ctx := context.Background()
+	reg := NewMemoryRegistry()
+	ref := Reference{Name: "library/llama3.2", Tag: "3b"}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, []byte("weights"))
+	reg.AddManifest(ref, config, model)
+	reg.AddManifest(Reference{Name: ref.Name, Tag: "1b"}, config, model)
+	reg.AddManifest(Reference{Name: "library/qwen2", Tag: "latest"}, config, model)
+
+	m, err := reg.GetManifest(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Config != config || !reflect.DeepEqual(m.Layers, []Layer{model}) || m.Digest != sha256Digest(m.raw) {
+		t.Errorf("GetManifest() = %+v", m)
+	}
+	if _, err := reg.GetManifest(ctx, Reference{Name: ref.Name, Tag: "70b"}); !errors.Is(err, ErrManifestNotFound) {
+		t.Errorf("GetManifest() of a missing tag: %v", err)
+	}
+
+	body, offset, err := reg.GetBlob(ctx, ref, model.Digest, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	if offset != 3 || string(data) != "ghts" {
+		t.Errorf("GetBlob() from 3 = %q at %d", data, offset)
+	}
+	var httpErr *HTTPError
+	if _, _, err := reg.GetBlob(ctx, ref, sha256Digest([]byte("other")), 0); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetBlob() of a missing blob: %v", err)
+	}
+
+	if tags, err := reg.ListTags(ctx, ref); err != nil || !reflect.DeepEqual(tags, []string{"1b", "3b"}) {
+		t.Errorf("ListTags() = %v, %v", tags, err)
+	}
+	if repos, err := reg.Catalog(ctx); err != nil || !reflect.DeepEqual(repos, []string{"library/llama3.2", "library/qwen2"}) {
+		t.Errorf("Catalog() = %v, %v", repos, err)
+	}
+}
+
+func TestPullResumesStagedFile(t *testing.T) {
+	d, reg, root := newTestDownloader(t)
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := testGGUF("weights that were partly downloaded before")
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, weights)
+	reg.AddManifest(ref, config, model)
+
+	name := filepath.Join(root, "m", "model-"+model.Digest[len("sha256:"):][:12]+".gguf")
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name+".tmp", weights[:len(weights)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Pull(context.Background(), ref, "m"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, weights) {
+		t.Errorf("pulled %q, want %q", data, weights)
+	}
+	if _, err := os.Stat(name + ".tmp"); err == nil {
+		t.Error("staged file left behind")
+	}
+}
@@ -0,0 +1,82 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// MirrorResult describes what a Mirror did.
+type MirrorResult struct {
+	// Tags are the tags that were to be mirrored.
+	Tags []string
+	// Pulled are the resolutions of the tags that were mirrored.
+	Pulled []*Resolution
+	// Reused counts files copied or linked from another tag instead of
+	// being downloaded again.
+	Reused int
+}
+
+// Mirror pulls every tag of ref's repository into a subdirectory of destDir
+// named after the tag. Layers shared between tags are downloaded once and
+// copied to the other tags, as hard links when the store is a FileStore. A
+// tag that fails doesn't stop the others; the failures are returned
+// together.
+func (d *Downloader) Mirror(ctx context.Context, ref Reference, destDir string) (*MirrorResult, error) {
+	tags, err := d.Tags(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return d.MirrorTags(ctx, ref, tags, destDir)
+}
+
+// MirrorTags is like Mirror but pulls only the given tags.
+func (d *Downloader) MirrorTags(ctx context.Context, ref Reference, tags []string, destDir string) (*MirrorResult, error) {
+	result := &MirrorResult{Tags: tags}
+	have := make(map[string]string) // stored file by digest
+	lookup := func(digest string) (string, bool) {
+		file, ok := have[digest]
+		return file, ok
+	}
+	var errs []error
+	for _, tag := range tags {
+		tagRef := Reference{Name: ref.Name, Tag: tag}
+		res, reused, err := d.mirrorTag(ctx, tagRef, path.Join(filepath.ToSlash(destDir), tag), lookup)
+		result.Reused += reused
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", tagRef, err))
+			continue
+		}
+
+		result.Pulled = append(result.Pulled, res)
+		for _, job := range res.Jobs {
+			have[job.Layer.Digest] = job.DestPath
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// mirrorTag pulls ref into destDir for MirrorTags, reusing the files lookup
+// finds. It returns how many files it reused.
+func (d *Downloader) mirrorTag(ctx context.Context, ref Reference, destDir string, lookup func(string) (string, bool)) (*Resolution, int, error) {
+	done := d.startPull(ctx, ref, destDir)
+	res, err := d.Resolve(ctx, ref, destDir)
+	if err != nil {
+		return nil, 0, done(nil, d.opts.Hooks.fail(ctx, nil, err))
+	}
+	unlock, err := d.lockDir(ctx, destDir)
+	if err != nil {
+		return nil, 0, done(res, d.opts.Hooks.fail(ctx, nil, err))
+	}
+	defer unlock()
+	reused, err := d.reuseFiles(ctx, res.Jobs, lookup)
+	if err != nil {
+		return nil, reused, done(res, err)
+	}
+	return res, reused, done(res, d.pull(ctx, res))
+}
@@ -0,0 +1,215 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ModelCardFileName is the file Options.ModelCard saves a model's card in.
+const ModelCardFileName = "README.md"
+
+// Where model cards come from. The registry carries no documentation, so
+// it is taken from the model's page on ollama.com, or the repository's
+// README on Hugging Face for hf.co models.
+const (
+	ollamaWebsite      = "https://ollama.com"
+	huggingFaceWebsite = "https://huggingface.co"
+)
+
+// ModelCard is the human-readable description of a model.
+type ModelCard struct {
+	// URL is the page the card was taken from.
+	URL string
+	// Description is the one-line summary, and Capabilities what the
+	// model supports, such as tools or vision. Hugging Face cards have
+	// neither.
+	Description  string
+	Capabilities []string
+	// Readme is the model's documentation, in Markdown.
+	Readme string
+}
+
+// Markdown returns the card as a Markdown document.
+func (c *ModelCard) Markdown() []byte {
+	var buf bytes.Buffer
+	if c.Description != "" {
+		fmt.Fprintf(&buf, "%s\n\n", c.Description)
+	}
+	if len(c.Capabilities) > 0 {
+		fmt.Fprintf(&buf, "Capabilities: %s\n\n", strings.Join(c.Capabilities, ", "))
+	}
+	if c.Readme != "" {
+		fmt.Fprintf(&buf, "%s\n\n", strings.TrimSpace(c.Readme))
+	}
+	fmt.Fprintf(&buf, "Source: %s\n", c.URL)
+	return buf.Bytes()
+}
+
+// ModelCard fetches the card of ref's model. Pages on ollama.com are HTML
+// meant for browsers, so the card is extracted on a best-effort basis.
+func (d *Downloader) ModelCard(ctx context.Context, ref Reference) (*ModelCard, error) {
+	if ref.Host() == HuggingFaceHost {
+		repo := strings.TrimPrefix(ref.Name, HuggingFaceHost+"/")
+		card := &ModelCard{URL: huggingFaceWebsite + "/" + escapePath(repo)}
+		readme, err := fetchPage(ctx, card.URL+"/raw/main/README.md")
+		if err != nil {
+			return nil, err
+		}
+		card.Readme = string(stripFrontMatter(readme))
+		return card, nil
+	}
+
+	card := &ModelCard{URL: ollamaWebsite + "/" + escapePath(ref.Name)}
+	page, err := fetchPage(ctx, card.URL)
+	if err != nil {
+		return nil, err
+	}
+	if m := metaDescriptionPattern.FindSubmatch(page); m != nil {
+		card.Description = html.UnescapeString(string(m[1]))
+	}
+	for _, m := range capabilityPattern.FindAllSubmatch(page, -1) {
+		card.Capabilities = append(card.Capabilities, html.UnescapeString(string(m[1])))
+	}
+	if readme := elementContent(page, `id="display"`); readme != nil {
+		card.Readme = htmlToMarkdown(string(readme))
+	}
+	return card, nil
+}
+
+// fetchPage returns the body of a web page.
+func fetchPage(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, URL: url}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+}
+
+// saveModelCard fetches the card of res's model into destDir. Cards are
+// a convenience, so failing to get one only logs a warning.
+func (d *Downloader) saveModelCard(ctx context.Context, res *Resolution) {
+	card, err := d.ModelCard(ctx, res.Ref)
+	if err == nil {
+		err = writeFile(ctx, d.opts.Store, path.Join(filepath.ToSlash(res.DestDir), ModelCardFileName), "text/markdown", card.Markdown())
+	}
+	if err != nil {
+		d.log.Warn("Failed to save the model card", "model", res.Ref.String(), "error", err)
+	}
+}
+
+var (
+	metaDescriptionPattern = regexp.MustCompile(`<meta\s+name="description"\s+content="([^"]*)"`)
+	capabilityPattern      = regexp.MustCompile(`<span[^>]*\bx-test-capability\b[^>]*>\s*([^<]+?)\s*</span>`)
+	frontMatterPattern     = regexp.MustCompile(`(?s)\A---\n.*?\n---\n`)
+)
+
+// stripFrontMatter removes the YAML block Hugging Face READMEs start with.
+func stripFrontMatter(readme []byte) []byte {
+	return frontMatterPattern.ReplaceAll(readme, nil)
+}
+
+// elementContent returns the content of the first element whose opening
+// tag contains attr, up to its matching closing tag, or nil.
+func elementContent(page []byte, attr string) []byte {
+	i := bytes.Index(page, []byte(attr))
+	if i < 0 {
+		return nil
+	}
+	tagStart := bytes.LastIndexByte(page[:i], '<')
+	if tagStart < 0 {
+		return nil
+	}
+	name := page[tagStart+1:]
+	if end := bytes.IndexAny(name, " \t\n>"); end >= 0 {
+		name = name[:end]
+	}
+	open, close := []byte("<"+string(name)), []byte("</"+string(name)+">")
+	contentStart := bytes.IndexByte(page[i:], '>')
+	if contentStart < 0 {
+		return nil
+	}
+	contentStart += i + 1
+
+	depth := 1
+	for pos := contentStart; pos < len(page); {
+		nextOpen := bytes.Index(page[pos:], open)
+		nextClose := bytes.Index(page[pos:], close)
+		if nextClose < 0 {
+			return nil
+		}
+		if nextOpen >= 0 && nextOpen < nextClose {
+			depth++
+			pos += nextOpen + len(open)
+			continue
+		}
+		depth--
+		if depth == 0 {
+			return page[contentStart : pos+nextClose]
+		}
+		pos += nextClose + len(close)
+	}
+	return nil
+}
+
+var (
+	preBlockPattern = regexp.MustCompile(`(?s)<pre[^>]*>(.*?)</pre>`)
+	headingPattern  = regexp.MustCompile(`(?s)<h([1-6])[^>]*>(.*?)</h[1-6]>`)
+	linkPattern     = regexp.MustCompile(`(?s)<a[^>]*\bhref="([^"]*)"[^>]*>(.*?)</a>`)
+	imagePattern    = regexp.MustCompile(`<img[^>]*\bsrc="([^"]*)"[^>]*>`)
+	tagPattern      = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankPattern    = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToMarkdown turns the rendered Markdown of a readme back into rough
+// Markdown: headings, paragraphs, lists, links, emphasis and code survive,
+// other markup is dropped.
+func htmlToMarkdown(s string) string {
+	// Code blocks are kept verbatim, out of reach of the rewriting below.
+	var blocks []string
+	s = preBlockPattern.ReplaceAllStringFunc(s, func(block string) string {
+		code := tagPattern.ReplaceAllString(preBlockPattern.FindStringSubmatch(block)[1], "")
+		blocks = append(blocks, "```\n"+strings.TrimRight(html.UnescapeString(code), "\n")+"\n```")
+		return fmt.Sprintf("\n\n\x00%d\x00\n\n", len(blocks)-1)
+	})
+	s = headingPattern.ReplaceAllStringFunc(s, func(h string) string {
+		m := headingPattern.FindStringSubmatch(h)
+		return "\n\n" + strings.Repeat("#", int(m[1][0]-'0')) + " " + strings.TrimSpace(m[2]) + "\n\n"
+	})
+	s = imagePattern.ReplaceAllString(s, "![]($1)")
+	s = linkPattern.ReplaceAllString(s, "[$2]($1)")
+	s = strings.NewReplacer(
+		"<li>", "\n- ", "<p>", "\n\n", "</p>", "\n\n", "<br>", "\n", "<br/>", "\n", "<br />", "\n",
+		"<strong>", "**", "</strong>", "**", "<b>", "**", "</b>", "**",
+		"<em>", "*", "</em>", "*", "<code>", "`", "</code>", "`",
+		"<ul>", "\n", "</ul>", "\n", "<ol>", "\n", "</ol>", "\n",
+	).Replace(s)
+	s = html.UnescapeString(tagPattern.ReplaceAllString(s, ""))
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Trim(line, " \t")
+	}
+	s = blankPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	for i, block := range blocks {
+		s = strings.Replace(s, fmt.Sprintf("\x00%d\x00", i), block, 1)
+	}
+	return strings.TrimSpace(s)
+}
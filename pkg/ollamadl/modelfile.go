@@ -0,0 +1,294 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ModelfileName is the file `ollama create` reads a model's definition from.
+const ModelfileName = "Modelfile"
+
+// modelDefinition is what Ollama needs to create a model pulled into a
+// directory.
+type modelDefinition struct {
+	Model      modelFile
+	Projectors []modelFile
+	Adapters   []modelFile
+
+	Template, System string
+	Licenses         []string
+	Params           map[string]any
+	Messages         []modelMessage
+}
+
+// modelFile is a file of a model definition, within the store. Digest is
+// empty if the file isn't stored as its layer was served, as with merged
+// splits.
+type modelFile struct {
+	Name, Digest string
+}
+
+type modelMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// modelDefinition reads the model pulled into destDir. A split model can
+// only be used once merged, since Ollama doesn't load splits.
+func (d *Downloader) modelDefinition(ctx context.Context, destDir string) (*modelDefinition, error) {
+	res, err := d.savedResolution(ctx, destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var def modelDefinition
+	for _, job := range res.Jobs {
+		file := modelFile{Name: job.DestPath}
+		if job.compression() == "" {
+			file.Digest = job.Layer.Digest
+		}
+		mediaType := baseMediaType(job.Layer.MediaType)
+		switch mediaType {
+		case ModelMediaType:
+			if job.Split > 1 {
+				continue
+			}
+			if job.Split == 1 {
+				file = modelFile{Name: mergedFileName(job.DestPath, job.SplitCount)}
+				if exists, err := d.opts.Store.Exists(ctx, file.Name); err != nil {
+					return nil, err
+				} else if !exists {
+					return nil, errors.New("ollama can't load a split model; merge it first")
+				}
+			}
+			def.Model = file
+			continue
+		case ProjectorMediaType:
+			def.Projectors = append(def.Projectors, file)
+			continue
+		case "application/vnd.ollama.image.adapter":
+			def.Adapters = append(def.Adapters, file)
+			continue
+		}
+
+		data, found, err := readStored(ctx, d.opts.Store, job.DestPath)
+		if err != nil {
+			return nil, err
+		} else if !found {
+			continue
+		}
+		switch mediaType {
+		case TemplateMediaType:
+			def.Template = string(data)
+		case "application/vnd.ollama.image.system":
+			def.System = string(data)
+		case LicenseMediaType:
+			def.Licenses = append(def.Licenses, string(data))
+		case ParamsMediaType:
+			if err := json.Unmarshal(data, &def.Params); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", job.DestPath, err)
+			}
+		case "application/vnd.ollama.image.messages":
+			if err := json.Unmarshal(data, &def.Messages); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", job.DestPath, err)
+			}
+		}
+	}
+	if def.Model.Name == "" {
+		return nil, fmt.Errorf("no model file in %s", destDir)
+	}
+	return &def, nil
+}
+
+// Modelfile returns a Modelfile that recreates the model pulled into
+// destDir with `ollama create`, run in that directory: the model file, any
+// projector and adapter, and the template, system prompt, parameters,
+// messages and licenses of its layers. A split model can only be used once
+// merged, as with Options.MergeSplits, since Ollama doesn't load splits.
+func (d *Downloader) Modelfile(ctx context.Context, destDir string) ([]byte, error) {
+	def, err := d.modelDefinition(ctx, destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	// The model comes first; a second FROM adds a projector.
+	fmt.Fprintf(&buf, "FROM ./%s\n", path.Base(def.Model.Name))
+	for _, file := range def.Projectors {
+		fmt.Fprintf(&buf, "FROM ./%s\n", path.Base(file.Name))
+	}
+	for _, file := range def.Adapters {
+		fmt.Fprintf(&buf, "ADAPTER ./%s\n", path.Base(file.Name))
+	}
+	if def.Template != "" {
+		fmt.Fprintf(&buf, "TEMPLATE %s\n", modelfileText(def.Template))
+	}
+	if def.System != "" {
+		fmt.Fprintf(&buf, "SYSTEM %s\n", modelfileText(def.System))
+	}
+	for _, license := range def.Licenses {
+		fmt.Fprintf(&buf, "LICENSE %s\n", modelfileText(license))
+	}
+	for _, line := range modelfileParams(def.Params) {
+		fmt.Fprintln(&buf, line)
+	}
+	for _, m := range def.Messages {
+		fmt.Fprintf(&buf, "MESSAGE %s %s\n", m.Role, modelfileText(m.Content))
+	}
+	return buf.Bytes(), nil
+}
+
+// modelfileParams returns the PARAMETER lines for params, in name order,
+// with a line for each value of a list such as stop.
+func modelfileParams(params map[string]any) []string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		values, ok := params[name].([]any)
+		if !ok {
+			values = []any{params[name]}
+		}
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				lines = append(lines, "PARAMETER "+name+" "+strconv.Quote(s))
+			} else {
+				lines = append(lines, "PARAMETER "+name+" "+paramString(value))
+			}
+		}
+	}
+	return lines
+}
+
+// modelfileText quotes text for a Modelfile, in triple quotes where it
+// spans lines or contains quotes.
+func modelfileText(text string) string {
+	if !strings.ContainsAny(text, "\"\n") {
+		return `"` + text + `"`
+	}
+	return `"""` + text + `"""`
+}
+
+// ModelfileSource is a FROM or ADAPTER line of a Modelfile: what
+// `ollama create` builds the model from.
+type ModelfileSource struct {
+	// Command is "FROM" or "ADAPTER".
+	Command string
+	// Value is the argument as written, a model name or a file path.
+	Value string
+	// Line is where the command is in the Modelfile, from 1.
+	Line int
+	// Ref is the model Value names, unless Local.
+	Ref Reference
+	// Local means Value is a file or directory, resolved against the
+	// Modelfile's directory in Path, rather than a model to pull.
+	Local bool
+	Path  string
+}
+
+// ReadModelfile reads the Modelfile in the file name and returns the
+// models and files it builds on. As with `ollama create`, a FROM or
+// ADAPTER value is a local file if one exists at that path, relative to
+// the Modelfile, and otherwise a model in a registry, such as llama3.2 or
+// hf.co/<user>/<repo>:<quant>; values that can only be paths, like ./x or
+// adapter.gguf, are local even if missing.
+func ReadModelfile(name string) ([]ModelfileSource, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := parseModelfile(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	dir := filepath.Dir(name)
+	for i := range sources {
+		src := &sources[i]
+		p := src.Value
+		if rest, ok := strings.CutPrefix(p, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				p = filepath.Join(home, rest)
+			}
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		_, statErr := os.Stat(p)
+		if statErr == nil || looksLikePath(src.Value) {
+			src.Local, src.Path = true, p
+			continue
+		}
+		if src.Ref, err = ParseReference(src.Value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, src.Line, err)
+		}
+	}
+	return sources, nil
+}
+
+// looksLikePath reports whether a FROM or ADAPTER value can't be a model
+// name.
+func looksLikePath(value string) bool {
+	if strings.HasPrefix(value, ".") || strings.HasPrefix(value, "~") || filepath.IsAbs(value) || strings.Contains(value, `\`) {
+		return true
+	}
+	switch strings.ToLower(path.Ext(value)) {
+	case ".gguf", ".bin", ".safetensors":
+		return true
+	}
+	return false
+}
+
+// parseModelfile returns the FROM and ADAPTER lines of a Modelfile,
+// skipping the text of other commands, which may span lines in triple
+// quotes.
+func parseModelfile(text string) ([]ModelfileSource, error) {
+	var sources []ModelfileSource
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		n := i + 1
+		command, value, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		value = strings.TrimSpace(value)
+		if rest, ok := strings.CutPrefix(value, `"""`); ok {
+			for !strings.Contains(rest, `"""`) {
+				if i++; i == len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated \"\"\"", n)
+				}
+				rest = lines[i]
+			}
+			value, _, _ = strings.Cut(strings.TrimPrefix(value, `"""`), `"""`)
+		} else if s, err := strconv.Unquote(value); err == nil {
+			value = s
+		}
+		if command != "FROM" && command != "ADAPTER" {
+			continue
+		}
+		if value == "" {
+			return nil, fmt.Errorf("line %d: %s without a value", n, command)
+		}
+		sources = append(sources, ModelfileSource{Command: command, Value: value, Line: n})
+	}
+	for _, src := range sources {
+		if src.Command == "FROM" {
+			return sources, nil
+		}
+	}
+	return nil, errors.New("no FROM line")
+}
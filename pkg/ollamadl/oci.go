@@ -0,0 +1,116 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// OCILayoutFileName and OCIIndexFileName are the files that mark a
+	// directory as an OCI image layout and list the manifests in it.
+	OCILayoutFileName = "oci-layout"
+	OCIIndexFileName  = "index.json"
+
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// ociRefNameAnnotation names a manifest in an OCI layout's index; skopeo,
+	// oras and crane look manifests up by it.
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// ociDescriptor points to a blob from an OCI index.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// ociBlobPath returns where an OCI layout keeps a blob, e.g.
+// blobs/sha256/<hex>.
+func ociBlobPath(destDir, digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return path.Join(filepath.ToSlash(destDir), "blobs", algorithm, hex)
+}
+
+// planOCIJobs maps the config and every layer of manifest to its blob in an
+// OCI layout at destDir, to be stored as served.
+func (d *Downloader) planOCIJobs(ref Reference, manifest *Manifest, destDir string) []DownloadJob {
+	var jobs []DownloadJob
+	planned := make(map[string]bool)
+	for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+		if layer.Digest == "" || planned[layer.Digest] {
+			continue
+		}
+		planned[layer.Digest] = true
+		jobs = append(jobs, DownloadJob{
+			Layer:    layer,
+			Ref:      ref,
+			DestPath: ociBlobPath(destDir, layer.Digest),
+			BlobURL:  d.blobURL(ref, layer.Digest),
+			Size:     layer.Size,
+			Raw:      true,
+		})
+	}
+	return jobs
+}
+
+// saveOCILayout adds the manifest of res to the OCI layout at its DestDir,
+// under its tag, replacing whatever the tag named before. The manifest is
+// stored as the registry served it, so it keeps its digest.
+func saveOCILayout(ctx context.Context, store BlobStore, res *Resolution) error {
+	destDir := filepath.ToSlash(res.DestDir)
+	manifest := res.Manifest
+	data, digest := manifest.raw, manifest.Digest
+	if data == nil {
+		var err error
+		if data, err = json.Marshal(manifest); err != nil {
+			return err
+		}
+		digest = sha256Digest(data)
+	}
+	if err := writeFile(ctx, store, ociBlobPath(destDir, digest), manifest.MediaType, data); err != nil {
+		return err
+	}
+
+	layout := path.Join(destDir, OCILayoutFileName)
+	if exists, err := store.Exists(ctx, layout); err != nil {
+		return err
+	} else if !exists {
+		if err := writeFile(ctx, store, layout, "application/json", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+			return err
+		}
+	}
+
+	name := path.Join(destDir, OCIIndexFileName)
+	index := ociIndex{SchemaVersion: 2, MediaType: ociIndexMediaType}
+	if _, err := readStoredJSON(ctx, store, name, &index); err != nil {
+		return err
+	}
+	manifests := index.Manifests[:0]
+	for _, desc := range index.Manifests {
+		if desc.Annotations[ociRefNameAnnotation] != res.Ref.Tag {
+			manifests = append(manifests, desc)
+		}
+	}
+	index.Manifests = append(manifests, ociDescriptor{
+		MediaType:   manifest.MediaType,
+		Digest:      digest,
+		Size:        int64(len(data)),
+		Annotations: map[string]string{ociRefNameAnnotation: res.Ref.Tag},
+	})
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(ctx, store, name, ociIndexMediaType, append(data, '\n'))
+}
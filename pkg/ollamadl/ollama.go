@@ -0,0 +1,202 @@
+package ollamadl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ollamaHost is the registry host Ollama files models from the default
+// registry under.
+const ollamaHost = "registry.ollama.ai"
+
+// OllamaModelsDir returns the directory a local Ollama installation keeps
+// its models in: $OLLAMA_MODELS, or .ollama/models in the home directory.
+func OllamaModelsDir() (string, error) {
+	if dir := os.Getenv("OLLAMA_MODELS"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ollama", "models"), nil
+}
+
+// ollamaBlobPath returns where Ollama keeps a blob, e.g.
+// blobs/sha256-<hex>.
+func ollamaBlobPath(modelsDir, digest string) string {
+	return filepath.Join(modelsDir, "blobs", strings.Replace(digest, ":", "-", 1))
+}
+
+// reuseOllamaBlobs puts the files of jobs whose blobs the local Ollama
+// installation at Options.OllamaModels has into place, so pulling the jobs
+// won't download them again. Only layers stored as served can be; a blob
+// whose size doesn't match its layer is left alone.
+func (d *Downloader) reuseOllamaBlobs(ctx context.Context, jobs []DownloadJob) error {
+	if d.opts.OllamaModels == "" || d.opts.Force {
+		return nil
+	}
+	for _, job := range jobs {
+		if job.compression() != "" {
+			continue
+		}
+		blob := ollamaBlobPath(d.opts.OllamaModels, job.Layer.Digest)
+		if info, err := os.Stat(blob); err != nil || !info.Mode().IsRegular() || info.Size() != job.Layer.Size {
+			continue
+		}
+		exists, err := d.opts.Store.Exists(ctx, job.DestPath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := d.linkOllamaBlob(ctx, blob, job); err != nil {
+			// Downloading the layer still works.
+			d.log.Warn("Could not reuse Ollama blob", "blob", blob, "to", job.DestPath, "error", err)
+			continue
+		}
+		d.log.Info("Reused", "path", job.DestPath, "from", blob)
+	}
+	return nil
+}
+
+// linkOllamaBlob hard links the Ollama blob into place as the file of job,
+// or copies it into the store.
+func (d *Downloader) linkOllamaBlob(ctx context.Context, blob string, job DownloadJob) error {
+	if fs, ok := d.opts.Store.(*FileStore); ok {
+		if err := fs.mkdirAll(filepath.Dir(fs.Path(job.DestPath))); err != nil {
+			return err
+		}
+		if os.Link(blob, fs.Path(job.DestPath)) == nil {
+			return nil
+		}
+	}
+	f, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return copyStored(ctx, d.opts.Store, f, job.DestPath, job.Layer)
+}
+
+// ollamaManifestPath returns where Ollama keeps the manifest of ref.
+func ollamaManifestPath(modelsDir string, ref Reference) string {
+	return filepath.Join(modelsDir, "manifests", ollamaHost, filepath.FromSlash(ref.Name), ref.Tag)
+}
+
+// ImportToOllama unpacks a bundle written by Export from r into the models
+// directory of an Ollama installation (see OllamaModelsDir), so Ollama can
+// run the model without pulling it. Blobs Ollama already has are kept.
+// Every blob is checked against its digest and the other files against the
+// bundle's SHA256SUMS; the manifest is only written once all of it checks
+// out.
+func (d *Downloader) ImportToOllama(ctx context.Context, r io.Reader, modelsDir string) (Reference, error) {
+	var (
+		ref      Reference
+		manifest *Manifest
+	)
+	onModel := func(r Reference, m *Manifest, _ pullMetadata) error {
+		ref, manifest = r, m
+		return nil
+	}
+	onBlob := func(layer Layer, blob io.Reader) (bool, error) {
+		name := ollamaBlobPath(modelsDir, layer.Digest)
+		if info, err := os.Stat(name); err == nil && info.Size() == layer.Size {
+			d.log.Info("Already have", "path", name)
+			return false, nil
+		}
+		job := DownloadJob{Layer: layer, Ref: ref, DestPath: name, Size: layer.Size}
+		if err := d.writeOllamaBlob(ctx, job, blob); err != nil {
+			return true, fmt.Errorf("%s: %w", layer.Digest, err)
+		}
+		return true, nil
+	}
+	if err := readBundle(ctx, r, onModel, onBlob); err != nil {
+		return Reference{}, err
+	}
+
+	for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+		if _, err := os.Stat(ollamaBlobPath(modelsDir, layer.Digest)); err != nil {
+			return Reference{}, fmt.Errorf("bundle lacks blob %s", layer.Digest)
+		}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return Reference{}, err
+	}
+	if err := writeFileAtomic(ollamaManifestPath(modelsDir, ref), data); err != nil {
+		return Reference{}, err
+	}
+	return ref, nil
+}
+
+// writeOllamaBlob writes the blob read from r to job's path, verbatim as
+// Ollama keeps blobs, once its digest checks out.
+func (d *Downloader) writeOllamaBlob(ctx context.Context, job DownloadJob, r io.Reader) error {
+	reporter := d.opts.Progress
+	reporter.LayerStarted(job, 0)
+	err := func() error {
+		if err := os.MkdirAll(filepath.Dir(job.DestPath), 0755); err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(job.DestPath), filepath.Base(job.DestPath)+".*.tmp")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		hasher := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tmp, hasher, progressWriter{job, reporter}), ctxReader{ctx, r}); err != nil {
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if got := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); got != job.Layer.Digest {
+			return fmt.Errorf("%w: got %s", ErrDigestMismatch, got)
+		}
+		if err := os.Chmod(tmp.Name(), 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), job.DestPath)
+	}()
+	if err != nil {
+		reporter.LayerFailed(job, err)
+		return err
+	}
+	reporter.LayerCompleted(job)
+	return nil
+}
+
+// writeFileAtomic writes data to name through a temporary file, creating
+// the directory if needed.
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,172 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// PushToOllama creates the model pulled into destDir as name on the Ollama
+// server at serverURL, e.g. http://gpu-box:11434, through its API: files
+// the server doesn't have yet are uploaded as blobs, then the model is
+// created from them with the template, parameters and other settings of
+// its layers. The server needs no access to a registry.
+func (d *Downloader) PushToOllama(ctx context.Context, destDir, serverURL, name string) error {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return errors.New("pushing to Ollama needs the model in a local directory")
+	}
+	def, err := d.modelDefinition(ctx, destDir)
+	if err != nil {
+		return err
+	}
+	api := &ollamaAPI{base: strings.TrimSuffix(serverURL, "/"), client: &http.Client{}}
+
+	// Ollama names the files of a model by their digests.
+	upload := func(file modelFile) (string, string, error) {
+		if file.Digest == "" {
+			digest, err := storedDigest(ctx, store, file.Name)
+			if err != nil {
+				return "", "", err
+			}
+			file.Digest = digest
+		}
+		if err := api.pushBlob(ctx, store.Path(file.Name), file.Digest, d.log); err != nil {
+			return "", "", fmt.Errorf("uploading %s: %w", file.Name, err)
+		}
+		return path.Base(file.Name), file.Digest, nil
+	}
+	req := ollamaCreateRequest{
+		Model:      name,
+		Files:      make(map[string]string),
+		Template:   def.Template,
+		System:     def.System,
+		License:    def.Licenses,
+		Parameters: def.Params,
+		Messages:   def.Messages,
+	}
+	for _, file := range append([]modelFile{def.Model}, def.Projectors...) {
+		base, digest, err := upload(file)
+		if err != nil {
+			return err
+		}
+		req.Files[base] = digest
+	}
+	for _, file := range def.Adapters {
+		base, digest, err := upload(file)
+		if err != nil {
+			return err
+		}
+		if req.Adapters == nil {
+			req.Adapters = make(map[string]string)
+		}
+		req.Adapters[base] = digest
+	}
+	d.log.Info("Creating model on Ollama", "server", api.base, "name", name)
+	return api.create(ctx, req)
+}
+
+// ollamaCreateRequest is the body of Ollama's /api/create.
+type ollamaCreateRequest struct {
+	Model      string            `json:"model"`
+	Files      map[string]string `json:"files"`
+	Adapters   map[string]string `json:"adapters,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	System     string            `json:"system,omitempty"`
+	License    []string          `json:"license,omitempty"`
+	Parameters map[string]any    `json:"parameters,omitempty"`
+	Messages   []modelMessage    `json:"messages,omitempty"`
+	Stream     bool              `json:"stream"`
+}
+
+// ollamaAPI talks to an Ollama server's API.
+type ollamaAPI struct {
+	base   string
+	client *http.Client
+}
+
+// pushBlob uploads the file name as the blob digest, unless the server has
+// it already.
+func (a *ollamaAPI) pushBlob(ctx context.Context, name, digest string, log *slog.Logger) error {
+	blobURL := a.base + "/api/blobs/" + digest
+	req, err := http.NewRequestWithContext(ctx, "HEAD", blobURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	log.Info("Uploading to Ollama", "file", name, "size", info.Size())
+	req, err = http.NewRequestWithContext(ctx, "POST", blobURL, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	resp, err = a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return ollamaError(resp, blobURL)
+	}
+	return nil
+}
+
+func (a *ollamaAPI) create(ctx context.Context, body ollamaCreateRequest) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	createURL := a.base + "/api/create"
+	req, err := http.NewRequestWithContext(ctx, "POST", createURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return ollamaError(resp, createURL)
+	}
+	return nil
+}
+
+// ollamaError returns the error an Ollama API response reports, or an
+// HTTPError if it carries none.
+func ollamaError(resp *http.Response, url string) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return fmt.Errorf("ollama: %s", body.Error)
+	}
+	return &HTTPError{StatusCode: resp.StatusCode, URL: url}
+}
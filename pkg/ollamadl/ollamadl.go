@@ -0,0 +1,1085 @@
+// Package ollamadl downloads models from an Ollama registry.
+//
+// A Downloader resolves a model reference to its manifest, fetches the layers
+// it knows how to name (model weights, template, parameters, licenses, ...)
+// into a directory, and can later verify those files against the manifest.
+package ollamadl
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRegistry is the public Ollama registry.
+const DefaultRegistry = "https://registry.ollama.ai/"
+
+// HuggingFaceHost is the registry host of models on Hugging Face. It serves
+// the GGUF files of a repository as models, with the quantization as the
+// tag, e.g. hf.co/bartowski/Llama-3.2-1B-Instruct-GGUF:Q4_K_M; "latest"
+// picks a default quantization.
+const HuggingFaceHost = "hf.co"
+
+// manifestTimeout bounds a manifest request on top of the caller's context;
+// blob downloads are only bounded by the context.
+const manifestTimeout = 30 * time.Second
+
+// Options configures a Downloader. The zero value downloads from
+// DefaultRegistry.
+type Options struct {
+	// Registry is the registry base URL, possibly with a path a reverse
+	// proxy serves it under; a bare host means HTTPS, and a trailing slash
+	// or /v2 doesn't matter. unix:///path reaches a registry listening on
+	// a Unix domain socket.
+	Registry string
+	// DialOverride, when set, sends every connection to this address
+	// (host:port or unix:///path) regardless of the registry host.
+	DialOverride string
+	// ConnectTimeout, TLSHandshakeTimeout and ResponseHeaderTimeout bound
+	// opening a connection to the registry, its TLS handshake, and the
+	// wait for a response's headers once a request is sent.
+	// IdleConnTimeout is how long an unused connection is kept for the
+	// next request. Zero means 30s, 10s, 30s and 90s. None of them limits
+	// how long a body takes to arrive.
+	ConnectTimeout        time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	// Resolve maps host:port pairs, such as registry.ollama.ai:443, to the
+	// IP addresses connections to them go to instead of the ones DNS
+	// gives, like curl's --resolve; TLS still checks the host's name.
+	Resolve map[string]string
+	// Resolver, when set, is the DNS server (host or host:port) names are
+	// looked up with instead of the system's.
+	Resolver string
+	// DNSCacheTTL, when positive, keeps the addresses looked up for a host
+	// for this long, so connections don't each depend on a flaky DNS.
+	DNSCacheTTL time.Duration
+	// IPVersion, when 4 or 6, connects to the registry over only IPv4 or
+	// IPv6, e.g. where the path to its CDN over the other is broken and
+	// downloads stall instead of failing.
+	IPVersion int
+	// MaxIdleConnsPerHost is how many unused connections to a host are
+	// kept for reuse, so concurrent downloads don't each set up a new
+	// connection and TLS session; zero means 16. MaxConnsPerHost, when
+	// positive, caps the connections to a host, in use or not, making
+	// further requests wait for one. KeepAlive is the interval of TCP
+	// keep-alive probes on connections; zero means 30s, and a negative
+	// value turns them off.
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	KeepAlive           time.Duration
+	// HTTP1 talks to the registry over HTTP/1.1 only, for proxies and
+	// middleboxes that mishandle HTTP/2 streams of large bodies. The
+	// protocol each host ends up with is logged at debug level.
+	HTTP1 bool
+
+	// HTTPClient, when set, is used for all registry and seed requests
+	// instead of a client built from Registry and DialOverride, e.g. to
+	// plug in a test double. It can't be combined with DialOverride or a
+	// unix:// registry.
+	HTTPClient *http.Client
+	// WrapTransport, when set, wraps the transport of the built-in clients
+	// for the registry and seeds, e.g. to add authentication or tracing
+	// middleware.
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+	// Auth, when set, authenticates requests to the registry. Without it,
+	// bearer challenges are still answered with anonymous tokens.
+	Auth *Credentials
+	// TokenURL, when set, is where bearer tokens are fetched from instead
+	// of the token service the registry's challenges name, for proxies
+	// such as Artifactory and Nexus whose challenges point to a host that
+	// can't be reached from outside.
+	TokenURL string
+	// RepositoryPrefix is put in front of every repository name on the
+	// registry, e.g. the repository key of an Artifactory remote
+	// repository reached without its reverse proxy, as in
+	// https://artifactory.example.com/v2/<key>/library/llama3.2/...
+	// Models are still named and stored without it. Proxies that serve a
+	// repository under a path instead, such as Artifactory's
+	// /artifactory/api/docker/<key> or Nexus's /repository/<name>, need
+	// only that path in Registry.
+	RepositoryPrefix string
+	// HuggingFaceToken, when set, is sent to HuggingFaceHost with pulls of
+	// hf.co models, for gated and private repositories.
+	HuggingFaceToken string
+	// Webseeds are HTTP servers blobs are fetched from before the
+	// registry, each holding them as <seed>/sha256-<hex>, like Ollama's
+	// blobs directory. Manifests still come from the registry, and blobs
+	// are checked against them, so seeds needn't be trusted. No
+	// credentials are sent to seeds.
+	Webseeds []string
+	// IPFSCIDs maps blob digests to the IPFS CIDs of their content; those
+	// blobs are fetched through the IPFS gateway at IPFSGateway (default
+	// DefaultIPFSGateway, a local node's) after any Webseeds and before
+	// the registry, and checked like blobs from anywhere else.
+	IPFSCIDs    map[string]string
+	IPFSGateway string
+	// SignaturePolicy, when set, makes Resolve, and so every pull, and Cat
+	// fail with ErrSignature unless the model's manifest carries a cosign
+	// signature the policy accepts, before anything is downloaded.
+	SignaturePolicy *SignaturePolicy
+	// Policy, when set, makes Resolve and Cat fail with ErrPolicy for
+	// models it doesn't allow, before anything is downloaded.
+	Policy *ModelPolicy
+	// RegistryClient, when set, is used for all registry access instead of
+	// the HTTP client configured by the fields above, e.g. a
+	// MemoryRegistry in tests.
+	RegistryClient Registry
+	// ManifestCache, when set, is a directory where the manifests fetched
+	// from the registry are kept with their ETags. Fetching one again
+	// asks the registry only whether it changed, so repeated syncs of
+	// unchanged tags cost next to nothing, and while the registry can't
+	// be reached, manifests cached within ManifestCacheMaxStale are used
+	// instead.
+	ManifestCache         string
+	ManifestCacheMaxStale time.Duration
+
+	// Concurrency limits how many layers download at the same time, across
+	// all the pulls a Downloader runs at once, which take turns. Zero
+	// downloads them all at once.
+	Concurrency int
+	// RateLimit caps the combined download rate in bytes per second. Zero
+	// means no limit.
+	RateLimit int64
+	// RateSchedule sets other limits for times of day, e.g. none at night
+	// and RateLimit otherwise. The first window a time falls in applies.
+	RateSchedule []RateWindow
+	// VerifyConcurrency limits how many files verification hashes at the
+	// same time. Zero means one per CPU.
+	VerifyConcurrency int
+	// VerifyProgress, when set, is called as verification hashes files,
+	// with the bytes hashed so far and the total to hash. Calls don't
+	// overlap.
+	VerifyProgress func(hashed, total int64)
+
+	// FileTemplates adds or overrides the file name used for a layer media
+	// type. Templates contain one %s for the short hash; an empty template
+	// skips that media type.
+	FileTemplates map[string]string
+	// IncludeUnknown also downloads layers with unrecognized media types.
+	IncludeUnknown bool
+
+	// AggregateLicenses writes all license layers into LICENSES.txt as well.
+	AggregateLicenses bool
+	// WriteChecksums writes a SHA256SUMS file next to the downloaded files,
+	// covering them and the manifest, for checking copies with sha256sum.
+	WriteChecksums bool
+	// Attestation writes AttestationFileName next to the downloaded files:
+	// an in-toto statement of SLSA provenance recording the registry, the
+	// manifest and layer digests and the version of ollama-dl, with the
+	// files and their digests as subjects, for supply-chain audit trails.
+	// It is signed in a DSSE envelope if AttestationKey is set.
+	Attestation    bool
+	AttestationKey crypto.Signer
+	// MergeSplits merges a split GGUF model into a single file after
+	// download, if llama.cpp's gguf-split tool is available.
+	MergeSplits bool
+	// DedupeDir, when set, is a directory of the FileStore with earlier
+	// pulls, such as the common root of several models. Files of layers
+	// already stored there are hard linked into new pulls instead of
+	// downloaded again.
+	DedupeDir string
+	// DedupeSymlinks links files found through DedupeDir symbolically
+	// rather than with hard links.
+	DedupeSymlinks bool
+	// OllamaModels, when set, is the models directory of a local Ollama
+	// installation (see OllamaModelsDir). Layers stored as served whose
+	// blobs it has are hard linked from there, or copied where linking
+	// fails, instead of downloaded.
+	OllamaModels string
+	// OCILayout stores pulls as OCI image layouts instead: the manifest,
+	// config and every layer verbatim under blobs/sha256, with index.json
+	// naming the manifest by its tag. Tools such as skopeo, oras and crane
+	// can push such a directory on to other registries unchanged.
+	OCILayout bool
+	// LMStudioLayout names the model file, and any projector, the way LM
+	// Studio expects to find them, e.g. llama3.2-3b.gguf and
+	// mmproj-llava-7b.gguf, instead of by hash. Pull into LMStudioDir(ref)
+	// under LMStudioModelsDir() for LM Studio to list the model.
+	LMStudioLayout bool
+	// DeltaUpdates fetches a layer that replaces one of the previous pull
+	// into the same directory by copying the ranges the old file shares
+	// with it and downloading only the rest, if the registry serves range
+	// requests. It needs a FileStore and layers stored as served.
+	DeltaUpdates bool
+	// ModelCard saves the model's description, capabilities and readme
+	// from ollama.com (or Hugging Face, for hf.co models) as
+	// ModelCardFileName next to its files, since the registry carries no
+	// documentation. A card that can't be fetched only logs a warning.
+	ModelCard bool
+	// Audit, when set, records every pull, whether it succeeded or not,
+	// e.g. in an AuditLog. A pull that can't be recorded fails, though its
+	// files stay in place.
+	Audit Auditor
+	// State, when set, records pulls into a FileStore, for State.List and
+	// VerifyQuick.
+	State *State
+	// Force downloads every file again, replacing files already in the
+	// store and discarding partial downloads rather than resuming them,
+	// e.g. to recover from suspected corruption. Nothing is reused from
+	// DedupeDir, other tags or previous versions either.
+	Force bool
+	// StaleTempAge, when positive, is how old a partial download in a
+	// pull's directory may get before the pull starts it over rather than
+	// resuming it. Temporary files of layers the model doesn't have are
+	// always removed, as interrupted pulls would leave them there forever.
+	StaleTempAge time.Duration
+	// MaxStoreSize, when positive, caps the combined size of the models
+	// pulled under StoreRoot, a directory of the FileStore. Before a pull
+	// downloads anything, other models there are removed in the order
+	// Eviction gives until the new files fit. Models in Pinned, and
+	// models another pull is writing to, are never removed.
+	MaxStoreSize int64
+	// StoreRoot is the directory MaxStoreSize and KeepVersions cover.
+	// Empty means the parent directory of each pull's destination.
+	StoreRoot string
+	// KeepVersions, when positive, keeps only the KeepVersions most
+	// recently pulled versions (manifest digests) of a model under
+	// StoreRoot: once a pull is done, other directories there holding
+	// older versions of the same model are removed. It suits pulling a tag
+	// into a new directory each time. Directories in Pinned are kept.
+	KeepVersions int
+	// LatestLink, when set, is a symbolic link within the FileStore, such
+	// as models/llama3-latest, that is pointed at the directory of each
+	// successful pull, so configurations can name a fixed path whichever
+	// version it holds. The link is replaced in one step.
+	LatestLink string
+	// VersionedDirs puts each pull in a subdirectory of its destination
+	// named after the first 12 hex digits of the manifest digest, so a
+	// new version of a mutable tag lands next to the old ones instead of
+	// among their files. Files the versions share are linked from the
+	// older ones rather than downloaded again.
+	VersionedDirs bool
+	// Eviction chooses which models go first; empty means EvictLRU.
+	Eviction EvictionPolicy
+	// Pinned are models kept whatever the limit, by reference, such as
+	// "llama3:latest", or by directory within the store.
+	Pinned []string
+
+	// Progress receives download progress events. Nil disables reporting.
+	Progress ProgressReporter
+
+	// Store receives the downloaded files. Nil means the local filesystem,
+	// relative to the current directory.
+	Store BlobStore
+
+	// Hooks are called at each stage of a Pull.
+	Hooks Hooks
+
+	// Logger receives the downloader's messages, such as skipped files and
+	// retries. Nil means slog.Default().
+	Logger *slog.Logger
+}
+
+// Downloader fetches models from a registry.
+type Downloader struct {
+	registry      Registry
+	fileTemplates map[string]string
+	layerNames    *regexp.Regexp
+	limiter       *rateLimiter
+	slots         *layerSlots
+	opts          Options
+	log           *slog.Logger
+	dedupe        dedupeIndex
+}
+
+// New returns a Downloader configured by options, applied in order. With no
+// options it downloads from DefaultRegistry into the current directory.
+func New(options ...Option) (*Downloader, error) {
+	var opts Options
+	for _, o := range options {
+		o.apply(&opts)
+	}
+	if opts.Registry == "" {
+		opts.Registry = DefaultRegistry
+	}
+	if opts.Progress == nil {
+		opts.Progress = nopReporter{}
+	}
+	if opts.Store == nil {
+		opts.Store = NewFileStore("")
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.OCILayout && opts.LMStudioLayout {
+		return nil, errors.New("an OCI layout cannot be combined with the LM Studio layout")
+	}
+	if !opts.Eviction.valid() {
+		return nil, fmt.Errorf("unknown eviction policy %q", opts.Eviction)
+	}
+
+	client, seedClient, registry, err := newHTTPClient(&opts)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(opts.Registry, "unix://") {
+		opts.Registry = registry
+	}
+	switch {
+	case opts.HTTPClient != nil:
+		if opts.DialOverride != "" || strings.HasPrefix(opts.Registry, "unix://") {
+			return nil, errors.New("a custom HTTP client cannot be combined with a dial override or unix:// registry")
+		}
+		client, seedClient = opts.HTTPClient, opts.HTTPClient
+	case opts.WrapTransport != nil:
+		client.Transport = opts.WrapTransport(client.Transport)
+		seedClient.Transport = opts.WrapTransport(seedClient.Transport)
+	}
+	var creds Credentials
+	if opts.Auth != nil {
+		creds = *opts.Auth
+	}
+	transport, err := newAuthTransport(client.Transport, registry, creds)
+	if err != nil {
+		return nil, err
+	}
+	transport.tokenURL = opts.TokenURL
+	// Copy the client rather than change one supplied in the options.
+	authClient := *client
+	authClient.Transport = transport
+	client = &authClient
+	if opts.HuggingFaceToken != "" {
+		transport, err := newAuthTransport(client.Transport, "https://"+HuggingFaceHost, Credentials{Token: opts.HuggingFaceToken})
+		if err != nil {
+			return nil, err
+		}
+		hfClient := *client
+		hfClient.Transport = transport
+		client = &hfClient
+	}
+
+	fileTemplates := make(map[string]string, len(defaultFileTemplates))
+	for mediaType, fileTemplate := range defaultFileTemplates {
+		fileTemplates[mediaType] = fileTemplate
+	}
+	for mediaType, fileTemplate := range opts.FileTemplates {
+		if fileTemplate == "" {
+			delete(fileTemplates, mediaType)
+			continue
+		}
+		if err := ValidateFileTemplate(mediaType, fileTemplate); err != nil {
+			return nil, err
+		}
+		fileTemplates[mediaType] = fileTemplate
+	}
+
+	httpReg := &httpRegistry{client: client, base: registry, prefix: strings.Trim(opts.RepositoryPrefix, "/")}
+	if opts.ManifestCache != "" {
+		httpReg.cache = &manifestCache{dir: opts.ManifestCache, maxStale: opts.ManifestCacheMaxStale, log: opts.Logger}
+	}
+	var reg Registry = httpReg
+	if opts.RegistryClient != nil {
+		reg = opts.RegistryClient
+	}
+	var seeds []blobSeed
+	for _, seed := range opts.Webseeds {
+		seeds = append(seeds, webseed(seed))
+	}
+	if len(opts.IPFSCIDs) > 0 {
+		seeds = append(seeds, ipfsSeed(opts.IPFSGateway, opts.IPFSCIDs))
+	}
+	if len(seeds) > 0 {
+		reg = newWebseedRegistry(reg, seedClient, seeds, opts.Logger)
+	}
+
+	// Files named by the default templates are recognized even when the
+	// options change them, as earlier pulls may have written them.
+	templates := []string{configFileTemplate, unknownFileTemplate}
+	for _, fileTemplate := range defaultFileTemplates {
+		templates = append(templates, fileTemplate)
+	}
+	for _, fileTemplate := range fileTemplates {
+		templates = append(templates, fileTemplate)
+	}
+
+	return &Downloader{
+		registry:      reg,
+		fileTemplates: fileTemplates,
+		layerNames:    layerNamePattern(templates),
+		limiter:       newRateLimiter(opts.RateLimit, opts.RateSchedule),
+		slots:         newLayerSlots(opts.Concurrency),
+		opts:          opts,
+		log:           opts.Logger,
+	}, nil
+}
+
+type DownloadJob struct {
+	Layer Layer
+	Ref   Reference
+	// DestPath is the file's name within the store.
+	DestPath string
+	// BlobURL is where the layer is downloaded from; it is empty with a
+	// RegistryClient that isn't HTTP based.
+	BlobURL string
+	Size    int64
+
+	// Split and SplitCount are set for parts of a split GGUF model.
+	Split      int
+	SplitCount int
+	// Raw is set when the blob is stored as served, compressed or not.
+	Raw bool
+
+	// seed is an earlier version of the file to copy unchanged ranges
+	// from, with Options.DeltaUpdates.
+	seed string
+}
+
+// compression returns the compression to undo when storing job's layer.
+func (job DownloadJob) compression() string {
+	if job.Raw {
+		return ""
+	}
+	return layerCompression(job.Layer.MediaType)
+}
+
+// Resolution is a resolved manifest together with the files it maps to.
+type Resolution struct {
+	Ref      Reference
+	Manifest Manifest
+	DestDir  string
+	Jobs     []DownloadJob
+
+	// downloaded is how many bytes pulling the resolution fetched.
+	downloaded int64
+}
+
+// Resolve fetches the manifest for ref and works out which files its layers
+// are stored in under destDir.
+func (d *Downloader) Resolve(ctx context.Context, ref Reference, destDir string) (*Resolution, error) {
+	manifest, err := d.fetchAllowedManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.opts.VersionedDirs {
+		if destDir, err = versionDir(destDir, manifest); err != nil {
+			return nil, err
+		}
+	}
+	jobs, err := d.planJobs(ref, manifest, destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolution{Ref: ref, Manifest: *manifest, DestDir: destDir, Jobs: jobs}, nil
+}
+
+// fetchAllowedManifest fetches the manifest for ref, failing if
+// Options.SignaturePolicy or Options.Policy doesn't let it be pulled. Ref's
+// name is checked before anything is fetched.
+func (d *Downloader) fetchAllowedManifest(ctx context.Context, ref Reference) (*Manifest, error) {
+	if d.opts.Policy != nil {
+		if err := d.checkPolicyName(ref); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := d.fetchManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.SignaturePolicy != nil {
+		if err := d.verifySignature(ctx, ref, manifest); err != nil {
+			return nil, err
+		}
+	}
+	if d.opts.Policy != nil {
+		if err := d.checkPolicyManifest(ctx, ref, manifest); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+func (d *Downloader) fetchManifest(ctx context.Context, ref Reference) (*Manifest, error) {
+	manifest, err := d.registry.GetManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.MediaType != ManifestMediaType {
+		return nil, fmt.Errorf("%w for manifest: %s", ErrUnsupportedMediaType, manifest.MediaType)
+	}
+	d.log.Debug("Fetched manifest", "ref", ref.String(), "layers", len(manifest.Layers))
+	return manifest, nil
+}
+
+// Tags returns the tags of ref's repository.
+func (d *Downloader) Tags(ctx context.Context, ref Reference) ([]string, error) {
+	return d.registry.ListTags(ctx, ref)
+}
+
+// Catalog returns the names of all repositories in the registry. It fails
+// with ErrCatalogUnsupported if the registry can't list them.
+func (d *Downloader) Catalog(ctx context.Context) ([]string, error) {
+	c, ok := d.baseRegistry().(Cataloger)
+	if !ok {
+		return nil, ErrCatalogUnsupported
+	}
+	return c.Catalog(ctx)
+}
+
+// baseRegistry returns the registry itself, without any web seeds in
+// front of it.
+func (d *Downloader) baseRegistry() Registry {
+	if seeded, ok := d.registry.(*webseedRegistry); ok {
+		return seeded.Registry
+	}
+	return d.registry
+}
+
+// blobURL returns the URL a blob is fetched from, or "" when the registry
+// isn't reached over HTTP.
+func (d *Downloader) blobURL(ref Reference, digest string) string {
+	if r, ok := d.baseRegistry().(*httpRegistry); ok {
+		return r.blobURL(ref, digest)
+	}
+	return ""
+}
+
+func (d *Downloader) planJobs(ref Reference, manifest *Manifest, destDir string) ([]DownloadJob, error) {
+	if d.opts.OCILayout {
+		return d.planOCIJobs(ref, manifest, destDir), nil
+	}
+	var jobs []DownloadJob
+	addJob := func(layer Layer, filename string) *DownloadJob {
+		jobs = append(jobs, DownloadJob{
+			Layer:    layer,
+			Ref:      ref,
+			DestPath: path.Join(filepath.ToSlash(destDir), filename),
+			BlobURL:  d.blobURL(ref, layer.Digest),
+			Size:     layer.Size,
+		})
+		return &jobs[len(jobs)-1]
+	}
+
+	if manifest.Config.Digest != "" {
+		filename, err := layerFileName(manifest.Config, configFileTemplate)
+		if err != nil {
+			return nil, err
+		}
+		addJob(manifest.Config, filename)
+	}
+
+	// Models too large for a single file are shipped as several model
+	// layers; name them the way llama.cpp's split loader expects.
+	var splitPrefix, splitExt string
+	splitCount, splitIndex := 0, 0
+	for _, layer := range manifest.Layers {
+		if baseMediaType(layer.MediaType) != ModelMediaType {
+			continue
+		}
+		if splitCount == 0 {
+			first, err := layerFileName(layer, d.fileTemplates[ModelMediaType])
+			if err != nil {
+				return nil, err
+			}
+			if d.opts.LMStudioLayout {
+				first = lmStudioFileName(ref, ModelMediaType)
+			}
+			splitExt = filepath.Ext(first)
+			splitPrefix = strings.TrimSuffix(first, splitExt)
+		}
+		splitCount++
+	}
+
+	for _, layer := range manifest.Layers {
+		fileTemplate, ok := d.fileTemplates[baseMediaType(layer.MediaType)]
+		if !ok {
+			if !d.opts.IncludeUnknown {
+				continue
+			}
+			fileTemplate = unknownFileTemplate
+		}
+
+		filename, err := layerFileName(layer, fileTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if name := lmStudioFileName(ref, baseMediaType(layer.MediaType)); d.opts.LMStudioLayout && name != "" {
+			filename = name
+		}
+		if !ok {
+			d.log.Warn("Unknown layer media type", "mediaType", layer.MediaType, "path", filename)
+		}
+
+		if baseMediaType(layer.MediaType) == ModelMediaType && splitCount > 1 {
+			splitIndex++
+			job := addJob(layer, splitFileName(splitPrefix, splitExt, splitIndex, splitCount))
+			job.Split, job.SplitCount = splitIndex, splitCount
+			continue
+		}
+
+		addJob(layer, filename)
+	}
+
+	return jobs, nil
+}
+
+// Pull resolves ref and downloads its layers into destDir within the store,
+// skipping files that are already present, and saves the manifest there as
+// ManifestFileName. Cancelling ctx stops the downloads, keeping partial files
+// so a later Pull can resume them.
+func (d *Downloader) Pull(ctx context.Context, ref Reference, destDir string) (*Resolution, error) {
+	done := d.startPull(ctx, ref, destDir)
+	res, err := d.Resolve(ctx, ref, destDir)
+	if err != nil {
+		return nil, done(nil, d.opts.Hooks.fail(ctx, nil, err))
+	}
+	unlock, err := d.lockDir(ctx, res.DestDir)
+	if err != nil {
+		return nil, done(res, d.opts.Hooks.fail(ctx, nil, err))
+	}
+	defer unlock()
+	return res, done(res, d.pull(ctx, res))
+}
+
+// pull downloads the files of a resolved manifest and saves the manifest
+// next to them.
+func (d *Downloader) pull(ctx context.Context, res *Resolution) error {
+	hooks := &d.opts.Hooks
+	destDir := res.DestDir
+	started := time.Now()
+	if hooks.OnManifestResolved != nil {
+		if err := hooks.OnManifestResolved(ctx, res); err != nil {
+			return hooks.fail(ctx, nil, err)
+		}
+	}
+	if err := d.removePartials(ctx, res.DestDir); err != nil {
+		return hooks.fail(ctx, nil, err)
+	}
+	if err := d.removeStaleTemps(ctx, res); err != nil {
+		return hooks.fail(ctx, nil, err)
+	}
+	if err := d.dedupeFiles(ctx, res.Jobs); err != nil {
+		return hooks.fail(ctx, nil, err)
+	}
+	if err := d.reuseVersions(ctx, res); err != nil {
+		return hooks.fail(ctx, nil, err)
+	}
+	if err := d.reuseOllamaBlobs(ctx, res.Jobs); err != nil {
+		return hooks.fail(ctx, nil, err)
+	}
+	if err := d.planDeltas(ctx, res); err != nil {
+		return hooks.fail(ctx, nil, err)
+	}
+	if err := d.makeRoom(ctx, res); err != nil {
+		return hooks.fail(ctx, nil, err)
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		failed     []DownloadJob
+		errs       = make(map[string]error)
+		skipped    = make(map[string]bool)
+		downloaded = make(map[string]bool)
+	)
+	// fetch downloads jobs at once, each on its own: one failing leaves
+	// the others to finish.
+	fetch := func(jobs []DownloadJob) {
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job DownloadJob) {
+				defer wg.Done()
+				err := d.slots.acquire(ctx, res)
+				if err == nil {
+					err = d.pullLayer(ctx, job)
+					d.slots.release()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case errors.Is(err, ErrSkipLayer):
+					skipped[job.DestPath] = true
+				case err != nil:
+					failed = append(failed, job)
+					errs[job.DestPath] = err
+				default:
+					delete(errs, job.DestPath)
+					downloaded[job.DestPath] = true
+					res.downloaded += job.Size
+				}
+			}(job)
+		}
+		wg.Wait()
+	}
+
+	var pending []DownloadJob
+	for _, job := range res.Jobs {
+		exists, err := d.opts.Store.Exists(ctx, job.DestPath)
+		if err != nil {
+			return hooks.fail(ctx, &job, err)
+		}
+		if exists && !d.opts.Force {
+			d.log.Info("Already have", "path", job.DestPath)
+			continue
+		}
+		pending = append(pending, job)
+	}
+	fetch(pending)
+
+	// Failures that outlasted the retries of their own are often down to
+	// a network that has recovered since; give them one more go now the
+	// rest is done.
+	var retry []DownloadJob
+	for _, job := range failed {
+		if retryable(errs[job.DestPath]) {
+			retry = append(retry, job)
+		}
+	}
+	if len(retry) > 0 && ctx.Err() == nil {
+		d.log.Warn("Retrying failed downloads", "count", len(retry))
+		failed = nil
+		fetch(retry)
+	}
+
+	if len(errs) > 0 {
+		var joined []error
+		for _, job := range res.Jobs {
+			if err, ok := errs[job.DestPath]; ok {
+				joined = append(joined, fmt.Errorf("%s: %w", job.DestPath, err))
+			}
+		}
+		return fmt.Errorf("%d of %d files failed: %w", len(joined), len(pending), errors.Join(joined...))
+	}
+
+	if len(skipped) > 0 {
+		jobs := res.Jobs[:0:0]
+		for _, job := range res.Jobs {
+			if !skipped[job.DestPath] {
+				jobs = append(jobs, job)
+			}
+		}
+		res.Jobs = jobs
+	}
+
+	if d.opts.MergeSplits {
+		for _, job := range res.Jobs {
+			if job.Split != 1 {
+				continue
+			}
+			fs, ok := d.opts.Store.(*FileStore)
+			if !ok {
+				return hooks.fail(ctx, nil, errors.New("merging split models needs a local file store"))
+			}
+			first := fs.Path(job.DestPath)
+			merged := mergedFileName(first, job.SplitCount)
+			if err := mergeSplits(first, merged); err != nil {
+				return hooks.fail(ctx, nil, fmt.Errorf("merging split model: %w", err))
+			}
+			if fs.FileMode != 0 {
+				if err := os.Chmod(merged, fs.FileMode); err != nil {
+					return hooks.fail(ctx, nil, err)
+				}
+			}
+			if err := fs.chown(merged); err != nil {
+				return hooks.fail(ctx, nil, err)
+			}
+		}
+	}
+
+	if d.opts.AggregateLicenses {
+		if err := writeLicenses(ctx, d.opts.Store, destDir, res.Jobs); err != nil {
+			return hooks.fail(ctx, nil, fmt.Errorf("writing %s: %w", LicensesFileName, err))
+		}
+	}
+
+	if d.opts.OCILayout {
+		if err := saveOCILayout(ctx, d.opts.Store, res); err != nil {
+			return hooks.fail(ctx, nil, fmt.Errorf("saving %s: %w", OCIIndexFileName, err))
+		}
+	} else {
+		if err := saveManifest(ctx, d.opts.Store, destDir, res.Manifest); err != nil {
+			return hooks.fail(ctx, nil, fmt.Errorf("saving %s: %w", ManifestFileName, err))
+		}
+		meta := d.metadata(res)
+		if err := saveMetadata(ctx, d.opts.Store, destDir, meta); err != nil {
+			return hooks.fail(ctx, nil, fmt.Errorf("saving %s: %w", MetadataFileName, err))
+		}
+		if d.opts.WriteChecksums {
+			if err := d.writeChecksums(ctx, res); err != nil {
+				return hooks.fail(ctx, nil, fmt.Errorf("writing %s: %w", ChecksumsFileName, err))
+			}
+		}
+		if d.opts.Attestation {
+			if err := d.writeAttestation(ctx, res, meta, started); err != nil {
+				return hooks.fail(ctx, nil, fmt.Errorf("writing %s: %w", AttestationFileName, err))
+			}
+		}
+		if d.opts.ModelCard {
+			d.saveModelCard(ctx, res)
+		}
+	}
+	d.dedupe.add(res.Jobs)
+	// The files are in place; a state that can't be updated only costs
+	// later quick checks.
+	if err := d.recordPull(ctx, res, downloaded); err != nil {
+		d.log.Warn("Failed to record pull in state", "error", err)
+	}
+	if err := d.updateLatestLink(res); err != nil {
+		return hooks.fail(ctx, nil, fmt.Errorf("updating %s: %w", d.opts.LatestLink, err))
+	}
+	if err := d.pruneVersions(ctx, res); err != nil {
+		d.log.Warn("Failed to remove old versions", "error", err)
+	}
+	return nil
+}
+
+// pullLayer downloads one layer, running the layer hooks around it.
+func (d *Downloader) pullLayer(ctx context.Context, job DownloadJob) error {
+	hooks := &d.opts.Hooks
+	if hooks.OnLayerStart != nil {
+		if err := hooks.OnLayerStart(ctx, job); err != nil {
+			if errors.Is(err, ErrSkipLayer) {
+				return err
+			}
+			return hooks.fail(ctx, &job, err)
+		}
+	}
+	if err := d.downloadBlob(ctx, job); err != nil {
+		return hooks.fail(ctx, &job, err)
+	}
+	if err := checkGGUF(ctx, d.opts.Store, job); err != nil {
+		return hooks.fail(ctx, &job, err)
+	}
+	if hooks.OnLayerDone != nil {
+		hooks.OnLayerDone(ctx, job)
+	}
+	return nil
+}
+
+// VerifyResult is the outcome of checking one downloaded file.
+type VerifyResult struct {
+	Path   string
+	Digest string
+	// Skipped is set for files that can't be checked against the digest,
+	// such as layers that were decompressed on download.
+	Skipped bool
+	// Trusted is set for files VerifyQuick took as good without reading
+	// them.
+	Trusted bool
+	Err     error
+}
+
+// Verify resolves ref and checks each file under destDir in the store
+// against the digest recorded in the manifest.
+func (d *Downloader) Verify(ctx context.Context, ref Reference, destDir string) ([]VerifyResult, error) {
+	return d.verify(ctx, ref, destDir, false)
+}
+
+// VerifyQuick is like Verify, but takes files as good without reading them
+// if Options.State has them checked before and their size and modification
+// time haven't changed since. That includes layers decompressed on
+// download, which were checked as they were downloaded.
+func (d *Downloader) VerifyQuick(ctx context.Context, ref Reference, destDir string) ([]VerifyResult, error) {
+	return d.verify(ctx, ref, destDir, true)
+}
+
+func (d *Downloader) verify(ctx context.Context, ref Reference, destDir string, quick bool) ([]VerifyResult, error) {
+	res, err := d.Resolve(ctx, ref, destDir)
+	if err != nil {
+		return nil, err
+	}
+	var trusted map[string]bool
+	if quick {
+		if trusted, err = d.unchangedFiles(res); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := d.verifyFiles(ctx, res.Jobs, trusted, nil)
+	if err != nil {
+		return results, err
+	}
+	if err := d.recordVerify(ctx, res, results); err != nil {
+		d.log.Warn("Failed to record verification in state", "error", err)
+	}
+	return results, nil
+}
+
+// VerifyOffline checks the files of the model pulled into destDir without
+// contacting the registry, against the manifest the pull saved. If the pull
+// also wrote a ChecksumsFileName, the files it lists are checked against
+// that instead, which covers layers stored decompressed and the manifest
+// itself.
+func (d *Downloader) VerifyOffline(ctx context.Context, destDir string) ([]VerifyResult, error) {
+	res, err := d.savedResolution(ctx, destDir)
+	if err != nil {
+		return nil, err
+	}
+	jobs := res.Jobs
+	dir := path.Clean(filepath.ToSlash(destDir))
+
+	sums := make(map[string]string) // digest by file
+	data, found, err := readStored(ctx, d.opts.Store, path.Join(dir, ChecksumsFileName))
+	if err != nil {
+		return nil, err
+	} else if found {
+		listed, err := parseChecksums(data)
+		if err != nil {
+			return nil, err
+		}
+		for name, sum := range listed {
+			sums[path.Join(dir, name)] = "sha256:" + sum
+		}
+	}
+
+	results, err := d.verifyFiles(ctx, jobs, nil, sums)
+	if err != nil {
+		return results, err
+	}
+	// The other files listed, such as the manifest.
+	for _, job := range jobs {
+		delete(sums, job.DestPath)
+	}
+	others := make([]string, 0, len(sums))
+	for name := range sums {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		results = append(results, VerifyResult{
+			Path:   name,
+			Digest: sums[name],
+			Err:    checkStored(ctx, d.opts.Store, name, sums[name], nil),
+		})
+	}
+
+	if err := d.recordVerify(ctx, res, results); err != nil {
+		d.log.Warn("Failed to record verification in state", "error", err)
+	}
+	return results, nil
+}
+
+// verifyFiles checks the files of jobs. Files in trusted are taken as good
+// without reading them. Files with a digest in sums are checked against it
+// rather than their layer's, so layers stored decompressed can be checked
+// too. Up to Options.VerifyConcurrency files are hashed at once, and the
+// results come back in the order of jobs.
+func (d *Downloader) verifyFiles(ctx context.Context, jobs []DownloadJob, trusted map[string]bool, sums map[string]string) ([]VerifyResult, error) {
+	results := make([]VerifyResult, len(jobs))
+	var hash []int
+	var total int64
+	for i, job := range jobs {
+		result := &results[i]
+		*result = VerifyResult{Path: job.DestPath, Digest: job.Layer.Digest}
+		sum, listed := sums[job.DestPath]
+		switch {
+		case trusted[job.DestPath]:
+			result.Trusted = true
+			continue
+		case listed:
+			result.Digest = sum
+		case job.compression() != "":
+			result.Skipped = true
+			if exists, err := d.opts.Store.Exists(ctx, job.DestPath); err != nil {
+				result.Err = err
+			} else if !exists {
+				result.Err = fmt.Errorf("%s does not exist", job.DestPath)
+			}
+			continue
+		}
+		hash = append(hash, i)
+		total += d.storedSize(job.DestPath, job.Size)
+	}
+
+	var count func(int64)
+	if report := d.opts.VerifyProgress; report != nil {
+		var mu sync.Mutex
+		var hashed int64
+		report(0, total)
+		count = func(n int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			hashed += n
+			report(hashed, total)
+		}
+	}
+	workers := d.opts.VerifyConcurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, i := range hash {
+		wg.Add(1)
+		go func(result *VerifyResult) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() == nil {
+				result.Err = checkStored(ctx, d.opts.Store, result.Path, result.Digest, count)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// storedSize returns the size of the stored file name, or size if the
+// store can't tell.
+func (d *Downloader) storedSize(name string, size int64) int64 {
+	if fs, ok := d.opts.Store.(*FileStore); ok {
+		if info, err := os.Stat(fs.Path(name)); err == nil {
+			return info.Size()
+		}
+	}
+	return size
+}
+
+// checkStored checks the stored file name against digest. count, if not
+// nil, is told how many bytes were read as they are.
+func checkStored(ctx context.Context, store BlobStore, name, digest string, count func(int64)) error {
+	opener, ok := store.(BlobOpener)
+	if !ok {
+		return errors.New("store cannot read back downloaded files")
+	}
+	rc, err := opener.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if count != nil {
+		r = countingReader{rc, count}
+	}
+	got, err := readerDigest(ctx, r)
+	if err != nil {
+		return err
+	}
+	if got != digest {
+		return fmt.Errorf("%w: got %s", ErrDigestMismatch, got)
+	}
+	return nil
+}
+
+// countingReader passes the length of every read to count.
+type countingReader struct {
+	r     io.Reader
+	count func(int64)
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.count(int64(n))
+	}
+	return n, err
+}
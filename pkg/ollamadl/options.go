@@ -0,0 +1,148 @@
+package ollamadl
+
+import (
+	"crypto"
+	"time"
+)
+
+// Option configures a Downloader. Besides the With functions, an Options
+// value is itself an Option that sets every field at once, so
+// New(Options{...}) works as it always has; combined with With options it
+// must come first, as it overwrites what earlier options set.
+type Option interface {
+	apply(*Options)
+}
+
+type optionFunc func(*Options)
+
+func (f optionFunc) apply(o *Options) { f(o) }
+
+func (o Options) apply(dst *Options) { *dst = o }
+
+// WithRegistry sets the registry base URL; see Options.Registry.
+func WithRegistry(registry string) Option {
+	return optionFunc(func(o *Options) { o.Registry = registry })
+}
+
+// WithConcurrency downloads at most n layers at the same time; see
+// Options.Concurrency.
+func WithConcurrency(n int) Option {
+	return optionFunc(func(o *Options) { o.Concurrency = n })
+}
+
+// WithVerifyConcurrency hashes at most n files at the same time when
+// verifying; see Options.VerifyConcurrency.
+func WithVerifyConcurrency(n int) Option {
+	return optionFunc(func(o *Options) { o.VerifyConcurrency = n })
+}
+
+// WithRateLimit caps the combined download rate at bytesPerSecond.
+func WithRateLimit(bytesPerSecond int64) Option {
+	return optionFunc(func(o *Options) { o.RateLimit = bytesPerSecond })
+}
+
+// WithRateSchedule limits the download rate by time of day; see
+// Options.RateSchedule.
+func WithRateSchedule(windows ...RateWindow) Option {
+	return optionFunc(func(o *Options) { o.RateSchedule = windows })
+}
+
+// WithAuth authenticates registry requests with creds.
+func WithAuth(creds Credentials) Option {
+	return optionFunc(func(o *Options) { o.Auth = &creds })
+}
+
+// WithTokenURL fetches bearer tokens from tokenURL; see Options.TokenURL.
+func WithTokenURL(tokenURL string) Option {
+	return optionFunc(func(o *Options) { o.TokenURL = tokenURL })
+}
+
+// WithRepositoryPrefix puts prefix in front of repository names on the
+// registry; see Options.RepositoryPrefix.
+func WithRepositoryPrefix(prefix string) Option {
+	return optionFunc(func(o *Options) { o.RepositoryPrefix = prefix })
+}
+
+// WithHuggingFaceToken authenticates pulls of hf.co models with token; see
+// Options.HuggingFaceToken.
+func WithHuggingFaceToken(token string) Option {
+	return optionFunc(func(o *Options) { o.HuggingFaceToken = token })
+}
+
+// WithWebseeds fetches blobs from seeds before the registry; see
+// Options.Webseeds.
+func WithWebseeds(seeds ...string) Option {
+	return optionFunc(func(o *Options) { o.Webseeds = append(o.Webseeds, seeds...) })
+}
+
+// WithIPFS fetches the blobs cids maps to IPFS CIDs through gateway before
+// the registry; see Options.IPFSCIDs.
+func WithIPFS(gateway string, cids map[string]string) Option {
+	return optionFunc(func(o *Options) { o.IPFSGateway, o.IPFSCIDs = gateway, cids })
+}
+
+// WithSignaturePolicy only lets models whose manifests are signed as
+// policy requires be pulled; see Options.SignaturePolicy.
+func WithSignaturePolicy(policy SignaturePolicy) Option {
+	return optionFunc(func(o *Options) { o.SignaturePolicy = &policy })
+}
+
+// WithAttestation writes a provenance attestation with every pull, signed
+// by key unless it is nil; see Options.Attestation.
+func WithAttestation(key crypto.Signer) Option {
+	return optionFunc(func(o *Options) { o.Attestation, o.AttestationKey = true, key })
+}
+
+// WithPolicy only lets models policy allows be pulled; see Options.Policy.
+func WithPolicy(policy ModelPolicy) Option {
+	return optionFunc(func(o *Options) { o.Policy = &policy })
+}
+
+// WithOllamaModels reuses the blobs of the local Ollama installation with
+// models directory dir; see Options.OllamaModels.
+func WithOllamaModels(dir string) Option {
+	return optionFunc(func(o *Options) { o.OllamaModels = dir })
+}
+
+// WithLatestLink points the symbolic link name at the directory of each
+// successful pull; see Options.LatestLink.
+func WithLatestLink(name string) Option {
+	return optionFunc(func(o *Options) { o.LatestLink = name })
+}
+
+// WithVersionedDirs puts each pull in a subdirectory named after its
+// manifest digest; see Options.VersionedDirs.
+func WithVersionedDirs() Option {
+	return optionFunc(func(o *Options) { o.VersionedDirs = true })
+}
+
+// WithStaleTempAge starts partial downloads older than age over; see
+// Options.StaleTempAge.
+func WithStaleTempAge(age time.Duration) Option {
+	return optionFunc(func(o *Options) { o.StaleTempAge = age })
+}
+
+// WithManifestCache keeps fetched manifests in dir, using them for up to
+// maxStale while the registry can't be reached; see Options.ManifestCache.
+func WithManifestCache(dir string, maxStale time.Duration) Option {
+	return optionFunc(func(o *Options) {
+		o.ManifestCache = dir
+		o.ManifestCacheMaxStale = maxStale
+	})
+}
+
+// WithAudit records every pull with auditor; see Options.Audit.
+func WithAudit(auditor Auditor) Option {
+	return optionFunc(func(o *Options) { o.Audit = auditor })
+}
+
+// WithStore sets where downloaded files go; see Options.Store.
+func WithStore(store BlobStore) Option {
+	return optionFunc(func(o *Options) { o.Store = store })
+}
+
+// WithRegistryClient replaces HTTP registry access with reg; see
+// Options.RegistryClient.
+func WithRegistryClient(reg Registry) Option {
+	return optionFunc(func(o *Options) { o.RegistryClient = reg })
+}
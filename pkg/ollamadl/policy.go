@@ -0,0 +1,160 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxPolicyLicense bounds the license layers Options.Policy reads, and
+// their text.
+const maxPolicyLicense = 1 << 20
+
+// ModelPolicy decides which models may be pulled, for Options.Policy.
+type ModelPolicy struct {
+	// Allow, when not empty, only lets models matching one of its patterns
+	// be pulled; models matching a Deny pattern never are. Patterns are
+	// path.Match globs over the full reference, e.g. "library/llama3*:*";
+	// a pattern without a tag matches every tag, so "library/*" allows
+	// every official model. As "*" doesn't match "/", "*/*" matches every
+	// model of the configured registry with a namespace, but no deeper
+	// name. Models on another registry, such as hf.co/org/repo, are
+	// matched with their host, so only patterns like "hf.co/*/*" match
+	// them, while a name starting with the configured registry's host is
+	// matched without it, as the same model.
+	Allow []string
+	Deny  []string
+	// MaxSize, when positive, blocks models whose layers and config come
+	// to more bytes.
+	MaxSize int64
+	// AllowLicenses, when not empty, only lets models be pulled if the
+	// text of one of their license layers matches one of the expressions;
+	// models without a license are blocked then. Models with a license
+	// matching a DenyLicenses expression are always blocked.
+	AllowLicenses []*regexp.Regexp
+	DenyLicenses  []*regexp.Regexp
+}
+
+// checkPolicyName checks ref against the name patterns of Options.Policy,
+// before anything is fetched.
+func (d *Downloader) checkPolicyName(ref Reference) error {
+	policy := d.opts.Policy
+	name := d.policyName(ref)
+	for _, pattern := range policy.Deny {
+		if matchModelPattern(pattern, name) {
+			return fmt.Errorf("%w: %s matches %q, which is denied", ErrPolicy, ref, pattern)
+		}
+	}
+	if len(policy.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range policy.Allow {
+		if matchModelPattern(pattern, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not on the allowlist", ErrPolicy, ref)
+}
+
+// policyName returns ref as policy patterns see it. A name starting with
+// the configured registry's host, such as registry.ollama.ai/llama3, loses
+// the host and gets the library namespace as it would without it; names
+// on other registries keep theirs.
+func (d *Downloader) policyName(ref Reference) Reference {
+	host := ref.Host()
+	if host == "" {
+		return ref
+	}
+	if u, err := url.Parse(d.opts.Registry); err != nil || !strings.EqualFold(u.Host, host) {
+		return ref
+	}
+	ref.Name = ref.Name[len(host)+1:]
+	if !strings.Contains(ref.Name, "/") {
+		ref.Name = "library/" + ref.Name
+	}
+	return ref
+}
+
+// matchModelPattern reports whether ref matches a policy pattern. Patterns
+// that don't parse match nothing.
+func matchModelPattern(pattern string, ref Reference) bool {
+	name := ref.String()
+	if !strings.Contains(path.Base(pattern), ":") {
+		name = ref.Name
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// checkPolicyManifest checks the size and licenses of ref's manifest
+// against Options.Policy, fetching its license layers if there are
+// license patterns.
+func (d *Downloader) checkPolicyManifest(ctx context.Context, ref Reference, manifest *Manifest) error {
+	policy := d.opts.Policy
+	if policy.MaxSize > 0 {
+		size := manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+		if size > policy.MaxSize {
+			return fmt.Errorf("%w: %s is %d bytes, over the limit of %d", ErrPolicy, ref, size, policy.MaxSize)
+		}
+	}
+	if len(policy.AllowLicenses) == 0 && len(policy.DenyLicenses) == 0 {
+		return nil
+	}
+
+	allowed := len(policy.AllowLicenses) == 0
+	for _, layer := range manifest.Layers {
+		if baseMediaType(layer.MediaType) != LicenseMediaType {
+			continue
+		}
+		text, err := d.fetchLicense(ctx, ref, layer)
+		if err != nil {
+			return fmt.Errorf("fetching license %s: %w", layer.Digest, err)
+		}
+		for _, re := range policy.DenyLicenses {
+			if re.Match(text) {
+				return fmt.Errorf("%w: license of %s matches %q, which is denied", ErrPolicy, ref, re)
+			}
+		}
+		for _, re := range policy.AllowLicenses {
+			allowed = allowed || re.Match(text)
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s has no allowed license", ErrPolicy, ref)
+	}
+	return nil
+}
+
+// fetchLicense returns the text of a license layer, decompressed, once its
+// digest has been checked, so a mirror can't pass off another license.
+func (d *Downloader) fetchLicense(ctx context.Context, ref Reference, layer Layer) ([]byte, error) {
+	if layer.Size > maxPolicyLicense {
+		return nil, fmt.Errorf("license of %d bytes", layer.Size)
+	}
+	body, _, err := d.registry.GetBlob(ctx, ref, layer.Digest, 0)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxPolicyLicense))
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if got := sha256Digest(data); got != layer.Digest {
+		return nil, fmt.Errorf("%w: got %s", ErrDigestMismatch, got)
+	}
+	r, err := decompress(bytes.NewReader(data), layerCompression(layer.MediaType))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, maxPolicyLicense))
+}
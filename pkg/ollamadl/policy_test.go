@@ -0,0 +1,92 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+)
+
+func TestCheckPolicyName(t *testing.T) {
+	tests := []struct {
+		ref     string
+		policy  ModelPolicy
+		blocked bool
+	}{
+		{"llama3.2", ModelPolicy{Deny: []string{"library/llama*"}}, true},
+		{"registry.ollama.ai/library/llama3.2", ModelPolicy{Deny: []string{"library/llama*"}}, true},
+		{"Registry.Ollama.AI/llama3.2:1b", ModelPolicy{Deny: []string{"library/llama*:1b"}}, true},
+		{"registry.ollama.ai/library/qwen2", ModelPolicy{Allow: []string{"library/*"}}, false},
+		{"registry.example.com/library/qwen2", ModelPolicy{Allow: []string{"library/*"}}, true},
+		{"hf.co/org/repo", ModelPolicy{Deny: []string{"*/*"}}, false},
+		{"hf.co/org/repo", ModelPolicy{Allow: []string{"*/*"}}, true},
+		{"hf.co/org/repo", ModelPolicy{Deny: []string{"hf.co/*/*"}}, true},
+		{"hf.co/org/repo:Q4_K_M", ModelPolicy{Allow: []string{"hf.co/org/*:Q4_K_M"}}, false},
+		{"acme/team/model", ModelPolicy{Allow: []string{"acme/*"}}, true},
+		{"acme/team/model", ModelPolicy{Allow: []string{"acme/*/*"}}, false},
+	}
+	for _, tt := range tests {
+		d, err := New(Options{Policy: &tt.policy}, WithRegistryClient(NewMemoryRegistry()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := ParseReference(tt.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = d.checkPolicyName(ref)
+		if blocked := errors.Is(err, ErrPolicy); blocked != tt.blocked {
+			t.Errorf("%s with %+v: checkPolicyName() = %v, want blocked %v", tt.ref, tt.policy, err, tt.blocked)
+		}
+	}
+}
+
+// tamperedRegistry serves other content for one blob than its digest
+// promises, as a compromised mirror could.
+type tamperedRegistry struct {
+	*MemoryRegistry
+	digest string
+	data   []byte
+}
+
+func (r *tamperedRegistry) GetBlob(ctx context.Context, ref Reference, digest string, offset int64) (io.ReadCloser, int64, error) {
+	if digest == r.digest {
+		return io.NopCloser(bytes.NewReader(r.data[offset:])), offset, nil
+	}
+	return r.MemoryRegistry.GetBlob(ctx, ref, digest, offset)
+}
+
+func TestCheckPolicyLicense(t *testing.T) {
+	reg := NewMemoryRegistry()
+	ref, err := ParseReference("test/model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	license := reg.AddBlob(LicenseMediaType, []byte("Non-commercial use only"))
+	reg.AddManifest(ref, config, license)
+	manifest, err := reg.GetManifest(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &ModelPolicy{AllowLicenses: []*regexp.Regexp{regexp.MustCompile("MIT")}}
+
+	d, err := New(Options{Policy: policy}, WithRegistryClient(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.checkPolicyManifest(context.Background(), ref, manifest); !errors.Is(err, ErrPolicy) {
+		t.Errorf("checkPolicyManifest() = %v, want ErrPolicy", err)
+	}
+
+	tampered := &tamperedRegistry{MemoryRegistry: reg, digest: license.Digest, data: []byte("MIT License")}
+	d, err = New(Options{Policy: policy}, WithRegistryClient(tampered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.checkPolicyManifest(context.Background(), ref, manifest); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("checkPolicyManifest() with another license served = %v, want ErrDigestMismatch", err)
+	}
+}
@@ -0,0 +1,34 @@
+package ollamadl
+
+// ProgressReporter receives progress events while layers download, so
+// applications can render their own UI. Methods are called concurrently from
+// the goroutines downloading different layers and must not block for long.
+type ProgressReporter interface {
+	// LayerStarted is called at the start of each download attempt; offset
+	// is the number of bytes resumed from an earlier partial download.
+	LayerStarted(job DownloadJob, offset int64)
+	// BytesWritten reports n more bytes of the layer received.
+	BytesWritten(job DownloadJob, n int64)
+	// LayerCompleted is called once the layer is verified and in place.
+	LayerCompleted(job DownloadJob)
+	// LayerFailed is called when the layer is given up on.
+	LayerFailed(job DownloadJob, err error)
+}
+
+type nopReporter struct{}
+
+func (nopReporter) LayerStarted(DownloadJob, int64) {}
+func (nopReporter) BytesWritten(DownloadJob, int64) {}
+func (nopReporter) LayerCompleted(DownloadJob)      {}
+func (nopReporter) LayerFailed(DownloadJob, error)  {}
+
+// progressWriter forwards the size of each write to a ProgressReporter.
+type progressWriter struct {
+	job      DownloadJob
+	reporter ProgressReporter
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	w.reporter.BytesWritten(w.job, int64(len(p)))
+	return len(p), nil
+}
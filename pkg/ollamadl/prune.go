@@ -0,0 +1,149 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// layerFileHash returns the short hash of the layer a file named name holds,
+// or "" unless name is one planJobs gives layer files: a file template's
+// prefix and suffix around the hash, possibly with the part number of a
+// split model. Other files in a model's directory are the user's.
+func (d *Downloader) layerFileHash(name string) string {
+	match := d.layerNames.FindStringSubmatch(name)
+	for i := 1; i < len(match); i++ {
+		if match[i] != "" {
+			return match[i]
+		}
+	}
+	return ""
+}
+
+// PruneResult describes what a Prune removed.
+type PruneResult struct {
+	// Removed are the files deleted, or that would be in a dry run.
+	Removed []string
+	// Bytes is the combined size of the removed files. Files hard linked
+	// elsewhere, as by Mirror, only free their space with the last link.
+	Bytes int64
+}
+
+// Prune deletes layer files under root whose layers are no longer in the
+// manifest saved in their directory, such as those a Sync left behind after
+// a model changed. Only files named as Pull names layer files are
+// considered, and directories without a saved manifest are left alone.
+// With dryRun set nothing is deleted. Prune needs a FileStore.
+func (d *Downloader) Prune(ctx context.Context, root string, dryRun bool) (*PruneResult, error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil, errors.New("pruning needs a local directory")
+	}
+
+	result := &PruneResult{}
+	err := walkSaved(ctx, store, root, func(dir string, manifest *Manifest) error {
+		return d.pruneDir(ctx, store, dir, manifest, dryRun, result)
+	})
+	if err != nil {
+		return result, err
+	}
+	sort.Strings(result.Removed)
+	return result, nil
+}
+
+// walkSaved calls fn for each directory under root, within store, that has
+// a manifest saved by a pull.
+func walkSaved(ctx context.Context, store *FileStore, root string, fn func(dir string, manifest *Manifest) error) error {
+	top := store.Path(root)
+	return filepath.WalkDir(top, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(top, p)
+		if err != nil {
+			return err
+		}
+		dir := path.Join(filepath.ToSlash(root), filepath.ToSlash(rel))
+		manifest, err := readSavedManifest(ctx, store, dir)
+		if err != nil || manifest == nil {
+			return err
+		}
+		return fn(dir, manifest)
+	})
+}
+
+// pruneDir prunes the files directly in the store directory dir, which
+// has manifest saved.
+func (d *Downloader) pruneDir(ctx context.Context, store *FileStore, dir string, manifest *Manifest, dryRun bool, result *PruneResult) error {
+	files, err := d.layerFiles(store, dir, manifest)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.referenced {
+			continue
+		}
+		if !dryRun {
+			if err := store.Remove(ctx, file.name); err != nil {
+				return err
+			}
+			d.log.Info("Removed", "path", file.name)
+		}
+		result.Removed = append(result.Removed, file.name)
+		result.Bytes += file.size
+	}
+	return nil
+}
+
+// layerFile is a file named as Pull names layer files.
+type layerFile struct {
+	name string
+	size int64
+	// referenced is set if the layer is in the directory's manifest.
+	referenced bool
+}
+
+// layerFiles returns the layer files directly in the store directory dir,
+// which has manifest saved. Partial downloads are left out.
+func (d *Downloader) layerFiles(store *FileStore, dir string, manifest *Manifest) ([]layerFile, error) {
+	// Going by hash rather than file name keeps files of layers that
+	// options would skip or name differently.
+	referenced := make(map[string]bool)
+	for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+		if hash, err := getShortHash(layer); err == nil {
+			referenced[hash] = true
+		}
+	}
+
+	entries, err := os.ReadDir(store.Path(dir))
+	if err != nil {
+		return nil, err
+	}
+	var files []layerFile
+	for _, entry := range entries {
+		hash := d.layerFileHash(entry.Name())
+		if !entry.Type().IsRegular() || hash == "" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, layerFile{
+			name:       path.Join(dir, entry.Name()),
+			size:       info.Size(),
+			referenced: referenced[hash],
+		})
+	}
+	return files, nil
+}
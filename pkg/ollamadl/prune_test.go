@@ -0,0 +1,77 @@
+package ollamadl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPruneKeepsUnrelatedFiles(t *testing.T) {
+	ctx := context.Background()
+	d, reg, root := newTestDownloader(t)
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	oldModel := reg.AddBlob(ModelMediaType, testGGUF("old weights"))
+	reg.AddManifest(ref, config, oldModel)
+	if _, err := d.Pull(ctx, ref, "m"); err != nil {
+		t.Fatal(err)
+	}
+	newModel := reg.AddBlob(ModelMediaType, testGGUF("new weights"))
+	reg.AddManifest(ref, config, newModel)
+	if _, err := d.Pull(ctx, ref, "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	unrelated := []string{"backup-202410161230.tar", "notes-0123456789ab.txt", "0123456789ab", "model-0123456789ab.gguf.bak"}
+	for _, name := range unrelated {
+		if err := os.WriteFile(filepath.Join(root, "m", name), []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldFile, _ := layerFileName(oldModel, defaultFileTemplates[ModelMediaType])
+	newFile, _ := layerFileName(newModel, defaultFileTemplates[ModelMediaType])
+	result, err := d.Prune(ctx, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"m/" + oldFile}; !reflect.DeepEqual(result.Removed, want) {
+		t.Errorf("Removed = %v, want %v", result.Removed, want)
+	}
+	for _, name := range append(unrelated, newFile) {
+		if _, err := os.Stat(filepath.Join(root, "m", name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestLayerFileHash(t *testing.T) {
+	d, err := New(Options{FileTemplates: map[string]string{ModelMediaType: "weights.%s.bin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"model-0123456789ab.gguf":                "0123456789ab",
+		"weights.0123456789ab.bin":               "0123456789ab",
+		"config-0123456789ab.json":               "0123456789ab",
+		"layer-0123456789ab.bin":                 "0123456789ab",
+		"model-0123456789ab-00002-of-00003.gguf": "0123456789ab",
+		"model-0123456789ab.gguf.tmp":            "",
+		"model-0123456789abc.gguf":               "",
+		"model-0123456789AB.gguf":                "",
+		"backup-202410161230.tar":                "",
+		"my-model-0123456789ab.gguf":             "",
+		"README.md":                              "",
+	}
+	for name, want := range tests {
+		if got := d.layerFileHash(name); got != want {
+			t.Errorf("layerFileHash(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
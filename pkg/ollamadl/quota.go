@@ -0,0 +1,167 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// EvictionPolicy decides which models Options.MaxStoreSize removes first.
+type EvictionPolicy string
+
+const (
+	// EvictLRU removes the models least recently used first, going by
+	// when their files were last read or the model was last pulled.
+	// Filesystems mounted with noatime only record the latter.
+	EvictLRU EvictionPolicy = "lru"
+	// EvictOldest removes the models pulled longest ago first.
+	EvictOldest EvictionPolicy = "oldest"
+	// EvictLargest removes the largest models first, so as few as
+	// possible go.
+	EvictLargest EvictionPolicy = "largest"
+)
+
+// valid reports whether p is a known policy or empty.
+func (p EvictionPolicy) valid() bool {
+	switch p {
+	case "", EvictLRU, EvictOldest, EvictLargest:
+		return true
+	}
+	return false
+}
+
+// evictionCandidate is a model that may be removed to make room.
+type evictionCandidate struct {
+	LocalModel
+	lastUsed time.Time
+}
+
+// makeRoom removes models under the store root, as Options.MaxStoreSize
+// describes, until the files res still has to download fit.
+func (d *Downloader) makeRoom(ctx context.Context, res *Resolution) error {
+	if d.opts.MaxStoreSize <= 0 {
+		return nil
+	}
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return errors.New("a store size limit needs a local directory")
+	}
+	destDir := path.Clean(filepath.ToSlash(res.DestDir))
+	root := d.opts.StoreRoot
+	if root == "" {
+		root = path.Dir(destDir)
+	}
+
+	var needed int64
+	for _, job := range res.Jobs {
+		if exists, err := store.Exists(ctx, job.DestPath); err != nil {
+			return err
+		} else if !exists {
+			needed += job.Size
+		}
+	}
+	models, err := d.List(ctx, root)
+	if err != nil {
+		return err
+	}
+	var used int64
+	for _, model := range models {
+		used += model.Size
+	}
+	if used+needed <= d.opts.MaxStoreSize {
+		return nil
+	}
+
+	candidates, err := d.evictionCandidates(store, models, destDir)
+	if err != nil {
+		return err
+	}
+	// Don't evict anything for a pull that won't fit anyway.
+	evictable := int64(0)
+	for _, model := range candidates {
+		evictable += model.Size
+	}
+	if used-evictable+needed > d.opts.MaxStoreSize {
+		return fmt.Errorf("%w: %d bytes to download, %d of %d in use, %d of it evictable", ErrStoreFull, needed, used, d.opts.MaxStoreSize, evictable)
+	}
+	for _, model := range candidates {
+		if used+needed <= d.opts.MaxStoreSize {
+			break
+		}
+		unlock, err := tryLock(store.Path(path.Join(model.Dir, LockFileName)))
+		if err != nil {
+			return err
+		} else if unlock == nil {
+			d.log.Debug("Not evicting a model in use", "dir", model.Dir)
+			continue
+		}
+		d.log.Info("Evicting to stay within the store size limit", "dir", model.Dir, "model", model.Ref, "size", model.Size)
+		if _, err := d.removeModel(ctx, store, root, model.Dir, false, unlock); err != nil {
+			unlock()
+			return fmt.Errorf("evicting %s: %w", model.Dir, err)
+		}
+		used -= model.Size
+	}
+	if used+needed > d.opts.MaxStoreSize {
+		return fmt.Errorf("%w: %d bytes to download, %d of %d in use", ErrStoreFull, needed, used, d.opts.MaxStoreSize)
+	}
+	return nil
+}
+
+// evictionCandidates returns the models that may be evicted, other than the
+// one in destDir and pinned ones, in the order they go.
+func (d *Downloader) evictionCandidates(store *FileStore, models []LocalModel, destDir string) ([]evictionCandidate, error) {
+	pinned := d.pinned()
+	var candidates []evictionCandidate
+	for _, model := range models {
+		if model.Dir == destDir || pinned[model.Dir] || (model.Ref != Reference{} && pinned[model.Ref.String()]) {
+			continue
+		}
+		candidate := evictionCandidate{LocalModel: model, lastUsed: model.Pulled}
+		if d.opts.Eviction == "" || d.opts.Eviction == EvictLRU {
+			files, err := d.layerFiles(store, model.Dir, model.Manifest)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				info, err := os.Stat(store.Path(file.name))
+				if err != nil {
+					return nil, err
+				}
+				if used := accessTime(info); used.After(candidate.lastUsed) {
+					candidate.lastUsed = used
+				}
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch d.opts.Eviction {
+		case EvictOldest:
+			return a.Pulled.Before(b.Pulled)
+		case EvictLargest:
+			return a.Size > b.Size
+		}
+		return a.lastUsed.Before(b.lastUsed)
+	})
+	return candidates, nil
+}
+
+// pinned returns Options.Pinned as a set of directories and references.
+func (d *Downloader) pinned() map[string]bool {
+	pinned := make(map[string]bool)
+	for _, pin := range d.opts.Pinned {
+		pinned[path.Clean(filepath.ToSlash(pin))] = true
+		if ref, err := ParseReference(pin); err == nil {
+			pinned[ref.String()] = true
+		}
+	}
+	return pinned
+}
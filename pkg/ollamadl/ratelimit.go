@@ -0,0 +1,124 @@
+package ollamadl
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateWindow is a daily period with a rate limit of its own, for
+// Options.RateSchedule.
+type RateWindow struct {
+	// Start and End are local times of day, as durations since midnight.
+	// A window whose End isn't after its Start runs past midnight.
+	Start, End time.Duration
+	// Rate is the limit in bytes per second during the window; zero means
+	// none.
+	Rate int64
+}
+
+// contains reports whether the time of day t falls in the window.
+func (w RateWindow) contains(t time.Duration) bool {
+	if w.Start < w.End {
+		return w.Start <= t && t < w.End
+	}
+	return t >= w.Start || t < w.End
+}
+
+// rateLimiter is a token bucket shared by all downloads of a Downloader, so
+// the limit applies to their combined rate.
+type rateLimiter struct {
+	rate     int64 // bytes per second, outside the windows
+	schedule []RateWindow
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64, schedule []RateWindow) *rateLimiter {
+	if bytesPerSecond <= 0 && len(schedule) == 0 {
+		return nil
+	}
+	return &rateLimiter{rate: bytesPerSecond, schedule: schedule, last: time.Now()}
+}
+
+// rateAt returns the limit at now: that of the first window now falls in,
+// or else the default. Zero means no limit.
+func (l *rateLimiter) rateAt(now time.Time) int64 {
+	if len(l.schedule) == 0 {
+		return l.rate
+	}
+	h, m, sec := now.Clock()
+	t := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	for _, w := range l.schedule {
+		if w.contains(t) {
+			return w.Rate
+		}
+	}
+	return l.rate
+}
+
+// burst is the most a single read may take at once at rate: a tenth of a
+// second's worth, so the rate stays smooth.
+func burst(rate int64) int {
+	return max(int(rate/10), 1)
+}
+
+// wait blocks until n bytes may be consumed. The bucket can go into debt,
+// which later callers pay off.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	rate := l.rateAt(now)
+	if rate <= 0 {
+		l.tokens, l.last = 0, now
+		l.mu.Unlock()
+		return nil
+	}
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(rate), float64(burst(rate)))
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(deficit / float64(rate) * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader limits reads from r. A nil limiter returns r unchanged.
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if rate := r.l.rateAt(time.Now()); rate > 0 && len(p) > burst(rate) {
+		p = p[:burst(rate)]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.wait(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,343 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Registry is the registry access a Downloader needs. The default
+// implementation talks to a registry over HTTP; MemoryRegistry serves
+// models from memory, for tests.
+type Registry interface {
+	// GetManifest returns the manifest of ref, with its Digest set. A
+	// missing model or tag is reported as ErrManifestNotFound.
+	GetManifest(ctx context.Context, ref Reference) (*Manifest, error)
+	// GetBlob returns the content of a blob of ref's repository from offset
+	// on, if the registry can resume there, and the offset the content
+	// actually starts at: 0 when it can't.
+	GetBlob(ctx context.Context, ref Reference, digest string, offset int64) (io.ReadCloser, int64, error)
+	// ListTags returns the tags of ref's repository; ref's tag is ignored.
+	ListTags(ctx context.Context, ref Reference) ([]string, error)
+}
+
+// Cataloger is implemented by registries that can list their repositories.
+type Cataloger interface {
+	// Catalog returns the names of all repositories, e.g. library/llama3.
+	Catalog(ctx context.Context) ([]string, error)
+}
+
+// httpRegistry is a Registry reached over the Docker registry HTTP API.
+type httpRegistry struct {
+	client *http.Client
+	base   string
+	// prefix is Options.RepositoryPrefix, without slashes around it.
+	prefix string
+	// cache is Options.ManifestCache, or nil.
+	cache *manifestCache
+}
+
+// repoURL returns the base URL of ref's repository. A reference naming a
+// registry host, such as hf.co/org/repo, is reached on that host over HTTPS
+// rather than on the configured registry.
+func (r *httpRegistry) repoURL(ref Reference) string {
+	if host := ref.Host(); host != "" {
+		return fmt.Sprintf("https://%s/v2/%s", host, escapePath(strings.TrimPrefix(ref.Name, host+"/")))
+	}
+	if r.prefix != "" {
+		return fmt.Sprintf("%s/v2/%s/%s", r.base, escapePath(r.prefix), escapePath(ref.Name))
+	}
+	return fmt.Sprintf("%s/v2/%s", r.base, escapePath(ref.Name))
+}
+
+func (r *httpRegistry) blobURL(ref Reference, digest string) string {
+	return fmt.Sprintf("%s/blobs/%s", r.repoURL(ref), url.PathEscape(digest))
+}
+
+// GetManifest revalidates a manifest it has cached with If-None-Match, and
+// falls back on it while the registry can't be reached.
+func (r *httpRegistry) GetManifest(ctx context.Context, ref Reference) (*Manifest, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+
+	manifestURL := fmt.Sprintf("%s/manifests/%s", r.repoURL(ref), url.PathEscape(ref.Tag))
+	var cached *cachedManifest
+	if r.cache != nil {
+		cached = r.cache.get(manifestURL)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, "GET", manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// OCI manifests are asked for too, as signatures come in them.
+	req.Header.Set("Accept", ManifestMediaType+", "+ociManifestMediaType)
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return r.staleManifest(ctx, cached, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		// Refreshing the entry restarts the time it may be used offline.
+		r.cache.put(manifestURL, cached.ETag, cached.Data)
+		return decodeManifest(cached.Data)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrManifestNotFound, ref)
+	default:
+		err := fmt.Errorf("failed to get manifest: %w", &HTTPError{StatusCode: resp.StatusCode, URL: manifestURL})
+		if resp.StatusCode >= 500 {
+			return r.staleManifest(ctx, cached, err)
+		}
+		return nil, err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := decodeManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache != nil {
+		r.cache.put(manifestURL, resp.Header.Get("ETag"), data)
+	}
+	return manifest, nil
+}
+
+// staleManifest returns the cached manifest in place of the one the
+// registry failed to serve with err, if it is recent enough and the
+// caller hasn't given up.
+func (r *httpRegistry) staleManifest(ctx context.Context, cached *cachedManifest, err error) (*Manifest, error) {
+	if !r.cache.usable(cached) || ctx.Err() != nil {
+		return nil, err
+	}
+	r.cache.log.Warn("Registry unavailable, using cached manifest", "url", cached.URL, "fetched", cached.Fetched, "error", err)
+	return decodeManifest(cached.Data)
+}
+
+// decodeManifest decodes a manifest as served by a registry.
+func decodeManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	manifest.Digest = sha256Digest(data)
+	manifest.raw = data
+	return &manifest, nil
+}
+
+// GetBlob undoes any Content-Encoding the server applies, so the content is
+// the blob as stored. Resumed requests ask for no encoding, as range offsets
+// would otherwise count encoded bytes.
+func (r *httpRegistry) GetBlob(ctx context.Context, ref Reference, digest string, offset int64) (io.ReadCloser, int64, error) {
+	blobURL := r.blobURL(ref, digest)
+	req, err := http.NewRequestWithContext(ctx, "GET", blobURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Accept-Encoding", "identity")
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		req.Header.Set("Accept-Encoding", "gzip, zstd")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored any range request; the content starts at 0.
+		offset = 0
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+	default:
+		resp.Body.Close()
+		return nil, 0, &HTTPError{StatusCode: resp.StatusCode, URL: blobURL}
+	}
+
+	body, err := decompress(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		resp.Body.Close()
+		return nil, 0, err
+	}
+	return readCloser{body, resp.Body}, offset, nil
+}
+
+func (r *httpRegistry) ListTags(ctx context.Context, ref Reference) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+
+	tagsURL := r.repoURL(ref) + "/tags/list"
+	req, err := http.NewRequestWithContext(ctx, "GET", tagsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrManifestNotFound, ref.Name)
+	default:
+		return nil, fmt.Errorf("failed to list tags: %w", &HTTPError{StatusCode: resp.StatusCode, URL: tagsURL})
+	}
+
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list.Tags, nil
+}
+
+// referrers returns the digests of the manifests of ref's repository
+// referring to digest with the given artifact type, through the OCI
+// referrers API. Registries without it have none.
+func (r *httpRegistry) referrers(ctx context.Context, ref Reference, digest, artifactType string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+
+	referrersURL := fmt.Sprintf("%s/referrers/%s?artifactType=%s", r.repoURL(ref), url.PathEscape(digest), url.QueryEscape(artifactType))
+	req, err := http.NewRequestWithContext(ctx, "GET", referrersURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusBadRequest, http.StatusMethodNotAllowed:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("failed to list referrers: %w", &HTTPError{StatusCode: resp.StatusCode, URL: referrersURL})
+	}
+
+	var index struct {
+		Manifests []struct {
+			Digest       string `json:"digest"`
+			ArtifactType string `json:"artifactType"`
+		} `json:"manifests"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&index); err != nil {
+		return nil, err
+	}
+	var digests []string
+	for _, m := range index.Manifests {
+		// Registries may ignore the filter.
+		if m.ArtifactType == artifactType {
+			digests = append(digests, m.Digest)
+		}
+	}
+	return digests, nil
+}
+
+// Catalog lists repositories through /v2/_catalog, following the Link
+// headers of paginated responses. Registries that don't offer a catalog,
+// such as registry.ollama.ai, fail with ErrCatalogUnsupported.
+func (r *httpRegistry) Catalog(ctx context.Context) ([]string, error) {
+	var repos []string
+	pageURL := r.base + "/v2/_catalog"
+	for pageURL != "" {
+		page, next, err := r.catalogPage(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page...)
+		pageURL = next
+	}
+	return repos, nil
+}
+
+// catalogPage fetches one page of the catalog and returns the URL of the
+// next one, or "" if it was the last.
+func (r *httpRegistry) catalogPage(ctx context.Context, pageURL string) ([]string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, "", ErrCatalogUnsupported
+	default:
+		return nil, "", fmt.Errorf("failed to list repositories: %w", &HTTPError{StatusCode: resp.StatusCode, URL: pageURL})
+	}
+
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&catalog); err != nil {
+		return nil, "", err
+	}
+	repos := catalog.Repositories
+	if r.prefix != "" {
+		// Only the repositories under the prefix are reachable as models.
+		repos = repos[:0]
+		for _, repo := range catalog.Repositories {
+			if name, ok := strings.CutPrefix(repo, r.prefix+"/"); ok {
+				repos = append(repos, name)
+			}
+		}
+	}
+	return repos, nextLink(req.URL, resp.Header.Get("Link")), nil
+}
+
+// nextLink returns the rel="next" target of a Link header such as
+// `</v2/_catalog?last=b&n=100>; rel="next"`, resolved against base.
+func nextLink(base *url.URL, header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, _ := strings.Cut(link, ";")
+		target = strings.TrimSpace(target)
+		if !strings.Contains(params, `rel="next"`) || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		u, err := base.Parse(target[1 : len(target)-1])
+		if err != nil {
+			return ""
+		}
+		return u.String()
+	}
+	return ""
+}
+
+// readCloser reads from a decoder and closes both it and the underlying
+// body.
+type readCloser struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (rc readCloser) Close() error {
+	rc.ReadCloser.Close()
+	return rc.body.Close()
+}
@@ -0,0 +1,293 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sha256HexPattern matches the hex digest OCI layouts name blobs by.
+var sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// RemoveResult describes what RemoveModel did.
+type RemoveResult struct {
+	// Removed are the files deleted, or that would be in a dry run.
+	Removed []string
+	// Moved maps files other models link to, which were moved to the
+	// first of those links instead of deleted, to their new names.
+	Moved map[string]string
+	// Bytes is the combined size of the removed files. Files hard linked
+	// elsewhere only free their space with the last link.
+	Bytes int64
+}
+
+// RemoveModel deletes the model pulled into dir: its layer files, including
+// partial and stale ones, the blobs of an OCI layout, and the files Pull
+// writes next to them, such as the manifest, README.md and attestation.
+// Other files are left alone, and dir is removed only if that leaves it
+// empty.
+//
+// Files that models elsewhere under root link to symbolically, as with
+// Options.DedupeSymlinks, are moved to one of those links rather than
+// deleted, and the other links are pointed there. Hard links need no such
+// care. With dryRun set nothing is changed. RemoveModel needs a FileStore.
+func (d *Downloader) RemoveModel(ctx context.Context, root, dir string, dryRun bool) (*RemoveResult, error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil, errors.New("removing models needs a local directory")
+	}
+	dir = path.Clean(filepath.ToSlash(dir))
+	if manifest, err := readSavedManifest(ctx, store, dir); err != nil {
+		return nil, err
+	} else if manifest == nil {
+		return nil, fmt.Errorf("no model in %s", dir)
+	}
+	unlock := func() {}
+	if !dryRun {
+		var err error
+		if unlock, err = d.lockDir(ctx, dir); err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	return d.removeModel(ctx, store, root, dir, dryRun, unlock)
+}
+
+// removeModel does the work of RemoveModel. Unless dryRun is set, the
+// caller holds the lock on dir, which unlock releases.
+func (d *Downloader) removeModel(ctx context.Context, store *FileStore, root, dir string, dryRun bool, unlock func()) (*RemoveResult, error) {
+	entries, err := os.ReadDir(store.Path(dir))
+	if err != nil {
+		return nil, err
+	}
+	var files []fs.DirEntry
+	for _, entry := range entries {
+		if d.pulledFile(entry.Name()) && (entry.Type().IsRegular() || entry.Type()&fs.ModeSymlink != 0) {
+			files = append(files, entry)
+		}
+	}
+	var blobs []string
+	if isOCILayout(store, dir) {
+		if blobs, err = ociBlobFiles(store, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	links, err := linksInto(ctx, store, root, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RemoveResult{Moved: make(map[string]string)}
+	for _, entry := range files {
+		name := path.Join(dir, entry.Name())
+		abs, err := filepath.Abs(store.Path(name))
+		if err != nil {
+			return result, err
+		}
+		if dependents := links[abs]; len(dependents) > 0 && entry.Type().IsRegular() {
+			if !dryRun {
+				if err := handOver(abs, dependents); err != nil {
+					return result, err
+				}
+				d.log.Info("Moved", "path", name, "to", dependents[0])
+			}
+			result.Moved[name] = dependents[0]
+			continue
+		}
+
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return result, err
+			}
+			result.Bytes += info.Size()
+		}
+		if !dryRun {
+			if err := store.Remove(ctx, name); err != nil {
+				return result, err
+			}
+			d.log.Info("Removed", "path", name)
+		}
+		result.Removed = append(result.Removed, name)
+	}
+	for _, name := range blobs {
+		if info, err := os.Lstat(store.Path(name)); err == nil {
+			result.Bytes += info.Size()
+		}
+		if !dryRun {
+			if err := store.Remove(ctx, name); err != nil {
+				return result, err
+			}
+			d.log.Info("Removed", "path", name)
+		}
+		result.Removed = append(result.Removed, name)
+	}
+	if !dryRun {
+		if err := d.forget(ctx, dir); err != nil {
+			d.log.Warn("Failed to update state", "error", err)
+		}
+		unlock()
+		os.Remove(store.Path(path.Join(dir, LockFileName)))
+		// These fail, as they should, if anything else is left.
+		for _, name := range []string{path.Join(dir, "blobs", "sha256"), path.Join(dir, "blobs"), dir} {
+			os.Remove(store.Path(name))
+		}
+	}
+	sort.Strings(result.Removed)
+	return result, nil
+}
+
+// pulledFileNames are the files Pull may write next to a model's layers.
+var pulledFileNames = map[string]bool{
+	ManifestFileName:    true,
+	MetadataFileName:    true,
+	LicensesFileName:    true,
+	ChecksumsFileName:   true,
+	ModelCardFileName:   true,
+	AttestationFileName: true,
+	CompleteFileName:    true,
+	OCILayoutFileName:   true,
+	OCIIndexFileName:    true,
+}
+
+// pulledFile reports whether a file named name in a model's directory is
+// one Pull writes: a layer file, one of pulledFileNames, or the partial
+// download or hash state of either.
+func (d *Downloader) pulledFile(name string) bool {
+	name = strings.TrimSuffix(name, hashStateSuffix)
+	name = strings.TrimSuffix(name, ".tmp")
+	return pulledFileNames[name] || d.layerFileHash(name) != ""
+}
+
+// isOCILayout reports whether the store directory dir holds an OCI image
+// layout, as written with Options.OCILayout.
+func isOCILayout(store *FileStore, dir string) bool {
+	_, err := os.Stat(store.Path(path.Join(dir, OCILayoutFileName)))
+	return err == nil
+}
+
+// ociBlobFiles returns the blobs, and their partial downloads, of the OCI
+// image layout in the store directory dir.
+func ociBlobFiles(store *FileStore, dir string) ([]string, error) {
+	blobDir := path.Join(dir, "blobs", "sha256")
+	entries, err := os.ReadDir(store.Path(blobDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var blobs []string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), hashStateSuffix), ".tmp")
+		if entry.Type().IsRegular() && sha256HexPattern.MatchString(name) {
+			blobs = append(blobs, path.Join(blobDir, entry.Name()))
+		}
+	}
+	return blobs, nil
+}
+
+// linksInto finds the symbolic links under root, outside dir, that point to
+// files directly in dir. It returns the links' paths by the absolute path
+// of their targets.
+func linksInto(ctx context.Context, store *FileStore, root, dir string) (map[string][]string, error) {
+	absDir, err := filepath.Abs(store.Path(dir))
+	if err != nil {
+		return nil, err
+	}
+	links := make(map[string][]string)
+	err = filepath.WalkDir(store.Path(root), func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		if target, err = filepath.Abs(target); err != nil {
+			return err
+		}
+		link, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		if filepath.Dir(target) == absDir && filepath.Dir(link) != absDir {
+			links[target] = append(links[target], p)
+		}
+		return nil
+	})
+	for _, dependents := range links {
+		sort.Strings(dependents)
+	}
+	return links, err
+}
+
+// handOver moves file to the first of the symbolic links to it and points
+// the other links at its new place.
+func handOver(file string, links []string) error {
+	if err := os.Rename(file, links[0]); err != nil {
+		// Links may cross filesystems, which files can't be renamed across.
+		if err := copyOver(file, links[0]); err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	for _, link := range links[1:] {
+		target, err := filepath.Rel(shortPath(filepath.Dir(link)), shortPath(links[0]))
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyOver replaces dst with a copy of src, going through a temporary file
+// so dst is never left half written.
+func copyOver(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,95 @@
+package ollamadl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveModel(t *testing.T) {
+	ctx := context.Background()
+	d, reg, root := newTestDownloader(t)
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	reg.AddManifest(ref, config, reg.AddBlob(ModelMediaType, testGGUF("weights")))
+	if _, err := d.Pull(ctx, ref, "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, "m")
+	written := []string{ModelCardFileName, AttestationFileName, "model-0123456789ab.gguf.tmp", "model-0123456789ab.gguf.sha256.tmp"}
+	for _, name := range written {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.RemoveModel(ctx, "", "m", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		entries, _ := os.ReadDir(dir)
+		t.Errorf("directory left behind with %d entries: %v", len(entries), err)
+	}
+
+	// Files the pull didn't write are kept, and with them the directory.
+	if _, err := d.Pull(ctx, ref, "m"); err != nil {
+		t.Fatal(err)
+	}
+	user := []string{"notes-202410161230.txt", "backup-202410161230.tar", "scratch.tmp"}
+	for _, name := range user {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.RemoveModel(ctx, "", "m", false); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(user) {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("left %v, want only %v", names, user)
+	}
+}
+
+func TestCleanKeepsUnrelatedTempFiles(t *testing.T) {
+	ctx := context.Background()
+	d, reg, root := newTestDownloader(t)
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	reg.AddManifest(ref, config, reg.AddBlob(ModelMediaType, testGGUF("weights")))
+	if _, err := d.Pull(ctx, ref, "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, "m")
+	for _, name := range []string{"model-0123456789ab.gguf.tmp", "scratch.tmp", "0123456789ab.tmp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := d.Clean(ctx, "", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != "m/model-0123456789ab.gguf.tmp" {
+		t.Errorf("Removed = %v, want only the partial download of a layer", result.Removed)
+	}
+	for _, name := range []string{"scratch.tmp", "0123456789ab.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package ollamadl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostResolver dials registry connections for Options.Resolve,
+// Options.Resolver and Options.DNSCacheTTL: pinned addresses are used as
+// they are, other hosts are looked up through the resolver and the
+// addresses kept for the TTL.
+type hostResolver struct {
+	dialer   *net.Dialer
+	pinned   map[string]netip.Addr // by host:port
+	resolver *net.Resolver
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[string]resolvedHost
+}
+
+// resolvedHost is a cached lookup.
+type resolvedHost struct {
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+// newHostResolver returns the hostResolver for opts, or nil if opts need
+// none.
+func newHostResolver(opts *Options, dialer *net.Dialer) (*hostResolver, error) {
+	if len(opts.Resolve) == 0 && opts.Resolver == "" && opts.DNSCacheTTL <= 0 {
+		return nil, nil
+	}
+	r := &hostResolver{
+		dialer:   dialer,
+		pinned:   make(map[string]netip.Addr, len(opts.Resolve)),
+		resolver: net.DefaultResolver,
+		ttl:      opts.DNSCacheTTL,
+		cache:    make(map[string]resolvedHost),
+	}
+	for hostPort, addr := range opts.Resolve {
+		if _, _, err := net.SplitHostPort(hostPort); err != nil {
+			return nil, fmt.Errorf("invalid host to resolve %q: %v", hostPort, err)
+		}
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address for %s: %v", hostPort, err)
+		}
+		r.pinned[hostPort] = ip
+	}
+	if opts.Resolver != "" {
+		server := opts.Resolver
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return r, nil
+}
+
+// DialContext connects to addr, a host:port, trying the host's addresses
+// the way net.Dialer does: the addresses of the first one's family in
+// turn, sharing the dial timeout, raced against the other family's after
+// the dialer's FallbackDelay (Happy Eyeballs).
+func (r *hostResolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []netip.Addr
+	if ip, ok := r.pinned[addr]; ok {
+		addrs = []netip.Addr{ip}
+	} else if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{ip}
+	} else if addrs, err = r.lookup(ctx, host); err != nil {
+		return nil, err
+	}
+
+	var primaries, fallbacks []netip.Addr
+	for _, ip := range addrs {
+		switch {
+		case network == "tcp4" && !ip.Is4() || network == "tcp6" && !ip.Is6():
+		case len(primaries) == 0 || ip.Is4() == primaries[0].Is4():
+			primaries = append(primaries, ip)
+		default:
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("no IPv%s address for %s", strings.TrimPrefix(network, "tcp"), host)
+	}
+	delay := r.dialer.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	if len(fallbacks) == 0 || delay < 0 {
+		return r.dialSerial(ctx, network, port, append(primaries, fallbacks...))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	dial := func(addrs []netip.Addr) {
+		go func() {
+			conn, err := r.dialSerial(ctx, network, port, addrs)
+			results <- result{conn, err}
+		}()
+	}
+	dial(primaries)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	fallback, running := timer.C, 1
+
+	var firstErr error
+	for {
+		select {
+		case <-fallback:
+			dial(fallbacks)
+			fallback, running = nil, running+1
+		case res := <-results:
+			running--
+			if res.err == nil {
+				if running > 0 {
+					// The other family may connect before it sees the
+					// cancellation.
+					go func() {
+						if res := <-results; res.conn != nil {
+							res.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if fallback != nil {
+				dial(fallbacks)
+				fallback, running = nil, running+1
+			} else if running == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// defaultFallbackDelay is how long the first address family gets before
+// the other is tried as well, when the dialer doesn't say; net.Dialer
+// uses the same.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// minDialTimeout is the least time an address gets when the dial timeout
+// is shared among several.
+const minDialTimeout = 2 * time.Second
+
+// dialSerial connects to the first of addrs that accepts, at port. As in
+// net.Dialer, the dialer's timeout covers all of them: each address gets
+// an equal share of what is left, but at least minDialTimeout.
+func (r *hostResolver) dialSerial(ctx context.Context, network, port string, addrs []netip.Addr) (net.Conn, error) {
+	deadline, hasDeadline := ctx.Deadline()
+	if r.dialer.Timeout > 0 {
+		if d := time.Now().Add(r.dialer.Timeout); !hasDeadline || d.Before(deadline) {
+			deadline, hasDeadline = d, true
+		}
+	}
+
+	var firstErr error
+	for i, ip := range addrs {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if hasDeadline {
+			left := time.Until(deadline)
+			timeout := left / time.Duration(len(addrs)-i)
+			if timeout < minDialTimeout {
+				timeout = min(minDialTimeout, left)
+			}
+			dialCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		conn, err := r.dialer.DialContext(dialCtx, network, net.JoinHostPort(ip.String(), port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// lookup returns the addresses of host, from the cache while they are
+// fresh.
+func (r *hostResolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	addrs, err := r.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[host] = resolvedHost{addrs: addrs, expires: time.Now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return addrs, nil
+}
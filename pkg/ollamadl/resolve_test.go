@@ -0,0 +1,58 @@
+package ollamadl
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestHostResolverFallsBackToOtherFamily(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// The IPv6 addresses are in the discard prefix: they either fail at
+	// once or never answer, and must not hold up the IPv4 one.
+	r, err := newHostResolver(&Options{DNSCacheTTL: time.Hour}, &net.Dialer{Timeout: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.cache["registry.test"] = resolvedHost{
+		addrs:   []netip.Addr{netip.MustParseAddr("100::1"), netip.MustParseAddr("100::2"), netip.MustParseAddr("127.0.0.1")},
+		expires: time.Now().Add(time.Hour),
+	}
+
+	start := time.Now()
+	conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("registry.test", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("connecting took %v", elapsed)
+	}
+}
+
+func TestHostResolverNoAddressOfNetwork(t *testing.T) {
+	r, err := newHostResolver(&Options{Resolve: map[string]string{"registry.test:443": "::1"}}, &net.Dialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DialContext(context.Background(), "tcp4", "registry.test:443"); err == nil {
+		t.Error("dialed an IPv6 address over tcp4")
+	}
+}
@@ -0,0 +1,147 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// versionDir returns the subdirectory of destDir that Options.VersionedDirs
+// puts a pull of manifest in.
+func versionDir(destDir string, manifest *Manifest) (string, error) {
+	digest := manifest.Digest
+	if !strings.HasPrefix(digest, "sha256:") || len(digest) < 19 {
+		return "", fmt.Errorf("unexpected manifest digest: %q", digest)
+	}
+	return path.Join(filepath.ToSlash(destDir), digest[7:19]), nil
+}
+
+// reuseVersions links the files res shares with the other versions saved
+// next to it under Options.VersionedDirs into place.
+func (d *Downloader) reuseVersions(ctx context.Context, res *Resolution) error {
+	if !d.opts.VersionedDirs || d.opts.OCILayout {
+		return nil
+	}
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil
+	}
+	files, err := d.indexStored(ctx, store, path.Dir(path.Clean(filepath.ToSlash(res.DestDir))))
+	if err != nil {
+		return err
+	}
+	_, err = d.reuseFiles(ctx, res.Jobs, func(digest string) (string, bool) {
+		file, ok := files[digest]
+		return file, ok
+	})
+	return err
+}
+
+// updateLatestLink points the symbolic link Options.LatestLink at the
+// directory of res, replacing the link in one step so readers never find
+// it missing.
+func (d *Downloader) updateLatestLink(res *Resolution) error {
+	if d.opts.LatestLink == "" {
+		return nil
+	}
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return errors.New("a latest link needs a local directory")
+	}
+	link := store.Path(filepath.ToSlash(d.opts.LatestLink))
+	if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s exists and is not a symbolic link", link)
+	}
+	if err := store.mkdirAll(filepath.Dir(link)); err != nil {
+		return err
+	}
+	target, err := filepath.Rel(shortPath(filepath.Dir(link)), shortPath(store.Path(res.DestDir)))
+	if err != nil {
+		return err
+	}
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := store.chown(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	d.log.Info("Updated link", "link", d.opts.LatestLink, "target", target)
+	return nil
+}
+
+// pruneVersions removes the directories under the store root holding
+// versions of res's model other than the Options.KeepVersions most
+// recently pulled, as Options.KeepVersions describes.
+func (d *Downloader) pruneVersions(ctx context.Context, res *Resolution) error {
+	if d.opts.KeepVersions <= 0 {
+		return nil
+	}
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return errors.New("keeping versions needs a local directory")
+	}
+	destDir := path.Clean(filepath.ToSlash(res.DestDir))
+	root := d.opts.StoreRoot
+	if root == "" {
+		root = path.Dir(destDir)
+	}
+	models, err := d.List(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	// A version is a manifest digest; pulls that didn't record theirs
+	// count as versions of their own.
+	version := func(model LocalModel) string {
+		if model.Manifest.Digest != "" {
+			return model.Manifest.Digest
+		}
+		return model.Dir
+	}
+	var versions []LocalModel
+	for _, model := range models {
+		if model.Ref == res.Ref {
+			versions = append(versions, model)
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Pulled.After(versions[j].Pulled) })
+	keep := map[string]bool{res.Manifest.Digest: true}
+	for _, model := range versions {
+		if len(keep) >= d.opts.KeepVersions {
+			break
+		}
+		keep[version(model)] = true
+	}
+
+	pinned := d.pinned()
+	for _, model := range versions {
+		if keep[version(model)] || model.Dir == destDir || pinned[model.Dir] {
+			continue
+		}
+		unlock, err := tryLock(store.Path(path.Join(model.Dir, LockFileName)))
+		if err != nil {
+			return err
+		} else if unlock == nil {
+			d.log.Debug("Not removing a version in use", "dir", model.Dir)
+			continue
+		}
+		d.log.Info("Removing old version", "dir", model.Dir, "digest", model.Manifest.Digest)
+		if _, err := d.removeModel(ctx, store, root, model.Dir, false, unlock); err != nil {
+			unlock()
+			return fmt.Errorf("removing %s: %w", model.Dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,476 @@
+// Package s3store implements an ollamadl.BlobStore on S3-compatible object
+// storage (AWS S3, MinIO, ...). Blobs are streamed with multipart uploads, so
+// nothing is staged on local disk, and an interrupted upload can be continued
+// by a later run: the digest's progress is kept in an object next to it, so
+// nothing has to be read back.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// PartSize is the size of each uploaded part. S3 allows at most 10,000 parts,
+// so this caps a single blob at about 625 GiB.
+const PartSize = 64 << 20
+
+// hashStateSuffix names, after a blob's key, the object keeping the hash
+// state of its unfinished upload.
+const hashStateSuffix = ".sha256.tmp"
+
+// Store writes blobs to Bucket under Prefix.
+type Store struct {
+	Bucket   string
+	Prefix   string
+	Region   string
+	Endpoint string
+	// PathStyle addresses the bucket as a path component rather than a
+	// subdomain, as MinIO and most S3-compatible servers expect.
+	PathStyle   bool
+	Credentials Credentials
+	Client      *http.Client
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+// New returns a Store for a URL of the form s3://bucket/prefix. Credentials
+// come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN;
+// the region from AWS_REGION. A custom endpoint (e.g. MinIO) can be given in
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL, or as ?endpoint= and ?region= in
+// the URL.
+func New(rawURL string) (*Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q: want s3://bucket/prefix", rawURL)
+	}
+
+	s := &Store{
+		Bucket: u.Host,
+		Prefix: strings.Trim(u.Path, "/"),
+		Region: firstNonEmpty(u.Query().Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		Credentials: Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		},
+		Client: http.DefaultClient,
+	}
+
+	s.Endpoint = firstNonEmpty(u.Query().Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	if s.Endpoint != "" {
+		s.PathStyle = true
+	} else {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+
+	if s.Credentials.AccessKeyID == "" || s.Credentials.SecretAccessKey == "" {
+		return nil, errors.New("S3 credentials missing: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *Store) key(name string) string {
+	return path.Join(s.Prefix, name)
+}
+
+// objectURL returns the URL of key (or of the bucket when key is empty).
+func (s *Store) objectURL(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	p := "/" + key
+	if s.PathStyle {
+		p = "/" + s.Bucket + p
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = p
+	u.RawPath = awsEscape(p, true)
+	u.RawQuery = canonicalQuery(query)
+	return u, nil
+}
+
+// do sends a signed request and returns the response if its status is one of
+// ok.
+func (s *Store) do(ctx context.Context, method, key string, query url.Values, body []byte, ok ...int) (*http.Response, error) {
+	u, err := s.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = sha256Hex(body)
+	}
+	signV4(req, s.Credentials, s.Region, payloadHash, time.Now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, s3Err.Code, s3Err.Message)
+	}
+	return nil, fmt.Errorf("s3 %s %s: unexpected status code: %d", method, key, resp.StatusCode)
+}
+
+func (s *Store) doXML(ctx context.Context, method, key string, query url.Values, body []byte, out any) error {
+	resp, err := s.do(ctx, method, key, query, body, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return xml.NewDecoder(resp.Body).Decode(out)
+}
+
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.key(name), nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (s *Store) Remove(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.key(name), nil, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(name), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Store) SaveHashState(ctx context.Context, name string, state []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, s.key(name)+hashStateSuffix, nil, state, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *Store) LoadHashState(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(name)+hashStateSuffix, nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+func (s *Store) RemoveHashState(ctx context.Context, name string) error {
+	return s.Remove(ctx, name+hashStateSuffix)
+}
+
+type part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size,omitempty"`
+}
+
+// upload is an in-progress multipart upload.
+type upload struct {
+	id    string
+	parts []part
+	empty bool
+}
+
+// pendingUpload returns the most recent unfinished multipart upload of key.
+func (s *Store) pendingUpload(ctx context.Context, key string) (string, error) {
+	var result struct {
+		Uploads []struct {
+			Key       string    `xml:"Key"`
+			UploadID  string    `xml:"UploadId"`
+			Initiated time.Time `xml:"Initiated"`
+		} `xml:"Upload"`
+	}
+	if err := s.doXML(ctx, http.MethodGet, "", url.Values{"uploads": {""}, "prefix": {key}}, nil, &result); err != nil {
+		return "", err
+	}
+
+	var id string
+	var latest time.Time
+	for _, u := range result.Uploads {
+		if u.Key == key && !u.Initiated.Before(latest) {
+			id, latest = u.UploadID, u.Initiated
+		}
+	}
+	return id, nil
+}
+
+func (s *Store) listParts(ctx context.Context, key, uploadID string) ([]part, error) {
+	var parts []part
+	marker := "0"
+	for {
+		var result struct {
+			Parts       []part `xml:"Part"`
+			IsTruncated bool   `xml:"IsTruncated"`
+			NextMarker  string `xml:"NextPartNumberMarker"`
+		}
+		query := url.Values{"uploadId": {uploadID}, "part-number-marker": {marker}}
+		if err := s.doXML(ctx, http.MethodGet, key, query, nil, &result); err != nil {
+			return nil, err
+		}
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextMarker
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+func (s *Store) ResumeOffset(ctx context.Context, name string) (int64, error) {
+	key := s.key(name)
+	id, err := s.pendingUpload(ctx, key)
+	if err != nil || id == "" {
+		return 0, err
+	}
+	parts, err := s.listParts(ctx, key, id)
+	if err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	for i, p := range parts {
+		// Only a contiguous run of full parts can be continued.
+		if p.PartNumber != i+1 || p.Size != PartSize {
+			break
+		}
+		offset += p.Size
+	}
+	return offset, nil
+}
+
+func (s *Store) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	key := s.key(name)
+	up := &upload{}
+
+	if resume {
+		id, err := s.pendingUpload(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			parts, err := s.listParts(ctx, key, id)
+			if err != nil {
+				return nil, err
+			}
+			up.id = id
+			for i, p := range parts {
+				if p.PartNumber != i+1 || p.Size != PartSize {
+					break
+				}
+				up.parts = append(up.parts, p)
+			}
+		}
+	} else if err := s.abortPending(ctx, key); err != nil {
+		return nil, err
+	}
+
+	if up.id == "" {
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := s.doXML(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, &result); err != nil {
+			return nil, err
+		}
+		up.id = result.UploadID
+	}
+
+	s.mu.Lock()
+	if s.uploads == nil {
+		s.uploads = make(map[string]*upload)
+	}
+	s.uploads[name] = up
+	s.mu.Unlock()
+
+	return &writer{ctx: ctx, store: s, key: key, upload: up, buf: make([]byte, 0, PartSize)}, nil
+}
+
+// abortPending discards unfinished uploads of key, which would otherwise be
+// billed until the bucket's lifecycle rules clean them up.
+func (s *Store) abortPending(ctx context.Context, key string) error {
+	for {
+		id, err := s.pendingUpload(ctx, key)
+		if err != nil || id == "" {
+			return err
+		}
+		resp, err := s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {id}}, nil, http.StatusNoContent, http.StatusNotFound)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+}
+
+func (s *Store) Commit(ctx context.Context, name string, layer ollamadl.Layer) error {
+	s.mu.Lock()
+	up := s.uploads[name]
+	delete(s.uploads, name)
+	s.mu.Unlock()
+	if up == nil {
+		return fmt.Errorf("no upload in progress for %s", name)
+	}
+
+	key := s.key(name)
+	if up.empty {
+		// A multipart upload needs at least one part; store empty blobs
+		// with a plain PUT instead.
+		resp, err := s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {up.id}}, nil, http.StatusNoContent, http.StatusNotFound)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		resp, err = s.do(ctx, http.MethodPut, key, nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for _, p := range up.parts {
+		complete.Parts = append(complete.Parts, part{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	// CompleteMultipartUpload can report failure in a 200 response body.
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := s.doXML(ctx, http.MethodPost, key, url.Values{"uploadId": {up.id}}, body, &result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("s3 complete upload %s: %s: %s", key, result.Code, result.Message)
+	}
+	return nil
+}
+
+// writer buffers writes into parts and uploads each as it fills.
+type writer struct {
+	ctx    context.Context
+	store  *Store
+	key    string
+	upload *upload
+	buf    []byte
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) flush() error {
+	number := len(w.upload.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.upload.id}}
+	resp, err := w.store.do(w.ctx, http.MethodPut, w.key, query, w.buf, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	w.upload.parts = append(w.upload.parts, part{PartNumber: number, ETag: resp.Header.Get("ETag"), Size: int64(len(w.buf))})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Staged returns how many bytes have been uploaded as parts.
+func (w *writer) Staged() int64 {
+	var n int64
+	for _, p := range w.upload.parts {
+		n += p.Size
+	}
+	return n
+}
+
+func (w *writer) Close() error {
+	if len(w.buf) > 0 {
+		return w.flush()
+	}
+	w.upload.empty = len(w.upload.parts) == 0
+	return nil
+}
@@ -0,0 +1,79 @@
+package s3store
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// objectServer serves objects from memory for PUT, GET, HEAD and DELETE,
+// ignoring signatures.
+func objectServer(t *testing.T) *Store {
+	t.Helper()
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet, http.MethodHead:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return &Store{
+		Bucket:      "models",
+		Prefix:      "m",
+		Region:      "us-east-1",
+		Endpoint:    srv.URL,
+		PathStyle:   true,
+		Credentials: Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		Client:      srv.Client(),
+	}
+}
+
+func TestHashState(t *testing.T) {
+	ctx := context.Background()
+	s := objectServer(t)
+
+	if state, err := s.LoadHashState(ctx, "model.gguf"); err != nil || state != nil {
+		t.Fatalf("LoadHashState() = %q, %v before saving", state, err)
+	}
+	if err := s.SaveHashState(ctx, "model.gguf", []byte(`{"offset":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := s.LoadHashState(ctx, "model.gguf"); err != nil || string(state) != `{"offset":1}` {
+		t.Fatalf("LoadHashState() = %q, %v", state, err)
+	}
+	if exists, err := s.Exists(ctx, "model.gguf"); err != nil || exists {
+		t.Errorf("Exists() = %v, %v: the state must not look like the blob", exists, err)
+	}
+	if err := s.RemoveHashState(ctx, "model.gguf"); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := s.LoadHashState(ctx, "model.gguf"); err != nil || state != nil {
+		t.Fatalf("LoadHashState() = %q, %v after removing", state, err)
+	}
+}
+
+func TestWriterStaged(t *testing.T) {
+	up := &upload{parts: []part{{PartNumber: 1, Size: PartSize}}}
+	w := &writer{upload: up, buf: make([]byte, 0, PartSize)}
+	w.buf = append(w.buf, "buffered"...)
+	if got := w.Staged(); got != PartSize {
+		t.Errorf("Staged() = %d, want %d: buffered data isn't staged", got, PartSize)
+	}
+}
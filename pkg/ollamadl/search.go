@@ -0,0 +1,99 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SearchResult is a model found by Search.
+type SearchResult struct {
+	// Name is the model's reference without a tag, e.g. llama3.2 or
+	// user/model.
+	Name        string
+	Description string
+	// Pulls is the pull count as ollama.com shows it, e.g. 10.2M.
+	Pulls string
+	// Tags is how many tags the model has, and Sizes the parameter sizes
+	// they come in, e.g. 1b and 3b.
+	Tags         int
+	Sizes        []string
+	Capabilities []string
+	// Updated is when the model last changed, as ollama.com puts it, e.g.
+	// "2 months ago".
+	Updated string
+}
+
+// Search returns the models ollama.com's library search finds for query,
+// in the order it ranks them. Like ModelCard, it reads a page meant for
+// browsers, on a best-effort basis.
+func (d *Downloader) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	page, err := fetchPage(ctx, ollamaWebsite+"/search?q="+url.QueryEscape(query))
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	items := bytes.Split(page, []byte("x-test-model"))
+	for _, item := range items[1:] {
+		var result SearchResult
+		if m := searchLinkPattern.FindSubmatch(item); m != nil {
+			result.Name = strings.TrimPrefix(string(m[1]), "library/")
+		} else {
+			continue
+		}
+		result.Description = searchField(item, "description")
+		if result.Description == "" {
+			if m := searchDescriptionPattern.FindSubmatch(item); m != nil {
+				result.Description = cleanText(m[1])
+			}
+		}
+		result.Pulls = searchField(item, "pull-count")
+		result.Tags, _ = strconv.Atoi(searchField(item, "tag-count"))
+		result.Updated = searchField(item, "updated")
+		result.Sizes = searchFields(item, "size")
+		result.Capabilities = searchFields(item, "capability")
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+var (
+	searchLinkPattern        = regexp.MustCompile(`<a[^>]*\bhref="/([^"?#]+)"`)
+	searchDescriptionPattern = regexp.MustCompile(`(?s)<p[^>]*>(.*?)</p>`)
+	// searchFieldPatterns match the elements of a search result marked
+	// with x-test-<name>, by name.
+	searchFieldPatterns = func() map[string]*regexp.Regexp {
+		patterns := make(map[string]*regexp.Regexp)
+		for _, name := range []string{"description", "pull-count", "tag-count", "updated", "size", "capability"} {
+			patterns[name] = regexp.MustCompile(`(?s)<(\w+)[^>]*\bx-test-` + name + `\b[^>]*>(.*?)</\w+>`)
+		}
+		return patterns
+	}()
+)
+
+// searchFields returns the text of the elements of a search result marked
+// with x-test-<name>.
+func searchFields(item []byte, name string) []string {
+	var fields []string
+	for _, m := range searchFieldPatterns[name].FindAllSubmatch(item, -1) {
+		fields = append(fields, cleanText(m[2]))
+	}
+	return fields
+}
+
+func searchField(item []byte, name string) string {
+	if fields := searchFields(item, name); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// cleanText returns the text of an HTML fragment on one line.
+func cleanText(fragment []byte) string {
+	text := html.UnescapeString(tagPattern.ReplaceAllString(string(fragment), ""))
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,180 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RegistryServer serves the models saved under a directory of a FileStore
+// through the read-only part of the registry HTTP API, so ollama-dl and
+// Ollama can pull them from it:
+//
+//	GET /v2/                             version check
+//	GET /v2/_catalog                     repositories
+//	GET /v2/<name>/tags/list             tags of a repository
+//	GET /v2/<name>/manifests/<tag>       a manifest, by tag or digest
+//	GET /v2/<name>/blobs/<digest>        a layer
+//
+// Models are known by the reference their pull recorded, and manifests
+// served as saved; their digests are those of the saved manifests, not of
+// the originals. As with BlobServer, layers stored decompressed can't be
+// served, so neither can models with such layers in full.
+type RegistryServer struct {
+	*localIndex
+}
+
+// NewRegistryServer returns a RegistryServer for the models saved under
+// dir.
+func (d *Downloader) NewRegistryServer(ctx context.Context, dir string) (*RegistryServer, error) {
+	x, err := d.newLocalIndex(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &RegistryServer{x}, nil
+}
+
+// Models returns the references of the models the server knows, sorted.
+func (s *RegistryServer) Models() []Reference {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refs := make([]Reference, 0, len(s.models))
+	for ref := range s.models {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].String() < refs[j].String() })
+	return refs
+}
+
+func (s *RegistryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		registryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "this registry is read-only")
+		return
+	}
+	p := path.Clean(r.URL.Path)
+	switch {
+	case p == "/v2/" || p == "/v2":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	case p == "/v2/_catalog":
+		s.refresh(r.Context())
+		seen := make(map[string]bool)
+		repos := []string{}
+		for _, ref := range s.Models() {
+			if !seen[ref.Name] {
+				seen[ref.Name] = true
+				repos = append(repos, ref.Name)
+			}
+		}
+		writeRegistryJSON(w, map[string]any{"repositories": repos})
+	case strings.HasPrefix(p, "/v2/"):
+		name, kind, arg, ok := splitRegistryPath(strings.TrimPrefix(p, "/v2/"))
+		if !ok {
+			registryError(w, http.StatusNotFound, "NAME_UNKNOWN", "no such endpoint")
+			return
+		}
+		switch kind {
+		case "tags":
+			s.serveTags(w, r, name)
+		case "manifests":
+			s.serveManifest(w, r, name, arg)
+		case "blobs":
+			file, ok := s.lookup(r.Context(), arg)
+			if !ok {
+				registryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown: "+arg)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", arg)
+			s.serveFile(w, r, file)
+		}
+	default:
+		registryError(w, http.StatusNotFound, "NAME_UNKNOWN", "no such endpoint")
+	}
+}
+
+// splitRegistryPath splits the part of a path after /v2/ into the
+// repository name, which may contain slashes, the kind of request and its
+// argument.
+func splitRegistryPath(p string) (name, kind, arg string, ok bool) {
+	if name, ok := strings.CutSuffix(p, "/tags/list"); ok && name != "" {
+		return name, "tags", "", true
+	}
+	for _, kind := range []string{"manifests", "blobs"} {
+		if i := strings.LastIndex(p, "/"+kind+"/"); i > 0 {
+			arg := p[i+len(kind)+2:]
+			if arg != "" && !strings.Contains(arg, "/") {
+				return p[:i], kind, arg, true
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+func (s *RegistryServer) serveTags(w http.ResponseWriter, r *http.Request, name string) {
+	s.refresh(r.Context())
+	tags := []string{}
+	for _, ref := range s.Models() {
+		if ref.Name == name {
+			tags = append(tags, ref.Tag)
+		}
+	}
+	if len(tags) == 0 {
+		registryError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository unknown: "+name)
+		return
+	}
+	writeRegistryJSON(w, map[string]any{"name": name, "tags": tags})
+}
+
+// serveManifest serves the saved manifest of name:reference, where the
+// reference is a tag or the digest of a manifest of the repository.
+func (s *RegistryServer) serveManifest(w http.ResponseWriter, r *http.Request, name, reference string) {
+	var dirs []string
+	if strings.HasPrefix(reference, "sha256:") {
+		s.refresh(r.Context())
+		s.mu.Lock()
+		for ref, dir := range s.models {
+			if ref.Name == name {
+				dirs = append(dirs, dir)
+			}
+		}
+		s.mu.Unlock()
+	} else if dir, ok := s.model(r.Context(), Reference{Name: name, Tag: reference}); ok {
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		data, found, err := readStored(r.Context(), s.store, path.Join(dir, ManifestFileName))
+		if err != nil || !found {
+			continue
+		}
+		digest := sha256Digest(data)
+		if strings.HasPrefix(reference, "sha256:") && digest != reference {
+			continue
+		}
+		w.Header().Set("Content-Type", ManifestMediaType)
+		w.Header().Set("Docker-Content-Digest", digest)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		return
+	}
+	registryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown: "+name+":"+reference)
+}
+
+func writeRegistryJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// registryError writes an error the way registries report them.
+func registryError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,381 @@
+package sftpstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// SFTP version 3 packet types and constants (draft-ietf-secsh-filexfer-02).
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpFstat         = 8
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+
+	fxfRead  = 0x01
+	fxfWrite = 0x02
+	fxfCreat = 0x08
+	fxfTrunc = 0x10
+
+	fxOK         = 0
+	fxEOF        = 1
+	fxNoSuchFile = 2
+
+	attrSize        = 0x01
+	attrUIDGID      = 0x02
+	attrPermissions = 0x04
+	attrACModTime   = 0x08
+	attrExtended    = 0x80000000
+)
+
+// StatusError is an SFTP status response other than OK.
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("sftp: %s (status %d)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("sftp: status %d", e.Code)
+}
+
+func isStatus(err error, code uint32) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Code == code
+}
+
+// packet is a response: its type and the payload following the request ID.
+type packet struct {
+	typ  byte
+	data []byte
+}
+
+// buffer builds request payloads.
+type buffer []byte
+
+func (b buffer) u32(v uint32) buffer   { return binary.BigEndian.AppendUint32(b, v) }
+func (b buffer) u64(v uint64) buffer   { return binary.BigEndian.AppendUint64(b, v) }
+func (b buffer) str(s string) buffer   { return append(b.u32(uint32(len(s))), s...) }
+func (b buffer) bytes(p []byte) buffer { return append(b.u32(uint32(len(p))), p...) }
+
+// decoder reads response payloads, remembering the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) u32() uint32 {
+	if len(d.b) < 4 {
+		d.err = errors.New("sftp: short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *decoder) u64() uint64 {
+	if len(d.b) < 8 {
+		d.err = errors.New("sftp: short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.u32()
+	if d.err != nil || uint32(len(d.b)) < n {
+		d.err = errors.New("sftp: short packet")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+// attrs decodes an ATTRS structure, returning only the size.
+func (d *decoder) attrs() (size int64) {
+	flags := d.u32()
+	if flags&attrSize != 0 {
+		size = int64(d.u64())
+	}
+	if flags&attrUIDGID != 0 {
+		d.u32()
+		d.u32()
+	}
+	if flags&attrPermissions != 0 {
+		d.u32()
+	}
+	if flags&attrACModTime != 0 {
+		d.u32()
+		d.u32()
+	}
+	if flags&attrExtended != 0 {
+		for n := d.u32(); n > 0 && d.err == nil; n-- {
+			d.bytes()
+			d.bytes()
+		}
+	}
+	return size
+}
+
+// conn is an SFTP session over a byte stream, typically the stdin/stdout of
+// "ssh -s host sftp". Requests may be pipelined; responses are matched to
+// requests by ID.
+type conn struct {
+	w   io.Writer
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan packet
+	err     error
+
+	extensions map[string]string
+}
+
+func newConn(r io.Reader, w io.Writer) (*conn, error) {
+	c := &conn{w: w, pending: make(map[uint32]chan packet), extensions: make(map[string]string)}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	if err := c.writePacket(fxpInit, buffer(nil).u32(3)); err != nil {
+		return nil, err
+	}
+	typ, data, err := readPacket(br)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: handshake: %v", err)
+	}
+	if typ != fxpVersion {
+		return nil, fmt.Errorf("sftp: unexpected handshake packet %d", typ)
+	}
+	d := &decoder{b: data}
+	d.u32() // version
+	for len(d.b) > 0 && d.err == nil {
+		name, value := d.bytes(), d.bytes()
+		c.extensions[string(name)] = string(value)
+	}
+
+	go c.readLoop(br)
+	return c, nil
+}
+
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr[:4])
+	if length < 1 || length > 1<<20 {
+		return 0, nil, fmt.Errorf("sftp: bad packet length %d", length)
+	}
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], data, nil
+}
+
+func (c *conn) writePacket(typ byte, payload buffer) error {
+	hdr := buffer(nil).u32(uint32(len(payload) + 1))
+	hdr = append(hdr, typ)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := c.w.Write(payload)
+	return err
+}
+
+func (c *conn) readLoop(r io.Reader) {
+	for {
+		typ, data, err := readPacket(r)
+		if err == nil && len(data) < 4 {
+			err = errors.New("sftp: short packet")
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("sftp: connection closed")
+			}
+			c.mu.Lock()
+			c.err = err
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		id := binary.BigEndian.Uint32(data)
+		c.mu.Lock()
+		ch := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- packet{typ: typ, data: data[4:]}
+		}
+	}
+}
+
+// send issues a request without waiting; the response arrives on the
+// returned channel, which is closed if the connection fails first.
+func (c *conn) send(typ byte, payload buffer) (<-chan packet, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan packet, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.writePacket(typ, append(buffer(nil).u32(id), payload...)); err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (c *conn) wait(ctx context.Context, ch <-chan packet) (packet, error) {
+	select {
+	case p, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return packet{}, c.err
+		}
+		return p, nil
+	case <-ctx.Done():
+		return packet{}, ctx.Err()
+	}
+}
+
+func (c *conn) request(ctx context.Context, typ byte, payload buffer) (packet, error) {
+	ch, err := c.send(typ, payload)
+	if err != nil {
+		return packet{}, err
+	}
+	return c.wait(ctx, ch)
+}
+
+// statusError converts a response into an error: nil for an OK status, a
+// *StatusError for any other status, or an error if want was expected.
+func statusError(p packet, want byte) error {
+	if p.typ == fxpStatus {
+		d := &decoder{b: p.data}
+		code := d.u32()
+		msg := d.bytes()
+		if d.err != nil {
+			return d.err
+		}
+		if code == fxOK {
+			return nil
+		}
+		return &StatusError{Code: code, Message: string(msg)}
+	}
+	if p.typ != want {
+		return fmt.Errorf("sftp: unexpected response packet %d", p.typ)
+	}
+	return nil
+}
+
+func (c *conn) status(ctx context.Context, typ byte, payload buffer) error {
+	p, err := c.request(ctx, typ, payload)
+	if err != nil {
+		return err
+	}
+	if err := statusError(p, fxpStatus); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *conn) stat(ctx context.Context, path string) (int64, error) {
+	p, err := c.request(ctx, fxpStat, buffer(nil).str(path))
+	if err != nil {
+		return 0, err
+	}
+	if err := statusError(p, fxpAttrs); err != nil {
+		return 0, err
+	}
+	if p.typ != fxpAttrs {
+		return 0, errors.New("sftp: stat returned no attributes")
+	}
+	d := &decoder{b: p.data}
+	size := d.attrs()
+	return size, d.err
+}
+
+func (c *conn) open(ctx context.Context, path string, flags uint32) (string, error) {
+	p, err := c.request(ctx, fxpOpen, buffer(nil).str(path).u32(flags).u32(0))
+	if err != nil {
+		return "", err
+	}
+	if err := statusError(p, fxpHandle); err != nil {
+		return "", err
+	}
+	if p.typ != fxpHandle {
+		return "", errors.New("sftp: open returned no handle")
+	}
+	d := &decoder{b: p.data}
+	handle := d.bytes()
+	return string(handle), d.err
+}
+
+func (c *conn) fstat(ctx context.Context, handle string) (int64, error) {
+	p, err := c.request(ctx, fxpFstat, buffer(nil).str(handle))
+	if err != nil {
+		return 0, err
+	}
+	if err := statusError(p, fxpAttrs); err != nil {
+		return 0, err
+	}
+	d := &decoder{b: p.data}
+	size := d.attrs()
+	return size, d.err
+}
+
+func (c *conn) close(ctx context.Context, handle string) error {
+	return c.status(ctx, fxpClose, buffer(nil).str(handle))
+}
+
+// rename replaces newPath with oldPath. Plain SFTP v3 rename refuses to
+// overwrite, so OpenSSH's posix-rename extension is used when available.
+func (c *conn) rename(ctx context.Context, oldPath, newPath string) error {
+	if _, ok := c.extensions["posix-rename@openssh.com"]; ok {
+		return c.status(ctx, fxpExtended, buffer(nil).str("posix-rename@openssh.com").str(oldPath).str(newPath))
+	}
+	if err := c.status(ctx, fxpRemove, buffer(nil).str(newPath)); err != nil && !isStatus(err, fxNoSuchFile) {
+		return err
+	}
+	return c.status(ctx, fxpRename, buffer(nil).str(oldPath).str(newPath))
+}
+
+func (c *conn) mkdir(ctx context.Context, path string) error {
+	return c.status(ctx, fxpMkdir, buffer(nil).str(path).u32(attrPermissions).u32(0755))
+}
@@ -0,0 +1,135 @@
+package sftpstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestReadPacket(t *testing.T) {
+	raw := []byte{0, 0, 0, 5, fxpVersion, 0, 0, 0, 3}
+	typ, data, err := readPacket(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fxpVersion || !bytes.Equal(data, []byte{0, 0, 0, 3}) {
+		t.Errorf("readPacket = %d %x", typ, data)
+	}
+
+	for _, raw := range [][]byte{
+		{0, 0, 0, 0, fxpVersion},       // zero length
+		{0, 0x10, 0, 1, fxpVersion},    // over 1 MiB
+		{0, 0, 0, 9, fxpVersion, 0, 0}, // truncated
+	} {
+		if _, _, err := readPacket(bytes.NewReader(raw)); err == nil {
+			t.Errorf("readPacket(%x) succeeded, want error", raw)
+		}
+	}
+}
+
+func TestBufferEncoding(t *testing.T) {
+	got := buffer(nil).u32(7).u64(1 << 32).str("ab").bytes([]byte{9})
+	want := []byte{
+		0, 0, 0, 7,
+		0, 0, 0, 1, 0, 0, 0, 0,
+		0, 0, 0, 2, 'a', 'b',
+		0, 0, 0, 1, 9,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("buffer = %x, want %x", []byte(got), want)
+	}
+}
+
+func TestDecoderAttrs(t *testing.T) {
+	b := buffer(nil).u32(attrSize | attrUIDGID | attrPermissions | attrACModTime | attrExtended).
+		u64(12345).
+		u32(1000).u32(1000).
+		u32(0644).
+		u32(1).u32(2).
+		u32(1).str("name").str("value")
+	d := &decoder{b: b}
+	if size := d.attrs(); d.err != nil || size != 12345 {
+		t.Errorf("attrs = %d, %v; want 12345", size, d.err)
+	}
+	if len(d.b) != 0 {
+		t.Errorf("%d bytes left after attrs", len(d.b))
+	}
+
+	d = &decoder{b: buffer(nil).u32(attrSize).u32(0)}
+	d.attrs()
+	if d.err == nil {
+		t.Error("truncated attrs decoded without error")
+	}
+
+	d = &decoder{b: buffer(nil).u32(10).str("ab")[:6]}
+	if d.bytes(); d.err == nil {
+		t.Error("string longer than packet decoded without error")
+	}
+}
+
+// fakeServer answers the handshake and then replies to each request with
+// the packet built by reply.
+func fakeServer(t *testing.T, r io.Reader, w io.Writer, reply func(typ byte, id uint32, payload []byte) (byte, buffer)) {
+	t.Helper()
+	go func() {
+		typ, _, err := readPacket(r)
+		if err != nil || typ != fxpInit {
+			return
+		}
+		version := buffer(nil).u32(3).str("posix-rename@openssh.com").str("1")
+		w.Write(append(buffer(nil).u32(uint32(len(version)+1)), append([]byte{fxpVersion}, version...)...))
+		for {
+			typ, data, err := readPacket(r)
+			if err != nil {
+				return
+			}
+			id := binary.BigEndian.Uint32(data)
+			rtyp, payload := reply(typ, id, data[4:])
+			payload = append(buffer(nil).u32(id), payload...)
+			w.Write(append(buffer(nil).u32(uint32(len(payload)+1)), append([]byte{rtyp}, payload...)...))
+		}
+	}()
+}
+
+func TestConn(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	defer cw.Close()
+	fakeServer(t, sr, sw, func(typ byte, id uint32, payload []byte) (byte, buffer) {
+		d := &decoder{b: payload}
+		switch p := string(d.bytes()); {
+		case typ == fxpStat && p == "/present":
+			return fxpAttrs, buffer(nil).u32(attrSize).u64(42)
+		case typ == fxpStat:
+			return fxpStatus, buffer(nil).u32(fxNoSuchFile).str("no such file").str("")
+		default:
+			return fxpStatus, buffer(nil).u32(fxOK).str("").str("")
+		}
+	})
+
+	c, err := newConn(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.extensions["posix-rename@openssh.com"]; !ok {
+		t.Errorf("extensions = %v, want posix-rename", c.extensions)
+	}
+
+	ctx := context.Background()
+	if size, err := c.stat(ctx, "/present"); err != nil || size != 42 {
+		t.Errorf("stat = %d, %v; want 42", size, err)
+	}
+	if _, err := c.stat(ctx, "/missing"); !isStatus(err, fxNoSuchFile) {
+		t.Errorf("stat of missing file: %v, want no such file status", err)
+	}
+	if err := c.rename(ctx, "/a", "/b"); err != nil {
+		t.Errorf("rename: %v", err)
+	}
+
+	sw.Close()
+	if _, err := c.stat(ctx, "/present"); err == nil {
+		t.Error("stat after the connection closed succeeded")
+	}
+}
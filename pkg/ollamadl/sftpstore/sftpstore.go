@@ -0,0 +1,338 @@
+// Package sftpstore implements an ollamadl.BlobStore on a remote host over
+// SFTP. The connection is made by running the system's ssh client with the
+// sftp subsystem, so keys, agents, known_hosts and ~/.ssh/config work exactly
+// as they do for ssh itself.
+package sftpstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+const (
+	// chunkSize is the payload of each read and write request; every SFTP
+	// server accepts at least 32 KiB.
+	chunkSize = 32 << 10
+	// maxInflight is how many requests are pipelined, so throughput isn't
+	// bound by the round-trip time.
+	maxInflight = 64
+)
+
+// Store writes files below Root on the remote host. Staged data is kept next
+// to the final file with a .tmp suffix.
+type Store struct {
+	Root string
+
+	c   *conn
+	cmd *exec.Cmd
+}
+
+// New connects to a URL of the form sftp://[user@]host[:port]/path. A path
+// starting with /~/ is relative to the remote user's home directory.
+func New(rawURL string) (*Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "sftp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SFTP URL %q: want sftp://[user@]host[:port]/path", rawURL)
+	}
+
+	root := u.Path
+	switch {
+	case root == "/~" || root == "":
+		root = "."
+	case strings.HasPrefix(root, "/~/"):
+		root = strings.TrimPrefix(root, "/~/")
+	}
+
+	args, err := sshArgs(u)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting ssh: %v", err)
+	}
+
+	c, err := newConn(stdout, stdin)
+	if err != nil {
+		stdin.Close()
+		cmd.Wait()
+		return nil, err
+	}
+	return &Store{Root: root, c: c, cmd: cmd}, nil
+}
+
+// sshArgs builds the ssh command line that starts the sftp subsystem on the
+// host named by u. A user or host starting with "-" is refused, and "--" ends
+// the options, so nothing taken from the URL can be read as an ssh option.
+func sshArgs(u *url.URL) ([]string, error) {
+	host := u.Hostname()
+	if strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid SFTP host %q", host)
+	}
+	dest := host
+	if u.User != nil {
+		user := u.User.Username()
+		if strings.HasPrefix(user, "-") {
+			return nil, fmt.Errorf("invalid SFTP user %q", user)
+		}
+		dest = user + "@" + dest
+	}
+	var args []string
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	return append(args, "-s", "--", dest, "sftp"), nil
+}
+
+// Close ends the SFTP session.
+func (s *Store) Close() error {
+	if w, ok := s.c.w.(io.Closer); ok {
+		w.Close()
+	}
+	return s.cmd.Wait()
+}
+
+func (s *Store) path(name string) string {
+	return path.Join(s.Root, name)
+}
+
+func (s *Store) stagedPath(name string) string {
+	return s.path(name) + ".tmp"
+}
+
+func (s *Store) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.c.stat(ctx, s.path(name))
+	if isStatus(err, fxNoSuchFile) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Store) Remove(ctx context.Context, name string) error {
+	err := s.c.status(ctx, fxpRemove, buffer(nil).str(s.path(name)))
+	if isStatus(err, fxNoSuchFile) {
+		return nil
+	}
+	return err
+}
+
+func (s *Store) ResumeOffset(ctx context.Context, name string) (int64, error) {
+	size, err := s.c.stat(ctx, s.stagedPath(name))
+	if isStatus(err, fxNoSuchFile) {
+		return 0, nil
+	}
+	return size, err
+}
+
+// mkdirAll creates dir and any missing parents.
+func (s *Store) mkdirAll(ctx context.Context, dir string) error {
+	if _, err := s.c.stat(ctx, dir); err == nil {
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := s.mkdirAll(ctx, parent); err != nil {
+			return err
+		}
+	}
+	if err := s.c.mkdir(ctx, dir); err != nil {
+		// Lost a race, or the server reports existing directories as
+		// a generic failure.
+		if _, statErr := s.c.stat(ctx, dir); statErr == nil {
+			return nil
+		}
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	return nil
+}
+
+func (s *Store) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	p := s.stagedPath(name)
+	if err := s.mkdirAll(ctx, path.Dir(p)); err != nil {
+		return nil, err
+	}
+
+	flags := uint32(fxfWrite | fxfCreat)
+	if !resume {
+		flags |= fxfTrunc
+	}
+	handle, err := s.c.open(ctx, p, flags)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", p, err)
+	}
+
+	var offset int64
+	if resume {
+		// SFTP writes are positional, so continue at the current size.
+		if offset, err = s.c.fstat(ctx, handle); err != nil {
+			s.c.close(ctx, handle)
+			return nil, err
+		}
+	}
+	return &writer{ctx: ctx, c: s.c, handle: handle, offset: offset}, nil
+}
+
+func (s *Store) Commit(ctx context.Context, name string, layer ollamadl.Layer) error {
+	return s.c.rename(ctx, s.stagedPath(name), s.path(name))
+}
+
+func (s *Store) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.open(ctx, s.path(name))
+}
+
+func (s *Store) OpenStaged(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.open(ctx, s.stagedPath(name))
+}
+
+func (s *Store) open(ctx context.Context, p string) (io.ReadCloser, error) {
+	handle, err := s.c.open(ctx, p, fxfRead)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", p, err)
+	}
+	return &reader{ctx: ctx, c: s.c, handle: handle}, nil
+}
+
+// writer pipelines positional writes, checking their results as the window
+// fills and on Close.
+type writer struct {
+	ctx      context.Context
+	c        *conn
+	handle   string
+	offset   int64
+	inflight []<-chan packet
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), chunkSize)
+		payload := buffer(nil).str(w.handle).u64(uint64(w.offset)).bytes(p[:n])
+		ch, err := w.c.send(fxpWrite, payload)
+		if err != nil {
+			return written, err
+		}
+		w.inflight = append(w.inflight, ch)
+		w.offset += int64(n)
+		written += n
+		p = p[n:]
+
+		if len(w.inflight) >= maxInflight {
+			if err := w.ack(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// ack waits for the oldest outstanding write.
+func (w *writer) ack() error {
+	ch := w.inflight[0]
+	w.inflight = w.inflight[1:]
+	p, err := w.c.wait(w.ctx, ch)
+	if err != nil {
+		return err
+	}
+	return statusError(p, fxpStatus)
+}
+
+func (w *writer) Close() error {
+	var errs []error
+	for len(w.inflight) > 0 {
+		if err := w.ack(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+	errs = append(errs, w.c.close(w.ctx, w.handle))
+	return errors.Join(errs...)
+}
+
+// readRequest is an outstanding read of the chunk at off.
+type readRequest struct {
+	off int64
+	ch  <-chan packet
+}
+
+// reader keeps up to maxInflight reads ahead of the consumer.
+type reader struct {
+	ctx    context.Context
+	c      *conn
+	handle string
+	next   int64
+	queue  []readRequest
+	buf    []byte
+	eof    bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		for len(r.queue) < maxInflight {
+			ch, err := r.c.send(fxpRead, buffer(nil).str(r.handle).u64(uint64(r.next)).u32(chunkSize))
+			if err != nil {
+				return 0, err
+			}
+			r.queue = append(r.queue, readRequest{off: r.next, ch: ch})
+			r.next += chunkSize
+		}
+
+		req := r.queue[0]
+		r.queue = r.queue[1:]
+		resp, err := r.c.wait(r.ctx, req.ch)
+		if err != nil {
+			return 0, err
+		}
+		if err := statusError(resp, fxpData); err != nil {
+			if isStatus(err, fxEOF) {
+				r.eof = true
+				continue
+			}
+			return 0, err
+		}
+		d := &decoder{b: resp.data}
+		r.buf = d.bytes()
+		if d.err != nil {
+			return 0, d.err
+		}
+		if len(r.buf) == 0 {
+			r.eof = true
+		} else if len(r.buf) < chunkSize {
+			// A short read leaves a gap before the requests queued
+			// after it; drop them and continue right after this one.
+			r.queue = nil
+			r.next = req.off + int64(len(r.buf))
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	return r.c.close(r.ctx, r.handle)
+}
@@ -0,0 +1,49 @@
+package sftpstore
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSSHArgs(t *testing.T) {
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"sftp://host/models", []string{"-s", "--", "host", "sftp"}},
+		{"sftp://alice@host:2222/models", []string{"-p", "2222", "-s", "--", "alice@host", "sftp"}},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := sshArgs(u)
+		if err != nil {
+			t.Errorf("sshArgs(%q): %v", tt.url, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sshArgs(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSSHArgsRejectsOptions(t *testing.T) {
+	for _, raw := range []string{
+		"sftp://-oProxyCommand=touch%20%2Ftmp%2Fpwn@host/x",
+		"sftp://-oProxyCommand=id/x",
+	} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args, err := sshArgs(u); err == nil {
+			t.Errorf("sshArgs(%q) = %q, want error", raw, args)
+		}
+		if _, err := New(raw); err == nil {
+			t.Errorf("New(%q) succeeded, want error", raw)
+		}
+	}
+}
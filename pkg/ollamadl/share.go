@@ -0,0 +1,200 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reindexInterval is how often a BlobServer or RegistryServer looks for
+// new downloads when asked for something it doesn't know.
+const reindexInterval = 10 * time.Second
+
+// BlobServer serves the layer files of the models saved under a directory
+// of a FileStore as a web seed, at /sha256-<hex>, so other machines can
+// pull them with Options.Webseeds instead of from the registry. Only
+// finished files of the size their manifest gives are served; layers
+// stored decompressed aren't, since their content no longer matches their
+// digest. Clients check what they get against the manifest anyway.
+type BlobServer struct {
+	*localIndex
+}
+
+// NewBlobServer returns a BlobServer for the models saved under dir.
+func (d *Downloader) NewBlobServer(ctx context.Context, dir string) (*BlobServer, error) {
+	x, err := d.newLocalIndex(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &BlobServer{x}, nil
+}
+
+// localIndex finds the models saved under a directory, and the blobs
+// stored with them, for serving.
+type localIndex struct {
+	d     *Downloader
+	store *FileStore
+	dir   string
+
+	mu      sync.Mutex
+	blobs   map[string]string // stored file by digest
+	models  map[Reference]string
+	indexed time.Time
+}
+
+func (d *Downloader) newLocalIndex(ctx context.Context, dir string) (*localIndex, error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if !ok {
+		return nil, errors.New("serving models needs a local file store")
+	}
+	x := &localIndex{d: d, store: store, dir: dir}
+	if err := x.index(ctx); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Len returns how many blobs the index knows.
+func (x *localIndex) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.blobs)
+}
+
+// index finds the models and blobs stored under the directory. A model
+// is known by the reference its pull recorded.
+func (x *localIndex) index(ctx context.Context) error {
+	blobs := make(map[string]string)
+	models := make(map[Reference]string)
+	err := walkSaved(ctx, x.store, x.dir, func(dir string, manifest *Manifest) error {
+		files, err := x.d.layerFiles(x.store, dir, manifest)
+		if err != nil {
+			return err
+		}
+		sizes := make(map[string]int64) // file size by short hash
+		byHash := make(map[string]string)
+		for _, file := range files {
+			hash := x.d.layerFileHash(path.Base(file.name))
+			sizes[hash], byHash[hash] = file.size, file.name
+		}
+		for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+			hash, err := getShortHash(layer)
+			if err != nil || layerCompression(layer.MediaType) != "" {
+				continue
+			}
+			if name, ok := byHash[hash]; ok && sizes[hash] == layer.Size {
+				if _, ok := blobs[layer.Digest]; !ok {
+					blobs[layer.Digest] = name
+				}
+			}
+		}
+
+		var meta pullMetadata
+		if found, err := readStoredJSON(ctx, x.store, path.Join(dir, MetadataFileName), &meta); err != nil {
+			return err
+		} else if found {
+			if ref, err := ParseReference(meta.Model); err == nil {
+				models[ref] = dir
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	x.mu.Lock()
+	x.blobs, x.models, x.indexed = blobs, models, time.Now()
+	x.mu.Unlock()
+	x.d.log.Debug("Indexed models to serve", "dir", x.dir, "models", len(models), "blobs", len(blobs))
+	return nil
+}
+
+// refresh indexes the directory again, unless that was done recently.
+func (x *localIndex) refresh(ctx context.Context) {
+	x.mu.Lock()
+	stale := time.Since(x.indexed) > reindexInterval
+	x.mu.Unlock()
+	if !stale {
+		return
+	}
+	if err := x.index(ctx); err != nil {
+		x.d.log.Warn("Failed to index models", "dir", x.dir, "error", err)
+	}
+}
+
+// lookup returns the stored file of digest, indexing the directory again
+// if it isn't known.
+func (x *localIndex) lookup(ctx context.Context, digest string) (string, bool) {
+	x.mu.Lock()
+	name, ok := x.blobs[digest]
+	x.mu.Unlock()
+	if ok {
+		return name, true
+	}
+	x.refresh(ctx)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	name, ok = x.blobs[digest]
+	return name, ok
+}
+
+// model returns the directory of ref's model, indexing the directory again
+// if it isn't known.
+func (x *localIndex) model(ctx context.Context, ref Reference) (string, bool) {
+	x.mu.Lock()
+	dir, ok := x.models[ref]
+	x.mu.Unlock()
+	if ok {
+		return dir, true
+	}
+	x.refresh(ctx)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	dir, ok = x.models[ref]
+	return dir, ok
+}
+
+var blobPathPattern = regexp.MustCompile(`^/sha256-[0-9a-f]{64}$`)
+
+func (s *BlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !blobPathPattern.MatchString(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+	digest := strings.Replace(r.URL.Path[1:], "-", ":", 1)
+	name, ok := s.lookup(r.Context(), digest)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveFile(w, r, name)
+}
+
+// serveFile serves a stored file, with range requests so clients can
+// resume.
+func (x *localIndex) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := os.Open(x.store.Path(name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	x.d.log.Debug("Serving blob", "path", name, "client", r.RemoteAddr, "range", r.Header.Get("Range"))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
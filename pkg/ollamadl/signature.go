@@ -0,0 +1,376 @@
+package ollamadl
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Media types and annotations of cosign signatures. Cosign attaches them
+// to a manifest under the tag sha256-<hex>.sig, or, on registries with the
+// OCI referrers API, as manifests referring to it.
+const (
+	cosignSignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	cosignPayloadMediaType      = "application/vnd.dev.cosign.simplesigning.v1+json"
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertAnnotation        = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// Extensions of Fulcio certificates naming the OIDC issuer that vouched
+// for the signer's identity.
+var (
+	fulcioIssuerOID   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// maxSignaturePayload bounds the size of a signed payload.
+const maxSignaturePayload = 1 << 20
+
+// SignaturePolicy is what cosign signatures of a model's manifest must
+// satisfy for Options.SignaturePolicy. One valid signature is enough.
+type SignaturePolicy struct {
+	// Keys are the public keys of signers, for signatures made with
+	// cosign sign --key.
+	Keys []crypto.PublicKey
+
+	// Identity, when set, accepts keyless signatures whose Fulcio
+	// certificate names this identity, an email address or URI, and was
+	// issued on the word of the OIDC issuer Issuer, if set. The
+	// certificate must chain up to Roots, e.g. Fulcio's root, at the time
+	// the transparency log recorded the signature: the signature's cosign
+	// bundle must hold a log entry for it whose signed entry timestamp
+	// one of RekorKeys, e.g. Rekor's public key, verifies. Keyless
+	// signatures without such a bundle are refused.
+	Identity  string
+	Issuer    string
+	Roots     *x509.CertPool
+	RekorKeys []crypto.PublicKey
+}
+
+// ParsePublicKey parses a PEM-encoded public key, as cosign generate-key-pair
+// writes to cosign.pub.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// signatureManifest is the part of a cosign signature manifest that
+// matters here.
+type signatureManifest struct {
+	Layers []struct {
+		Layer
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// simpleSigning is the payload cosign signs.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// verifySignature checks that ref's manifest carries a signature
+// satisfying Options.SignaturePolicy.
+func (d *Downloader) verifySignature(ctx context.Context, ref Reference, manifest *Manifest) error {
+	policy := d.opts.SignaturePolicy
+	sigs, err := d.signatureManifests(ctx, ref, manifest.Digest)
+	if err != nil {
+		return fmt.Errorf("%w: fetching signatures: %v", ErrSignature, err)
+	}
+	if len(sigs) == 0 {
+		return fmt.Errorf("%w: %s is not signed", ErrSignature, ref)
+	}
+
+	var errs []error
+	for _, sig := range sigs {
+		for _, layer := range sig.Layers {
+			if layer.MediaType != cosignPayloadMediaType {
+				continue
+			}
+			err := d.verifySignatureLayer(ctx, ref, manifest.Digest, layer.Layer, layer.Annotations, policy)
+			if err == nil {
+				d.log.Info("Verified signature", "model", ref.String(), "digest", manifest.Digest)
+				return nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("%w: %s has no cosign signatures", ErrSignature, ref)
+	}
+	return fmt.Errorf("%w: no signature of %s satisfies the policy: %v", ErrSignature, ref, errors.Join(errs...))
+}
+
+// signatureManifests fetches the signature manifests attached to the
+// manifest digest, by tag and through the referrers API.
+func (d *Downloader) signatureManifests(ctx context.Context, ref Reference, digest string) ([]signatureManifest, error) {
+	tags := []string{strings.Replace(digest, ":", "-", 1) + ".sig"}
+	if r, ok := d.baseRegistry().(*httpRegistry); ok {
+		referrers, err := r.referrers(ctx, ref, digest, cosignSignatureArtifactType)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, referrers...)
+	}
+
+	var sigs []signatureManifest
+	for _, tag := range tags {
+		m, err := d.registry.GetManifest(ctx, Reference{Name: ref.Name, Tag: tag})
+		if errors.Is(err, ErrManifestNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var sig signatureManifest
+		if err := json.Unmarshal(m.raw, &sig); err != nil {
+			return nil, fmt.Errorf("signature manifest %s: %v", tag, err)
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// verifySignatureLayer checks one signature: its payload must name digest
+// and be signed by a key or certificate the policy accepts.
+func (d *Downloader) verifySignatureLayer(ctx context.Context, ref Reference, digest string, layer Layer, annotations map[string]string, policy *SignaturePolicy) error {
+	if layer.Size > maxSignaturePayload {
+		return fmt.Errorf("signature payload of %d bytes", layer.Size)
+	}
+	body, _, err := d.registry.GetBlob(ctx, ref, layer.Digest, 0)
+	if err != nil {
+		return err
+	}
+	payload, err := io.ReadAll(io.LimitReader(body, maxSignaturePayload))
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if got := sha256Digest(payload); got != layer.Digest {
+		return fmt.Errorf("%w for signature payload %s: got %s", ErrDigestMismatch, layer.Digest, got)
+	}
+	var signed simpleSigning
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("signature payload: %v", err)
+	}
+	if signed.Critical.Type != "cosign container image signature" {
+		return fmt.Errorf("signature payload of type %q", signed.Critical.Type)
+	}
+	if signed.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s", signed.Critical.Image.DockerManifestDigest)
+	}
+	sig, err := base64.StdEncoding.DecodeString(annotations[cosignSignatureAnnotation])
+	if err != nil || len(sig) == 0 {
+		return errors.New("signature missing or not base64")
+	}
+
+	for _, key := range policy.Keys {
+		if verifyWithKey(key, payload, sig) {
+			return nil
+		}
+	}
+	if policy.Identity == "" || annotations[cosignCertAnnotation] == "" {
+		return errors.New("not signed by any of the keys")
+	}
+	cert, err := verifyCertificate(annotations, payload, sig, policy)
+	if err != nil {
+		return err
+	}
+	if !verifyWithKey(cert.PublicKey, payload, sig) {
+		return errors.New("signature doesn't match its certificate")
+	}
+	return nil
+}
+
+// verifyWithKey reports whether sig is key's signature of payload.
+func verifyWithKey(key crypto.PublicKey, payload, sig []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hash[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil ||
+			rsa.VerifyPSS(key, crypto.SHA256, hash[:], sig, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
+
+// verifyCertificate checks the Fulcio certificate of the keyless signature
+// sig of payload against the policy and returns it.
+func verifyCertificate(annotations map[string]string, payload, sig []byte, policy *SignaturePolicy) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(annotations[cosignCertAnnotation]))
+	if block == nil {
+		return nil, errors.New("certificate is not PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signedAt, err := verifyBundle(annotations[cosignBundleAnnotation], payload, sig, cert, policy.RekorKeys)
+	if err != nil {
+		return nil, fmt.Errorf("transparency log: %v", err)
+	}
+	intermediates := x509.NewCertPool()
+	for rest := []byte(annotations[cosignChainAnnotation]); ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+			intermediates.AddCert(c)
+		}
+	}
+	// Fulcio certificates are valid for minutes; what counts is that the
+	// signature was made meanwhile.
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         policy.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("certificate: %v", err)
+	}
+
+	var uris []string
+	for _, u := range cert.URIs {
+		uris = append(uris, u.String())
+	}
+	if !slices.Contains(cert.EmailAddresses, policy.Identity) && !slices.Contains(uris, policy.Identity) {
+		return nil, fmt.Errorf("certificate is for %s", strings.Join(append(cert.EmailAddresses, uris...), ", "))
+	}
+	if policy.Issuer != "" {
+		if issuer := certificateIssuer(cert); issuer != policy.Issuer {
+			return nil, fmt.Errorf("certificate was issued on the word of %q", issuer)
+		}
+	}
+	return cert, nil
+}
+
+// rekorBundle is the transparency log entry cosign attaches to a keyless
+// signature, with the log's signed promise to include it.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+// rekorPayload is what the signed entry timestamp signs. Its fields are in
+// the order of RFC 8785 canonical JSON, the form the log signs.
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// rekorEntry is the part of a hashedrekord or rekord log entry that ties
+// it to a signature.
+type rekorEntry struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyBundle checks that the cosign bundle of a keyless signature is
+// signed by one of the transparency log's keys and records sig of payload
+// by cert, and returns when the log recorded it.
+func verifyBundle(data string, payload, sig []byte, cert *x509.Certificate, keys []crypto.PublicKey) (time.Time, error) {
+	if len(keys) == 0 {
+		return time.Time{}, errors.New("no keys to verify entries with")
+	}
+	if data == "" {
+		return time.Time{}, errors.New("signature has no bundle")
+	}
+	var bundle rekorBundle
+	if err := json.Unmarshal([]byte(data), &bundle); err != nil {
+		return time.Time{}, fmt.Errorf("bundle: %v", err)
+	}
+	signed, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !slices.ContainsFunc(keys, func(key crypto.PublicKey) bool {
+		return verifyWithKey(key, signed, bundle.SignedEntryTimestamp)
+	}) {
+		return time.Time{}, errors.New("entry timestamp not signed by any of the keys")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("entry: %v", err)
+	}
+	var entry rekorEntry
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("entry: %v", err)
+	}
+	if entry.Kind != "hashedrekord" && entry.Kind != "rekord" {
+		return time.Time{}, fmt.Errorf("entry of kind %q", entry.Kind)
+	}
+	hash := sha256.Sum256(payload)
+	if h := entry.Spec.Data.Hash; h.Algorithm != "sha256" || h.Value != hex.EncodeToString(hash[:]) {
+		return time.Time{}, errors.New("entry is for another payload")
+	}
+	if !bytes.Equal(entry.Spec.Signature.Content, sig) {
+		return time.Time{}, errors.New("entry is for another signature")
+	}
+	block, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+	if block == nil || !bytes.Equal(block.Bytes, cert.Raw) {
+		return time.Time{}, errors.New("entry is for another certificate")
+	}
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// certificateIssuer returns the OIDC issuer a Fulcio certificate names.
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(fulcioIssuerV2OID):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(fulcioIssuerOID):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+package ollamadl
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keylessSignature is a keyless cosign signature of payload: a certificate
+// for release@example.com that was valid for ten minutes a year ago,
+// signed by a root in roots, and a bundle that rekorKey signed.
+type keylessSignature struct {
+	payload, sig []byte
+	annotations  map[string]string
+	roots        *x509.CertPool
+	rekorKey     *ecdsa.PrivateKey
+	signedAt     time.Time
+}
+
+func newKeylessSignature(t *testing.T) *keylessSignature {
+	t.Helper()
+	signedAt := time.Now().Add(-365 * 24 * time.Hour).Truncate(time.Second)
+	rootKey := newTestKey(t)
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             signedAt.Add(-time.Hour),
+		NotAfter:              signedAt.Add(10 * 365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ = x509.ParseCertificate(rootDER)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	leafKey := newTestKey(t)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		NotBefore:      signedAt.Add(-time.Minute),
+		NotAfter:       signedAt.Add(9 * time.Minute),
+		EmailAddresses: []string{"release@example.com"},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}, root, leafKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+
+	payload := []byte(`{"critical":{"type":"cosign container image signature"}}`)
+	sig := signTest(t, leafKey, payload)
+
+	ks := &keylessSignature{payload: payload, sig: sig, roots: roots, rekorKey: newTestKey(t), signedAt: signedAt}
+	ks.annotations = map[string]string{
+		cosignCertAnnotation:   string(certPEM),
+		cosignBundleAnnotation: ks.bundle(t, "hashedrekord", payload, sig, certPEM),
+	}
+	return ks
+}
+
+// bundle returns a bundle of a log entry of kind recording sig of payload
+// by the certificate certPEM, signed with the Rekor key.
+func (ks *keylessSignature) bundle(t *testing.T, kind string, payload, sig, certPEM []byte) string {
+	t.Helper()
+	hash := sha256.Sum256(payload)
+	entry := map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       kind,
+		"spec": map[string]any{
+			"data": map[string]any{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(hash[:])}},
+			"signature": map[string]any{
+				"content":   base64.StdEncoding.EncodeToString(sig),
+				"publicKey": map[string]string{"content": base64.StdEncoding.EncodeToString(certPEM)},
+			},
+		},
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadJSON := rekorPayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: ks.signedAt.Unix(),
+		LogID:          "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d",
+		LogIndex:       42,
+	}
+	signed, _ := json.Marshal(payloadJSON)
+	data, err := json.Marshal(rekorBundle{SignedEntryTimestamp: signTest(t, ks.rekorKey, signed), Payload: payloadJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func (ks *keylessSignature) policy() *SignaturePolicy {
+	return &SignaturePolicy{Identity: "release@example.com", Roots: ks.roots, RekorKeys: []crypto.PublicKey{ks.rekorKey.Public()}}
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func signTest(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	t.Helper()
+	hash := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestVerifyCertificateKeyless(t *testing.T) {
+	ks := newKeylessSignature(t)
+	cert, err := verifyCertificate(ks.annotations, ks.payload, ks.sig, ks.policy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyWithKey(cert.PublicKey, ks.payload, ks.sig) {
+		t.Error("signature doesn't match the certificate")
+	}
+}
+
+func TestVerifyCertificateKeylessRefused(t *testing.T) {
+	ks := newKeylessSignature(t)
+	certPEM := []byte(ks.annotations[cosignCertAnnotation])
+	otherSig := signTest(t, newTestKey(t), ks.payload)
+
+	tests := []struct {
+		name   string
+		change func(annotations map[string]string, policy *SignaturePolicy)
+		want   string
+	}{
+		{"no bundle", func(a map[string]string, _ *SignaturePolicy) { delete(a, cosignBundleAnnotation) }, "no bundle"},
+		{"no Rekor keys", func(_ map[string]string, p *SignaturePolicy) { p.RekorKeys = nil }, "no keys"},
+		{"other Rekor key", func(_ map[string]string, p *SignaturePolicy) {
+			p.RekorKeys = []crypto.PublicKey{newTestKey(t).Public()}
+		}, "not signed"},
+		{"later time", func(a map[string]string, _ *SignaturePolicy) {
+			a[cosignBundleAnnotation] = strings.Replace(a[cosignBundleAnnotation],
+				`"integratedTime":`, `"integratedTime":1`, 1)
+		}, "not signed"},
+		{"other signature", func(a map[string]string, _ *SignaturePolicy) {
+			a[cosignBundleAnnotation] = ks.bundle(t, "hashedrekord", ks.payload, otherSig, certPEM)
+		}, "another signature"},
+		{"other payload", func(a map[string]string, _ *SignaturePolicy) {
+			a[cosignBundleAnnotation] = ks.bundle(t, "hashedrekord", []byte("{}"), ks.sig, certPEM)
+		}, "another payload"},
+		{"other kind", func(a map[string]string, _ *SignaturePolicy) {
+			a[cosignBundleAnnotation] = ks.bundle(t, "intoto", ks.payload, ks.sig, certPEM)
+		}, "kind"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := make(map[string]string)
+			for k, v := range ks.annotations {
+				annotations[k] = v
+			}
+			policy := ks.policy()
+			tt.change(annotations, policy)
+			_, err := verifyCertificate(annotations, ks.payload, ks.sig, policy)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("verifyCertificate() error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
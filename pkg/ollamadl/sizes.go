@@ -0,0 +1,67 @@
+package ollamadl
+
+import (
+	"context"
+	"sync"
+)
+
+// sizeFetches is how many manifests TagSizes fetches at a time.
+const sizeFetches = 8
+
+// TagSize is the size of a tag's download, as its manifest states it.
+type TagSize struct {
+	Tag string
+	// Digest is the manifest's digest; tags with the same digest are
+	// aliases.
+	Digest string
+	// Size is the total of the tag's layers and config, what pulling it
+	// downloads. Weights is the part of it that are model weights and
+	// projectors, which roughly bounds the memory the model needs.
+	Size    int64
+	Weights int64
+	// Err is why the tag's manifest couldn't be fetched, if it couldn't.
+	Err error
+}
+
+// TagSizes fetches the manifests of the given tags of ref's repository,
+// or of all its tags if there are none, and returns their sizes in the
+// same order. Nothing is downloaded but the manifests.
+func (d *Downloader) TagSizes(ctx context.Context, ref Reference, tags []string) ([]TagSize, error) {
+	if len(tags) == 0 {
+		var err error
+		if tags, err = d.Tags(ctx, ref); err != nil {
+			return nil, err
+		}
+	}
+
+	sizes := make([]TagSize, len(tags))
+	sem := make(chan struct{}, sizeFetches)
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		sizes[i].Tag = tag
+		wg.Add(1)
+		go func(size *TagSize) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			manifest, err := d.fetchManifest(ctx, Reference{Name: ref.Name, Tag: size.Tag})
+			if err != nil {
+				size.Err = err
+				return
+			}
+			size.Digest = manifest.Digest
+			size.Size = manifest.Config.Size
+			for _, layer := range manifest.Layers {
+				size.Size += layer.Size
+				if layer.MediaType == ModelMediaType || layer.MediaType == ProjectorMediaType {
+					size.Weights += layer.Size
+				}
+			}
+		}(&sizes[i])
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
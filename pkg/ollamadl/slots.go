@@ -0,0 +1,110 @@
+package ollamadl
+
+import (
+	"context"
+	"sync"
+)
+
+// layerSlots hands out the Options.Concurrency download slots of a
+// Downloader to the layers of all the pulls it runs at once. Pulls take
+// turns: a freed slot goes to the next pull in line with a layer waiting,
+// so one pull with many layers doesn't hold back the others until it is
+// done. A nil layerSlots doesn't limit anything.
+type layerSlots struct {
+	mu   sync.Mutex
+	free int
+	// waiting holds the layers waiting for a slot, by pull; turns lists
+	// the pulls with layers waiting, next first.
+	waiting map[*Resolution][]chan struct{}
+	turns   []*Resolution
+}
+
+func newLayerSlots(n int) *layerSlots {
+	if n <= 0 {
+		return nil
+	}
+	return &layerSlots{free: n, waiting: make(map[*Resolution][]chan struct{})}
+}
+
+// acquire waits for a slot for a layer of res.
+func (s *layerSlots) acquire(ctx context.Context, res *Resolution) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if s.free > 0 && len(s.turns) == 0 {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	if len(s.waiting[res]) == 0 {
+		s.turns = append(s.turns, res)
+	}
+	s.waiting[res] = append(s.waiting[res], ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-ready:
+		// Granted meanwhile; pass it on.
+		s.releaseLocked()
+		return ctx.Err()
+	default:
+	}
+	queue := s.waiting[res]
+	for i, ch := range queue {
+		if ch == ready {
+			s.waiting[res] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(s.waiting[res]) == 0 {
+		s.dropTurn(res)
+	}
+	return ctx.Err()
+}
+
+// release frees a slot acquired before.
+func (s *layerSlots) release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+func (s *layerSlots) releaseLocked() {
+	if len(s.turns) == 0 {
+		s.free++
+		return
+	}
+	res := s.turns[0]
+	s.turns = s.turns[1:]
+	queue := s.waiting[res]
+	close(queue[0])
+	if queue = queue[1:]; len(queue) > 0 {
+		s.waiting[res] = queue
+		s.turns = append(s.turns, res)
+	} else {
+		delete(s.waiting, res)
+	}
+}
+
+// dropTurn takes res out of the line of pulls waiting.
+func (s *layerSlots) dropTurn(res *Resolution) {
+	delete(s.waiting, res)
+	for i, r := range s.turns {
+		if r == res {
+			s.turns = append(s.turns[:i], s.turns[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// State is a small database of the models pulled into local directories:
+// which model each directory holds, and the digest, size and modification
+// time of its files. It lets List and Verify answer without scanning or
+// hashing. It is kept as a JSON file that is rewritten whole under a lock,
+// so several processes can share it.
+type State struct {
+	path string
+}
+
+// OpenState returns the State kept in the file at path, which needn't
+// exist yet.
+func OpenState(path string) *State {
+	return &State{path: path}
+}
+
+// StateRecord is what State knows about one directory.
+type StateRecord struct {
+	// Dir is the absolute path of the directory.
+	Dir    string      `json:"dir"`
+	Model  string      `json:"model"`
+	Digest string      `json:"digest,omitempty"`
+	Pulled time.Time   `json:"pulled"`
+	Files  []StateFile `json:"files"`
+}
+
+// StateFile is a file of a StateRecord.
+type StateFile struct {
+	// Name is the file's path relative to the record's Dir.
+	Name    string    `json:"name"`
+	Digest  string    `json:"digest"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Checked is when the file was last found to match Digest, by
+	// downloading or verifying it, or zero if it never was.
+	Checked time.Time `json:"checked"`
+}
+
+type stateData struct {
+	Models []*StateRecord `json:"models"`
+}
+
+// Records returns everything the State knows, sorted by directory.
+func (s *State) Records() ([]StateRecord, error) {
+	data, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]StateRecord, len(data.Models))
+	for i, record := range data.Models {
+		records[i] = *record
+	}
+	return records, nil
+}
+
+// List returns the models the State knows under the local directory root,
+// as Downloader.List would find them, without reading their directories.
+// The Manifest of each has only its Digest set.
+func (s *State) List(root string) ([]LocalModel, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.Records()
+	if err != nil {
+		return nil, err
+	}
+	var models []LocalModel
+	for _, record := range records {
+		rel, err := filepath.Rel(absRoot, record.Dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		model := LocalModel{
+			Dir:      path.Join(filepath.ToSlash(root), filepath.ToSlash(rel)),
+			Manifest: &Manifest{Digest: record.Digest},
+			Pulled:   record.Pulled,
+		}
+		if model.Ref, err = ParseReference(record.Model); err != nil {
+			return nil, err
+		}
+		for _, file := range record.Files {
+			model.Size += file.Size
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+func (s *State) read() (*stateData, error) {
+	var data stateData
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", s.path, err)
+	}
+	return &data, nil
+}
+
+// update applies fn to the records by directory and saves the result.
+func (s *State) update(ctx context.Context, fn func(records map[string]*StateRecord)) error {
+	unlock, err := lockFile(ctx, s.path+".lock", func() {})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	records := make(map[string]*StateRecord, len(data.Models))
+	for _, record := range data.Models {
+		records[record.Dir] = record
+	}
+	fn(records)
+
+	data.Models = data.Models[:0]
+	for _, record := range records {
+		data.Models = append(data.Models, record)
+	}
+	sort.Slice(data.Models, func(i, j int) bool { return data.Models[i].Dir < data.Models[j].Dir })
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(raw, '\n'))
+}
+
+// stateDir returns the absolute path State records destDir under, or ""
+// if pulls into the store aren't recorded.
+func (d *Downloader) stateDir(destDir string) (string, error) {
+	store, ok := d.opts.Store.(*FileStore)
+	if d.opts.State == nil || !ok {
+		return "", nil
+	}
+	return filepath.Abs(store.Path(destDir))
+}
+
+// recordPull records the pull of res in the State. The files in checked
+// were downloaded and had their digests checked; other files keep the
+// Checked time of their last record if they haven't changed since.
+func (d *Downloader) recordPull(ctx context.Context, res *Resolution, checked map[string]bool) error {
+	dir, err := d.stateDir(res.DestDir)
+	if dir == "" || err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	files, err := statFiles(dir, res)
+	if err != nil {
+		return err
+	}
+	return d.opts.State.update(ctx, func(records map[string]*StateRecord) {
+		previous := make(map[string]StateFile)
+		if old := records[dir]; old != nil {
+			for _, file := range old.Files {
+				previous[file.Name] = file
+			}
+		}
+		for i, file := range files {
+			if checked[path.Join(filepath.ToSlash(res.DestDir), file.Name)] {
+				files[i].Checked = now
+			} else if old, ok := previous[file.Name]; ok && old.sameAs(file) {
+				files[i].Checked = old.Checked
+			}
+		}
+		records[dir] = &StateRecord{
+			Dir:    dir,
+			Model:  res.Ref.String(),
+			Digest: res.Manifest.Digest,
+			Pulled: now,
+			Files:  files,
+		}
+	})
+}
+
+// statFiles describes the files of res's jobs that exist, as StateFiles
+// relative to dir.
+func statFiles(dir string, res *Resolution) ([]StateFile, error) {
+	files := make([]StateFile, 0, len(res.Jobs))
+	for _, job := range res.Jobs {
+		rel, err := filepath.Rel(filepath.FromSlash(res.DestDir), filepath.FromSlash(job.DestPath))
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(filepath.Join(dir, rel))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // e.g. merged split parts
+		} else if err != nil {
+			return nil, err
+		}
+		files = append(files, StateFile{
+			Name:    filepath.ToSlash(rel),
+			Digest:  job.Layer.Digest,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		})
+	}
+	return files, nil
+}
+
+// sameAs reports whether f and other describe the same content, going by
+// digest, size and modification time.
+func (f StateFile) sameAs(other StateFile) bool {
+	return f.Digest == other.Digest && f.Size == other.Size && f.ModTime.Equal(other.ModTime)
+}
+
+// recordedFiles returns the files the State has for res's directory, by
+// name, and the files of res as they are now. Both are empty if pulls into
+// the store aren't recorded.
+func (d *Downloader) recordedFiles(res *Resolution) (map[string]StateFile, []StateFile, error) {
+	dir, err := d.stateDir(res.DestDir)
+	if dir == "" || err != nil {
+		return nil, nil, err
+	}
+	data, err := d.opts.State.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	known := make(map[string]StateFile)
+	for _, record := range data.Models {
+		if record.Dir == dir {
+			for _, file := range record.Files {
+				known[file.Name] = file
+			}
+		}
+	}
+	files, err := statFiles(dir, res)
+	return known, files, err
+}
+
+// unchangedFiles returns the files of res, by DestPath, that the State has
+// checked before and that haven't changed since.
+func (d *Downloader) unchangedFiles(res *Resolution) (map[string]bool, error) {
+	known, files, err := d.recordedFiles(res)
+	if err != nil {
+		return nil, err
+	}
+	unchanged := make(map[string]bool)
+	for _, file := range files {
+		if old, ok := known[file.Name]; ok && !old.Checked.IsZero() && old.sameAs(file) {
+			unchanged[path.Join(filepath.ToSlash(res.DestDir), file.Name)] = true
+		}
+	}
+	return unchanged, nil
+}
+
+// alteredFiles returns the files of res, by DestPath, that the State has a
+// different size for than they have now, e.g. because they were truncated
+// or written to since.
+func (d *Downloader) alteredFiles(res *Resolution) (map[string]bool, error) {
+	known, files, err := d.recordedFiles(res)
+	if err != nil {
+		return nil, err
+	}
+	altered := make(map[string]bool)
+	for _, file := range files {
+		if old, ok := known[file.Name]; ok && old.Digest == file.Digest && old.Size != file.Size {
+			altered[path.Join(filepath.ToSlash(res.DestDir), file.Name)] = true
+		}
+	}
+	return altered, nil
+}
+
+// recordVerify records in the State which files of the pull recorded for
+// res's directory a verification found good or bad.
+func (d *Downloader) recordVerify(ctx context.Context, res *Resolution, results []VerifyResult) error {
+	dir, err := d.stateDir(res.DestDir)
+	if dir == "" || err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	files, err := statFiles(dir, res)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]StateFile, len(files))
+	for _, file := range files {
+		current[file.Name] = file
+	}
+	outcome := make(map[string]VerifyResult, len(results))
+	for _, result := range results {
+		outcome[result.Path] = result
+	}
+	return d.opts.State.update(ctx, func(records map[string]*StateRecord) {
+		record := records[dir]
+		if record == nil {
+			return
+		}
+		for i, file := range record.Files {
+			stat, ok := current[file.Name]
+			result, checked := outcome[path.Join(filepath.ToSlash(res.DestDir), file.Name)]
+			if !ok || !checked || stat.Digest != file.Digest || result.Trusted || result.Skipped {
+				continue
+			}
+			if result.Err != nil {
+				record.Files[i].Checked = time.Time{}
+				continue
+			}
+			stat.Checked = now
+			record.Files[i] = stat
+		}
+	})
+}
+
+// forget drops what the State knows about destDir.
+func (d *Downloader) forget(ctx context.Context, destDir string) error {
+	dir, err := d.stateDir(destDir)
+	if dir == "" || err != nil {
+		return err
+	}
+	return d.opts.State.update(ctx, func(records map[string]*StateRecord) {
+		delete(records, dir)
+	})
+}
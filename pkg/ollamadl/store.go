@@ -0,0 +1,219 @@
+package ollamadl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore is where downloaded files are written. Names are slash-separated
+// paths relative to the store, such as "library-llama3.2-latest/model-dde5aa3fc5ff.gguf".
+//
+// Writes are staged: data written through Create only becomes visible under
+// its name once Commit is called after the digest has been verified.
+type BlobStore interface {
+	// Exists reports whether a committed object with the given name exists.
+	Exists(ctx context.Context, name string) (bool, error)
+	// ResumeOffset returns how many bytes are staged for name from an
+	// earlier, interrupted write, or 0 if there are none.
+	ResumeOffset(ctx context.Context, name string) (int64, error)
+	// Create opens name for writing. With resume set, writes are appended to
+	// the staged data; otherwise any staged data is discarded.
+	Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error)
+	// Commit publishes the data staged for name. layer describes the
+	// content, for stores that record metadata alongside it.
+	Commit(ctx context.Context, name string, layer Layer) error
+}
+
+// BlobOpener is implemented by stores that can read committed objects back,
+// which verification and post-processing such as LICENSES.txt need.
+type BlobOpener interface {
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// StagedOpener is implemented by stores that can read back staged data. A
+// resumed download has to hash the bytes it already has, so stores without
+// it download from the beginning unless they implement HashStateStore.
+type StagedOpener interface {
+	OpenStaged(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// HashStateStore is implemented by stores that can keep, next to a staged
+// download, how far its digest has been computed. Stores that also
+// implement StagedOpener use it to hash less of the staged data on resume;
+// the others can resume without reading anything back, when the saved state
+// covers exactly what ResumeOffset reports.
+type HashStateStore interface {
+	// SaveHashState replaces the saved state of name's staged download.
+	SaveHashState(ctx context.Context, name string, state []byte) error
+	// LoadHashState returns the saved state of name's staged download, or
+	// nil if there is none.
+	LoadHashState(ctx context.Context, name string) ([]byte, error)
+	// RemoveHashState deletes the saved state of name's staged download,
+	// if any.
+	RemoveHashState(ctx context.Context, name string) error
+}
+
+// StagedCounter is implemented by writers from Create that buffer data
+// before staging it. Staged returns how many bytes from the beginning of
+// the file are staged so far, which is what ResumeOffset would report if
+// the download were interrupted now.
+type StagedCounter interface {
+	Staged() int64
+}
+
+// BlobRemover is implemented by stores that can delete committed objects.
+// Removing an object that doesn't exist is not an error.
+type BlobRemover interface {
+	Remove(ctx context.Context, name string) error
+}
+
+// FileStore stores files in the local filesystem under Root. Staged data is
+// kept next to the final file with a .tmp suffix.
+type FileStore struct {
+	Root string
+	// FileMode and DirMode, when set, are the permissions of the files and
+	// directories the store creates, whatever the umask. Files get theirs
+	// while staged, so they never appear with other permissions. Zero
+	// means 0644 and 0755, less the umask.
+	FileMode, DirMode os.FileMode
+	// Owner, when set, is who the files and directories the store
+	// creates belong to, e.g. the account of a container that will read
+	// them when the store is written as root. It needs the privilege to
+	// change owners, and isn't supported on Windows.
+	Owner *FileOwner
+}
+
+// FileOwner is the numeric owner and group of files, for FileStore.Owner.
+// -1 leaves either as it is.
+type FileOwner struct {
+	UID, GID int
+}
+
+// NewFileStore returns a FileStore rooted at root; "" means the current
+// directory.
+func NewFileStore(root string) *FileStore {
+	return &FileStore{Root: root}
+}
+
+// Path returns the local path of the named file. On Windows, paths longer
+// than MAX_PATH come back absolute with the \\?\ prefix, so deep model
+// directories work.
+func (s *FileStore) Path(name string) string {
+	return longPath(filepath.Join(s.Root, filepath.FromSlash(name)))
+}
+
+func (s *FileStore) stagedPath(name string) string {
+	return s.Path(name) + ".tmp"
+}
+
+func (s *FileStore) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := os.Stat(s.Path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *FileStore) ResumeOffset(ctx context.Context, name string) (int64, error) {
+	info, err := os.Stat(s.stagedPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *FileStore) Create(ctx context.Context, name string, resume bool) (io.WriteCloser, error) {
+	tempPath := s.stagedPath(name)
+
+	// Ensure the directory exists
+	if err := s.mkdirAll(filepath.Dir(tempPath)); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(tempPath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if s.FileMode != 0 {
+		err = f.Chmod(s.FileMode)
+	}
+	if o := s.Owner; o != nil && err == nil {
+		err = f.Chown(o.UID, o.GID)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// chown gives the local path p to Owner, if set.
+func (s *FileStore) chown(p string) error {
+	if o := s.Owner; o != nil {
+		return os.Lchown(p, o.UID, o.GID)
+	}
+	return nil
+}
+
+// mkdirAll creates the local directory dir and its parents as needed, with
+// DirMode and Owner if set.
+func (s *FileStore) mkdirAll(dir string) error {
+	if s.DirMode == 0 && s.Owner == nil {
+		return os.MkdirAll(dir, 0755)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := s.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	mode := s.DirMode
+	if mode == 0 {
+		mode = 0755
+	}
+	if err := os.Mkdir(dir, mode); errors.Is(err, os.ErrExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if s.DirMode != 0 {
+		// Mkdir's mode is subject to the umask.
+		if err := os.Chmod(dir, s.DirMode); err != nil {
+			return err
+		}
+	}
+	return s.chown(dir)
+}
+
+func (s *FileStore) Commit(ctx context.Context, name string, layer Layer) error {
+	// Rename the temporary file to the final destination
+	return os.Rename(s.stagedPath(name), s.Path(name))
+}
+
+func (s *FileStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(s.Path(name))
+}
+
+func (s *FileStore) Remove(ctx context.Context, name string) error {
+	err := os.Remove(s.Path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileStore) OpenStaged(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(s.stagedPath(name))
+}
@@ -0,0 +1,220 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFileName is the file in a model's directory that Pull saves the
+// manifest to, so later syncs can tell which files belong to which layers.
+const ManifestFileName = "manifest.json"
+
+// saveManifest writes manifest to destDir.
+func saveManifest(ctx context.Context, store BlobStore, destDir string, manifest Manifest) error {
+	data, err := manifestJSON(manifest)
+	if err != nil {
+		return err
+	}
+	return writeFile(ctx, store, path.Join(filepath.ToSlash(destDir), ManifestFileName), ManifestMediaType, data)
+}
+
+// manifestJSON returns the content saveManifest writes for manifest.
+func manifestJSON(manifest Manifest) ([]byte, error) {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// SavedManifest returns the manifest saved in destDir by an earlier pull, or
+// nil if there is none or the store can't read files back.
+func (d *Downloader) SavedManifest(ctx context.Context, destDir string) (*Manifest, error) {
+	return readSavedManifest(ctx, d.opts.Store, destDir)
+}
+
+func readSavedManifest(ctx context.Context, store BlobStore, destDir string) (*Manifest, error) {
+	var manifest Manifest
+	found, err := readStoredJSON(ctx, store, path.Join(filepath.ToSlash(destDir), ManifestFileName), &manifest)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// readStoredJSON decodes the stored file name into v. It reports false if
+// there is no such file or the store can't read files back.
+func readStoredJSON(ctx context.Context, store BlobStore, name string, v any) (bool, error) {
+	data, found, err := readStored(ctx, store, name)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("reading %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// savedResolution returns the model pulled into destDir and its files,
+// going by the manifest and reference the pull saved.
+func (d *Downloader) savedResolution(ctx context.Context, destDir string) (*Resolution, error) {
+	manifest, err := d.SavedManifest(ctx, destDir)
+	if err != nil {
+		return nil, err
+	} else if manifest == nil {
+		return nil, fmt.Errorf("no %s in %s", ManifestFileName, destDir)
+	}
+	var (
+		ref  Reference
+		meta pullMetadata
+	)
+	if found, err := readStoredJSON(ctx, d.opts.Store, path.Join(path.Clean(filepath.ToSlash(destDir)), MetadataFileName), &meta); err != nil {
+		return nil, err
+	} else if found {
+		if ref, err = ParseReference(meta.Model); err != nil {
+			return nil, err
+		}
+		manifest.Digest = meta.Digest
+	}
+	jobs, err := d.planJobs(ref, manifest, destDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolution{Ref: ref, Manifest: *manifest, DestDir: destDir, Jobs: jobs}, nil
+}
+
+// readStored returns the content of the stored file name. It reports false
+// if there is no such file or the store can't read files back.
+func readStored(ctx context.Context, store BlobStore, name string) ([]byte, bool, error) {
+	opener, ok := store.(BlobOpener)
+	if !ok {
+		return nil, false, nil
+	}
+	if exists, err := store.Exists(ctx, name); err != nil || !exists {
+		return nil, false, err
+	}
+	r, err := opener.Open(ctx, name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// SyncResult describes what a Sync changed.
+type SyncResult struct {
+	*Resolution
+	// Downloaded are the layers that were new or had changed.
+	Downloaded []DownloadJob
+	// Obsolete are files of the previous manifest that the current one no
+	// longer has. They are only deleted when Sync is asked to.
+	Obsolete []string
+}
+
+// Sync brings a model pulled into destDir up to date with the registry. It
+// compares the current manifest with the one saved by the previous pull and
+// downloads only new and changed layers; a file whose name stayed the same
+// but whose layer changed is replaced. With removeObsolete set, files the
+// current manifest no longer has are deleted. With Options.State, files
+// whose size changed since they were recorded are replaced too.
+//
+// Without a saved manifest Sync works like Pull: files already present are
+// assumed to be up to date.
+func (d *Downloader) Sync(ctx context.Context, ref Reference, destDir string, removeObsolete bool) (*SyncResult, error) {
+	done := d.startPull(ctx, ref, destDir)
+	result, err := d.sync(ctx, ref, destDir, removeObsolete)
+	var res *Resolution
+	if result != nil {
+		res = result.Resolution
+	}
+	return result, done(res, err)
+}
+
+func (d *Downloader) sync(ctx context.Context, ref Reference, destDir string, removeObsolete bool) (*SyncResult, error) {
+	// Resolving first tells which directory Options.VersionedDirs puts
+	// the current version in.
+	res, err := d.Resolve(ctx, ref, destDir)
+	if err != nil {
+		return nil, d.opts.Hooks.fail(ctx, nil, err)
+	}
+	unlock, err := d.lockDir(ctx, res.DestDir)
+	if err != nil {
+		return nil, d.opts.Hooks.fail(ctx, nil, err)
+	}
+	defer unlock()
+	saved, err := d.SavedManifest(ctx, res.DestDir)
+	if err != nil {
+		return nil, d.opts.Hooks.fail(ctx, nil, err)
+	}
+
+	had := make(map[string]string) // digest by file
+	if saved != nil {
+		old, err := d.planJobs(ref, saved, res.DestDir)
+		if err != nil {
+			return nil, d.opts.Hooks.fail(ctx, nil, err)
+		}
+		for _, job := range old {
+			had[job.DestPath] = job.Layer.Digest
+		}
+	}
+
+	altered, err := d.alteredFiles(res)
+	if err != nil {
+		return nil, d.opts.Hooks.fail(ctx, nil, err)
+	}
+
+	result := &SyncResult{}
+	remover, canRemove := d.opts.Store.(BlobRemover)
+	for _, job := range res.Jobs {
+		exists, err := d.opts.Store.Exists(ctx, job.DestPath)
+		if err != nil {
+			return nil, d.opts.Hooks.fail(ctx, &job, err)
+		}
+		digest, known := had[job.DestPath]
+		delete(had, job.DestPath)
+		switch {
+		case !exists:
+		case known && digest != job.Layer.Digest, altered[job.DestPath]:
+			if !canRemove {
+				return nil, d.opts.Hooks.fail(ctx, &job, fmt.Errorf("%s changed but the store cannot replace files", job.DestPath))
+			}
+			if err := remover.Remove(ctx, job.DestPath); err != nil {
+				return nil, d.opts.Hooks.fail(ctx, &job, err)
+			}
+		default:
+			continue
+		}
+		result.Downloaded = append(result.Downloaded, job)
+	}
+	for file := range had {
+		result.Obsolete = append(result.Obsolete, file)
+	}
+	sort.Strings(result.Obsolete)
+
+	result.Resolution = res
+	if err := d.pull(ctx, res); err != nil {
+		return result, err
+	}
+
+	if removeObsolete && len(result.Obsolete) > 0 {
+		if !canRemove {
+			return result, d.opts.Hooks.fail(ctx, nil, errors.New("store cannot delete files"))
+		}
+		for _, file := range result.Obsolete {
+			if err := remover.Remove(ctx, file); err != nil {
+				return result, d.opts.Hooks.fail(ctx, nil, err)
+			}
+		}
+	}
+	return result, nil
+}
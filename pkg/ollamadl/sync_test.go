@@ -0,0 +1,38 @@
+package ollamadl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncReportsErrors(t *testing.T) {
+	d, reg, root := newTestDownloader(t)
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, testGGUF("weights"))
+	reg.AddManifest(ref, config, model)
+
+	if err := os.MkdirAll(filepath.Join(root, "m"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "m", ManifestFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var reported []error
+	d.opts.Hooks.OnError = func(ctx context.Context, job *DownloadJob, err error) {
+		reported = append(reported, err)
+	}
+
+	_, err = d.Sync(context.Background(), ref, "m", false)
+	if err == nil {
+		t.Fatal("Sync() with an unreadable saved manifest succeeded")
+	}
+	if len(reported) != 1 || reported[0] != err {
+		t.Errorf("OnError got %v, want Sync's error %v", reported, err)
+	}
+}
@@ -0,0 +1,440 @@
+package ollamadl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template/parse"
+	"unicode"
+)
+
+// jinjaConverter translates an Ollama (Go text/template) chat template into a
+// Jinja2 chat template of the kind used by Hugging Face tokenizers, vLLM and
+// llama.cpp. The conversion is best effort: it covers the constructs found in
+// Ollama's library templates and reports anything it doesn't understand.
+type jinjaConverter struct {
+	out strings.Builder
+
+	// dots is the stack of Jinja expressions "." refers to.
+	dots []string
+	// vars maps template variables (without "$") to Jinja expressions.
+	vars map[string]string
+	// loopVars counts nested loops so element names stay unique.
+	loopVars int
+
+	// usesLegacy is set when .System or .Prompt is referenced and the
+	// preamble deriving them from messages must be emitted.
+	usesLegacy bool
+	// truncated is set once .Response was reached. Like Ollama, everything
+	// after it is dropped since that is where the model's reply goes.
+	truncated bool
+}
+
+// ConvertTemplate returns the Jinja2 equivalent of an Ollama template.
+func ConvertTemplate(text string) (string, error) {
+	tree := parse.New("template")
+	tree.Mode = parse.SkipFuncCheck
+	treeSet := map[string]*parse.Tree{}
+	if _, err := tree.Parse(text, "{{", "}}", treeSet); err != nil {
+		return "", err
+	}
+
+	c := &jinjaConverter{dots: []string{""}, vars: map[string]string{"": ""}}
+	if err := c.list(tree.Root); err != nil {
+		return "", err
+	}
+
+	if !c.usesLegacy {
+		return c.out.String(), nil
+	}
+
+	// Templates written against .System/.Prompt render a single turn; pick
+	// the latest system and user messages for them.
+	preamble := "{%- set ns = namespace(system='', prompt='') -%}\n" +
+		"{%- for message in messages -%}\n" +
+		"{%- if message['role'] == 'system' -%}{%- set ns.system = message['content'] -%}\n" +
+		"{%- elif message['role'] == 'user' -%}{%- set ns.prompt = message['content'] -%}\n" +
+		"{%- endif -%}\n" +
+		"{%- endfor -%}\n"
+	return preamble + c.out.String(), nil
+}
+
+// Template returns the template layer of the model pulled into destDir, as
+// Ollama wrote it. The layer is found through the saved manifest, so file
+// names from Options.FileTemplates are honored.
+func (d *Downloader) Template(ctx context.Context, destDir string) (string, error) {
+	res, err := d.savedResolution(ctx, destDir)
+	if err != nil {
+		return "", err
+	}
+
+	var file string
+	for _, job := range res.Jobs {
+		if baseMediaType(job.Layer.MediaType) != TemplateMediaType {
+			continue
+		}
+		if file != "" {
+			return "", fmt.Errorf("several template layers in %s", destDir)
+		}
+		file = job.DestPath
+	}
+	if file == "" {
+		return "", fmt.Errorf("no template layer in %s", destDir)
+	}
+
+	data, found, err := readStored(ctx, d.opts.Store, file)
+	if err != nil {
+		return "", err
+	} else if !found {
+		return "", fmt.Errorf("%s is missing", file)
+	}
+	return string(data), nil
+}
+
+func (c *jinjaConverter) list(list *parse.ListNode) error {
+	if list == nil {
+		return nil
+	}
+	for _, node := range list.Nodes {
+		if err := c.node(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *jinjaConverter) node(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.TextNode:
+		if c.truncated {
+			return nil
+		}
+		text := string(n.Text)
+		if strings.Contains(text, "{{") || strings.Contains(text, "{%") || strings.Contains(text, "{#") {
+			text = "{% raw %}" + text + "{% endraw %}"
+		}
+		c.out.WriteString(text)
+	case *parse.CommentNode:
+	case *parse.ActionNode:
+		return c.action(n)
+	case *parse.IfNode:
+		return c.ifNode(n, "if")
+	case *parse.RangeNode:
+		return c.rangeNode(n)
+	case *parse.WithNode:
+		return c.withNode(n)
+	default:
+		return fmt.Errorf("unsupported template construct: %s", node)
+	}
+	return nil
+}
+
+func (c *jinjaConverter) action(n *parse.ActionNode) error {
+	if c.truncated {
+		return nil
+	}
+	if isResponse(n.Pipe) {
+		c.truncated = true
+		return nil
+	}
+
+	expr, err := c.pipe(n.Pipe)
+	if err != nil {
+		return err
+	}
+
+	if len(n.Pipe.Decl) > 0 {
+		name := n.Pipe.Decl[len(n.Pipe.Decl)-1].Ident[0][1:]
+		c.vars[name] = name
+		fmt.Fprintf(&c.out, "{%% set %s = %s %%}", name, expr)
+		return nil
+	}
+
+	// Printing a whole element (e.g. a tool definition) gives JSON in Ollama.
+	if len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+		if _, ok := n.Pipe.Cmds[0].Args[0].(*parse.DotNode); ok {
+			expr = "(" + expr + " | tojson)"
+		}
+	}
+	fmt.Fprintf(&c.out, "{{ %s }}", expr)
+	return nil
+}
+
+func (c *jinjaConverter) ifNode(n *parse.IfNode, keyword string) error {
+	cond, err := c.pipe(n.Pipe)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&c.out, "{%% %s %s %%}", keyword, cond)
+	if err := c.list(n.List); err != nil {
+		return err
+	}
+
+	if n.ElseList != nil {
+		// {{ else if }} is parsed as an else branch holding a single if.
+		if len(n.ElseList.Nodes) == 1 {
+			if elif, ok := n.ElseList.Nodes[0].(*parse.IfNode); ok {
+				return c.ifNode(elif, "elif")
+			}
+		}
+		c.out.WriteString("{% else %}")
+		if err := c.list(n.ElseList); err != nil {
+			return err
+		}
+	}
+	c.out.WriteString("{% endif %}")
+	return nil
+}
+
+func (c *jinjaConverter) rangeNode(n *parse.RangeNode) error {
+	seq, err := c.pipe(&parse.PipeNode{Cmds: n.Pipe.Cmds})
+	if err != nil {
+		return err
+	}
+
+	elem := "item"
+	if strings.HasSuffix(seq, "messages") {
+		elem = "message"
+	} else if strings.HasSuffix(seq, "tools") {
+		elem = "tool"
+	} else if strings.HasSuffix(seq, "['tool_calls']") {
+		elem = "tool_call"
+	}
+	if c.loopVars > 0 {
+		elem = fmt.Sprintf("%s%d", elem, c.loopVars)
+	}
+	c.loopVars++
+	defer func() { c.loopVars-- }()
+
+	saved := make(map[string]string, len(c.vars))
+	for k, v := range c.vars {
+		saved[k] = v
+	}
+	defer func() { c.vars = saved }()
+
+	switch len(n.Pipe.Decl) {
+	case 1:
+		c.vars[n.Pipe.Decl[0].Ident[0][1:]] = elem
+	case 2:
+		c.vars[n.Pipe.Decl[0].Ident[0][1:]] = "loop.index0"
+		c.vars[n.Pipe.Decl[1].Ident[0][1:]] = elem
+	}
+
+	fmt.Fprintf(&c.out, "{%% for %s in %s %%}", elem, seq)
+	c.dots = append(c.dots, elem)
+	err = c.list(n.List)
+	c.dots = c.dots[:len(c.dots)-1]
+	if err != nil {
+		return err
+	}
+	if n.ElseList != nil {
+		c.out.WriteString("{% else %}")
+		if err := c.list(n.ElseList); err != nil {
+			return err
+		}
+	}
+	c.out.WriteString("{% endfor %}")
+	return nil
+}
+
+func (c *jinjaConverter) withNode(n *parse.WithNode) error {
+	value, err := c.pipe(&parse.PipeNode{Cmds: n.Pipe.Cmds})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&c.out, "{%% if %s %%}", value)
+	c.dots = append(c.dots, value)
+	err = c.list(n.List)
+	c.dots = c.dots[:len(c.dots)-1]
+	if err != nil {
+		return err
+	}
+	if n.ElseList != nil {
+		c.out.WriteString("{% else %}")
+		if err := c.list(n.ElseList); err != nil {
+			return err
+		}
+	}
+	c.out.WriteString("{% endif %}")
+	return nil
+}
+
+// pipe converts a pipeline. As in Go templates, the result of each command is
+// passed as the final argument of the next.
+func (c *jinjaConverter) pipe(p *parse.PipeNode) (string, error) {
+	var prev string
+	for i, cmd := range p.Cmds {
+		var extra []string
+		if i > 0 {
+			extra = []string{prev}
+		}
+		expr, err := c.command(cmd.Args, extra)
+		if err != nil {
+			return "", err
+		}
+		prev = expr
+	}
+	return prev, nil
+}
+
+func (c *jinjaConverter) command(args []parse.Node, extra []string) (string, error) {
+	ident, ok := args[0].(*parse.IdentifierNode)
+	if !ok {
+		if len(args) > 1 || len(extra) > 0 {
+			return "", fmt.Errorf("unsupported method call: %s", args[0])
+		}
+		return c.operand(args[0])
+	}
+
+	var operands []string
+	for _, arg := range args[1:] {
+		op, err := c.operand(arg)
+		if err != nil {
+			return "", err
+		}
+		operands = append(operands, op)
+	}
+	operands = append(operands, extra...)
+	return callFunction(ident.Ident, operands)
+}
+
+func callFunction(name string, args []string) (string, error) {
+	binary := map[string]string{"eq": "==", "ne": "!=", "lt": "<", "le": "<=", "gt": ">", "ge": ">=", "and": "and", "or": "or"}
+	if op, ok := binary[name]; ok {
+		if len(args) < 2 {
+			return "", fmt.Errorf("%s needs at least two arguments", name)
+		}
+		if name == "eq" && len(args) > 2 {
+			var alts []string
+			for _, arg := range args[1:] {
+				alts = append(alts, args[0]+" == "+arg)
+			}
+			return "(" + strings.Join(alts, " or ") + ")", nil
+		}
+		return "(" + strings.Join(args, " "+op+" ") + ")", nil
+	}
+
+	switch name {
+	case "not":
+		return "(not " + args[0] + ")", nil
+	case "len":
+		return "(" + args[0] + " | length)", nil
+	case "index":
+		expr := args[0]
+		for _, idx := range args[1:] {
+			expr += "[" + idx + "]"
+		}
+		return expr, nil
+	case "slice":
+		switch len(args) {
+		case 1:
+			return args[0], nil
+		case 2:
+			return args[0] + "[" + args[1] + ":]", nil
+		case 3:
+			return args[0] + "[" + args[1] + ":" + args[2] + "]", nil
+		}
+	case "json":
+		return "(" + args[0] + " | tojson)", nil
+	case "print":
+		return "(" + strings.Join(args, " ~ ") + ")", nil
+	case "currentDate":
+		return "strftime_now('%Y-%m-%d')", nil
+	}
+	return "", fmt.Errorf("unsupported template function: %s", name)
+}
+
+func (c *jinjaConverter) operand(node parse.Node) (string, error) {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		if dot := c.dots[len(c.dots)-1]; dot != "" {
+			return dot, nil
+		}
+		return "", errors.New("printing the root context is not supported")
+	case *parse.FieldNode:
+		return c.field(c.dots[len(c.dots)-1], n.Ident)
+	case *parse.VariableNode:
+		name := n.Ident[0][1:]
+		base, ok := c.vars[name]
+		if !ok {
+			return "", fmt.Errorf("undefined variable: %s", n.Ident[0])
+		}
+		return c.field(base, n.Ident[1:])
+	case *parse.ChainNode:
+		base, err := c.operand(n.Node)
+		if err != nil {
+			return "", err
+		}
+		return c.field(base, n.Field)
+	case *parse.PipeNode:
+		return c.pipe(n)
+	case *parse.StringNode:
+		quoted, _ := json.Marshal(n.Text)
+		return string(quoted), nil
+	case *parse.NumberNode:
+		return n.Text, nil
+	case *parse.BoolNode:
+		if n.True {
+			return "true", nil
+		}
+		return "false", nil
+	case *parse.NilNode:
+		return "none", nil
+	}
+	return "", fmt.Errorf("unsupported operand: %s", node)
+}
+
+// field resolves a chain of field names against base, which is "" for the
+// template's root context.
+func (c *jinjaConverter) field(base string, idents []string) (string, error) {
+	expr := base
+	for _, ident := range idents {
+		if expr == "" {
+			switch ident {
+			case "System":
+				c.usesLegacy = true
+				expr = "ns.system"
+			case "Prompt":
+				c.usesLegacy = true
+				expr = "ns.prompt"
+			case "Response", "Suffix":
+				expr = "''"
+			default:
+				expr = snakeCase(ident)
+			}
+			continue
+		}
+		expr += "['" + snakeCase(ident) + "']"
+	}
+	return expr, nil
+}
+
+func isResponse(p *parse.PipeNode) bool {
+	if len(p.Decl) > 0 || len(p.Cmds) != 1 || len(p.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch n := p.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return len(n.Ident) == 1 && n.Ident[0] == "Response"
+	case *parse.VariableNode:
+		return len(n.Ident) == 2 && n.Ident[0] == "$" && n.Ident[1] == "Response"
+	}
+	return false
+}
+
+// snakeCase turns a Go field name such as ToolCalls into tool_calls.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package ollamadl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyPreamble is what ConvertTemplate puts before templates using
+// .System or .Prompt.
+const legacyPreamble = "{%- set ns = namespace(system='', prompt='') -%}\n" +
+	"{%- for message in messages -%}\n" +
+	"{%- if message['role'] == 'system' -%}{%- set ns.system = message['content'] -%}\n" +
+	"{%- elif message['role'] == 'user' -%}{%- set ns.prompt = message['content'] -%}\n" +
+	"{%- endif -%}\n" +
+	"{%- endfor -%}\n"
+
+func TestConvertTemplate(t *testing.T) {
+	tests := []struct {
+		name, template, want string
+	}{
+		{
+			name:     "messages",
+			template: "{{ range .Messages }}<|{{ .Role }}|>{{ .Content }}{{ end }}<|assistant|>",
+			want:     "{% for message in messages %}<|{{ message['role'] }}|>{{ message['content'] }}{% endfor %}<|assistant|>",
+		},
+		{
+			name:     "if else",
+			template: `{{ range .Messages }}{{ if eq .Role "user" }}[INST] {{ .Content }} [/INST]{{ else }}{{ .Content }}</s>{{ end }}{{ end }}`,
+			want:     `{% for message in messages %}{% if (message['role'] == "user") %}[INST] {{ message['content'] }} [/INST]{% else %}{{ message['content'] }}</s>{% endif %}{% endfor %}`,
+		},
+		{
+			name:     "else if",
+			template: `{{ range .Messages }}{{ if eq .Role "system" }}S{{ else if eq .Role "user" }}U{{ else }}A{{ end }}{{ end }}`,
+			want:     `{% for message in messages %}{% if (message['role'] == "system") %}S{% elif (message['role'] == "user") %}U{% else %}A{% endif %}{% endfor %}`,
+		},
+		{
+			name:     "and, not and len",
+			template: `{{ range .Messages }}{{ if and (eq .Role "assistant") (not .Content) (len .ToolCalls) }}x{{ end }}{{ end }}`,
+			want:     `{% for message in messages %}{% if ((message['role'] == "assistant") and (not message['content']) and (message['tool_calls'] | length)) %}x{% endif %}{% endfor %}`,
+		},
+		{
+			name:     "range variables",
+			template: "{{ range $i, $m := .Messages }}{{ $i }}:{{ $m.Content }}{{ end }}",
+			want:     "{% for message in messages %}{{ loop.index0 }}:{{ message['content'] }}{% endfor %}",
+		},
+		{
+			name:     "nested range",
+			template: "{{ range .Messages }}{{ range .ToolCalls }}{{ .Function.Name }}{{ end }}{{ end }}",
+			want:     "{% for message in messages %}{% for tool_call1 in message['tool_calls'] %}{{ tool_call1['function']['name'] }}{% endfor %}{% endfor %}",
+		},
+		{
+			name:     "tools",
+			template: "{{ if .Tools }}{{ range .Tools }}{{ . }}{{ end }}{{ end }}",
+			want:     "{% if tools %}{% for tool in tools %}{{ (tool | tojson) }}{% endfor %}{% endif %}",
+		},
+		{
+			name:     "root in range",
+			template: "{{ range .Messages }}{{ if $.Tools }}t{{ end }}{{ end }}",
+			want:     "{% for message in messages %}{% if tools %}t{% endif %}{% endfor %}",
+		},
+		{
+			name:     "trim markers",
+			template: "{{- range .Messages }}\n{{ .Content -}}\n{{ end }}",
+			want:     "{% for message in messages %}\n{{ message['content'] }}{% endfor %}",
+		},
+		{
+			name:     "system and prompt",
+			template: "{{ if .System }}<|system|>{{ .System }}{{ end }}<|user|>{{ .Prompt }}<|assistant|>",
+			want:     legacyPreamble + "{% if ns.system %}<|system|>{{ ns.system }}{% endif %}<|user|>{{ ns.prompt }}<|assistant|>",
+		},
+		{
+			name:     "response",
+			template: "{{ .Prompt }}\n{{ .Response }}<|end|>",
+			want:     legacyPreamble + "{{ ns.prompt }}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConvertTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ConvertTemplate() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertTemplateErrors(t *testing.T) {
+	for _, template := range []string{
+		`{{ printf "%s" .Prompt }}`,
+		"{{ range .Messages }}{{ end",
+	} {
+		if got, err := ConvertTemplate(template); err == nil {
+			t.Errorf("ConvertTemplate(%q) = %q, want an error", template, got)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	d, reg, root := newTestDownloader(t)
+	d.fileTemplates[TemplateMediaType] = "chat-%s.tmpl"
+	ref, err := ParseReference("test/model:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := reg.AddBlob("application/vnd.docker.container.image.v1+json", []byte("{}"))
+	model := reg.AddBlob(ModelMediaType, testGGUF("weights"))
+	template := reg.AddBlob(TemplateMediaType, []byte("{{ .Prompt }}"))
+	reg.AddManifest(ref, config, model, template)
+	if _, err := d.Pull(context.Background(), ref, "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := d.Template(context.Background(), "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "{{ .Prompt }}" {
+		t.Errorf("Template() = %q", got)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Template(context.Background(), "empty"); err == nil || !strings.Contains(err.Error(), ManifestFileName) {
+		t.Errorf("Template() of a directory without a manifest: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package ollamadl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// webseedRegistry fetches blobs from web seeds before falling back to the
+// registry. A web seed is any HTTP server holding blobs under the names
+// Ollama gives them, e.g. <seed>/sha256-<hex>, such as a static server over
+// another machine's ~/.ollama/models/blobs; an IPFS gateway serves as one
+// for the blobs a CID is known for. Seeds are not trusted: what they serve
+// is checked against the manifest's digest like anything else, and a seed
+// that failed to serve a blob isn't asked for it again.
+type webseedRegistry struct {
+	Registry
+	client *http.Client
+	seeds  []blobSeed
+	log    *slog.Logger
+
+	mu sync.Mutex
+	// served is the seed each blob last came from, and bad the seeds that
+	// failed to serve a blob, by blob.
+	served map[string]string
+	bad    map[string]map[string]bool
+}
+
+// blobSeed is a web seed, named by its URL.
+type blobSeed struct {
+	name string
+	// url returns where the seed has a blob, or "" if it doesn't.
+	url func(digest string) string
+}
+
+// webseed returns the seed of an HTTP server holding blobs as
+// sha256-<hex>.
+func webseed(base string) blobSeed {
+	base = strings.TrimSuffix(base, "/")
+	return blobSeed{name: base, url: func(digest string) string {
+		return base + "/" + strings.Replace(digest, ":", "-", 1)
+	}}
+}
+
+func newWebseedRegistry(reg Registry, client *http.Client, seeds []blobSeed, log *slog.Logger) *webseedRegistry {
+	return &webseedRegistry{
+		Registry: reg,
+		client:   client,
+		seeds:    seeds,
+		log:      log,
+		served:   make(map[string]string),
+		bad:      make(map[string]map[string]bool),
+	}
+}
+
+func (r *webseedRegistry) GetBlob(ctx context.Context, ref Reference, digest string, offset int64) (io.ReadCloser, int64, error) {
+	for _, seed := range r.seeds {
+		blobURL := seed.url(digest)
+		r.mu.Lock()
+		bad := r.bad[digest][seed.name]
+		r.mu.Unlock()
+		if blobURL == "" || bad {
+			continue
+		}
+		body, got, err := r.getSeedBlob(ctx, blobURL, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, 0, ctx.Err()
+			}
+			r.log.Debug("Web seed failed", "seed", seed.name, "digest", digest, "error", err)
+			continue
+		}
+		r.log.Debug("Fetching from web seed", "seed", seed.name, "digest", digest)
+		r.mu.Lock()
+		r.served[digest] = seed.name
+		r.mu.Unlock()
+		return body, got, nil
+	}
+
+	r.mu.Lock()
+	delete(r.served, digest)
+	r.mu.Unlock()
+	return r.Registry.GetBlob(ctx, ref, digest, offset)
+}
+
+// getSeedBlob requests a blob from a seed, resuming at offset if the seed
+// supports ranges.
+func (r *webseedRegistry) getSeedBlob(ctx context.Context, blobURL string, offset int64) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", blobURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+	default:
+		resp.Body.Close()
+		return nil, 0, &HTTPError{StatusCode: resp.StatusCode, URL: blobURL}
+	}
+	return resp.Body, offset, nil
+}
+
+// distrust stops asking the seed that last served digest for it, after
+// fetching it from there failed, and reports whether there was one.
+func (r *webseedRegistry) distrust(digest string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seed, ok := r.served[digest]
+	if !ok {
+		return false
+	}
+	if r.bad[digest] == nil {
+		r.bad[digest] = make(map[string]bool)
+	}
+	r.bad[digest][seed] = true
+	delete(r.served, digest)
+	r.log.Warn("Web seed failed to serve a blob; using other sources for it", "seed", seed, "digest", digest)
+	return true
+}
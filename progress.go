@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+	"github.com/schollz/progressbar/v3"
+)
+
+// barReporter renders one terminal progress bar per layer.
+type barReporter struct {
+	mu   sync.Mutex
+	bars map[string]*progressbar.ProgressBar
+}
+
+func newBarReporter() *barReporter {
+	return &barReporter{bars: make(map[string]*progressbar.ProgressBar)}
+}
+
+func (r *barReporter) bar(job ollamadl.DownloadJob) *progressbar.ProgressBar {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bar, ok := r.bars[job.DestPath]
+	if !ok {
+		size := job.Size
+		if size <= 0 {
+			// A bar of zero bytes can't show a fraction; draw a spinner.
+			size = -1
+		}
+		bar = progressbar.DefaultBytes(size, job.DestPath)
+		r.bars[job.DestPath] = bar
+	}
+	return bar
+}
+
+func (r *barReporter) LayerStarted(job ollamadl.DownloadJob, offset int64) {
+	r.bar(job).Set64(offset)
+}
+
+func (r *barReporter) BytesWritten(job ollamadl.DownloadJob, n int64) {
+	r.bar(job).Add64(n)
+}
+
+func (r *barReporter) LayerCompleted(job ollamadl.DownloadJob) {
+	r.bar(job).Finish()
+}
+
+func (r *barReporter) LayerFailed(job ollamadl.DownloadJob, err error) {
+	r.bar(job).Exit()
+	// A retry once the other layers are done gets a bar of its own.
+	r.mu.Lock()
+	delete(r.bars, job.DestPath)
+	r.mu.Unlock()
+}
+
+// verifyBar returns an Options.VerifyProgress rendering one terminal
+// progress bar for all the files being hashed.
+func verifyBar() func(hashed, total int64) {
+	var bar *progressbar.ProgressBar
+	return func(hashed, total int64) {
+		if bar == nil {
+			if total == 0 {
+				return
+			}
+			bar = progressbar.DefaultBytes(total, "verifying")
+		}
+		bar.Set64(hashed)
+		if hashed >= total {
+			bar.Finish()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runPrune implements "ollama-dl prune", which deletes layer files that no
+// saved manifest references any more.
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "Only report what would be deleted")
+	fs.BoolVar(dryRun, "dry-run", false, "Same as -n")
+	fs.Parse(args)
+	root := "."
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	d, err := ollamadl.New(ollamadl.Options{
+		Store:  ollamadl.NewFileStore(""),
+		Logger: newLogger(slog.LevelInfo),
+	})
+	if err != nil {
+		return err
+	}
+	result, err := d.Prune(commandContext(), root, *dryRun)
+	if err != nil {
+		return fmt.Errorf("prune failed: %w", err)
+	}
+
+	if *dryRun {
+		for _, file := range result.Removed {
+			fmt.Println("Would delete", file)
+		}
+		fmt.Printf("%d files, %s reclaimable\n", len(result.Removed), formatSize(result.Bytes))
+	} else {
+		fmt.Printf("Deleted %d files, %s\n", len(result.Removed), formatSize(result.Bytes))
+	}
+	return nil
+}
+
+// formatSize formats a byte count for people, e.g. "4.7 GB".
+func formatSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runRm implements "ollama-dl rm", which deletes downloaded models.
+func runRm(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	root := fs.String("root", ".", "Directory to find models by name in, and to look for links into removed models in")
+	dryRun := fs.Bool("n", false, "Only report what would be deleted")
+	fs.BoolVar(dryRun, "dry-run", false, "Same as -n")
+	var statePath string
+	addStateFlag(fs, &statePath)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Usage: ollama-dl rm [flags] <dir|name[:tag]>...")
+		os.Exit(1)
+	}
+
+	d, err := ollamadl.New(ollamadl.Options{
+		Store:  ollamadl.NewFileStore(""),
+		Logger: newLogger(slog.LevelInfo),
+		State:  openState(statePath),
+	})
+	if err != nil {
+		return err
+	}
+	ctx := commandContext()
+
+	var models []ollamadl.LocalModel
+	for _, arg := range fs.Args() {
+		dir, err := findModelDir(ctx, d, *root, arg, &models)
+		if err != nil {
+			return err
+		}
+		result, err := d.RemoveModel(ctx, *root, dir, *dryRun)
+		if err != nil {
+			return fmt.Errorf("removing %s: %w", arg, err)
+		}
+
+		verb := "Deleted"
+		if *dryRun {
+			verb = "Would delete"
+			for _, file := range result.Removed {
+				fmt.Println(verb, file)
+			}
+			var moved []string
+			for file := range result.Moved {
+				moved = append(moved, file)
+			}
+			sort.Strings(moved)
+			for _, file := range moved {
+				fmt.Println("Would move", file, "to", result.Moved[file], "which links to it")
+			}
+		}
+		fmt.Printf("%s %s: %d files, %s\n", verb, dir, len(result.Removed), formatSize(result.Bytes))
+	}
+	return nil
+}
+
+// findModelDir returns the directory of the model arg names: a directory,
+// or a model reference looked up among the models under root, which are
+// listed into models on first use.
+func findModelDir(ctx context.Context, d *ollamadl.Downloader, root, arg string, models *[]ollamadl.LocalModel) (string, error) {
+	if info, err := os.Stat(filepath.Join(arg, ollamadl.ManifestFileName)); err == nil && info.Mode().IsRegular() {
+		return arg, nil
+	}
+	ref, err := ollamadl.ParseReference(arg)
+	if err != nil {
+		return "", err
+	}
+	if *models == nil {
+		if *models, err = d.List(ctx, root); err != nil {
+			return "", err
+		}
+	}
+
+	var dirs []string
+	for _, m := range *models {
+		if m.Ref == ref {
+			dirs = append(dirs, m.Dir)
+		}
+	}
+	switch len(dirs) {
+	case 0:
+		return "", fmt.Errorf("%s is not downloaded under %s", ref, root)
+	case 1:
+		return dirs[0], nil
+	default:
+		return "", fmt.Errorf("%s is downloaded to several directories, name one: %s", ref, strings.Join(dirs, ", "))
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runRunArgs implements "ollama-dl run-args", which prints the llama.cpp
+// command line that runs a downloaded model with its parameters and chat
+// template.
+func runRunArgs(args []string) error {
+	fs := flag.NewFlagSet("run-args", flag.ExitOnError)
+	program := fs.String("program", "llama-server", "llama.cpp program to run: llama-server or llama-cli, or a path to either")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: ollama-dl run-args [-program llama-server|llama-cli] <dir>")
+		os.Exit(1)
+	}
+
+	d, err := ollamadl.New(ollamadl.Options{
+		Store:  ollamadl.NewFileStore(""),
+		Logger: newLogger(slog.LevelInfo),
+	})
+	if err != nil {
+		return err
+	}
+	cmdArgs, err := d.LlamaCppArgs(commandContext(), fs.Arg(0), *program)
+	if err != nil {
+		return err
+	}
+	quoted := make([]string, len(cmdArgs))
+	for i, arg := range cmdArgs {
+		quoted[i] = shellQuote(arg)
+	}
+	fmt.Println(strings.Join(quoted, " "))
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell, if it needs it.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runSearch implements "ollama-dl search", which looks models up in the
+// library on ollama.com.
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	long := fs.Bool("l", false, "Also print each model's description")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Println("Usage: ollama-dl search [-l] <query>")
+		os.Exit(1)
+	}
+
+	d, err := ollamadl.New(ollamadl.Options{Logger: newLogger(slog.LevelInfo)})
+	if err != nil {
+		return err
+	}
+	results, err := d.Search(commandContext(), strings.Join(fs.Args(), " "))
+	if err != nil {
+		return fmt.Errorf("search failed: %w", err)
+	}
+	if len(results) == 0 {
+		fmt.Println("No models found")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPULLS\tTAGS\tSIZES\tCAPABILITIES\tUPDATED")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", r.Name, r.Pulls, r.Tags,
+			strings.Join(r.Sizes, ", "), strings.Join(r.Capabilities, ", "), r.Updated)
+		if *long && r.Description != "" {
+			fmt.Fprintf(tw, "  %s\n", r.Description)
+		}
+	}
+	return tw.Flush()
+}
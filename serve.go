@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runServe implements "ollama-dl serve", which serves the models downloaded
+// under a directory as a read-only registry.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	destDir := fs.String("d", ".", "Directory the models were downloaded under")
+	fs.StringVar(destDir, "dest", ".", "Same as -d")
+	listen := fs.String("listen", ":5000", "Serve the registry on this address")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file; without it the registry is served in plain text")
+	tlsKey := fs.String("tls-key", "", "TLS key file")
+	verbose := fs.Bool("v", false, "Log debug messages, including every blob served")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Println("Usage: ollama-dl serve [-d <dir>] [-listen <addr>] [flags]")
+		os.Exit(1)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	log := newLogger(level)
+	d, err := ollamadl.New(ollamadl.Options{Logger: log})
+	if err != nil {
+		return err
+	}
+	ctx := commandContext()
+	registry, err := d.NewRegistryServer(ctx, *destDir)
+	if err != nil {
+		return err
+	}
+	for _, ref := range registry.Models() {
+		log.Debug("Serving model", "model", ref.String())
+	}
+
+	srv := &http.Server{Addr: *listen, Handler: registry, ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelError)}
+	errc := make(chan error, 1)
+	go func() {
+		log.Info("Serving registry", "dir", *destDir, "models", len(registry.Models()), "addr", *listen)
+		if *tlsCert != "" {
+			errc <- srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+			return
+		}
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runShare implements "ollama-dl share", which serves the models
+// downloaded under a directory to other machines as a web seed.
+func runShare(args []string) error {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	listen := fs.String("listen", ":7070", "Serve blobs on this address")
+	verbose := fs.Bool("v", false, "Log debug messages, including every blob served")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Println("Usage: ollama-dl share [-listen <addr>] [dir]")
+		os.Exit(1)
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	log := newLogger(level)
+	d, err := ollamadl.New(ollamadl.Options{Logger: log})
+	if err != nil {
+		return err
+	}
+	ctx := commandContext()
+	blobs, err := d.NewBlobServer(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Addr: *listen, Handler: blobs, ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelError)}
+	errc := make(chan error, 1)
+	go func() {
+		log.Info("Sharing", "dir", dir, "blobs", blobs.Len(), "addr", *listen)
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	return err
+}
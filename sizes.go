@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runSizes implements "ollama-dl sizes", which prints how much each tag of
+// a model downloads without downloading it.
+func runSizes(args []string) error {
+	fs := flag.NewFlagSet("sizes", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	bySize := fs.Bool("s", false, "Sort by size instead of the registry's order")
+
+	// Flags may come before, between and after the name and tags.
+	var names []string
+	fs.Parse(args)
+	for fs.NArg() > 0 {
+		names = append(names, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	if len(names) == 0 {
+		fmt.Println("Usage: ollama-dl sizes [flags] <name[:tag]> [tag...]")
+		os.Exit(1)
+	}
+	ref, err := ollamadl.ParseReference(names[0])
+	if err != nil {
+		return err
+	}
+	// Without tags every tag is listed, unless the name carries one.
+	tags := names[1:]
+	if len(tags) == 0 && strings.Contains(path.Base(names[0]), ":") {
+		tags = []string{ref.Tag}
+	}
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+	sizes, err := d.TagSizes(commandContext(), ref, tags)
+	if err != nil {
+		return fmt.Errorf("listing sizes failed: %w", err)
+	}
+	if *bySize {
+		sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].Size < sizes[j].Size })
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tDOWNLOAD\tWEIGHTS\tDIGEST")
+	failed := 0
+	for _, size := range sizes {
+		if size.Err != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t%v\n", size.Tag, size.Err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", size.Tag, formatSize(size.Size), formatSize(size.Weights), shortDigest(size.Digest))
+	}
+	tw.Flush()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tags failed", failed, len(sizes))
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl/azstore"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl/davstore"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl/gcsstore"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl/s3store"
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl/sftpstore"
+)
+
+// openStore returns the store for a -d destination and the directory within
+// it the model's files go to. Object storage URLs hold the files directly
+// under their prefix; a local destination defaults to a directory named after
+// the model.
+func openStore(dest string, ref ollamadl.Reference) (ollamadl.BlobStore, string, error) {
+	switch {
+	case strings.HasPrefix(dest, "s3://"):
+		store, err := s3store.New(dest)
+		return store, "", err
+	case strings.HasPrefix(dest, "az://"):
+		store, err := azstore.New(dest)
+		return store, "", err
+	case strings.HasPrefix(dest, "gs://"):
+		store, err := gcsstore.New(dest)
+		return store, "", err
+	case strings.HasPrefix(dest, "sftp://"):
+		store, err := sftpstore.New(dest)
+		return store, "", err
+	case strings.HasPrefix(dest, "webdav://"), strings.HasPrefix(dest, "webdavs://"):
+		store, err := davstore.New(dest)
+		return store, "", err
+	case dest == "":
+		dest = ref.DirName()
+	}
+	return ollamadl.NewFileStore(""), dest, nil
+}
+
+// storeFlags are the flags setting the permissions and owner of what
+// commands write to a local destination.
+type storeFlags struct {
+	fileMode, dirMode, chown string
+}
+
+func addStoreFlags(fs *flag.FlagSet) *storeFlags {
+	f := &storeFlags{}
+	fs.StringVar(&f.fileMode, "file-mode", "", "Create files with this octal `mode`, e.g. 0640, whatever the umask (local destinations only)")
+	fs.StringVar(&f.dirMode, "dir-mode", "", "Create directories with this octal `mode`, e.g. 2750, whatever the umask (local destinations only)")
+	fs.StringVar(&f.chown, "chown", "", "Give the files and directories created to `user[:group]`, names or numeric IDs, e.g. 1000:1000 when run as root to prepare a volume (local destinations only)")
+	return f
+}
+
+// apply sets the permissions and owner on store, which must be local if
+// any are set.
+func (f *storeFlags) apply(store ollamadl.BlobStore) error {
+	if f.fileMode == "" && f.dirMode == "" && f.chown == "" {
+		return nil
+	}
+	fs, ok := store.(*ollamadl.FileStore)
+	if !ok {
+		return errors.New("-file-mode, -dir-mode and -chown only apply to local destinations")
+	}
+	var err error
+	if fs.FileMode, err = parseFileMode(f.fileMode); err != nil {
+		return fmt.Errorf("-file-mode: %v", err)
+	}
+	if fs.DirMode, err = parseFileMode(f.dirMode); err != nil {
+		return fmt.Errorf("-dir-mode: %v", err)
+	}
+	if f.chown != "" {
+		if runtime.GOOS == "windows" {
+			return errors.New("-chown is not supported on Windows")
+		}
+		if fs.Owner, err = parseOwner(f.chown); err != nil {
+			return fmt.Errorf("-chown: %v", err)
+		}
+	}
+	return nil
+}
+
+// parseOwner parses "user[:group]", where each is a name or a numeric ID,
+// as chown takes it.
+func parseOwner(s string) (*ollamadl.FileOwner, error) {
+	name, group, hasGroup := strings.Cut(s, ":")
+	owner := &ollamadl.FileOwner{UID: -1, GID: -1}
+	if name != "" {
+		uid, err := strconv.Atoi(name)
+		if err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return nil, err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return nil, fmt.Errorf("user %s has no numeric ID", name)
+			}
+		}
+		owner.UID = uid
+	}
+	if hasGroup && group != "" {
+		gid, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return nil, fmt.Errorf("group %s has no numeric ID", group)
+			}
+		}
+		owner.GID = gid
+	}
+	if owner.UID < 0 && owner.GID < 0 {
+		return nil, fmt.Errorf("invalid owner %q", s)
+	}
+	return owner, nil
+}
+
+// parseFileMode parses an octal mode such as 0640 or 2775, with setuid,
+// setgid and sticky bits; "" is zero.
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n == 0 || n > 07777 {
+		return 0, fmt.Errorf("invalid mode %q", s)
+	}
+	mode := os.FileMode(n & 0777)
+	if n&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if n&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if n&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runSync implements "ollama-dl sync", which updates an earlier download to
+// the registry's current manifest.
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	destDir := fs.String("d", "", "Directory or storage URL the model was downloaded to")
+	fs.StringVar(destDir, "dest", "", "Same as -d")
+	sf := addStoreFlags(fs)
+	removeObsolete := fs.Bool("delete", false, "Delete files of layers the model no longer has")
+	checksums := fs.Bool("checksums", false, "Write a SHA256SUMS file covering the downloaded files and the manifest")
+	attest := fs.Bool("attest", false, "Write an in-toto provenance attestation of the download as "+ollamadl.AttestationFileName)
+	attestKey := fs.String("attest-key", "", "Sign the attestation with the PEM private key in this `file` (implies -attest)")
+	delta := fs.Bool("delta", false, "Fetch changed layers by reusing the unchanged ranges of their previous files")
+	force := fs.Bool("force", false, "Download every file again, replacing existing files and discarding partial downloads")
+	staleTempAge := fs.Duration("stale-tmp-age", 0, "Start partial downloads older than this over instead of resuming them, e.g. 72h (0 always resumes)")
+	keep := fs.Int("keep", 0, "Keep only this many versions of the model under -store-root, deleting older ones after the pull")
+	latestLink := fs.String("link", "", "After a successful pull, point the symbolic link at this `path` to the model's directory, e.g. models/llama3-latest")
+	versioned := fs.Bool("versioned", false, "Put each version of the model in a subdirectory named after its manifest digest, keeping earlier versions for rollback")
+	timeout := addTimeoutFlag(fs)
+	ref := parseModelArgs(fs, args, "ollama-dl sync [flags] <name>")
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	store, dir, err := openStore(*destDir, ref)
+	if err != nil {
+		return err
+	}
+	if err := sf.apply(store); err != nil {
+		return err
+	}
+	opts.Store = store
+	opts.DeltaUpdates = *delta
+	opts.WriteChecksums = *checksums
+	if opts.AttestationKey, err = readAttestationKey(*attestKey); err != nil {
+		return err
+	}
+	opts.Attestation = *attest || *attestKey != ""
+	opts.Force = *force
+	opts.StaleTempAge = *staleTempAge
+	opts.KeepVersions = *keep
+	opts.LatestLink = *latestLink
+	opts.VersionedDirs = *versioned
+	opts.Progress = newBarReporter()
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(commandContext(), *timeout)
+	defer cancel()
+	result, err := d.Sync(ctx, ref, dir, *removeObsolete)
+	if err != nil {
+		return fmt.Errorf("sync failed: %w", timedOut(ctx, err))
+	}
+
+	for _, file := range result.Obsolete {
+		if *removeObsolete {
+			fmt.Println("Deleted", file)
+		} else {
+			fmt.Println("Obsolete", file, "(use -delete to remove)")
+		}
+	}
+	if len(result.Downloaded) == 0 {
+		fmt.Println("Already up to date")
+	} else {
+		fmt.Printf("Updated %d of %d files\n", len(result.Downloaded), len(result.Jobs))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// readTemplate returns the template in path, or the template layer of the
+// model pulled into path when it is a directory.
+func readTemplate(d *ollamadl.Downloader, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return d.Template(commandContext(), path)
+	}
+	text, err := os.ReadFile(path)
+	return string(text), err
+}
+
+// runTemplate implements "ollama-dl template export".
+func runTemplate(args []string) error {
+	if len(args) < 1 || args[0] != "export" {
+		return errors.New("usage: ollama-dl template export [-format jinja|hf] [-o file] <dir|template-file>")
+	}
+
+	fs := flag.NewFlagSet("template export", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	format := fs.String("format", "jinja", "Output format: jinja (llama.cpp, vLLM) or hf (tokenizer_config.json snippet)")
+	output := fs.String("o", "", "Write to this file instead of stdout")
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: ollama-dl template export [-format jinja|hf] [-o file] <dir|template-file>")
+	}
+
+	// The config file's media types name the template layer.
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	opts.Store = ollamadl.NewFileStore("")
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	text, err := readTemplate(d, path)
+	if err != nil {
+		return err
+	}
+
+	converted, err := ollamadl.ConvertTemplate(text)
+	if err != nil {
+		return fmt.Errorf("failed to convert %s: %v", path, err)
+	}
+
+	switch *format {
+	case "jinja":
+	case "hf":
+		data, err := json.MarshalIndent(map[string]string{"chat_template": converted}, "", "  ")
+		if err != nil {
+			return err
+		}
+		converted = string(data) + "\n"
+	default:
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.WriteString(converted)
+		return err
+	}
+	return os.WriteFile(*output, []byte(converted), 0644)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runVerify implements "ollama-dl verify", which checks downloaded files
+// against the registry's current manifest, or with -offline against the
+// manifest and checksums saved with them.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	destDir := fs.String("d", "", "Directory or storage URL the model was downloaded to")
+	fs.StringVar(destDir, "dest", "", "Same as -d")
+	quick := fs.Bool("quick", false, "Don't re-hash files the state database has checked and that haven't changed since")
+	offline := fs.Bool("offline", false, "Check against the saved manifest and SHA256SUMS without contacting the registry")
+	jobs := fs.Int("jobs", 0, "Hash this many files at a time (default one per CPU)")
+	fs.Parse(args)
+	arg := fs.Arg(0)
+	if fs.NArg() > 0 {
+		// Flags may also follow the argument.
+		fs.Parse(fs.Args()[1:])
+	}
+
+	var ref ollamadl.Reference
+	if *offline {
+		if arg == "" && *destDir == "" {
+			fmt.Println("Usage: ollama-dl verify -offline [flags] <dir|name>")
+			os.Exit(1)
+		}
+		if *destDir == "" {
+			*destDir = arg
+			if info, err := os.Stat(arg); err != nil || !info.IsDir() {
+				// A model name, for its default directory.
+				if ref, err = ollamadl.ParseReference(arg); err != nil {
+					return err
+				}
+				*destDir = ""
+			}
+		}
+	} else {
+		ref = parseModelArgs(fs, args, "ollama-dl verify [flags] <name>")
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	store, dir, err := openStore(*destDir, ref)
+	if err != nil {
+		return err
+	}
+	opts.Store = store
+	opts.VerifyConcurrency = *jobs
+	opts.VerifyProgress = verifyBar()
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx := commandContext()
+	var results []ollamadl.VerifyResult
+	switch {
+	case *offline:
+		results, err = d.VerifyOffline(ctx, dir)
+	case *quick:
+		results, err = d.VerifyQuick(ctx, ref, dir)
+	default:
+		results, err = d.Verify(ctx, ref, dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			failed++
+			fmt.Printf("FAILED  %s: %v\n", r.Path, r.Err)
+		case r.Trusted:
+			fmt.Printf("OK      %s (unchanged since checked)\n", r.Path)
+		case r.Skipped:
+			fmt.Printf("SKIPPED %s (stored decompressed)\n", r.Path)
+		default:
+			fmt.Printf("OK      %s\n", r.Path)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed verification", failed, len(results))
+	}
+	return nil
+}
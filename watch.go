@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dimchansky/ollama-dl-go/pkg/ollamadl"
+)
+
+// runWatch implements "ollama-dl watch", which polls a model's tags and
+// pulls the ones that are new or changed.
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	rf := addRegistryFlags(fs)
+	destDir := fs.String("d", "", "Destination directory or storage URL; each tag goes into a subdirectory")
+	fs.StringVar(destDir, "dest", "", "Same as -d")
+	sf := addStoreFlags(fs)
+	interval := fs.Duration("interval", time.Hour, "Time between checks")
+	notifyOnly := fs.Bool("notify-only", false, "Only report new and changed tags instead of pulling them")
+	delta := fs.Bool("delta", false, "Fetch changed layers by reusing the unchanged ranges of their previous files")
+	fs.Parse(args)
+	name := fs.Arg(0)
+	ref := parseModelArgs(fs, args, "ollama-dl watch [flags] <name[:tag]>")
+	if *interval <= 0 {
+		return fmt.Errorf("invalid interval %v", *interval)
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	store, dir, err := openStore(*destDir, ref)
+	if err != nil {
+		return err
+	}
+	if *destDir == "" {
+		dir = strings.ReplaceAll(ref.Name, "/", "-")
+	}
+	if err := sf.apply(store); err != nil {
+		return err
+	}
+	opts.Store = store
+	opts.DeltaUpdates = *delta
+	if !*notifyOnly {
+		opts.Progress = newBarReporter()
+	}
+	d, err := ollamadl.New(opts)
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{
+		d:          d,
+		log:        opts.Logger,
+		ref:        ref,
+		dir:        dir,
+		notifyOnly: *notifyOnly,
+		known:      make(map[string]*ollamadl.Manifest),
+	}
+	// A tag given on the command line is watched alone; otherwise the tag
+	// list is polled too.
+	if strings.Contains(name, ":") {
+		w.tags = []string{ref.Tag}
+	}
+
+	ctx := commandContext()
+	for {
+		if err := w.poll(ctx); err != nil && ctx.Err() == nil {
+			w.log.Warn("Check failed", "model", ref.Name, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// watcher remembers the manifests of the tags it has seen.
+type watcher struct {
+	d          *ollamadl.Downloader
+	log        *slog.Logger
+	ref        ollamadl.Reference
+	dir        string
+	notifyOnly bool
+	tags       []string // nil to watch every tag
+
+	// known holds the manifest of each tag as last pulled or reported. A
+	// tag seen for the first time is compared with the manifest saved by
+	// an earlier pull, so restarting the watch doesn't report it again.
+	known map[string]*ollamadl.Manifest
+}
+
+// poll checks the watched tags once.
+func (w *watcher) poll(ctx context.Context) error {
+	tags := w.tags
+	if tags == nil {
+		var err error
+		if tags, err = w.d.Tags(ctx, w.ref); err != nil {
+			return err
+		}
+		w.forgetRemoved(tags)
+	}
+
+	var (
+		changed []string
+		errs    []error
+	)
+	for _, tag := range tags {
+		ref := ollamadl.Reference{Name: w.ref.Name, Tag: tag}
+		tagDir := path.Join(filepath.ToSlash(w.dir), tag)
+		res, err := w.d.Resolve(ctx, ref, tagDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ref, err))
+			continue
+		}
+
+		old, seen := w.known[tag]
+		if !seen {
+			if old, err = w.d.SavedManifest(ctx, tagDir); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", ref, err))
+				continue
+			}
+		}
+		if old != nil && sameLayers(old, &res.Manifest) {
+			w.known[tag] = old
+			continue
+		}
+
+		what := "New tag"
+		if old != nil {
+			what = "Updated tag"
+		}
+		w.log.Info(what, "model", ref)
+		if w.notifyOnly {
+			w.known[tag] = &res.Manifest
+		} else {
+			changed = append(changed, tag)
+		}
+	}
+	if len(changed) > 0 {
+		result, err := w.d.MirrorTags(ctx, w.ref, changed, w.dir)
+		for _, res := range result.Pulled {
+			w.known[res.Ref.Tag] = &res.Manifest
+			w.log.Info("Pulled", "model", res.Ref, "dest", res.DestDir)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// forgetRemoved reports and forgets known tags missing from tags.
+func (w *watcher) forgetRemoved(tags []string) {
+	current := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		current[tag] = true
+	}
+	for tag := range w.known {
+		if !current[tag] {
+			w.log.Info("Removed tag", "model", ollamadl.Reference{Name: w.ref.Name, Tag: tag})
+			delete(w.known, tag)
+		}
+	}
+}
+
+// sameLayers reports whether two manifests have the same config and layers.
+func sameLayers(a, b *ollamadl.Manifest) bool {
+	if a.Config.Digest != b.Config.Digest || len(a.Layers) != len(b.Layers) {
+		return false
+	}
+	for i := range a.Layers {
+		if a.Layers[i].Digest != b.Layers[i].Digest {
+			return false
+		}
+	}
+	return true
+}